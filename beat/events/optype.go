@@ -25,4 +25,5 @@ const (
 	OpTypeCreate                //create
 	OpTypeIndex                 // index
 	OpTypeDelete                // delete
+	OpTypeUpdate                // update
 )
@@ -29,11 +29,12 @@ func _() {
 	_ = x[OpTypeCreate-1]
 	_ = x[OpTypeIndex-2]
 	_ = x[OpTypeDelete-3]
+	_ = x[OpTypeUpdate-4]
 }
 
-const _OpType_name = "createindexdelete"
+const _OpType_name = "createindexdeleteupdate"
 
-var _OpType_index = [...]uint8{0, 0, 6, 11, 17}
+var _OpType_index = [...]uint8{0, 0, 6, 11, 17, 23}
 
 func (i OpType) String() string {
 	if i < 0 || i >= OpType(len(_OpType_index)-1) {
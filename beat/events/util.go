@@ -40,9 +40,22 @@ const (
 	FieldMetaPipeline = "pipeline"
 
 	// FieldMetaOpType defines the metadata key name for event operation type to use with the Elasticsearch
-	// Bulk API encoding of the event. The key's value can be an empty string, `create`, `index`, or `delete`.
-	// If empty, `create` will be used if FieldMetaID is set; otherwise `index` will be used.
+	// Bulk API encoding of the event. The key's value can be an empty string, `create`, `index`, `delete`,
+	// or `update`. If empty, `create` will be used if FieldMetaID is set; otherwise `index` will be used.
 	FieldMetaOpType = "op_type"
+
+	// FieldMetaRetryOnConflict defines how often an `update` operation is retried by Elasticsearch
+	// on version conflicts.
+	FieldMetaRetryOnConflict = "retry_on_conflict"
+
+	// FieldMetaDocAsUpsert defines whether an `update` operation creates the document from the event
+	// if it does not exist yet.
+	FieldMetaDocAsUpsert = "doc_as_upsert"
+
+	// FieldMetaScript defines the script run by an `update` operation. The value is either the
+	// script source or an object with the `source` or `id`, `lang`, and `params` of the script.
+	// If set, the update is a scripted upsert and the event is passed to the script as `params.event`.
+	FieldMetaScript = "script"
 )
 
 // GetMetaStringValue returns the value of the given event metadata string field
@@ -77,6 +90,8 @@ func GetOpType(e beat.Event) OpType {
 			return OpTypeIndex
 		case "delete":
 			return OpTypeDelete
+		case "update":
+			return OpTypeUpdate
 		}
 	}
 
@@ -59,6 +59,11 @@ type ClientConfig struct {
 
 	// Events configures callbacks for common client callbacks
 	Events ClientEventer
+
+	// Queue selects one of the named queues configured in `queues` to
+	// publish to, isolating the client's events from other inputs. If not
+	// set, the client publishes to the default queue.
+	Queue string
 }
 
 // ACKer can be registered with a Client when connecting to the pipeline.
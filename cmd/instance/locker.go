@@ -74,3 +74,14 @@ func (l *locker) unlock() error {
 
 	return nil
 }
+
+// LockDataPath acquires the lock on the data path, failing with
+// ErrAlreadyLocked if the Beat is running. It is used by commands modifying
+// files in the data path. The returned function releases the lock.
+func (b *Beat) LockDataPath() (func() error, error) {
+	l := newLocker(b)
+	if err := l.lock(); err != nil {
+		return nil, err
+	}
+	return l.unlock, nil
+}
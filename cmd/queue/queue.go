@@ -0,0 +1,364 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package queue implements commands to inspect and repair the disk queue of
+// a Beat that is not running.
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/njcx/libbeat_v7/cmd/instance"
+	"github.com/njcx/libbeat_v7/common/cli"
+	jsoncodec "github.com/njcx/libbeat_v7/outputs/codec/json"
+	"github.com/njcx/libbeat_v7/publisher/queue/diskqueue"
+)
+
+// GenListCmd generates the command listing the queue segments and position.
+func GenListCmd(settings instance.Settings) *cobra.Command {
+	var path string
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List disk queue segments and the current read position",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			_, queueSettings, err := loadSettings(settings, path)
+			if err != nil {
+				return err
+			}
+			return list(os.Stdout, queueSettings)
+		}),
+	}
+	addPathFlag(command, &path)
+	return command
+}
+
+// GenDumpCmd generates the command printing the frames of the queue as NDJSON.
+func GenDumpCmd(settings instance.Settings) *cobra.Command {
+	var path string
+	var segments []uint
+	command := &cobra.Command{
+		Use:   "dump",
+		Short: "Dump disk queue frames as NDJSON",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			b, queueSettings, err := loadSettings(settings, path)
+			if err != nil {
+				return err
+			}
+			return dump(os.Stdout, queueSettings, b.Info.Beat, b.Info.Version, segments)
+		}),
+	}
+	addPathFlag(command, &path)
+	command.Flags().UintSliceVar(&segments, "segment", nil, "Only dump the segments with the given ids")
+	return command
+}
+
+// GenCheckCmd generates the command validating all frames of the queue.
+func GenCheckCmd(settings instance.Settings) *cobra.Command {
+	var path string
+	command := &cobra.Command{
+		Use:   "check",
+		Short: "Validate the checksums and structure of the disk queue segments",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			_, queueSettings, err := loadSettings(settings, path)
+			if err != nil {
+				return err
+			}
+			return repair(os.Stdout, queueSettings, true)
+		}),
+	}
+	addPathFlag(command, &path)
+	return command
+}
+
+// GenRepairCmd generates the command truncating corrupt segments.
+func GenRepairCmd(settings instance.Settings) *cobra.Command {
+	var path string
+	var dryRun bool
+	command := &cobra.Command{
+		Use:   "repair",
+		Short: "Truncate corrupt data at the end of disk queue segments",
+		Long: "Truncate each disk queue segment at its first corrupt frame. Events " +
+			"stored after a corrupt frame are lost, all events before it are kept.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			b, queueSettings, err := loadSettings(settings, path)
+			if err != nil {
+				return err
+			}
+			unlock, err := b.LockDataPath()
+			if err != nil {
+				return fmt.Errorf("can not repair the queue while %s is running: %v", b.Info.Beat, err)
+			}
+			defer unlock()
+			return repair(os.Stdout, queueSettings, dryRun)
+		}),
+	}
+	addPathFlag(command, &path)
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "Only report corrupt segments, do not modify them")
+	return command
+}
+
+// GenExportCmd generates the command writing the undelivered events to a file.
+func GenExportCmd(settings instance.Settings) *cobra.Command {
+	var path, output string
+	var all bool
+	command := &cobra.Command{
+		Use:   "export",
+		Short: "Export undelivered events from the disk queue as NDJSON",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			b, queueSettings, err := loadSettings(settings, path)
+			if err != nil {
+				return err
+			}
+
+			out := io.Writer(os.Stdout)
+			if output != "" && output != "-" {
+				f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+
+			count, err := export(out, queueSettings, b.Info.Beat, b.Info.Version, all)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Exported %d events\n", count)
+			return nil
+		}),
+	}
+	addPathFlag(command, &path)
+	command.Flags().StringVarP(&output, "output", "o", "", "File to write the events to, stdout by default. Existing files are not overwritten")
+	command.Flags().BoolVar(&all, "all", false, "Export all events on disk, including events already acknowledged by the output")
+	return command
+}
+
+func addPathFlag(command *cobra.Command, path *string) {
+	command.Flags().StringVar(path, "path", "", "Path of the disk queue directory, overrides the configured path")
+}
+
+// loadSettings reads the disk queue settings from the Beat configuration.
+func loadSettings(settings instance.Settings, path string) (*instance.Beat, diskqueue.Settings, error) {
+	b, err := instance.NewInitializedBeat(settings)
+	if err != nil {
+		return nil, diskqueue.Settings{}, fmt.Errorf("error initializing beat: %s", err)
+	}
+
+	queueSettings := diskqueue.DefaultSettings()
+	queueConfig := b.Config.Pipeline.Queue
+	if queueConfig.IsSet() && queueConfig.Name() == "disk" {
+		queueSettings, err = diskqueue.SettingsForUserConfig(queueConfig.Config())
+		if err != nil {
+			return nil, diskqueue.Settings{}, fmt.Errorf("error reading disk queue settings: %v", err)
+		}
+	} else if path == "" {
+		return nil, diskqueue.Settings{}, errors.New("the disk queue is not configured, use --path to select the queue directory")
+	}
+
+	if path != "" {
+		queueSettings.Path = path
+	}
+	return b, queueSettings, nil
+}
+
+func listSegments(w io.Writer, settings diskqueue.Settings) ([]diskqueue.SegmentInfo, error) {
+	segments, err := diskqueue.ListSegments(settings)
+	if err != nil {
+		if segments == nil {
+			return nil, err
+		}
+		fmt.Fprintf(w, "Warning: %v\n", err)
+	}
+	return segments, nil
+}
+
+func list(w io.Writer, settings diskqueue.Settings) error {
+	position, err := diskqueue.ReadPosition(settings)
+	if err != nil {
+		fmt.Fprintf(w, "Position: unknown (%v)\n", err)
+	} else {
+		fmt.Fprintf(w, "Position: segment %d, frame %d, byte %d\n",
+			position.SegmentID, position.FrameIndex, position.ByteIndex)
+	}
+
+	segments, err := listSegments(w, settings)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSIZE\tFRAMES\tVERSION\tCOMPRESSION\tENCRYPTED")
+	for _, segment := range segments {
+		frames := fmt.Sprint(segment.FrameCount)
+		if segment.FrameCount == 0 {
+			// The frame count is only written when a segment is closed.
+			frames = "?"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%s\t%t\n", segment.ID, segment.Size, frames,
+			segment.Version, segment.Compression, segment.Encrypted)
+	}
+	return tw.Flush()
+}
+
+type dumpFrame struct {
+	Segment uint64          `json:"segment"`
+	Frame   uint64          `json:"frame"`
+	Offset  uint64          `json:"offset"`
+	Size    uint64          `json:"size"`
+	Event   json.RawMessage `json:"event"`
+}
+
+func dump(w io.Writer, settings diskqueue.Settings, beatName, version string, ids []uint) error {
+	segments, err := listSegments(os.Stderr, settings)
+	if err != nil {
+		return err
+	}
+
+	selected := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		selected[uint64(id)] = true
+	}
+
+	encoder := jsoncodec.New(version, jsoncodec.Config{})
+	out := json.NewEncoder(w)
+	for _, segment := range segments {
+		if len(selected) > 0 && !selected[segment.ID] {
+			continue
+		}
+
+		scan, err := diskqueue.ScanSegment(settings, segment, func(frame diskqueue.Frame) error {
+			event, err := encoder.Encode(beatName, &frame.Event.Content)
+			if err != nil {
+				return err
+			}
+			return out.Encode(dumpFrame{
+				Segment: segment.ID,
+				Frame:   frame.Index,
+				Offset:  frame.Offset,
+				Size:    frame.Size,
+				Event:   event,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("segment %d: %v", segment.ID, err)
+		}
+		if scan.Err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: segment %d is corrupt at offset %d: %v\n",
+				segment.ID, scan.ValidSize, scan.Err)
+		}
+	}
+	return nil
+}
+
+// repair checks all segments and truncates them at the first invalid frame.
+// If dryRun is set, the corrupt segments are only reported.
+func repair(w io.Writer, settings diskqueue.Settings, dryRun bool) error {
+	segments, err := listSegments(w, settings)
+	if err != nil {
+		return err
+	}
+
+	corrupt := 0
+	for _, segment := range segments {
+		scan, err := diskqueue.ScanSegment(settings, segment, nil)
+		if err != nil {
+			return fmt.Errorf("segment %d: %v", segment.ID, err)
+		}
+		if scan.Err == nil {
+			fmt.Fprintf(w, "Segment %d: OK, %d frames\n", segment.ID, scan.Frames)
+			continue
+		}
+
+		corrupt++
+		fmt.Fprintf(w, "Segment %d: corrupt after %d frames at offset %d: %v\n",
+			segment.ID, scan.Frames, scan.ValidSize, scan.Err)
+		if dryRun {
+			continue
+		}
+		if err := diskqueue.TruncateSegment(segment, scan); err != nil {
+			return fmt.Errorf("failed to repair segment %d: %v", segment.ID, err)
+		}
+		if scan.Frames == 0 {
+			fmt.Fprintf(w, "Segment %d: removed, no valid frames\n", segment.ID)
+		} else {
+			fmt.Fprintf(w, "Segment %d: truncated to %d bytes\n", segment.ID, scan.ValidSize)
+		}
+	}
+
+	if corrupt > 0 && dryRun {
+		return fmt.Errorf("found %d corrupt segments", corrupt)
+	}
+	return nil
+}
+
+// export writes all events that have not been acknowledged yet as NDJSON,
+// using the same format as the json codec. If all is set, events already
+// acknowledged but still on disk are exported as well.
+func export(w io.Writer, settings diskqueue.Settings, beatName, version string, all bool) (int, error) {
+	var position diskqueue.Position
+	if !all {
+		var err error
+		position, err = diskqueue.ReadPosition(settings)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("failed to read the queue position: %v", err)
+		}
+	}
+
+	segments, err := listSegments(os.Stderr, settings)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	encoder := jsoncodec.New(version, jsoncodec.Config{})
+	for _, segment := range segments {
+		if segment.ID < position.SegmentID {
+			continue
+		}
+
+		scan, err := diskqueue.ScanSegment(settings, segment, func(frame diskqueue.Frame) error {
+			if segment.ID == position.SegmentID && frame.Index < position.FrameIndex {
+				return nil
+			}
+			event, err := encoder.Encode(beatName, &frame.Event.Content)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append(event, '\n')); err != nil {
+				return err
+			}
+			count++
+			return nil
+		})
+		if err != nil {
+			return count, fmt.Errorf("segment %d: %v", segment.ID, err)
+		}
+		if scan.Err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: segment %d is corrupt at offset %d, events after it are skipped: %v\n",
+				segment.ID, scan.ValidSize, scan.Err)
+		}
+	}
+	return count, nil
+}
@@ -47,7 +47,14 @@ func GenTestOutputCmd(settings instance.Settings) *cobra.Command {
 				os.Exit(1)
 			}
 
-			for _, client := range output.Clients {
+			clients := output.Clients
+			if output.Fanout != nil {
+				for _, routed := range output.Fanout.Outputs {
+					clients = append(clients, routed.Group.Clients...)
+				}
+			}
+
+			for _, client := range clients {
 				tClient, ok := client.(testing.Testable)
 				if !ok {
 					fmt.Printf("%s output doesn't support testing\n", b.Config.Output.Name())
@@ -22,6 +22,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -30,12 +31,17 @@ import (
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/module/apmhttp"
 
+	"github.com/njcx/libbeat_v7/beat"
 	"github.com/njcx/libbeat_v7/common"
 	"github.com/njcx/libbeat_v7/logp"
 )
 
 var (
 	ErrTempBulkFailure = errors.New("temporary bulk send failure")
+
+	// ErrBulkTooLarge indicates a bulk request exceeding the maximum content
+	// length accepted by Elasticsearch.
+	ErrBulkTooLarge = errors.New("bulk request too large")
 )
 
 type BulkIndexAction struct {
@@ -50,11 +56,60 @@ type BulkDeleteAction struct {
 	Delete BulkMeta `json:"delete" struct:"delete"`
 }
 
+type BulkUpdateAction struct {
+	Update BulkMeta `json:"update" struct:"update"`
+}
+
 type BulkMeta struct {
-	Index    string `json:"_index" struct:"_index"`
-	DocType  string `json:"_type,omitempty" struct:"_type,omitempty"`
-	Pipeline string `json:"pipeline,omitempty" struct:"pipeline,omitempty"`
-	ID       string `json:"_id,omitempty" struct:"_id,omitempty"`
+	Index           string `json:"_index" struct:"_index"`
+	DocType         string `json:"_type,omitempty" struct:"_type,omitempty"`
+	Pipeline        string `json:"pipeline,omitempty" struct:"pipeline,omitempty"`
+	ID              string `json:"_id,omitempty" struct:"_id,omitempty"`
+	RetryOnConflict int    `json:"retry_on_conflict,omitempty" struct:"retry_on_conflict,omitempty"`
+}
+
+// BulkUpdateDoc is the body of an update action merging the event into the
+// existing document.
+type BulkUpdateDoc struct {
+	Doc         event `struct:"doc"`
+	DocAsUpsert bool  `struct:"doc_as_upsert,omitempty"`
+}
+
+// BulkScriptedUpsert is the body of an update action running a script. The
+// script also runs if the document does not exist yet, starting from an empty
+// document.
+type BulkScriptedUpsert struct {
+	Script         common.MapStr `struct:"script"`
+	ScriptedUpsert bool          `struct:"scripted_upsert"`
+	Upsert         common.MapStr `struct:"upsert"`
+}
+
+// NewBulkUpdateDoc creates the body of an update action merging the event
+// into the existing document. If docAsUpsert is set, the event is indexed if
+// the document does not exist.
+func NewBulkUpdateDoc(e *beat.Event, docAsUpsert bool) BulkUpdateDoc {
+	return BulkUpdateDoc{
+		Doc:         event{Timestamp: e.Timestamp, Fields: e.Fields},
+		DocAsUpsert: docAsUpsert,
+	}
+}
+
+// NewBulkScriptedUpsert creates the body of an update action running script.
+// The event is passed to the script as `params.event`.
+func NewBulkScriptedUpsert(e *beat.Event, script common.MapStr) BulkScriptedUpsert {
+	script = script.Clone()
+	params, _ := script["params"].(common.MapStr)
+	if params == nil {
+		params = common.MapStr{}
+	}
+	params["event"] = event{Timestamp: e.Timestamp, Fields: e.Fields}
+	script["params"] = params
+
+	return BulkScriptedUpsert{
+		Script:         script,
+		ScriptedUpsert: true,
+		Upsert:         common.MapStr{},
+	}
 }
 
 type bulkRequest struct {
@@ -81,6 +136,9 @@ func (conn *Connection) Bulk(
 		apm.CaptureError(ctx, err).Send()
 		return 0, nil, err
 	}
+	if conn.MaxContentLength > 0 && enc.rawLen() > conn.MaxContentLength {
+		return 0, nil, ErrBulkTooLarge
+	}
 
 	mergedParams := mergeParams(conn.ConnectionSettings.Parameters, params)
 
@@ -91,7 +149,11 @@ func (conn *Connection) Bulk(
 	}
 	requ.requ = apmhttp.RequestWithContext(ctx, requ.requ)
 
-	return conn.sendBulkRequest(requ)
+	status, result, err := conn.sendBulkRequest(requ)
+	if status == http.StatusRequestEntityTooLarge {
+		err = fmt.Errorf("%w: %v", ErrBulkTooLarge, err)
+	}
+	return status, result, err
 }
 
 // SendMonitoringBulk creates a HTTP request to the X-Pack Monitoring API containing a bunch of
@@ -52,6 +52,11 @@ type Connection struct {
 	Encoder BodyEncoder
 	HTTP    esHTTPClient
 
+	// MaxContentLength limits the size of bulk requests before compression.
+	// Larger requests fail with ErrBulkTooLarge without being sent. No limit
+	// is applied if 0.
+	MaxContentLength int
+
 	apiKeyAuthHeader string // Authorization HTTP request header with base64-encoded API key
 	version          common.Version
 	log              *logp.Logger
@@ -42,6 +42,9 @@ type bulkBodyEncoder interface {
 
 	AddHeader(*http.Header)
 	Reset()
+
+	// rawLen returns the size of the encoded body before compression.
+	rawLen() int
 }
 
 type BulkWriter interface {
@@ -59,11 +62,24 @@ type jsonEncoder struct {
 type gzipEncoder struct {
 	buf    *bytes.Buffer
 	gzip   *gzip.Writer
+	raw    countWriter
 	folder *gotype.Iterator
 
 	escapeHTML bool
 }
 
+// countWriter counts the bytes written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
 type event struct {
 	Timestamp time.Time     `struct:"@timestamp"`
 	Fields    common.MapStr `struct:",inline"`
@@ -96,6 +112,10 @@ func (b *jsonEncoder) resetState() {
 	}
 }
 
+func (b *jsonEncoder) rawLen() int {
+	return b.buf.Len()
+}
+
 func (b *jsonEncoder) AddHeader(header *http.Header) {
 	header.Add("Content-Type", "application/json; charset=UTF-8")
 }
@@ -152,13 +172,14 @@ func NewGzipEncoder(level int, buf *bytes.Buffer, escapeHTML bool) (*gzipEncoder
 	}
 
 	g := &gzipEncoder{buf: buf, gzip: w, escapeHTML: escapeHTML}
+	g.raw.w = w
 	g.resetState()
 	return g, nil
 }
 
 func (g *gzipEncoder) resetState() {
 	var err error
-	visitor := json.NewVisitor(g.gzip)
+	visitor := json.NewVisitor(&g.raw)
 	visitor.SetEscapeHTML(g.escapeHTML)
 
 	g.folder, err = gotype.NewIterator(visitor,
@@ -173,6 +194,11 @@ func (g *gzipEncoder) resetState() {
 func (b *gzipEncoder) Reset() {
 	b.buf.Reset()
 	b.gzip.Reset(b.buf)
+	b.raw.n = 0
+}
+
+func (b *gzipEncoder) rawLen() int {
+	return b.raw.n
 }
 
 func (b *gzipEncoder) Reader() io.Reader {
@@ -207,7 +233,7 @@ func (b *gzipEncoder) AddRaw(obj interface{}) error {
 		b.resetState()
 	}
 
-	_, err = b.gzip.Write(nl)
+	_, err = b.raw.Write(nl)
 	if err != nil {
 		b.resetState()
 	}
@@ -61,3 +61,53 @@ func TestJSONEncoderMarshalMonitoringEvent(t *testing.T) {
 	assert.Equal(t, encoder.buf.String(), "{\"timestamp\":\"2017-11-07T12:00:00.000Z\",\"field1\":\"value1\"}\n",
 		"Unexpected marshaled format of report.Event")
 }
+
+func TestJSONEncoderMarshalBulkUpdate(t *testing.T) {
+	event := &beat.Event{
+		Timestamp: time.Date(2017, time.November, 7, 12, 0, 0, 0, time.UTC),
+		Fields: common.MapStr{
+			"field1": "value1",
+		},
+	}
+
+	tests := map[string]struct {
+		body interface{}
+		want string
+	}{
+		"partial document": {
+			body: NewBulkUpdateDoc(event, false),
+			want: `{"doc":{"@timestamp":"2017-11-07T12:00:00.000Z","field1":"value1"}}`,
+		},
+		"document as upsert": {
+			body: NewBulkUpdateDoc(event, true),
+			want: `{"doc":{"@timestamp":"2017-11-07T12:00:00.000Z","field1":"value1"},"doc_as_upsert":true}`,
+		},
+		"scripted upsert": {
+			body: NewBulkScriptedUpsert(event, common.MapStr{
+				"source": "ctx._source.count = params.count",
+				"params": map[string]interface{}{"count": 1},
+			}),
+			want: `{
+				"script": {
+					"source": "ctx._source.count = params.count",
+					"params": {
+						"count": 1,
+						"event": {"@timestamp":"2017-11-07T12:00:00.000Z","field1":"value1"}
+					}
+				},
+				"scripted_upsert": true,
+				"upsert": {}
+			}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			encoder := NewJSONEncoder(nil, true)
+			if err := encoder.Marshal(test.body); err != nil {
+				t.Fatalf("Error while marshaling update body using JSONEncoder: %v", err)
+			}
+			assert.JSONEq(t, test.want, encoder.buf.String())
+		})
+	}
+}
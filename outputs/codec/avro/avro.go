@@ -0,0 +1,187 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package avro provides a codec encoding events as Avro records. The schema
+// is read from a file, or from a schema registry compatible with the
+// Confluent Schema Registry.
+package avro
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/linkedin/goavro/v2"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/outputs/codec/schemaregistry"
+)
+
+// Encoder encodes events as Avro records.
+type Encoder struct {
+	codec    *goavro.Codec
+	record   *schemaType
+	schemaID int
+	fields   map[string]string
+	buf      []byte
+}
+
+// Config configures the avro codec.
+type Config struct {
+	// Schema is the inline Avro schema.
+	Schema string `config:"schema"`
+
+	// SchemaFile is the path of a file containing the Avro schema.
+	SchemaFile string `config:"schema_file"`
+
+	// SchemaRegistry configures the schema registry. If set, the schema
+	// ID is prepended to each message using the Confluent wire format.
+	SchemaRegistry *common.Config `config:"schema_registry"`
+
+	// Fields maps top level record fields to event fields. Fields not listed
+	// are read from the event field with the same name.
+	Fields map[string]string `config:"fields"`
+}
+
+func init() {
+	codec.RegisterType("avro", func(_ beat.Info, cfg *common.Config) (codec.Codec, error) {
+		if cfg == nil {
+			return nil, errors.New("empty avro codec configuration")
+		}
+
+		config := Config{}
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+
+		schema, id, err := loadSchema(config)
+		if err != nil {
+			return nil, err
+		}
+		return New(schema, id, config.Fields)
+	})
+}
+
+func (c *Config) Validate() error {
+	if c.Schema != "" && c.SchemaFile != "" {
+		return errors.New("schema and schema_file can not be used together")
+	}
+	if c.Schema == "" && c.SchemaFile == "" && c.SchemaRegistry == nil {
+		return errors.New("one of schema, schema_file or schema_registry must be configured")
+	}
+	return nil
+}
+
+// loadSchema reads the configured schema. If a schema registry is configured,
+// the schema is either registered or read from the registry, and the schema ID
+// is returned.
+func loadSchema(config Config) (string, int, error) {
+	local := config.Schema
+	if config.SchemaFile != "" {
+		raw, err := ioutil.ReadFile(config.SchemaFile)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read avro schema file: %v", err)
+		}
+		local = string(raw)
+	}
+
+	if config.SchemaRegistry == nil {
+		return local, 0, nil
+	}
+
+	registryConfig := schemaregistry.DefaultConfig()
+	if err := config.SchemaRegistry.Unpack(&registryConfig); err != nil {
+		return "", 0, err
+	}
+	if local != "" && !registryConfig.AutoRegister {
+		return "", 0, errors.New("a local avro schema requires auto_register to be enabled for the schema registry")
+	}
+
+	schema, err := schemaregistry.Resolve(registryConfig, schemaregistry.TypeAvro, local)
+	if err != nil {
+		return "", 0, err
+	}
+	if schema.SchemaType != "" && schema.SchemaType != schemaregistry.TypeAvro {
+		return "", 0, fmt.Errorf("registry schema %v is a %v schema", schema.ID, schema.SchemaType)
+	}
+	return schema.Schema, schema.ID, nil
+}
+
+// New creates an Avro encoder for the record schema. If schemaID is not 0,
+// messages are prefixed with the schema ID using the Confluent wire format.
+// fields maps top level record fields to event fields.
+func New(schema string, schemaID int, fields map[string]string) (*Encoder, error) {
+	avroCodec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %v", err)
+	}
+
+	record, err := parseSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	if record.typ != "record" {
+		return nil, fmt.Errorf("avro schema must be a record, got %v", record.typ)
+	}
+
+	for name := range fields {
+		found := false
+		for _, f := range record.fields {
+			found = found || f.name == name
+		}
+		if !found {
+			return nil, fmt.Errorf("field '%v' is not part of the avro schema", name)
+		}
+	}
+
+	return &Encoder{
+		codec:    avroCodec,
+		record:   record,
+		schemaID: schemaID,
+		fields:   fields,
+	}, nil
+}
+
+// Encode serializes a beat event as Avro record. Top level record fields are
+// read from the event using the configured field mapping. The `@timestamp` and
+// `@metadata` fields can be mapped as well.
+func (e *Encoder) Encode(_ string, event *beat.Event) ([]byte, error) {
+	native, err := recordToNative(e.record, false, func(name string) (interface{}, bool) {
+		key := name
+		if mapped, ok := e.fields[name]; ok {
+			key = mapped
+		}
+		v, err := event.GetValue(key)
+		return v, err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := e.buf[:0]
+	if e.schemaID != 0 {
+		buf = schemaregistry.AppendHeader(buf, e.schemaID)
+	}
+	buf, err = e.codec.BinaryFromNative(buf, native)
+	if err != nil {
+		return nil, err
+	}
+	e.buf = buf
+	return buf, nil
+}
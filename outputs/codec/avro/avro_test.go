@@ -0,0 +1,256 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package avro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/outputs/codec/schemaregistry"
+	"github.com/njcx/libbeat_v7/outputs/codec/schemaregistry/registrytest"
+)
+
+const testSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "beats.test",
+  "fields": [
+    {"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "message", "type": "string"},
+    {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["debug", "info", "error"]}, "default": "info"},
+    {"name": "host", "type": ["null", {
+      "type": "record",
+      "name": "Host",
+      "fields": [
+        {"name": "name", "type": "string"},
+        {"name": "ip", "type": {"type": "array", "items": "string"}, "default": []}
+      ]
+    }]},
+    {"name": "status", "type": ["null", "long", "string"], "default": null},
+    {"name": "labels", "type": {"type": "map", "values": "string"}, "default": {}},
+    {"name": "duration", "type": "double", "default": 0}
+  ]
+}`
+
+var testFields = map[string]string{
+	"timestamp": "@timestamp",
+	"level":     "log.level",
+	"duration":  "event.duration",
+}
+
+func TestEncode(t *testing.T) {
+	ts := time.Date(2022, 3, 4, 10, 0, 0, 123000000, time.UTC)
+
+	tests := map[string]struct {
+		fields common.MapStr
+		want   map[string]interface{}
+	}{
+		"all fields": {
+			fields: common.MapStr{
+				"message": "hello",
+				"log":     common.MapStr{"level": "error"},
+				"host":    common.MapStr{"name": "web-1", "ip": []string{"10.0.0.1", "10.0.0.2"}},
+				"status":  200,
+				"labels":  common.MapStr{"env": "prod"},
+				"event":   common.MapStr{"duration": 15},
+			},
+			want: map[string]interface{}{
+				"timestamp": ts,
+				"message":   "hello",
+				"level":     "error",
+				"host": map[string]interface{}{
+					"beats.test.Host": map[string]interface{}{"name": "web-1", "ip": []interface{}{"10.0.0.1", "10.0.0.2"}},
+				},
+				"status":   map[string]interface{}{"long": int64(200)},
+				"labels":   map[string]interface{}{"env": "prod"},
+				"duration": float64(15),
+			},
+		},
+		"defaults and nulls": {
+			fields: common.MapStr{"message": "hello"},
+			want: map[string]interface{}{
+				"timestamp": ts,
+				"message":   "hello",
+				"level":     "info",
+				"host":      nil,
+				"status":    nil,
+				"labels":    map[string]interface{}{},
+				"duration":  float64(0),
+			},
+		},
+		"union prefers matching type": {
+			fields: common.MapStr{"message": "hello", "status": "ok"},
+			want: map[string]interface{}{
+				"timestamp": ts,
+				"message":   "hello",
+				"level":     "info",
+				"host":      nil,
+				"status":    map[string]interface{}{"string": "ok"},
+				"labels":    map[string]interface{}{},
+				"duration":  float64(0),
+			},
+		},
+		"lenient conversion": {
+			fields: common.MapStr{"message": 42, "event": common.MapStr{"duration": "1.5"}},
+			want: map[string]interface{}{
+				"timestamp": ts,
+				"message":   "42",
+				"level":     "info",
+				"host":      nil,
+				"status":    nil,
+				"labels":    map[string]interface{}{},
+				"duration":  1.5,
+			},
+		},
+	}
+
+	enc, err := New(testSchema, 0, testFields)
+	require.NoError(t, err)
+	decoder, err := goavro.NewCodec(testSchema)
+	require.NoError(t, err)
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			raw, err := enc.Encode("test", &beat.Event{Timestamp: ts, Fields: test.fields})
+			require.NoError(t, err)
+
+			native, rest, err := decoder.NativeFromBinary(raw)
+			require.NoError(t, err)
+			assert.Empty(t, rest)
+			assert.Equal(t, test.want, native)
+		})
+	}
+}
+
+func TestEncodeFails(t *testing.T) {
+	tests := map[string]common.MapStr{
+		"missing required field": {},
+		"unknown enum symbol":    {"message": "hello", "log": common.MapStr{"level": "trace"}},
+		"invalid nested record":  {"message": "hello", "host": "web-1"},
+		"invalid number":         {"message": "hello", "event": common.MapStr{"duration": "slow"}},
+	}
+
+	enc, err := New(testSchema, 0, testFields)
+	require.NoError(t, err)
+
+	for name, fields := range tests {
+		fields := fields
+		t.Run(name, func(t *testing.T) {
+			_, err := enc.Encode("test", &beat.Event{Timestamp: time.Now(), Fields: fields})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	registry := registrytest.NewServer()
+	defer registry.Close()
+
+	event := &beat.Event{Timestamp: time.Now(), Fields: common.MapStr{"message": "hello"}}
+
+	t.Run("auto register", func(t *testing.T) {
+		enc := createEncoder(t, common.MapStr{
+			"schema": testSchema,
+			"fields": testFields,
+			"schema_registry": common.MapStr{
+				"url":           registry.URL,
+				"subject":       "events-value",
+				"auto_register": true,
+			},
+		})
+
+		raw, err := enc.Encode("test", event)
+		require.NoError(t, err)
+
+		id, payload, err := schemaregistry.ParseHeader(raw)
+		require.NoError(t, err)
+		schema, ok := registry.Schema(id)
+		require.True(t, ok)
+		assert.Equal(t, "events-value", schema.Subject)
+		assertDecodes(t, schema.Schema, payload)
+	})
+
+	t.Run("latest registered schema", func(t *testing.T) {
+		id := registry.Register("logs-value", schemaregistry.TypeAvro, testSchema)
+		enc := createEncoder(t, common.MapStr{
+			"fields": testFields,
+			"schema_registry": common.MapStr{
+				"url":     registry.URL,
+				"subject": "logs-value",
+			},
+		})
+
+		raw, err := enc.Encode("test", event)
+		require.NoError(t, err)
+
+		gotID, payload, err := schemaregistry.ParseHeader(raw)
+		require.NoError(t, err)
+		assert.Equal(t, id, gotID)
+		assertDecodes(t, testSchema, payload)
+	})
+}
+
+func TestConfigFails(t *testing.T) {
+	tests := map[string]common.MapStr{
+		"no schema":             {},
+		"schema and file":       {"schema": testSchema, "schema_file": "event.avsc"},
+		"missing schema file":   {"schema_file": "/does/not/exist.avsc"},
+		"invalid schema":        {"schema": `{"type": "record"}`},
+		"schema is not record":  {"schema": `"string"`},
+		"unknown mapped field":  {"schema": testSchema, "fields": common.MapStr{"unknown": "message"}},
+		"local schema registry": {"schema": testSchema, "schema_registry": common.MapStr{"url": "http://localhost:8081", "subject": "test"}},
+	}
+
+	for name, cfg := range tests {
+		cfg := cfg
+		t.Run(name, func(t *testing.T) {
+			_, err := codec.CreateEncoder(beat.Info{}, codecConfig(t, cfg))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func createEncoder(t *testing.T, cfg common.MapStr) codec.Codec {
+	enc, err := codec.CreateEncoder(beat.Info{}, codecConfig(t, cfg))
+	require.NoError(t, err)
+	return enc
+}
+
+func codecConfig(t *testing.T, cfg common.MapStr) codec.Config {
+	var config codec.Config
+	require.NoError(t, common.MustNewConfigFrom(common.MapStr{"avro": cfg}).Unpack(&config))
+	return config
+}
+
+func assertDecodes(t *testing.T, schema string, payload []byte) {
+	decoder, err := goavro.NewCodec(schema)
+	require.NoError(t, err)
+
+	native, rest, err := decoder.NativeFromBinary(payload)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, "hello", native.(map[string]interface{})["message"])
+}
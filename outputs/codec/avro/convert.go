@@ -0,0 +1,239 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package avro
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs/codec/internal/convert"
+)
+
+// toNative converts an event value into the native representation of the
+// Avro type t. In strict mode, values are only converted if their Go type
+// corresponds to the Avro type. Strict mode is used to select the union
+// branch matching a value best, before falling back to lenient conversions,
+// like parsing numbers from strings.
+func toNative(t *schemaType, v interface{}, strict bool) (interface{}, error) {
+	if ct, ok := v.(common.Time); ok {
+		v = time.Time(ct)
+	}
+
+	switch t.typ {
+	case "null":
+		if v != nil {
+			return nil, fmt.Errorf("expected null, got %T", v)
+		}
+		return nil, nil
+
+	case "boolean":
+		return convert.Bool(v, strict)
+
+	case "int":
+		switch t.logical {
+		case "date":
+			return convert.Time(v, strict)
+		case "time-millis":
+			return toDuration(v, time.Millisecond, strict)
+		}
+		n, err := convert.Int64(v, strict)
+		if err != nil {
+			return nil, err
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("value %v out of range for int", n)
+		}
+		return int32(n), nil
+
+	case "long":
+		switch t.logical {
+		case "timestamp-millis", "timestamp-micros":
+			return convert.Time(v, strict)
+		case "time-micros":
+			return toDuration(v, time.Microsecond, strict)
+		}
+		return convert.Int64(v, strict)
+
+	case "float":
+		f, err := convert.Float64(v, strict)
+		if err != nil {
+			return nil, err
+		}
+		return float32(f), nil
+
+	case "double":
+		return convert.Float64(v, strict)
+
+	case "string":
+		return convert.String(v, strict)
+
+	case "bytes", "fixed":
+		if t.logical == "decimal" {
+			return toRat(v, strict)
+		}
+		return convert.Bytes(v, strict)
+
+	case "enum":
+		s, err := convert.String(v, strict)
+		if err != nil {
+			return nil, err
+		}
+		for _, symbol := range t.symbols {
+			if s == symbol {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("'%v' is not a symbol of enum %v", s, t.name)
+
+	case "array":
+		rv := reflect.ValueOf(v)
+		if v == nil || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+			return nil, fmt.Errorf("expected array, got %T", v)
+		}
+		arr := make([]interface{}, rv.Len())
+		for i := range arr {
+			item, err := toNative(t.items, rv.Index(i).Interface(), strict)
+			if err != nil {
+				return nil, fmt.Errorf("array item %v: %v", i, err)
+			}
+			arr[i] = item
+		}
+		return arr, nil
+
+	case "map":
+		m, err := convert.Map(v)
+		if err != nil {
+			return nil, err
+		}
+		out := make(map[string]interface{}, len(m))
+		for k, mv := range m {
+			nv, err := toNative(t.values, mv, strict)
+			if err != nil {
+				return nil, fmt.Errorf("map value '%v': %v", k, err)
+			}
+			out[k] = nv
+		}
+		return out, nil
+
+	case "record":
+		m, err := convert.Map(v)
+		if err != nil {
+			return nil, err
+		}
+		return recordToNative(t, strict, func(name string) (interface{}, bool) {
+			v, ok := m[name]
+			return v, ok
+		})
+
+	case "union":
+		if v == nil {
+			if t.hasNull() {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("missing value for union without null")
+		}
+
+		passes := []bool{true}
+		if !strict {
+			passes = append(passes, false)
+		}
+		for _, strictPass := range passes {
+			for _, b := range t.branches {
+				if b.typ == "null" {
+					continue
+				}
+				if nv, err := toNative(b, v, strictPass); err == nil {
+					return goavro.Union(b.unionName(), nv), nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("value of type %T does not match any union branch", v)
+	}
+
+	return nil, fmt.Errorf("unsupported avro type '%v'", t.typ)
+}
+
+// recordToNative converts a record, reading the field values using get.
+// Missing fields are left to their schema default, or set to null if the
+// field type allows null.
+func recordToNative(
+	t *schemaType,
+	strict bool,
+	get func(name string) (interface{}, bool),
+) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(t.fields))
+	for _, f := range t.fields {
+		v, ok := get(f.name)
+		if !ok || v == nil {
+			switch {
+			case f.hasDefault:
+			case f.typ.hasNull():
+				out[f.name] = nil
+			default:
+				return nil, fmt.Errorf("missing value for field '%v'", f.name)
+			}
+			continue
+		}
+
+		nv, err := toNative(f.typ, v, strict)
+		if err != nil {
+			return nil, fmt.Errorf("field '%v': %v", f.name, err)
+		}
+		out[f.name] = nv
+	}
+	return out, nil
+}
+
+func toDuration(v interface{}, unit time.Duration, strict bool) (time.Duration, error) {
+	if d, ok := v.(time.Duration); ok {
+		return d, nil
+	}
+	n, err := convert.Int64(v, strict)
+	if err != nil {
+		return 0, fmt.Errorf("expected duration, got %T", v)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+func toRat(v interface{}, strict bool) (*big.Rat, error) {
+	if s, ok := v.(string); ok {
+		if strict {
+			return nil, fmt.Errorf("expected decimal, got %T", v)
+		}
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, fmt.Errorf("invalid decimal '%v'", s)
+		}
+		return r, nil
+	}
+
+	if n, err := convert.Int64(v, true); err == nil {
+		return new(big.Rat).SetInt64(n), nil
+	}
+	f, err := convert.Float64(v, true)
+	if err != nil {
+		return nil, fmt.Errorf("expected decimal, got %T", v)
+	}
+	return new(big.Rat).SetFloat64(f), nil
+}
@@ -0,0 +1,229 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package avro
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// schemaType is the parsed representation of an Avro schema, used to convert
+// event fields into the native Go values expected by the Avro encoder.
+type schemaType struct {
+	typ      string
+	name     string // full name of named types
+	logical  string
+	fields   []*schemaField
+	symbols  []string
+	items    *schemaType
+	values   *schemaType
+	branches []*schemaType
+}
+
+type schemaField struct {
+	name       string
+	typ        *schemaType
+	hasDefault bool
+}
+
+// logicalTypes lists the logical types with a dedicated native representation
+// in the Avro encoder. Other logical types are encoded using their base type.
+var logicalTypes = map[string]bool{
+	"long.timestamp-millis": true,
+	"long.timestamp-micros": true,
+	"int.time-millis":       true,
+	"long.time-micros":      true,
+	"int.date":              true,
+	"bytes.decimal":         true,
+	"fixed.decimal":         true,
+}
+
+var primitiveTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"int":     true,
+	"long":    true,
+	"float":   true,
+	"double":  true,
+	"bytes":   true,
+	"string":  true,
+}
+
+type schemaParser struct {
+	names map[string]*schemaType
+}
+
+func parseSchema(schema string) (*schemaType, error) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(schema), &raw); err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %v", err)
+	}
+
+	p := &schemaParser{names: map[string]*schemaType{}}
+	return p.parse(raw, "")
+}
+
+func (p *schemaParser) parse(raw interface{}, namespace string) (*schemaType, error) {
+	switch v := raw.(type) {
+	case string:
+		return p.resolve(v, namespace)
+
+	case []interface{}:
+		t := &schemaType{typ: "union"}
+		for _, branch := range v {
+			bt, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			t.branches = append(t.branches, bt)
+		}
+		return t, nil
+
+	case map[string]interface{}:
+		return p.parseComplex(v, namespace)
+
+	default:
+		return nil, fmt.Errorf("invalid avro schema type: %v", raw)
+	}
+}
+
+func (p *schemaParser) resolve(name, namespace string) (*schemaType, error) {
+	if primitiveTypes[name] {
+		return &schemaType{typ: name}, nil
+	}
+	if namespace != "" && !strings.Contains(name, ".") {
+		if t, ok := p.names[namespace+"."+name]; ok {
+			return t, nil
+		}
+	}
+	if t, ok := p.names[name]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown avro type '%v'", name)
+}
+
+func (p *schemaParser) parseComplex(v map[string]interface{}, namespace string) (*schemaType, error) {
+	typ, ok := v["type"].(string)
+	if !ok {
+		// type is a nested schema
+		return p.parse(v["type"], namespace)
+	}
+
+	t := &schemaType{typ: typ}
+	if logical, ok := v["logicalType"].(string); ok && logicalTypes[typ+"."+logical] {
+		t.logical = logical
+	}
+
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		name, _ := v["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("avro %v type without name", typ)
+		}
+		if ns, ok := v["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		if strings.Contains(name, ".") {
+			namespace = name[:strings.LastIndex(name, ".")]
+		} else if namespace != "" {
+			name = namespace + "." + name
+		}
+		t.name = name
+		p.names[name] = t
+	}
+
+	switch typ {
+	case "record", "error":
+		t.typ = "record"
+		fields, _ := v["fields"].([]interface{})
+		for _, raw := range fields {
+			f, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid field in avro record %v", t.name)
+			}
+			name, _ := f["name"].(string)
+			ft, err := p.parse(f["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("field '%v' of record %v: %v", name, t.name, err)
+			}
+			_, hasDefault := f["default"]
+			t.fields = append(t.fields, &schemaField{name: name, typ: ft, hasDefault: hasDefault})
+		}
+
+	case "enum":
+		symbols, _ := v["symbols"].([]interface{})
+		for _, s := range symbols {
+			if str, ok := s.(string); ok {
+				t.symbols = append(t.symbols, str)
+			}
+		}
+
+	case "array":
+		items, err := p.parse(v["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		t.items = items
+
+	case "map":
+		values, err := p.parse(v["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		t.values = values
+
+	case "fixed":
+
+	default:
+		if !primitiveTypes[typ] {
+			named, err := p.resolve(typ, namespace)
+			if err != nil {
+				return nil, err
+			}
+			return named, nil
+		}
+	}
+
+	return t, nil
+}
+
+// unionName returns the name used by the Avro encoder to select a union
+// branch of this type.
+func (t *schemaType) unionName() string {
+	switch {
+	case t.name != "":
+		return t.name
+	case t.logical != "":
+		return t.typ + "." + t.logical
+	default:
+		return t.typ
+	}
+}
+
+func (t *schemaType) hasNull() bool {
+	if t.typ == "null" {
+		return true
+	}
+	for _, b := range t.branches {
+		if b.typ == "null" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cbor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/go-structform/cborl"
+	"github.com/elastic/go-structform/gotype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+func TestCborCodec(t *testing.T) {
+	ts := time.Date(2022, 3, 4, 10, 0, 0, 123000000, time.FixedZone("CET", 3600))
+
+	tests := map[string]struct {
+		event    beat.Event
+		expected map[string]interface{}
+	}{
+		"fields": {
+			event: beat.Event{
+				Timestamp: ts,
+				Fields: common.MapStr{
+					"msg":    "message",
+					"count":  42,
+					"nested": common.MapStr{"list": []interface{}{"a", 1, true}},
+				},
+			},
+			expected: map[string]interface{}{
+				"@timestamp": "2022-03-04T09:00:00.123Z",
+				"@metadata":  map[string]interface{}{"beat": "test", "type": "_doc", "version": "1.2.3"},
+				"msg":        "message",
+				"count":      uint8(42),
+				"nested":     map[string]interface{}{"list": []interface{}{"a", uint8(1), true}},
+			},
+		},
+		"metadata and common.Time": {
+			event: beat.Event{
+				Timestamp: ts,
+				Meta:      common.MapStr{"pipeline": "ingest"},
+				Fields:    common.MapStr{"created": common.Time(ts)},
+			},
+			expected: map[string]interface{}{
+				"@timestamp": "2022-03-04T09:00:00.123Z",
+				"@metadata":  map[string]interface{}{"beat": "test", "type": "_doc", "version": "1.2.3", "pipeline": "ingest"},
+				"created":    "2022-03-04T09:00:00.123Z",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			enc := New("1.2.3")
+			raw, err := enc.Encode("test", &test.event)
+			require.NoError(t, err)
+			assert.True(t, codec.IsBinary(enc))
+
+			var decoded map[string]interface{}
+			unfolder, err := gotype.NewUnfolder(nil)
+			require.NoError(t, err)
+			require.NoError(t, unfolder.SetTarget(&decoded))
+			require.NoError(t, cborl.NewParser(unfolder).Parse(raw))
+			assert.Equal(t, test.expected, decoded)
+		})
+	}
+}
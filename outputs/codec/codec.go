@@ -22,3 +22,16 @@ import "github.com/njcx/libbeat_v7/beat"
 type Codec interface {
 	Encode(index string, event *beat.Event) ([]byte, error)
 }
+
+// Binary is implemented by codecs producing self-delimiting binary messages.
+// Outputs writing events to a byte stream must not separate binary messages
+// with newlines.
+type Binary interface {
+	IsBinary() bool
+}
+
+// IsBinary reports whether the codec produces self-delimiting binary messages.
+func IsBinary(c Codec) bool {
+	b, ok := c.(Binary)
+	return ok && b.IsBinary()
+}
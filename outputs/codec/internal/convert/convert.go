@@ -0,0 +1,165 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package convert provides the conversions of event field values shared by
+// the schema based codecs.
+package convert
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common"
+)
+
+// Map converts v to a map. Maps with string keys, including common.MapStr,
+// are supported.
+func Map(v interface{}) (map[string]interface{}, error) {
+	switch m := v.(type) {
+	case common.MapStr:
+		return m, nil
+	case map[string]interface{}:
+		return m, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if v == nil || rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("expected object, got %T", v)
+	}
+	m := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		m[iter.Key().String()] = iter.Value().Interface()
+	}
+	return m, nil
+}
+
+// Bool converts v to a boolean. Strings are parsed unless strict is set.
+func Bool(v interface{}, strict bool) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		if !strict {
+			return strconv.ParseBool(b)
+		}
+	}
+	return false, fmt.Errorf("expected boolean, got %T", v)
+}
+
+// Int64 converts v to an integer. Unless strict is set, floating point numbers
+// without fraction are accepted, and strings are parsed.
+func Int64(v interface{}, strict bool) (int64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return 0, fmt.Errorf("value %v out of range for long", u)
+		}
+		return int64(u), nil
+	case reflect.Float32, reflect.Float64:
+		if strict {
+			break
+		}
+		f := rv.Float()
+		if f != math.Trunc(f) {
+			return 0, fmt.Errorf("value %v is not an integer", f)
+		}
+		return int64(f), nil
+	case reflect.String:
+		if !strict {
+			return strconv.ParseInt(rv.String(), 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("expected integer, got %T", v)
+}
+
+// Float64 converts v to a floating point number. Strings are parsed unless
+// strict is set.
+func Float64(v interface{}, strict bool) (float64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.String:
+		if !strict {
+			return strconv.ParseFloat(rv.String(), 64)
+		}
+	}
+	return 0, fmt.Errorf("expected number, got %T", v)
+}
+
+// String converts v to a string. Unless strict is set, numbers, booleans,
+// timestamps and values implementing fmt.Stringer are formatted.
+func String(v interface{}, strict bool) (string, error) {
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case []byte:
+		return string(s), nil
+	case time.Time:
+		if !strict {
+			return s.UTC().Format(time.RFC3339Nano), nil
+		}
+	case fmt.Stringer:
+		if !strict {
+			return s.String(), nil
+		}
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		if !strict {
+			return fmt.Sprint(s), nil
+		}
+	}
+	return "", fmt.Errorf("expected string, got %T", v)
+}
+
+// Bytes converts v to a byte slice. Strings are accepted unless strict is set.
+func Bytes(v interface{}, strict bool) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		if !strict {
+			return []byte(b), nil
+		}
+	}
+	return nil, fmt.Errorf("expected bytes, got %T", v)
+}
+
+// Time converts v to a timestamp. Strings in RFC 3339 format are parsed
+// unless strict is set.
+func Time(v interface{}, strict bool) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		if !strict {
+			return time.Parse(time.RFC3339Nano, t)
+		}
+	}
+	return time.Time{}, fmt.Errorf("expected timestamp, got %T", v)
+}
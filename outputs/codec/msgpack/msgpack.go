@@ -0,0 +1,78 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package msgpack provides a codec serializing events to MessagePack.
+package msgpack
+
+import (
+	"github.com/elastic/go-structform/gotype"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+// Encoder for serializing a beat.Event to MessagePack.
+type Encoder struct {
+	visitor visitor
+	folder  *gotype.Iterator
+
+	version string
+}
+
+func init() {
+	codec.RegisterType("msgpack", func(info beat.Info, _ *common.Config) (codec.Codec, error) {
+		return New(info.Version), nil
+	})
+}
+
+// New creates a new MessagePack Encoder.
+func New(version string) *Encoder {
+	e := &Encoder{version: version}
+	e.reset()
+	return e
+}
+
+func (e *Encoder) reset() {
+	var err error
+
+	e.visitor.reset()
+	e.folder, err = gotype.NewIterator(&e.visitor,
+		gotype.Folders(
+			codec.MakeTimestampEncoder(),
+			codec.MakeBCTimestampEncoder(),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Encode serializes a beat event to MessagePack. It adds additional metadata
+// in the `@metadata` namespace. Timestamps are encoded as RFC3339 strings.
+func (e *Encoder) Encode(index string, event *beat.Event) ([]byte, error) {
+	e.visitor.reset()
+	err := e.folder.Fold(makeEvent(index, e.version, event))
+	if err != nil {
+		e.reset()
+		return nil, err
+	}
+	return e.visitor.buf, nil
+}
+
+// IsBinary reports that MessagePack messages are self-delimiting binary data.
+func (e *Encoder) IsBinary() bool { return true }
@@ -0,0 +1,134 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package msgpack
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ugorji "github.com/ugorji/go/codec"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+func TestMsgpackCodec(t *testing.T) {
+	ts := time.Date(2022, 3, 4, 10, 0, 0, 123000000, time.FixedZone("CET", 3600))
+
+	enc := New("1.2.3")
+	assert.True(t, codec.IsBinary(enc))
+
+	raw, err := enc.Encode("test", &beat.Event{
+		Timestamp: ts,
+		Meta:      common.MapStr{"pipeline": "ingest"},
+		Fields: common.MapStr{
+			"msg":     "message",
+			"count":   -42,
+			"ratio":   0.5,
+			"created": common.Time(ts),
+			"nested":  common.MapStr{"list": []interface{}{"a", uint64(300), nil, true}},
+		},
+	})
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	handle := &ugorji.MsgpackHandle{}
+	handle.RawToString = true
+	require.NoError(t, ugorji.NewDecoderBytes(raw, handle).Decode(&decoded))
+
+	assert.Equal(t, map[string]interface{}{
+		"@timestamp": "2022-03-04T09:00:00.123Z",
+		"@metadata": map[interface{}]interface{}{
+			"beat": "test", "type": "_doc", "version": "1.2.3", "pipeline": "ingest",
+		},
+		"msg":     "message",
+		"count":   int64(-42),
+		"ratio":   0.5,
+		"created": "2022-03-04T09:00:00.123Z",
+		"nested": map[interface{}]interface{}{
+			"list": []interface{}{"a", uint64(300), nil, true},
+		},
+	}, decoded)
+}
+
+func TestVisitor(t *testing.T) {
+	tests := map[string]struct {
+		visit    func(v *visitor)
+		expected []byte
+	}{
+		"positive fixint": {func(v *visitor) { v.OnInt(7) }, []byte{0x07}},
+		"negative fixint": {func(v *visitor) { v.OnInt(-1) }, []byte{0xff}},
+		"uint8":           {func(v *visitor) { v.OnUint(200) }, []byte{0xcc, 200}},
+		"uint16":          {func(v *visitor) { v.OnInt(1000) }, []byte{0xcd, 0x03, 0xe8}},
+		"uint64":          {func(v *visitor) { v.OnUint64(math.MaxUint64) }, []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		"int8":            {func(v *visitor) { v.OnInt(-100) }, []byte{0xd0, 0x9c}},
+		"int16":           {func(v *visitor) { v.OnInt(-1000) }, []byte{0xd1, 0xfc, 0x18}},
+		"float32":         {func(v *visitor) { v.OnFloat32(1) }, []byte{0xca, 0x3f, 0x80, 0x00, 0x00}},
+		"nil and bools": {func(v *visitor) {
+			v.OnArrayStart(3, 0)
+			v.OnNil()
+			v.OnBool(true)
+			v.OnBool(false)
+			v.OnArrayFinished()
+		}, []byte{0x93, 0xc0, 0xc3, 0xc2}},
+		"fixstr":    {func(v *visitor) { v.OnString("abc") }, []byte{0xa3, 'a', 'b', 'c'}},
+		"str8":      {func(v *visitor) { v.OnString(strings.Repeat("x", 32)) }, append([]byte{0xd9, 32}, strings.Repeat("x", 32)...)},
+		"empty map": {func(v *visitor) { v.OnObjectStart(-1, 0); v.OnObjectFinished() }, []byte{0x80}},
+		"unknown length": {func(v *visitor) {
+			v.OnObjectStart(-1, 0)
+			v.OnKey("a")
+			v.OnArrayStart(-1, 0)
+			v.OnInt(1)
+			v.OnInt(2)
+			v.OnArrayFinished()
+			v.OnObjectFinished()
+		}, []byte{0x81, 0xa1, 'a', 0x92, 0x01, 0x02}},
+		"declared length": {func(v *visitor) {
+			v.OnObjectStart(5, 0)
+			v.OnKeyRef([]byte("k"))
+			v.OnStringRef([]byte("v"))
+			v.OnObjectFinished()
+		}, []byte{0x81, 0xa1, 'k', 0xa1, 'v'}},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var v visitor
+			test.visit(&v)
+			assert.Equal(t, test.expected, v.buf)
+		})
+	}
+}
+
+func TestVisitorLargeArray(t *testing.T) {
+	var v visitor
+	v.OnArrayStart(-1, 0)
+	for i := 0; i < 20; i++ {
+		v.OnInt(i)
+	}
+	v.OnArrayFinished()
+
+	assert.Equal(t, []byte{0xdc, 0x00, 20}, v.buf[:3])
+	assert.Len(t, v.buf, 23)
+}
@@ -0,0 +1,282 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package msgpack
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/elastic/go-structform"
+)
+
+// visitor is a structform.Visitor writing MessagePack to an in-memory buffer.
+//
+// MessagePack requires the number of entries of maps and arrays to be written
+// before the entries. The lengths reported by gotype can not be relied on, as
+// inline fields are not accounted for in the length of structs. Instead a
+// placeholder is written and replaced with the smallest possible header once
+// the container is finished.
+type visitor struct {
+	buf   []byte
+	stack []container
+}
+
+type container struct {
+	pos   int // position of the header placeholder
+	count int
+	array bool
+}
+
+// placeholderLen is the size of the 32bit map and array headers used as
+// placeholder.
+const placeholderLen = 5
+
+const (
+	codeNil     = 0xc0
+	codeFalse   = 0xc2
+	codeTrue    = 0xc3
+	codeFloat32 = 0xca
+	codeFloat64 = 0xcb
+	codeUint8   = 0xcc
+	codeUint16  = 0xcd
+	codeUint32  = 0xce
+	codeUint64  = 0xcf
+	codeInt8    = 0xd0
+	codeInt16   = 0xd1
+	codeInt32   = 0xd2
+	codeInt64   = 0xd3
+	codeStr8    = 0xd9
+	codeStr16   = 0xda
+	codeStr32   = 0xdb
+	codeArray16 = 0xdc
+	codeArray32 = 0xdd
+	codeMap16   = 0xde
+	codeMap32   = 0xdf
+
+	fixMap   = 0x80
+	fixArray = 0x90
+	fixStr   = 0xa0
+)
+
+func (v *visitor) reset() {
+	v.buf = v.buf[:0]
+	v.stack = v.stack[:0]
+}
+
+// value must be called before writing a value, to count array elements.
+func (v *visitor) value() {
+	if n := len(v.stack); n > 0 && v.stack[n-1].array {
+		v.stack[n-1].count++
+	}
+}
+
+func (v *visitor) OnObjectStart(_ int, _ structform.BaseType) error {
+	v.start(false)
+	return nil
+}
+
+func (v *visitor) OnObjectFinished() error {
+	v.finish()
+	return nil
+}
+
+func (v *visitor) OnKey(s string) error {
+	v.stack[len(v.stack)-1].count++
+	v.appendString(s)
+	return nil
+}
+
+func (v *visitor) OnKeyRef(s []byte) error {
+	v.stack[len(v.stack)-1].count++
+	v.appendStringHeader(len(s))
+	v.buf = append(v.buf, s...)
+	return nil
+}
+
+func (v *visitor) OnArrayStart(_ int, _ structform.BaseType) error {
+	v.start(true)
+	return nil
+}
+
+func (v *visitor) OnArrayFinished() error {
+	v.finish()
+	return nil
+}
+
+func (v *visitor) start(array bool) {
+	v.value()
+	v.stack = append(v.stack, container{pos: len(v.buf), array: array})
+	v.buf = append(v.buf, make([]byte, placeholderLen)...)
+}
+
+func (v *visitor) finish() {
+	c := v.stack[len(v.stack)-1]
+	v.stack = v.stack[:len(v.stack)-1]
+
+	var tmp [placeholderLen]byte
+	header := appendContainerHeader(tmp[:0], c.count, c.array)
+	start := c.pos + len(header)
+	copy(v.buf[start:], v.buf[c.pos+placeholderLen:])
+	v.buf = v.buf[:len(v.buf)-(placeholderLen-len(header))]
+	copy(v.buf[c.pos:], header)
+}
+
+func appendContainerHeader(buf []byte, n int, array bool) []byte {
+	fix, code16, code32 := byte(fixMap), byte(codeMap16), byte(codeMap32)
+	if array {
+		fix, code16, code32 = fixArray, codeArray16, codeArray32
+	}
+
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(buf, code16), uint16(n))
+	default:
+		return appendUint32(append(buf, code32), uint32(n))
+	}
+}
+
+func (v *visitor) OnNil() error {
+	v.value()
+	v.buf = append(v.buf, codeNil)
+	return nil
+}
+
+func (v *visitor) OnBool(b bool) error {
+	v.value()
+	if b {
+		v.buf = append(v.buf, codeTrue)
+	} else {
+		v.buf = append(v.buf, codeFalse)
+	}
+	return nil
+}
+
+func (v *visitor) OnString(s string) error {
+	v.value()
+	v.appendString(s)
+	return nil
+}
+
+func (v *visitor) OnStringRef(s []byte) error {
+	v.value()
+	v.appendStringHeader(len(s))
+	v.buf = append(v.buf, s...)
+	return nil
+}
+
+func (v *visitor) appendString(s string) {
+	v.appendStringHeader(len(s))
+	v.buf = append(v.buf, s...)
+}
+
+func (v *visitor) appendStringHeader(n int) {
+	switch {
+	case n < 32:
+		v.buf = append(v.buf, fixStr|byte(n))
+	case n <= math.MaxUint8:
+		v.buf = append(v.buf, codeStr8, byte(n))
+	case n <= math.MaxUint16:
+		v.buf = appendUint16(append(v.buf, codeStr16), uint16(n))
+	default:
+		v.buf = appendUint32(append(v.buf, codeStr32), uint32(n))
+	}
+}
+
+func (v *visitor) OnInt8(i int8) error   { return v.OnInt64(int64(i)) }
+func (v *visitor) OnInt16(i int16) error { return v.OnInt64(int64(i)) }
+func (v *visitor) OnInt32(i int32) error { return v.OnInt64(int64(i)) }
+func (v *visitor) OnInt(i int) error     { return v.OnInt64(int64(i)) }
+
+// OnInt64 writes integers using the smallest possible representation.
+func (v *visitor) OnInt64(i int64) error {
+	if i >= 0 {
+		return v.OnUint64(uint64(i))
+	}
+
+	v.value()
+	switch {
+	case i >= -32:
+		v.buf = append(v.buf, byte(i))
+	case i >= math.MinInt8:
+		v.buf = append(v.buf, codeInt8, byte(i))
+	case i >= math.MinInt16:
+		v.buf = appendUint16(append(v.buf, codeInt16), uint16(i))
+	case i >= math.MinInt32:
+		v.buf = appendUint32(append(v.buf, codeInt32), uint32(i))
+	default:
+		v.buf = appendUint64(append(v.buf, codeInt64), uint64(i))
+	}
+	return nil
+}
+
+func (v *visitor) OnByte(b byte) error     { return v.OnUint64(uint64(b)) }
+func (v *visitor) OnUint8(u uint8) error   { return v.OnUint64(uint64(u)) }
+func (v *visitor) OnUint16(u uint16) error { return v.OnUint64(uint64(u)) }
+func (v *visitor) OnUint32(u uint32) error { return v.OnUint64(uint64(u)) }
+func (v *visitor) OnUint(u uint) error     { return v.OnUint64(uint64(u)) }
+
+// OnUint64 writes unsigned integers using the smallest possible
+// representation.
+func (v *visitor) OnUint64(u uint64) error {
+	v.value()
+	switch {
+	case u <= 0x7f:
+		v.buf = append(v.buf, byte(u))
+	case u <= math.MaxUint8:
+		v.buf = append(v.buf, codeUint8, byte(u))
+	case u <= math.MaxUint16:
+		v.buf = appendUint16(append(v.buf, codeUint16), uint16(u))
+	case u <= math.MaxUint32:
+		v.buf = appendUint32(append(v.buf, codeUint32), uint32(u))
+	default:
+		v.buf = appendUint64(append(v.buf, codeUint64), u)
+	}
+	return nil
+}
+
+func (v *visitor) OnFloat32(f float32) error {
+	v.value()
+	v.buf = appendUint32(append(v.buf, codeFloat32), math.Float32bits(f))
+	return nil
+}
+
+func (v *visitor) OnFloat64(f float64) error {
+	v.value()
+	v.buf = appendUint64(append(v.buf, codeFloat64), math.Float64bits(f))
+	return nil
+}
+
+func appendUint16(buf []byte, u uint16) []byte {
+	var tmp [2]byte
+	binary.BigEndian.PutUint16(tmp[:], u)
+	return append(buf, tmp[:]...)
+}
+
+func appendUint32(buf []byte, u uint32) []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], u)
+	return append(buf, tmp[:]...)
+}
+
+func appendUint64(buf []byte, u uint64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], u)
+	return append(buf, tmp[:]...)
+}
@@ -0,0 +1,217 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protobuf
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/njcx/libbeat_v7/outputs/codec/internal/convert"
+)
+
+const timestampType protoreflect.FullName = "google.protobuf.Timestamp"
+
+// setFields sets the fields of msg to the values returned by get. Fields for
+// which get reports no value are left unset.
+func setFields(msg protoreflect.Message, get func(name string) (interface{}, bool)) error {
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		v, ok := get(string(fd.Name()))
+		if !ok || v == nil {
+			continue
+		}
+		if err := setField(msg, fd, v); err != nil {
+			return fmt.Errorf("field '%v': %v", fd.Name(), err)
+		}
+	}
+	return nil
+}
+
+func setField(msg protoreflect.Message, fd protoreflect.FieldDescriptor, v interface{}) error {
+	switch {
+	case fd.IsMap():
+		m, err := convert.Map(v)
+		if err != nil {
+			return err
+		}
+		mv := msg.Mutable(fd).Map()
+		for k, elem := range m {
+			if elem == nil {
+				continue
+			}
+			key, err := toScalar(fd.MapKey(), k)
+			if err != nil {
+				return fmt.Errorf("key '%v': %v", k, err)
+			}
+			var value protoreflect.Value
+			if isMessage(fd.MapValue()) {
+				value = mv.NewValue()
+				err = setMessage(value.Message(), elem)
+			} else {
+				value, err = toScalar(fd.MapValue(), elem)
+			}
+			if err != nil {
+				return fmt.Errorf("key '%v': %v", k, err)
+			}
+			mv.Set(key.MapKey(), value)
+		}
+		return nil
+
+	case fd.IsList():
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return fmt.Errorf("expected a list, got %T", v)
+		}
+		list := msg.Mutable(fd).List()
+		for i := 0; i < rv.Len(); i++ {
+			elem := rv.Index(i).Interface()
+			var value protoreflect.Value
+			var err error
+			if isMessage(fd) {
+				value = list.NewElement()
+				err = setMessage(value.Message(), elem)
+			} else {
+				value, err = toScalar(fd, elem)
+			}
+			if err != nil {
+				return fmt.Errorf("index %v: %v", i, err)
+			}
+			list.Append(value)
+		}
+		return nil
+
+	case isMessage(fd):
+		return setMessage(msg.Mutable(fd).Message(), v)
+
+	default:
+		value, err := toScalar(fd, v)
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, value)
+		return nil
+	}
+}
+
+// setMessage fills a nested message from an event value. Timestamps are set
+// from time values, other messages from objects.
+func setMessage(msg protoreflect.Message, v interface{}) error {
+	md := msg.Descriptor()
+	if md.FullName() == timestampType {
+		ts, err := convert.Time(v, false)
+		if err != nil {
+			return err
+		}
+		msg.Set(md.Fields().ByName("seconds"), protoreflect.ValueOfInt64(ts.Unix()))
+		msg.Set(md.Fields().ByName("nanos"), protoreflect.ValueOfInt32(int32(ts.Nanosecond())))
+		return nil
+	}
+
+	m, err := convert.Map(v)
+	if err != nil {
+		return err
+	}
+	return setFields(msg, func(name string) (interface{}, bool) {
+		v, ok := m[name]
+		return v, ok
+	})
+}
+
+func isMessage(fd protoreflect.FieldDescriptor) bool {
+	kind := fd.Kind()
+	return kind == protoreflect.MessageKind || kind == protoreflect.GroupKind
+}
+
+// toScalar converts an event value to the scalar type of the field.
+func toScalar(fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, err := convert.Bool(v, false)
+		return protoreflect.ValueOfBool(b), err
+
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := toInt(v, math.MinInt32, math.MaxInt32)
+		return protoreflect.ValueOfInt32(int32(i)), err
+
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := convert.Int64(v, false)
+		return protoreflect.ValueOfInt64(i), err
+
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		i, err := toInt(v, 0, math.MaxUint32)
+		return protoreflect.ValueOfUint32(uint32(i)), err
+
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		i, err := toInt(v, 0, math.MaxInt64)
+		return protoreflect.ValueOfUint64(uint64(i)), err
+
+	case protoreflect.FloatKind:
+		f, err := convert.Float64(v, false)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+
+	case protoreflect.DoubleKind:
+		f, err := convert.Float64(v, false)
+		return protoreflect.ValueOfFloat64(f), err
+
+	case protoreflect.StringKind:
+		s, err := convert.String(v, false)
+		return protoreflect.ValueOfString(s), err
+
+	case protoreflect.BytesKind:
+		b, err := convert.Bytes(v, false)
+		return protoreflect.ValueOfBytes(b), err
+
+	case protoreflect.EnumKind:
+		return toEnum(fd.Enum(), v)
+
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported field type %v", fd.Kind())
+	}
+}
+
+func toInt(v interface{}, min, max int64) (int64, error) {
+	i, err := convert.Int64(v, false)
+	if err != nil {
+		return 0, err
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("value %v out of range", i)
+	}
+	return i, nil
+}
+
+// toEnum converts an enum value given by name or number.
+func toEnum(ed protoreflect.EnumDescriptor, v interface{}) (protoreflect.Value, error) {
+	if s, ok := v.(string); ok {
+		value := ed.Values().ByName(protoreflect.Name(s))
+		if value == nil {
+			return protoreflect.Value{}, fmt.Errorf("unknown %v value '%v'", ed.FullName(), s)
+		}
+		return protoreflect.ValueOfEnum(value.Number()), nil
+	}
+
+	i, err := toInt(v, math.MinInt32, math.MaxInt32)
+	if err != nil {
+		return protoreflect.Value{}, err
+	}
+	return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+}
@@ -0,0 +1,293 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package protobuf provides a codec encoding events as Protocol Buffers
+// messages. The message type is read from a .proto file, or from a schema
+// registry compatible with the Confluent Schema Registry.
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/outputs/codec/schemaregistry"
+)
+
+// Encoder encodes events as Protocol Buffers messages.
+type Encoder struct {
+	message protoreflect.MessageDescriptor
+	header  []byte
+	fields  map[string]string
+	marshal proto.MarshalOptions
+	buf     []byte
+}
+
+// Config configures the protobuf codec.
+type Config struct {
+	// SchemaFile is the path of the .proto file defining the message.
+	SchemaFile string `config:"schema_file"`
+
+	// ImportPaths lists additional directories used to resolve imports of the
+	// schema file. The directory of the schema file is always searched.
+	ImportPaths []string `config:"import_paths"`
+
+	// Message is the name of the message type events are encoded as. The
+	// name can be fully qualified.
+	Message string `config:"message" validate:"required"`
+
+	// SchemaRegistry configures the schema registry. If set, the schema ID
+	// and message indexes are prepended to each message using the Confluent
+	// wire format.
+	SchemaRegistry *common.Config `config:"schema_registry"`
+
+	// Fields maps top level message fields to event fields. Fields not listed
+	// are read from the event field with the same name.
+	Fields map[string]string `config:"fields"`
+}
+
+// schemaFileName is the name the schema read from the registry is parsed as.
+const schemaFileName = "schema.proto"
+
+func init() {
+	codec.RegisterType("protobuf", func(_ beat.Info, cfg *common.Config) (codec.Codec, error) {
+		if cfg == nil {
+			return nil, errors.New("empty protobuf codec configuration")
+		}
+
+		config := Config{}
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+
+		file, id, err := loadSchema(config)
+		if err != nil {
+			return nil, err
+		}
+
+		md, err := findMessage(file, config.Message)
+		if err != nil {
+			return nil, err
+		}
+		return New(md, id, config.Fields)
+	})
+}
+
+func (c *Config) Validate() error {
+	if c.SchemaFile == "" && c.SchemaRegistry == nil {
+		return errors.New("one of schema_file or schema_registry must be configured")
+	}
+	return nil
+}
+
+// loadSchema parses the configured schema. If a schema registry is
+// configured, the schema is either registered or read from the registry, and
+// the schema ID is returned.
+func loadSchema(config Config) (*desc.FileDescriptor, int, error) {
+	var local string
+	if config.SchemaFile != "" {
+		raw, err := ioutil.ReadFile(config.SchemaFile)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read protobuf schema file: %v", err)
+		}
+		local = string(raw)
+	}
+
+	if config.SchemaRegistry == nil {
+		importPaths := append([]string{filepath.Dir(config.SchemaFile)}, config.ImportPaths...)
+		file, err := parseFile(protoparse.Parser{ImportPaths: importPaths}, filepath.Base(config.SchemaFile))
+		return file, 0, err
+	}
+
+	registryConfig := schemaregistry.DefaultConfig()
+	if err := config.SchemaRegistry.Unpack(&registryConfig); err != nil {
+		return nil, 0, err
+	}
+	if local != "" && !registryConfig.AutoRegister {
+		return nil, 0, errors.New("a local protobuf schema requires auto_register to be enabled for the schema registry")
+	}
+
+	schema, err := schemaregistry.Resolve(registryConfig, schemaregistry.TypeProtobuf, local)
+	if err != nil {
+		return nil, 0, err
+	}
+	if schema.SchemaType != schemaregistry.TypeProtobuf {
+		return nil, 0, fmt.Errorf("registry schema %v is not a protobuf schema", schema.ID)
+	}
+
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{schemaFileName: schema.Schema}),
+	}
+	file, err := parseFile(parser, schemaFileName)
+	return file, schema.ID, err
+}
+
+func parseFile(parser protoparse.Parser, name string) (*desc.FileDescriptor, error) {
+	files, err := parser.ParseFiles(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse protobuf schema: %v", err)
+	}
+	return files[0], nil
+}
+
+// findMessage returns the message type with the given name. The name can be
+// fully qualified, or the name of a top level or nested message.
+func findMessage(file *desc.FileDescriptor, name string) (protoreflect.MessageDescriptor, error) {
+	if md := file.FindMessage(name); md != nil {
+		return resolveMessage(file, md)
+	}
+
+	var found []*desc.MessageDescriptor
+	var walk func([]*desc.MessageDescriptor)
+	walk = func(mds []*desc.MessageDescriptor) {
+		for _, md := range mds {
+			if md.GetName() == name {
+				found = append(found, md)
+			}
+			walk(md.GetNestedMessageTypes())
+		}
+	}
+	walk(file.GetMessageTypes())
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("message '%v' not found in protobuf schema", name)
+	case 1:
+		return resolveMessage(file, found[0])
+	default:
+		return nil, fmt.Errorf("message name '%v' is ambiguous, use the fully qualified name", name)
+	}
+}
+
+// resolveMessage converts the parsed message type, including the files it
+// depends on, into a descriptor usable with dynamic messages.
+func resolveMessage(file *desc.FileDescriptor, md *desc.MessageDescriptor) (protoreflect.MessageDescriptor, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+	var collect func(*desc.FileDescriptor)
+	collect = func(fd *desc.FileDescriptor) {
+		if seen[fd.GetName()] {
+			return
+		}
+		seen[fd.GetName()] = true
+		for _, dep := range fd.GetDependencies() {
+			collect(dep)
+		}
+		set.File = append(set.File, fd.AsFileDescriptorProto())
+	}
+	collect(file)
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf schema: %v", err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(md.GetFullyQualifiedName()))
+	if err != nil {
+		return nil, err
+	}
+	return d.(protoreflect.MessageDescriptor), nil
+}
+
+// New creates a protobuf encoder for the message type. If schemaID is not 0,
+// messages are prefixed with the schema ID and message indexes using the
+// Confluent wire format. fields maps top level message fields to event fields.
+func New(md protoreflect.MessageDescriptor, schemaID int, fields map[string]string) (*Encoder, error) {
+	for name := range fields {
+		if md.Fields().ByName(protoreflect.Name(name)) == nil {
+			return nil, fmt.Errorf("field '%v' is not part of message %v", name, md.FullName())
+		}
+	}
+
+	var header []byte
+	if schemaID != 0 {
+		header = schemaregistry.AppendHeader(nil, schemaID)
+		header = appendMessageIndexes(header, md)
+	}
+
+	return &Encoder{
+		message: md,
+		header:  header,
+		fields:  fields,
+	}, nil
+}
+
+// Encode serializes a beat event as protobuf message. Top level message
+// fields are read from the event using the configured field mapping. The
+// `@timestamp` and `@metadata` fields can be mapped as well.
+func (e *Encoder) Encode(_ string, event *beat.Event) ([]byte, error) {
+	msg := dynamicpb.NewMessage(e.message)
+	err := setFields(msg, func(name string) (interface{}, bool) {
+		key := name
+		if mapped, ok := e.fields[name]; ok {
+			key = mapped
+		}
+		v, err := event.GetValue(key)
+		return v, err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := append(e.buf[:0], e.header...)
+	buf, err = e.marshal.MarshalAppend(buf, msg)
+	if err != nil {
+		return nil, err
+	}
+	e.buf = buf
+	return buf, nil
+}
+
+// appendMessageIndexes appends the path of the message type within its file,
+// as required by the Confluent wire format for protobuf schemas. The indexes
+// are encoded as zig-zag varints, prefixed with the number of indexes. The
+// common case of the first message in the file is encoded as single 0 byte.
+func appendMessageIndexes(buf []byte, md protoreflect.MessageDescriptor) []byte {
+	var indexes []int
+	var d protoreflect.Descriptor = md
+	for {
+		indexes = append([]int{d.Index()}, indexes...)
+		parent, ok := d.Parent().(protoreflect.MessageDescriptor)
+		if !ok {
+			break
+		}
+		d = parent
+	}
+
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return append(buf, 0)
+	}
+
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutVarint(tmp[:], int64(len(indexes)))]...)
+	for _, idx := range indexes {
+		buf = append(buf, tmp[:binary.PutVarint(tmp[:], int64(idx))]...)
+	}
+	return buf
+}
@@ -0,0 +1,304 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protobuf
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/outputs/codec/schemaregistry"
+	"github.com/njcx/libbeat_v7/outputs/codec/schemaregistry/registrytest"
+)
+
+const testSchema = `syntax = "proto3";
+
+package beats.test;
+
+import "google/protobuf/timestamp.proto";
+
+message Event {
+  enum Level {
+    LEVEL_UNSPECIFIED = 0;
+    DEBUG = 1;
+    INFO = 2;
+    ERROR = 3;
+  }
+
+  message Host {
+    string name = 1;
+    repeated string ip = 2;
+  }
+
+  google.protobuf.Timestamp timestamp = 1;
+  string message = 2;
+  Level level = 3;
+  Host host = 4;
+  int32 status = 5;
+  map<string, string> labels = 6;
+  double duration = 7;
+  repeated Host peers = 8;
+}
+
+message Other {
+  string name = 1;
+}
+`
+
+var testFields = map[string]string{
+	"timestamp": "@timestamp",
+	"level":     "log.level",
+	"duration":  "event.duration",
+}
+
+func TestEncode(t *testing.T) {
+	ts := time.Date(2022, 3, 4, 10, 0, 0, 123000000, time.UTC)
+
+	tests := map[string]struct {
+		fields common.MapStr
+		want   string
+	}{
+		"all fields": {
+			fields: common.MapStr{
+				"message": "hello",
+				"log":     common.MapStr{"level": "ERROR"},
+				"host":    common.MapStr{"name": "web-1", "ip": []string{"10.0.0.1", "10.0.0.2"}},
+				"status":  200,
+				"labels":  common.MapStr{"env": "prod"},
+				"event":   common.MapStr{"duration": 15},
+				"peers":   []common.MapStr{{"name": "web-2"}, {"name": "web-3"}},
+			},
+			want: `{
+				"timestamp": "2022-03-04T10:00:00.123Z",
+				"message": "hello",
+				"level": "ERROR",
+				"host": {"name": "web-1", "ip": ["10.0.0.1", "10.0.0.2"]},
+				"status": 200,
+				"labels": {"env": "prod"},
+				"duration": 15,
+				"peers": [{"name": "web-2"}, {"name": "web-3"}]
+			}`,
+		},
+		"missing fields": {
+			fields: common.MapStr{"message": "hello"},
+			want:   `{"timestamp": "2022-03-04T10:00:00.123Z", "message": "hello"}`,
+		},
+		"enum by number": {
+			fields: common.MapStr{"log": common.MapStr{"level": 1}},
+			want:   `{"timestamp": "2022-03-04T10:00:00.123Z", "level": "DEBUG"}`,
+		},
+		"lenient conversion": {
+			fields: common.MapStr{"message": 42, "status": "404", "event": common.MapStr{"duration": "1.5"}},
+			want:   `{"timestamp": "2022-03-04T10:00:00.123Z", "message": "42", "status": 404, "duration": 1.5}`,
+		},
+	}
+
+	md := loadMessage(t, "Event")
+	enc, err := New(md, 0, testFields)
+	require.NoError(t, err)
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			raw, err := enc.Encode("test", &beat.Event{Timestamp: ts, Fields: test.fields})
+			require.NoError(t, err)
+			assert.JSONEq(t, test.want, decode(t, md, raw))
+		})
+	}
+}
+
+func TestEncodeFails(t *testing.T) {
+	tests := map[string]common.MapStr{
+		"unknown enum value":     {"log": common.MapStr{"level": "TRACE"}},
+		"invalid nested message": {"host": "web-1"},
+		"invalid number":         {"event": common.MapStr{"duration": "slow"}},
+		"number out of range":    {"status": int64(1) << 40},
+		"invalid list":           {"peers": "web-2"},
+		"invalid timestamp":      {"ts": "yesterday"},
+	}
+
+	enc, err := New(loadMessage(t, "Event"), 0, map[string]string{"timestamp": "ts", "level": "log.level", "duration": "event.duration"})
+	require.NoError(t, err)
+
+	for name, fields := range tests {
+		fields := fields
+		t.Run(name, func(t *testing.T) {
+			_, err := enc.Encode("test", &beat.Event{Timestamp: time.Now(), Fields: fields})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMessageIndexes(t *testing.T) {
+	tests := map[string][]byte{
+		"Event":                 {0},
+		"Other":                 {2, 2},
+		"beats.test.Other":      {2, 2},
+		"Host":                  {4, 0, 0},
+		"beats.test.Event.Host": {4, 0, 0},
+	}
+
+	for name, want := range tests {
+		name, want := name, want
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, want, appendMessageIndexes(nil, loadMessage(t, name)))
+		})
+	}
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	registry := registrytest.NewServer()
+	defer registry.Close()
+
+	event := &beat.Event{Timestamp: time.Now(), Fields: common.MapStr{"message": "hello"}}
+
+	t.Run("auto register", func(t *testing.T) {
+		enc := createEncoder(t, common.MapStr{
+			"schema_file": writeSchema(t),
+			"message":     "Event",
+			"schema_registry": common.MapStr{
+				"url":           registry.URL,
+				"subject":       "events-value",
+				"auto_register": true,
+			},
+		})
+
+		raw, err := enc.Encode("test", event)
+		require.NoError(t, err)
+
+		id, payload, err := schemaregistry.ParseHeader(raw)
+		require.NoError(t, err)
+		schema, ok := registry.Schema(id)
+		require.True(t, ok)
+		assert.Equal(t, "events-value", schema.Subject)
+		assert.Equal(t, schemaregistry.TypeProtobuf, schema.SchemaType)
+
+		require.Equal(t, byte(0), payload[0], "message indexes")
+		assert.JSONEq(t, `{"message": "hello"}`, decode(t, loadMessage(t, "Event"), payload[1:]))
+	})
+
+	t.Run("latest registered schema", func(t *testing.T) {
+		id := registry.Register("logs-value", schemaregistry.TypeProtobuf, testSchema)
+		enc := createEncoder(t, common.MapStr{
+			"message": "beats.test.Other",
+			"fields":  common.MapStr{"name": "message"},
+			"schema_registry": common.MapStr{
+				"url":     registry.URL,
+				"subject": "logs-value",
+			},
+		})
+
+		raw, err := enc.Encode("test", event)
+		require.NoError(t, err)
+
+		gotID, payload, err := schemaregistry.ParseHeader(raw)
+		require.NoError(t, err)
+		assert.Equal(t, id, gotID)
+		require.Equal(t, []byte{2, 2}, payload[:2], "message indexes")
+		assert.JSONEq(t, `{"name": "hello"}`, decode(t, loadMessage(t, "Other"), payload[2:]))
+	})
+
+	t.Run("registered schema is not protobuf", func(t *testing.T) {
+		registry.Register("avro-value", schemaregistry.TypeAvro, `"string"`)
+		_, err := codec.CreateEncoder(beat.Info{}, codecConfig(t, common.MapStr{
+			"message": "Event",
+			"schema_registry": common.MapStr{
+				"url":     registry.URL,
+				"subject": "avro-value",
+			},
+		}))
+		assert.Error(t, err)
+	})
+}
+
+func TestConfigFails(t *testing.T) {
+	schemaFile := writeSchema(t)
+
+	tests := map[string]common.MapStr{
+		"no schema":             {"message": "Event"},
+		"no message":            {"schema_file": schemaFile},
+		"missing schema file":   {"schema_file": "/does/not/exist.proto", "message": "Event"},
+		"unknown message":       {"schema_file": schemaFile, "message": "Unknown"},
+		"unknown mapped field":  {"schema_file": schemaFile, "message": "Event", "fields": common.MapStr{"unknown": "message"}},
+		"local schema registry": {"schema_file": schemaFile, "message": "Event", "schema_registry": common.MapStr{"url": "http://localhost:8081", "subject": "test"}},
+	}
+
+	for name, cfg := range tests {
+		cfg := cfg
+		t.Run(name, func(t *testing.T) {
+			_, err := codec.CreateEncoder(beat.Info{}, codecConfig(t, cfg))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestInvalidSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invalid.proto")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`message Event { string message = ; }`), 0644))
+
+	_, err := codec.CreateEncoder(beat.Info{}, codecConfig(t, common.MapStr{
+		"schema_file": path,
+		"message":     "Event",
+	}))
+	assert.Error(t, err)
+}
+
+func writeSchema(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "event.proto")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testSchema), 0644))
+	return path
+}
+
+func loadMessage(t *testing.T, name string) protoreflect.MessageDescriptor {
+	file, _, err := loadSchema(Config{SchemaFile: writeSchema(t)})
+	require.NoError(t, err)
+	md, err := findMessage(file, name)
+	require.NoError(t, err)
+	return md
+}
+
+func createEncoder(t *testing.T, cfg common.MapStr) codec.Codec {
+	enc, err := codec.CreateEncoder(beat.Info{}, codecConfig(t, cfg))
+	require.NoError(t, err)
+	return enc
+}
+
+func codecConfig(t *testing.T, cfg common.MapStr) codec.Config {
+	var config codec.Config
+	require.NoError(t, common.MustNewConfigFrom(common.MapStr{"protobuf": cfg}).Unpack(&config))
+	return config
+}
+
+func decode(t *testing.T, md protoreflect.MessageDescriptor, raw []byte) string {
+	msg := dynamicpb.NewMessage(md)
+	require.NoError(t, proto.Unmarshal(raw, msg))
+	js, err := protojson.Marshal(msg)
+	require.NoError(t, err)
+	return string(js)
+}
@@ -0,0 +1,159 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package registrytest provides an in-memory schema registry, compatible with
+// the Confluent Schema Registry REST API, for testing codecs.
+package registrytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/njcx/libbeat_v7/outputs/codec/schemaregistry"
+)
+
+// Server is an in-memory schema registry.
+type Server struct {
+	URL string
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	schemas  []schemaregistry.Schema
+	subjects map[string][]int
+}
+
+// NewServer starts a new registry. The server must be closed after use.
+func NewServer() *Server {
+	s := &Server{subjects: map[string][]int{}}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the registry.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Register adds a new version of a schema to the subject and returns the
+// schema ID. Registering a schema already known for the subject returns the
+// existing ID.
+func (s *Server) Register(subject, schemaType, schema string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if schemaType == "" {
+		schemaType = schemaregistry.TypeAvro
+	}
+
+	for _, id := range s.subjects[subject] {
+		if s.schemas[id-1].Schema == schema {
+			return id
+		}
+	}
+
+	id := len(s.schemas) + 1
+	s.schemas = append(s.schemas, schemaregistry.Schema{
+		ID:         id,
+		Subject:    subject,
+		Version:    len(s.subjects[subject]) + 1,
+		SchemaType: schemaType,
+		Schema:     schema,
+	})
+	s.subjects[subject] = append(s.subjects[subject], id)
+	return id
+}
+
+// Schema returns the schema with the given ID.
+func (s *Server) Schema(id int) (schemaregistry.Schema, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || id > len(s.schemas) {
+		return schemaregistry.Schema{}, false
+	}
+	return s.schemas[id-1], true
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "schemas" && parts[1] == "ids" && r.Method == http.MethodGet:
+		id, _ := strconv.Atoi(parts[2])
+		schema, ok := s.Schema(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, 40403, "Schema not found")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"schema":     schema.Schema,
+			"schemaType": schema.SchemaType,
+		})
+
+	case len(parts) == 3 && parts[0] == "subjects" && parts[2] == "versions" && r.Method == http.MethodPost:
+		subject, _ := url.PathUnescape(parts[1])
+		var req schemaregistry.Schema
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Schema == "" {
+			writeError(w, http.StatusUnprocessableEntity, 42201, "Invalid schema")
+			return
+		}
+		id := s.Register(subject, req.SchemaType, req.Schema)
+		json.NewEncoder(w).Encode(map[string]int{"id": id})
+
+	case len(parts) == 4 && parts[0] == "subjects" && parts[2] == "versions" && r.Method == http.MethodGet:
+		subject, _ := url.PathUnescape(parts[1])
+
+		s.mu.Lock()
+		ids := s.subjects[subject]
+		s.mu.Unlock()
+		if len(ids) == 0 {
+			writeError(w, http.StatusNotFound, 40401, "Subject not found")
+			return
+		}
+
+		version := len(ids)
+		if parts[3] != "latest" {
+			version, _ = strconv.Atoi(parts[3])
+		}
+		if version < 1 || version > len(ids) {
+			writeError(w, http.StatusNotFound, 40402, "Version not found")
+			return
+		}
+
+		schema, _ := s.Schema(ids[version-1])
+		json.NewEncoder(w).Encode(schema)
+
+	default:
+		writeError(w, http.StatusNotFound, 404, "Not found")
+	}
+}
+
+func writeError(w http.ResponseWriter, status, code int, msg string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error_code": code,
+		"message":    msg,
+	})
+}
@@ -0,0 +1,252 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package schemaregistry provides a client for schema registries compatible
+// with the Confluent Schema Registry REST API, and the wire format used to
+// reference registered schemas in encoded messages.
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
+	"github.com/njcx/libbeat_v7/logp"
+)
+
+// Schema types supported by the registry.
+const (
+	TypeAvro     = "AVRO"
+	TypeProtobuf = "PROTOBUF"
+)
+
+// magicByte is the first byte of messages using the Confluent wire format.
+const magicByte = 0
+
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// Config configures the schema registry used by a codec.
+type Config struct {
+	URL          string `config:"url"           validate:"required"`
+	Subject      string `config:"subject"`
+	Version      int    `config:"version"       validate:"min=0"`
+	ID           int    `config:"id"            validate:"min=0"`
+	AutoRegister bool   `config:"auto_register"`
+	Username     string `config:"username"`
+	Password     string `config:"password"`
+
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+// Schema is a schema stored in the registry.
+type Schema struct {
+	ID         int    `json:"id"`
+	Subject    string `json:"subject,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+// Client is a schema registry client.
+type Client struct {
+	url      string
+	username string
+	password string
+	http     *http.Client
+}
+
+type errorResponse struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// DefaultConfig returns the default schema registry configuration.
+func DefaultConfig() Config {
+	return Config{
+		Transport: httpcommon.DefaultHTTPTransportSettings(),
+	}
+}
+
+// Validate checks the registry configuration.
+func (c *Config) Validate() error {
+	if c.ID == 0 && c.Subject == "" {
+		return errors.New("either subject or id must be configured for the schema registry")
+	}
+	if c.AutoRegister && c.Subject == "" {
+		return errors.New("auto_register requires subject to be configured")
+	}
+	return nil
+}
+
+// NewClient creates a client for the registry configured.
+func NewClient(config Config) (*Client, error) {
+	httpClient, err := config.Transport.Client(
+		httpcommon.WithLogger(logp.NewLogger("schemaregistry")),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		url:      strings.TrimSuffix(config.URL, "/"),
+		username: config.Username,
+		password: config.Password,
+		http:     httpClient,
+	}, nil
+}
+
+// Resolve returns the schema to encode events with. If auto_register is
+// enabled, the local schema is registered under the configured subject.
+// Otherwise the schema is read from the registry, either by its ID or by
+// subject and version. A version of 0 selects the latest version.
+func Resolve(config Config, schemaType, local string) (*Schema, error) {
+	client, err := NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case config.AutoRegister && local != "":
+		id, err := client.Register(config.Subject, schemaType, local)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{ID: id, Subject: config.Subject, SchemaType: schemaType, Schema: local}, nil
+
+	case config.ID != 0:
+		return client.SchemaByID(config.ID)
+
+	default:
+		return client.Subject(config.Subject, config.Version)
+	}
+}
+
+// Subject returns the schema registered under the subject with the given
+// version. A version of 0 returns the latest version.
+func (c *Client) Subject(subject string, version int) (*Schema, error) {
+	v := "latest"
+	if version > 0 {
+		v = strconv.Itoa(version)
+	}
+
+	var schema Schema
+	path := "/subjects/" + url.PathEscape(subject) + "/versions/" + v
+	if err := c.do(http.MethodGet, path, nil, &schema); err != nil {
+		return nil, fmt.Errorf("failed to read schema of subject '%v' (version %v): %w", subject, v, err)
+	}
+	return &schema, nil
+}
+
+// SchemaByID returns the schema with the given ID.
+func (c *Client) SchemaByID(id int) (*Schema, error) {
+	var schema Schema
+	if err := c.do(http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &schema); err != nil {
+		return nil, fmt.Errorf("failed to read schema %v: %w", id, err)
+	}
+	schema.ID = id
+	return &schema, nil
+}
+
+// Register registers a schema under the subject and returns its ID. If the
+// schema is already registered, the ID of the existing schema is returned.
+func (c *Client) Register(subject, schemaType, schema string) (int, error) {
+	req := Schema{Schema: schema}
+	if schemaType != TypeAvro {
+		// AVRO is the default and omitted for compatibility with older
+		// registries.
+		req.SchemaType = schemaType
+	}
+
+	var resp Schema
+	path := "/subjects/" + url.PathEscape(subject) + "/versions"
+	if err := c.do(http.MethodPost, path, &req, &resp); err != nil {
+		return 0, fmt.Errorf("failed to register schema for subject '%v': %w", subject, err)
+	}
+	return resp.ID, nil
+}
+
+func (c *Client) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var errResp errorResponse
+		if json.Unmarshal(raw, &errResp) == nil && errResp.Message != "" {
+			return fmt.Errorf("registry returned status %v: %v (error code %v)",
+				resp.StatusCode, errResp.Message, errResp.ErrorCode)
+		}
+		return fmt.Errorf("registry returned status %v", resp.StatusCode)
+	}
+
+	return json.Unmarshal(raw, result)
+}
+
+// AppendHeader appends the Confluent wire format header, referencing the
+// schema with the given ID, to buf.
+func AppendHeader(buf []byte, id int) []byte {
+	var header [5]byte
+	header[0] = magicByte
+	binary.BigEndian.PutUint32(header[1:], uint32(id))
+	return append(buf, header[:]...)
+}
+
+// ParseHeader returns the schema ID and payload of a message encoded using the
+// Confluent wire format.
+func ParseHeader(msg []byte) (int, []byte, error) {
+	if len(msg) < 5 || msg[0] != magicByte {
+		return 0, nil, errors.New("message does not use the schema registry wire format")
+	}
+	return int(binary.BigEndian.Uint32(msg[1:5])), msg[5:], nil
+}
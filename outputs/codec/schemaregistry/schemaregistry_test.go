@@ -0,0 +1,150 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package schemaregistry_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs/codec/schemaregistry"
+	"github.com/njcx/libbeat_v7/outputs/codec/schemaregistry/registrytest"
+)
+
+func TestResolve(t *testing.T) {
+	registry := registrytest.NewServer()
+	defer registry.Close()
+
+	v1 := registry.Register("events-value", schemaregistry.TypeAvro, `"string"`)
+	v2 := registry.Register("events-value", schemaregistry.TypeAvro, `"long"`)
+
+	tests := map[string]struct {
+		config common.MapStr
+		local  string
+		wantID int
+		want   string
+	}{
+		"latest version": {
+			config: common.MapStr{"subject": "events-value"},
+			wantID: v2,
+			want:   `"long"`,
+		},
+		"fixed version": {
+			config: common.MapStr{"subject": "events-value", "version": 1},
+			wantID: v1,
+			want:   `"string"`,
+		},
+		"by id": {
+			config: common.MapStr{"id": v1},
+			wantID: v1,
+			want:   `"string"`,
+		},
+		"auto register new schema": {
+			config: common.MapStr{"subject": "other-value", "auto_register": true},
+			local:  `"double"`,
+			wantID: v2 + 1,
+			want:   `"double"`,
+		},
+		"auto register known schema": {
+			config: common.MapStr{"subject": "events-value", "auto_register": true},
+			local:  `"string"`,
+			wantID: v1,
+			want:   `"string"`,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			config := readConfig(t, registry.URL, test.config)
+			schema, err := schemaregistry.Resolve(config, schemaregistry.TypeAvro, test.local)
+			require.NoError(t, err)
+			assert.Equal(t, test.wantID, schema.ID)
+			assert.Equal(t, test.want, schema.Schema)
+		})
+	}
+}
+
+func TestResolveFails(t *testing.T) {
+	registry := registrytest.NewServer()
+	defer registry.Close()
+
+	tests := map[string]common.MapStr{
+		"unknown subject": {"subject": "unknown"},
+		"unknown id":      {"id": 42},
+	}
+
+	for name, cfg := range tests {
+		cfg := cfg
+		t.Run(name, func(t *testing.T) {
+			config := readConfig(t, registry.URL, cfg)
+			_, err := schemaregistry.Resolve(config, schemaregistry.TypeAvro, "")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		cfg   common.MapStr
+		valid bool
+	}{
+		"subject":                       {cfg: common.MapStr{"url": "http://localhost:8081", "subject": "events-value"}, valid: true},
+		"id":                            {cfg: common.MapStr{"url": "http://localhost:8081", "id": 1}, valid: true},
+		"missing url":                   {cfg: common.MapStr{"subject": "events-value"}, valid: false},
+		"missing subject and id":        {cfg: common.MapStr{"url": "http://localhost:8081"}, valid: false},
+		"auto register without subject": {cfg: common.MapStr{"url": "http://localhost:8081", "id": 1, "auto_register": true}, valid: false},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			config := schemaregistry.DefaultConfig()
+			err := common.MustNewConfigFrom(test.cfg).Unpack(&config)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestHeader(t *testing.T) {
+	msg := schemaregistry.AppendHeader(nil, 258)
+	assert.Equal(t, []byte{0, 0, 0, 1, 2}, msg)
+
+	id, payload, err := schemaregistry.ParseHeader(append(msg, 'x'))
+	require.NoError(t, err)
+	assert.Equal(t, 258, id)
+	assert.Equal(t, []byte("x"), payload)
+
+	_, _, err = schemaregistry.ParseHeader([]byte{1, 0, 0, 0, 1})
+	assert.Error(t, err)
+}
+
+func readConfig(t *testing.T, url string, cfg common.MapStr) schemaregistry.Config {
+	cfg = cfg.Clone()
+	cfg["url"] = url
+
+	config := schemaregistry.DefaultConfig()
+	require.NoError(t, common.MustNewConfigFrom(cfg).Unpack(&config))
+	return config
+}
@@ -0,0 +1,244 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package outputs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/file"
+	"github.com/njcx/libbeat_v7/logp"
+	jsoncodec "github.com/njcx/libbeat_v7/outputs/codec/json"
+	"github.com/njcx/libbeat_v7/paths"
+)
+
+// DeadLetterQueue accepts events an output failed to publish permanently, for
+// example because an event can not be encoded or has been rejected by the
+// remote service. Events handed to the queue count as processed and must not
+// be retried by the output.
+type DeadLetterQueue interface {
+	// Add stores the event together with the reason it could not be
+	// published. The event is reported to the outputs observer as dead
+	// lettered, or as dropped if the event could not be stored.
+	Add(event *beat.Event, reason error)
+}
+
+// DeadLetterConfig configures the on-disk dead letter queue of an output.
+// Dead lettered events are written as newline delimited JSON to a set of
+// rotating files.
+type DeadLetterConfig struct {
+	Path          string `config:"path"`
+	Filename      string `config:"filename"`
+	RotateEveryKb uint   `config:"rotate_every_kb" validate:"min=1"`
+	NumberOfFiles uint   `config:"number_of_files"`
+	Permissions   uint32 `config:"permissions"`
+}
+
+// deadLetterRecord is the document written for every dead lettered event.
+type deadLetterRecord struct {
+	Timestamp time.Time       `json:"@timestamp"`
+	Output    string          `json:"output"`
+	Error     deadLetterError `json:"error"`
+	Event     json.RawMessage `json:"event"`
+}
+
+type deadLetterError struct {
+	Message string `json:"message"`
+}
+
+type dropQueue struct {
+	observer Observer
+}
+
+type deadLetterFile struct {
+	log      *logp.Logger
+	output   string
+	observer Observer
+	rotator  *file.Rotator
+
+	mu  sync.Mutex
+	enc *jsoncodec.Encoder
+}
+
+// rotators keeps one rotator per dead letter file, such that outputs being
+// reloaded or sharing a dead letter file do not rotate each others files.
+var rotators = struct {
+	sync.Mutex
+	files map[string]*file.Rotator
+}{files: map[string]*file.Rotator{}}
+
+func defaultDeadLetterConfig() DeadLetterConfig {
+	return DeadLetterConfig{
+		RotateEveryKb: 10 * 1024,
+		NumberOfFiles: 7,
+		Permissions:   0600,
+	}
+}
+
+// Validate checks the dead letter file settings.
+func (c *DeadLetterConfig) Validate() error {
+	if c.NumberOfFiles < 2 || c.NumberOfFiles > file.MaxBackupsLimit {
+		return fmt.Errorf("The number_of_files to keep should be between 2 and %v",
+			file.MaxBackupsLimit)
+	}
+	return nil
+}
+
+// LoadDeadLetterQueue creates the dead letter queue configured in the
+// `dead_letter` section of an outputs configuration. If the section is missing
+// or disabled, the returned queue drops all events.
+func LoadDeadLetterQueue(
+	info beat.Info,
+	output string,
+	observer Observer,
+	cfg *common.Config,
+) (DeadLetterQueue, error) {
+	if observer == nil {
+		observer = NewNilObserver()
+	}
+
+	if cfg == nil || !cfg.HasField("dead_letter") {
+		return NewDropQueue(observer), nil
+	}
+
+	sub, err := cfg.Child("dead_letter", -1)
+	if err != nil {
+		return nil, err
+	}
+	if !sub.Enabled() {
+		return NewDropQueue(observer), nil
+	}
+
+	config := defaultDeadLetterConfig()
+	if err := sub.Unpack(&config); err != nil {
+		return nil, err
+	}
+
+	return NewDeadLetterFile(info, output, observer, config)
+}
+
+// NewDropQueue returns a dead letter queue reporting all events as dropped.
+func NewDropQueue(observer Observer) DeadLetterQueue {
+	if observer == nil {
+		observer = NewNilObserver()
+	}
+	return &dropQueue{observer: observer}
+}
+
+// NewDeadLetterFile creates a dead letter queue writing events to a set of
+// rotating files.
+func NewDeadLetterFile(
+	info beat.Info,
+	output string,
+	observer Observer,
+	config DeadLetterConfig,
+) (DeadLetterQueue, error) {
+	dir := config.Path
+	if dir == "" {
+		dir = paths.Resolve(paths.Data, "dead_letter")
+	}
+	filename := config.Filename
+	if filename == "" {
+		filename = output
+	}
+	path, err := filepath.Abs(filepath.Join(dir, filename))
+	if err != nil {
+		return nil, err
+	}
+
+	rotators.Lock()
+	defer rotators.Unlock()
+
+	rotator := rotators.files[path]
+	if rotator == nil {
+		rotator, err = file.NewFileRotator(
+			path,
+			file.MaxSizeBytes(config.RotateEveryKb*1024),
+			file.MaxBackups(config.NumberOfFiles),
+			file.Permissions(os.FileMode(config.Permissions)),
+			file.RotateOnStartup(false),
+			file.WithLogger(logp.NewLogger("rotator").With(logp.Namespace("rotator"))),
+		)
+		if err != nil {
+			return nil, err
+		}
+		rotators.files[path] = rotator
+	}
+
+	log := logp.NewLogger("dead_letter")
+	log.Infof("Initialized dead letter queue for output %v. path=%v", output, path)
+
+	return &deadLetterFile{
+		log:      log,
+		output:   output,
+		observer: observer,
+		rotator:  rotator,
+		enc:      jsoncodec.New(info.Version, jsoncodec.Config{}),
+	}, nil
+}
+
+func (q *dropQueue) Add(_ *beat.Event, _ error) {
+	q.observer.Dropped(1)
+}
+
+func (q *deadLetterFile) Add(event *beat.Event, reason error) {
+	if err := q.write(event, reason); err != nil {
+		q.log.Errorf("Failed to write event to dead letter queue, dropping event: %+v", err)
+		q.observer.Dropped(1)
+		return
+	}
+	q.observer.DeadLettered(1)
+}
+
+func (q *deadLetterFile) write(event *beat.Event, reason error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	serialized, err := q.enc.Encode("", event)
+	if err != nil {
+		// The event itself might be the reason for the failure. Store the
+		// events printable representation, so it is not lost.
+		serialized, err = json.Marshal(fmt.Sprintf("%v", event.Fields))
+		if err != nil {
+			return err
+		}
+	}
+
+	msg := "unknown"
+	if reason != nil {
+		msg = reason.Error()
+	}
+	line, err := json.Marshal(deadLetterRecord{
+		Timestamp: time.Now().UTC(),
+		Output:    q.output,
+		Error:     deadLetterError{Message: msg},
+		Event:     serialized,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = q.rotator.Write(append(line, '\n'))
+	return err
+}
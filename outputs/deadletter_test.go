@@ -0,0 +1,143 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+// +build !integration
+
+package outputs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/monitoring"
+)
+
+func TestLoadDeadLetterQueue(t *testing.T) {
+	cases := map[string]struct {
+		config map[string]interface{}
+		file   bool
+	}{
+		"missing section": {
+			config: map[string]interface{}{},
+		},
+		"disabled": {
+			config: map[string]interface{}{
+				"dead_letter.enabled": false,
+				"dead_letter.path":    t.TempDir(),
+			},
+		},
+		"enabled": {
+			config: map[string]interface{}{
+				"dead_letter.path": t.TempDir(),
+			},
+			file: true,
+		},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := common.MustNewConfigFrom(test.config)
+			q, err := LoadDeadLetterQueue(beat.Info{}, "test", nil, cfg)
+			require.NoError(t, err)
+
+			_, isFile := q.(*deadLetterFile)
+			assert.Equal(t, test.file, isFile)
+		})
+	}
+}
+
+func TestLoadDeadLetterQueueInvalid(t *testing.T) {
+	cfg := common.MustNewConfigFrom(map[string]interface{}{
+		"dead_letter.path":            t.TempDir(),
+		"dead_letter.number_of_files": 1,
+	})
+	_, err := LoadDeadLetterQueue(beat.Info{}, "test", nil, cfg)
+	assert.Error(t, err)
+}
+
+func TestDropQueue(t *testing.T) {
+	stats := NewStats(monitoring.NewRegistry())
+	q := NewDropQueue(stats)
+
+	q.Add(&beat.Event{}, errors.New("oops"))
+	assert.Equal(t, uint64(1), stats.dropped.Get())
+	assert.Equal(t, uint64(0), stats.deadLetter.Get())
+}
+
+func TestDeadLetterFile(t *testing.T) {
+	dir := t.TempDir()
+	stats := NewStats(monitoring.NewRegistry())
+
+	config := defaultDeadLetterConfig()
+	config.Path = dir
+	config.Filename = "events"
+	q, err := NewDeadLetterFile(beat.Info{Version: "7.17.0"}, "test", stats, config)
+	require.NoError(t, err)
+
+	q.Add(&beat.Event{
+		Timestamp: time.Now(),
+		Fields:    common.MapStr{"message": "hello"},
+	}, errors.New("rejected"))
+	q.Add(&beat.Event{
+		Timestamp: time.Now(),
+		Fields:    common.MapStr{"message": "world"},
+	}, nil)
+
+	assert.Equal(t, uint64(2), stats.deadLetter.Get())
+	assert.Equal(t, uint64(0), stats.dropped.Get())
+
+	f, err := os.Open(filepath.Join(dir, "events"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	type record struct {
+		Output string `json:"output"`
+		Error  struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Event struct {
+			Message string `json:"message"`
+		} `json:"event"`
+	}
+
+	var records []record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, records, 2)
+
+	assert.Equal(t, "test", records[0].Output)
+	assert.Equal(t, "rejected", records[0].Error.Message)
+	assert.Equal(t, "hello", records[0].Event.Message)
+	assert.Equal(t, "unknown", records[1].Error.Message)
+	assert.Equal(t, "world", records[1].Event.Message)
+}
@@ -0,0 +1,180 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/njcx/libbeat_v7/esleg/eslegclient"
+	"github.com/njcx/libbeat_v7/outputs"
+)
+
+// defaultMaxContentLength is the default http.max_content_length of
+// Elasticsearch, used if the setting can not be read from the cluster.
+const defaultMaxContentLength = 100 * 1024 * 1024
+
+// bulkSizer adapts the number of events sent in a single bulk request to the
+// load of Elasticsearch. Similar to the window of the Logstash output, the
+// size starts at min_size and grows by 50% after each full request answered
+// within the target latency, up to bulk_max_size. It is halved if events are
+// rejected with 429, if a request fails or if it exceeds the maximum content
+// length, and shrinks proportionally if requests take longer than the target
+// latency. The sizer is shared by all clients of the output.
+type bulkSizer struct {
+	mutex sync.Mutex
+
+	size          int
+	minSize       int
+	maxSize       int
+	targetLatency time.Duration
+
+	// maxBytes limits the size of a request before compression. It is read
+	// from the cluster unless configured by max_bytes.
+	maxBytes   int
+	fixedBytes bool
+
+	observer outputs.BulkSizeObserver
+}
+
+func newBulkSizer(config adaptiveBulkConfig, maxSize int, observer outputs.Observer) *bulkSizer {
+	s := &bulkSizer{
+		size:          config.MinSize,
+		minSize:       config.MinSize,
+		maxSize:       maxSize,
+		targetLatency: config.TargetLatency,
+		maxBytes:      int(config.MaxBytes),
+		fixedBytes:    config.MaxBytes > 0,
+	}
+	s.observer, _ = observer.(outputs.BulkSizeObserver)
+	if !s.fixedBytes {
+		s.maxBytes = defaultMaxContentLength
+	}
+	s.report()
+	return s
+}
+
+// get returns the number of events to send in the next bulk request.
+func (s *bulkSizer) get() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.size
+}
+
+// contentLength returns the maximum size of a bulk request before compression.
+func (s *bulkSizer) contentLength() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.maxBytes
+}
+
+// setContentLength updates the maximum size of bulk requests to the
+// http.max_content_length read from the cluster.
+func (s *bulkSizer) setContentLength(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.fixedBytes || s.maxBytes == n {
+		return
+	}
+	s.maxBytes = n
+	s.report()
+}
+
+// update adapts the size after a bulk request of n events was answered after
+// latency, with tooMany events rejected by Elasticsearch with 429.
+func (s *bulkSizer) update(n int, latency time.Duration, tooMany int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case tooMany > 0:
+		s.resize(s.size / 2)
+	case latency > s.targetLatency:
+		// shrink proportionally to the latency, but at most by half
+		size := int(float64(s.size) * float64(s.targetLatency) / float64(latency))
+		if size < s.size/2 {
+			size = s.size / 2
+		}
+		s.resize(size)
+	case n >= s.size:
+		s.resize(int(math.Ceil(1.5 * float64(s.size))))
+	}
+}
+
+// shrink halves the size after a failed request.
+func (s *bulkSizer) shrink() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.resize(s.size / 2)
+}
+
+func (s *bulkSizer) resize(size int) {
+	if size < s.minSize {
+		size = s.minSize
+	}
+	if s.maxSize > 0 && size > s.maxSize {
+		size = s.maxSize
+	}
+	if size == s.size {
+		return
+	}
+	s.size = size
+	s.report()
+}
+
+func (s *bulkSizer) report() {
+	if s.observer != nil {
+		s.observer.BulkSize(s.size, s.maxBytes)
+	}
+}
+
+// readMaxContentLength returns the smallest http.max_content_length of the
+// nodes in the cluster.
+func readMaxContentLength(conn *eslegclient.Connection) (int, error) {
+	_, body, err := conn.Request("GET", "/_nodes/http", "", map[string]string{
+		"filter_path": "nodes.*.http.max_content_length_in_bytes",
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var info struct {
+		Nodes map[string]struct {
+			HTTP struct {
+				MaxContentLength int `json:"max_content_length_in_bytes"`
+			} `json:"http"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return 0, err
+	}
+
+	min := 0
+	for _, node := range info.Nodes {
+		if n := node.HTTP.MaxContentLength; n > 0 && (min == 0 || n < min) {
+			min = n
+		}
+	}
+	if min == 0 {
+		return 0, errors.New("no node reports http.max_content_length")
+	}
+	return min, nil
+}
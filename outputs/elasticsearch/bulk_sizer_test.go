@@ -0,0 +1,81 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkSizer(t *testing.T) {
+	newSizer := func() *bulkSizer {
+		return newBulkSizer(adaptiveBulkConfig{
+			MinSize:       10,
+			TargetLatency: time.Second,
+		}, 40, nil)
+	}
+
+	t.Run("grows after full fast requests up to bulk_max_size", func(t *testing.T) {
+		s := newSizer()
+		s.update(10, 100*time.Millisecond, 0)
+		assert.Equal(t, 15, s.get())
+		s.update(15, 100*time.Millisecond, 0)
+		assert.Equal(t, 23, s.get())
+		s.update(23, 100*time.Millisecond, 0)
+		s.update(35, 100*time.Millisecond, 0)
+		assert.Equal(t, 40, s.get())
+	})
+
+	t.Run("does not grow after partial requests", func(t *testing.T) {
+		s := newSizer()
+		s.update(5, 100*time.Millisecond, 0)
+		assert.Equal(t, 10, s.get())
+	})
+
+	t.Run("halves on too many requests", func(t *testing.T) {
+		s := newSizer()
+		s.size = 40
+		s.update(40, 100*time.Millisecond, 1)
+		assert.Equal(t, 20, s.get())
+		s.update(20, 100*time.Millisecond, 1)
+		s.update(10, 100*time.Millisecond, 1)
+		assert.Equal(t, 10, s.get(), "size must not shrink below min_size")
+	})
+
+	t.Run("shrinks proportionally to slow requests", func(t *testing.T) {
+		s := newSizer()
+		s.size = 40
+		s.update(40, 1250*time.Millisecond, 0)
+		assert.Equal(t, 32, s.get())
+		s.update(32, 10*time.Second, 0)
+		assert.Equal(t, 16, s.get(), "size must shrink by at most half")
+	})
+
+	t.Run("content length", func(t *testing.T) {
+		s := newSizer()
+		assert.Equal(t, defaultMaxContentLength, s.contentLength())
+		s.setContentLength(1024)
+		assert.Equal(t, 1024, s.contentLength())
+
+		fixed := newBulkSizer(adaptiveBulkConfig{MinSize: 10, MaxBytes: 2048, TargetLatency: time.Second}, 40, nil)
+		fixed.setContentLength(1024)
+		assert.Equal(t, 2048, fixed.contentLength())
+	})
+}
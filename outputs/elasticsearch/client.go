@@ -48,6 +48,15 @@ type Client struct {
 	observer           outputs.Observer
 	NonIndexableAction string
 
+	// sizer adapts the size of bulk requests if adaptive_bulk is enabled.
+	sizer *bulkSizer
+
+	// health tracks the health of the host if health_check is enabled.
+	health *hostHealth
+
+	// onConnectFailure is called if the client fails to connect.
+	onConnectFailure func()
+
 	log *logp.Logger
 }
 
@@ -58,6 +67,10 @@ type ClientSettings struct {
 	Pipeline           *outil.Selector
 	Observer           outputs.Observer
 	NonIndexableAction string
+
+	sizer            *bulkSizer
+	health           *hostHealth
+	onConnectFailure func()
 }
 
 type bulkResultStats struct {
@@ -66,6 +79,7 @@ type bulkResultStats struct {
 	fails        int // number of failed events (can be retried)
 	nonIndexable int // number of failed events (not indexable)
 	tooMany      int // number of events receiving HTTP 429 Too Many Requests
+	conflicts    int // number of failed events with `update` due to version conflicts (can be retried)
 }
 
 const (
@@ -131,6 +145,9 @@ func NewClient(
 		pipeline:           pipeline,
 		observer:           s.Observer,
 		NonIndexableAction: s.NonIndexableAction,
+		sizer:              s.sizer,
+		health:             s.health,
+		onConnectFailure:   s.onConnectFailure,
 
 		log: logp.NewLogger("elasticsearch"),
 	}
@@ -178,6 +195,12 @@ func (client *Client) Clone() *Client {
 }
 
 func (client *Client) Publish(ctx context.Context, batch publisher.Batch) error {
+	if err := client.checkHealth(); err != nil {
+		// Return the batch to the other workers while the host is ejected.
+		batch.Cancelled()
+		return err
+	}
+
 	events := batch.Events()
 	rest, err := client.publishEvents(ctx, events)
 	if len(rest) == 0 {
@@ -185,16 +208,27 @@ func (client *Client) Publish(ctx context.Context, batch publisher.Batch) error
 	} else {
 		batch.RetryEvents(rest)
 	}
+	if err == nil {
+		err = client.checkHealth()
+	}
 	return err
 }
 
+// checkHealth returns an error if the host has been ejected by the health
+// checks.
+func (client *Client) checkHealth() error {
+	if client.health == nil {
+		return nil
+	}
+	return client.health.check(client)
+}
+
 // PublishEvents sends all events to elasticsearch. On error a slice with all
 // events not published or confirmed to be processed by elasticsearch will be
 // returned. The input slice backing memory will be reused by return the value.
 func (client *Client) publishEvents(ctx context.Context, data []publisher.Event) ([]publisher.Event, error) {
 	span, ctx := apm.StartSpan(ctx, "publishEvents", "output")
 	defer span.End()
-	begin := time.Now()
 	st := client.observer
 
 	if st != nil {
@@ -205,6 +239,120 @@ func (client *Client) publishEvents(ctx context.Context, data []publisher.Event)
 		return nil, nil
 	}
 
+	if client.sizer != nil {
+		return client.publishAdaptive(ctx, span, data)
+	}
+	failed, _, err := client.publishBulk(ctx, span, data)
+	return failed, err
+}
+
+// publishAdaptive sends the events in bulk requests of the size selected by
+// the bulk sizer. Events failing with a temporary error are collected and
+// returned once all events have been sent. On request errors all events not
+// sent yet are returned.
+func (client *Client) publishAdaptive(ctx context.Context, span *apm.Span, data []publisher.Event) ([]publisher.Event, error) {
+	var failed []publisher.Event
+	var lastErr error
+	for len(data) > 0 {
+		n := client.sizer.get()
+		if n > len(data) {
+			n = len(data)
+		}
+
+		rest, err := client.publishSplit(ctx, span, data[:n])
+		failed = append(failed, rest...)
+		data = data[n:]
+		if err != nil {
+			lastErr = err
+			if err != eslegclient.ErrTempBulkFailure {
+				return append(failed, data...), err
+			}
+		}
+	}
+	return failed, lastErr
+}
+
+// publishSplit sends the events in a single bulk request and updates the bulk
+// sizer. If the request is too large, the events are split in half and sent
+// again. A single event exceeding the maximum request size is handled by the
+// non_indexable_policy.
+func (client *Client) publishSplit(ctx context.Context, span *apm.Span, data []publisher.Event) ([]publisher.Event, error) {
+	begin := time.Now()
+	failed, stats, err := client.publishBulk(ctx, span, data)
+	if !errors.Is(err, eslegclient.ErrBulkTooLarge) {
+		if err != nil && err != eslegclient.ErrTempBulkFailure {
+			client.sizer.shrink()
+		} else {
+			client.sizer.update(len(data), time.Since(begin), stats.tooMany)
+		}
+		return failed, err
+	}
+
+	client.sizer.shrink()
+
+	// failed holds the encodable events of the request.
+	data = failed
+	if len(data) == 1 {
+		return client.publishOversize(data[0])
+	}
+
+	half := len(data) / 2
+	client.log.Debugf("Bulk request of %v events too large, splitting it", len(data))
+	first, err := client.publishSplit(ctx, span, data[:half])
+	rest := append([]publisher.Event{}, first...)
+	if err != nil && err != eslegclient.ErrTempBulkFailure {
+		return append(rest, data[half:]...), err
+	}
+
+	second, err2 := client.publishSplit(ctx, span, data[half:])
+	rest = append(rest, second...)
+	if err2 != nil {
+		err = err2
+	}
+	return rest, err
+}
+
+// publishOversize applies the non_indexable_policy to a single event exceeding
+// the maximum request size. If the event is sent to the dead letter index, it
+// is returned for retry, with the message of the dead letter event truncated
+// to fit into a request.
+func (client *Client) publishOversize(event publisher.Event) ([]publisher.Event, error) {
+	st := client.observer
+	limit := client.sizer.contentLength()
+	msg := []byte(fmt.Sprintf("event exceeds the maximum request size of %v bytes", limit))
+
+	if isDeadLetter(&event) {
+		client.log.Errorf("Can't deliver to dead letter index event (status=%v): %s, dropping event!",
+			http.StatusRequestEntityTooLarge, msg)
+		if st != nil {
+			st.Dropped(1)
+		}
+		return nil, nil
+	}
+
+	if !client.applyNonIndexablePolicy(&event, http.StatusRequestEntityTooLarge, msg) {
+		if st != nil {
+			st.Dropped(1)
+		}
+		return nil, nil
+	}
+
+	// Leave room for the metadata and the error fields of the dead letter
+	// event.
+	if message, ok := event.Content.Fields["message"].(string); ok && len(message) > limit/2 {
+		event.Content.Fields["message"] = message[:limit/2]
+	}
+	if st != nil {
+		st.Failed(1)
+	}
+	return []publisher.Event{event}, eslegclient.ErrTempBulkFailure
+}
+
+// publishBulk sends the events in a single bulk request.
+func (client *Client) publishBulk(ctx context.Context, span *apm.Span, data []publisher.Event) ([]publisher.Event, bulkResultStats, error) {
+	begin := time.Now()
+	st := client.observer
+
 	// encode events into bulk request buffer, dropping failed elements from
 	// events slice
 	origCount := len(data)
@@ -216,15 +364,24 @@ func (client *Client) publishEvents(ctx context.Context, data []publisher.Event)
 		st.Dropped(origCount - newCount)
 	}
 	if newCount == 0 {
-		return nil, nil
+		return nil, bulkResultStats{}, nil
 	}
 
+	sent := time.Now()
 	status, result, sendErr := client.conn.Bulk(ctx, "", "", nil, bulkItems)
+	latency := time.Since(sent)
 	if sendErr != nil {
+		if sendErr == eslegclient.ErrBulkTooLarge {
+			// The request was not sent, let the caller split it.
+			return data, bulkResultStats{}, sendErr
+		}
+		if client.health != nil && !errors.Is(sendErr, eslegclient.ErrBulkTooLarge) {
+			client.health.observe(client, latency, true)
+		}
 		err := apm.CaptureError(ctx, fmt.Errorf("failed to perform any bulk index operations: %w", sendErr))
 		err.Send()
 		client.log.Error(err)
-		return data, sendErr
+		return data, bulkResultStats{}, sendErr
 	}
 	pubCount := len(data)
 	span.Context.SetLabel("events_published", pubCount)
@@ -242,6 +399,9 @@ func (client *Client) publishEvents(ctx context.Context, data []publisher.Event)
 	} else {
 		failedEvents, stats = client.bulkCollectPublishFails(result, data)
 	}
+	if client.health != nil {
+		client.health.observe(client, latency, stats.tooMany > 0)
+	}
 
 	failed := len(failedEvents)
 	span.Context.SetLabel("events_failed", failed)
@@ -261,9 +421,9 @@ func (client *Client) publishEvents(ctx context.Context, data []publisher.Event)
 		if sendErr == nil {
 			sendErr = eslegclient.ErrTempBulkFailure
 		}
-		return failedEvents, sendErr
+		return failedEvents, stats, sendErr
 	}
-	return nil, nil
+	return nil, stats, nil
 }
 
 // bulkEncodePublishRequest encodes all bulk requests and returns slice of events
@@ -278,10 +438,18 @@ func (client *Client) bulkEncodePublishRequest(version common.Version, data []pu
 			client.log.Errorf("Failed to encode event meta data: %+v", err)
 			continue
 		}
-		if opType := events.GetOpType(*event); opType == events.OpTypeDelete {
+		switch events.GetOpType(*event) {
+		case events.OpTypeDelete:
 			// We don't include the event source in a bulk DELETE
 			bulkItems = append(bulkItems, meta)
-		} else {
+		case events.OpTypeUpdate:
+			body, err := createEventUpdateBody(event)
+			if err != nil {
+				client.log.Errorf("Failed to encode event update: %+v", err)
+				continue
+			}
+			bulkItems = append(bulkItems, meta, body)
+		default:
 			bulkItems = append(bulkItems, meta, event)
 		}
 		okEvents = append(okEvents, data[i])
@@ -324,6 +492,19 @@ func (client *Client) createEventBulkMeta(version common.Version, event *beat.Ev
 			return nil, fmt.Errorf("%s %s requires _id", events.FieldMetaOpType, events.OpTypeDelete)
 		}
 	}
+	if opType == events.OpTypeUpdate {
+		if id == "" {
+			return nil, fmt.Errorf("%s %s requires _id", events.FieldMetaOpType, events.OpTypeUpdate)
+		}
+		retries, err := getRetryOnConflict(event)
+		if err != nil {
+			return nil, err
+		}
+		// Ingest pipelines can not be used with updates.
+		meta.Pipeline = ""
+		meta.RetryOnConflict = retries
+		return eslegclient.BulkUpdateAction{Update: meta}, nil
+	}
 	if id != "" || version.Major > 7 || (version.Major == 7 && version.Minor >= 5) {
 		if opType == events.OpTypeIndex {
 			return eslegclient.BulkIndexAction{Index: meta}, nil
@@ -333,6 +514,55 @@ func (client *Client) createEventBulkMeta(version common.Version, event *beat.Ev
 	return eslegclient.BulkIndexAction{Index: meta}, nil
 }
 
+// createEventUpdateBody returns the body of the bulk update of an event. If the
+// event has a script, the update is a scripted upsert. Otherwise the event is
+// merged into the document.
+func createEventUpdateBody(event *beat.Event) (interface{}, error) {
+	if tmp, err := event.Meta.GetValue(events.FieldMetaScript); err == nil {
+		var script common.MapStr
+		switch v := tmp.(type) {
+		case string:
+			script = common.MapStr{"source": v}
+		case common.MapStr:
+			script = v
+		case map[string]interface{}:
+			script = common.MapStr(v)
+		default:
+			return nil, fmt.Errorf("%s metadata is no string or object", events.FieldMetaScript)
+		}
+		return eslegclient.NewBulkScriptedUpsert(event, script), nil
+	}
+
+	docAsUpsert := false
+	if tmp, err := event.Meta.GetValue(events.FieldMetaDocAsUpsert); err == nil {
+		b, ok := tmp.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s metadata is no boolean", events.FieldMetaDocAsUpsert)
+		}
+		docAsUpsert = b
+	}
+	return eslegclient.NewBulkUpdateDoc(event, docAsUpsert), nil
+}
+
+func getRetryOnConflict(event *beat.Event) (int, error) {
+	tmp, err := event.Meta.GetValue(events.FieldMetaRetryOnConflict)
+	if err != nil {
+		return 0, nil
+	}
+
+	switch v := tmp.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case uint64:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("%s metadata is no number", events.FieldMetaRetryOnConflict)
+}
+
 func (client *Client) getPipeline(event *beat.Event) (string, error) {
 	if event.Meta != nil {
 		pipeline, err := events.GetMetaStringValue(*event, events.FieldMetaPipeline)
@@ -379,6 +609,18 @@ func (client *Client) bulkCollectPublishFails(result eslegclient.BulkResult, dat
 		}
 
 		if status == 409 {
+			if events.GetOpType(data[i].Content) == events.OpTypeUpdate {
+				// 409 is used to indicate a version conflict if `update`
+				// op_type is used and retry_on_conflict was exhausted. The
+				// document was changed concurrently, so the update can be
+				// retried.
+				client.log.Debugf("Bulk item update failed with version conflict (i=%v): %s", i, msg)
+				stats.conflicts++
+				stats.fails++
+				failed = append(failed, data[i])
+				continue
+			}
+
 			// 409 is used to indicate an event with same ID already exists if
 			// `create` op_type is used.
 			stats.duplicates++
@@ -388,35 +630,16 @@ func (client *Client) bulkCollectPublishFails(result eslegclient.BulkResult, dat
 		if status < 500 {
 			if status == http.StatusTooManyRequests {
 				stats.tooMany++
-			} else {
-				// hard failure, apply policy action
-				result, _ := data[i].Content.Meta.HasKey(dead_letter_marker_field)
-				if result {
-					stats.nonIndexable++
-					client.log.Errorf("Can't deliver to dead letter index event (status=%v). Enable debug logs to view the event and cause.", status)
-					client.log.Debugf("Can't deliver to dead letter index event %#v (status=%v): %s", data[i], status, msg)
-					// poison pill - this will clog the pipeline if the underlying failure is non transient.
-				} else if client.NonIndexableAction == dead_letter_index {
-					client.log.Warnf("Cannot index event (status=%v), trying dead letter index. Enable debug logs to view the event and cause.", status)
-					client.log.Debugf("Cannot index event %#v (status=%v): %s, trying dead letter index", data[i], status, msg)
-					if data[i].Content.Meta == nil {
-						data[i].Content.Meta = common.MapStr{
-							dead_letter_marker_field: true,
-						}
-					} else {
-						data[i].Content.Meta.Put(dead_letter_marker_field, true)
-					}
-					data[i].Content.Fields = common.MapStr{
-						"message":       data[i].Content.Fields.String(),
-						"error.type":    status,
-						"error.message": string(msg),
-					}
-				} else { // drop
-					stats.nonIndexable++
-					client.log.Warnf("Cannot index event (status=%v): dropping event! Enable debug logs to view the event and cause.", status)
-					client.log.Debugf("Cannot index event %#v (status=%v): %s, dropping event!", data[i], status, msg)
-					continue
-				}
+			} else if isDeadLetter(&data[i]) {
+				// hard failure of a dead letter event
+				stats.nonIndexable++
+				client.log.Errorf("Can't deliver to dead letter index event (status=%v). Enable debug logs to view the event and cause.", status)
+				client.log.Debugf("Can't deliver to dead letter index event %#v (status=%v): %s", data[i], status, msg)
+				// poison pill - this will clog the pipeline if the underlying failure is non transient.
+			} else if !client.applyNonIndexablePolicy(&data[i], status, msg) {
+				// hard failure, event dropped by policy
+				stats.nonIndexable++
+				continue
 			}
 		}
 
@@ -428,8 +651,76 @@ func (client *Client) bulkCollectPublishFails(result eslegclient.BulkResult, dat
 	return failed, stats
 }
 
+// isDeadLetter returns true if the event is already sent to the dead letter
+// index.
+func isDeadLetter(event *publisher.Event) bool {
+	result, _ := event.Content.Meta.HasKey(dead_letter_marker_field)
+	return result
+}
+
+// applyNonIndexablePolicy applies the non_indexable_policy to an event that
+// can not be indexed. It returns true if the event has been turned into a
+// dead letter event that must be retried, false if it is dropped.
+func (client *Client) applyNonIndexablePolicy(event *publisher.Event, status int, msg []byte) bool {
+	if client.NonIndexableAction != dead_letter_index {
+		client.log.Warnf("Cannot index event (status=%v): dropping event! Enable debug logs to view the event and cause.", status)
+		client.log.Debugf("Cannot index event %#v (status=%v): %s, dropping event!", *event, status, msg)
+		return false
+	}
+
+	client.log.Warnf("Cannot index event (status=%v), trying dead letter index. Enable debug logs to view the event and cause.", status)
+	client.log.Debugf("Cannot index event %#v (status=%v): %s, trying dead letter index", *event, status, msg)
+	if event.Content.Meta == nil {
+		event.Content.Meta = common.MapStr{
+			dead_letter_marker_field: true,
+		}
+	} else {
+		event.Content.Meta.Put(dead_letter_marker_field, true)
+	}
+	if events.GetOpType(event.Content) == events.OpTypeUpdate {
+		// Failed updates are indexed as regular documents.
+		event.Content.Meta.Delete(events.FieldMetaOpType)
+	}
+	event.Content.Fields = common.MapStr{
+		"message":       event.Content.Fields.String(),
+		"error.type":    status,
+		"error.message": string(msg),
+	}
+	return true
+}
+
 func (client *Client) Connect() error {
-	return client.conn.Connect()
+	if err := client.checkHealth(); err != nil {
+		return err
+	}
+	if err := client.conn.Connect(); err != nil {
+		if client.health != nil {
+			client.health.fail(client)
+		}
+		if client.onConnectFailure != nil {
+			client.onConnectFailure()
+		}
+		return err
+	}
+	if client.sizer != nil {
+		client.updateMaxContentLength()
+	}
+	return nil
+}
+
+// updateMaxContentLength limits the size of bulk requests to the maximum
+// content length accepted by the cluster.
+func (client *Client) updateMaxContentLength() {
+	if !client.sizer.fixedBytes {
+		n, err := readMaxContentLength(&client.conn)
+		if err != nil {
+			client.log.Debugf("Failed to read http.max_content_length, using %v bytes: %v",
+				client.sizer.contentLength(), err)
+		} else {
+			client.sizer.setContentLength(n)
+		}
+	}
+	client.conn.MaxContentLength = client.sizer.contentLength()
 }
 
 func (client *Client) Close() error {
@@ -22,7 +22,9 @@ package elasticsearch
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -96,6 +98,85 @@ func TestCollectPublishFailMiddle(t *testing.T) {
 	assert.Equal(t, bulkResultStats{acked: 2, fails: 1, tooMany: 1}, stats)
 }
 
+func TestCollectPublishFailVersionConflict(t *testing.T) {
+	client, err := NewClient(
+		ClientSettings{
+			NonIndexableAction: "drop",
+		},
+		nil,
+	)
+	assert.NoError(t, err)
+
+	response := []byte(`
+    { "items": [
+      {"create": {"status": 409, "error": "document already exists"}},
+      {"update": {"status": 409, "error": "version conflict"}},
+      {"update": {"status": 200}}
+    ]}
+  `)
+
+	event := publisher.Event{Content: beat.Event{Fields: common.MapStr{"field": 1}}}
+	update := publisher.Event{Content: beat.Event{
+		Meta:   common.MapStr{e.FieldMetaOpType: "update", e.FieldMetaID: "1"},
+		Fields: common.MapStr{"field": 2},
+	}}
+	events := []publisher.Event{event, update, update}
+
+	res, stats := client.bulkCollectPublishFails(response, events)
+	assert.Equal(t, []publisher.Event{update}, res)
+	assert.Equal(t, bulkResultStats{acked: 1, duplicates: 1, fails: 1, conflicts: 1}, stats)
+}
+
+func TestBulkEncodeUpdateBody(t *testing.T) {
+	cases := map[string]struct {
+		meta  common.MapStr
+		want  interface{}
+		valid bool
+	}{
+		"partial document": {
+			meta:  common.MapStr{},
+			want:  eslegclient.BulkUpdateDoc{},
+			valid: true,
+		},
+		"document as upsert": {
+			meta:  common.MapStr{e.FieldMetaDocAsUpsert: true},
+			want:  eslegclient.BulkUpdateDoc{},
+			valid: true,
+		},
+		"inline script": {
+			meta:  common.MapStr{e.FieldMetaScript: "ctx._source.count++"},
+			want:  eslegclient.BulkScriptedUpsert{},
+			valid: true,
+		},
+		"stored script": {
+			meta:  common.MapStr{e.FieldMetaScript: map[string]interface{}{"id": "merge-host"}},
+			want:  eslegclient.BulkScriptedUpsert{},
+			valid: true,
+		},
+		"invalid script": {
+			meta: common.MapStr{e.FieldMetaScript: 1},
+		},
+		"invalid doc_as_upsert": {
+			meta: common.MapStr{e.FieldMetaDocAsUpsert: "yes"},
+		},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			body, err := createEventUpdateBody(&beat.Event{
+				Meta:   test.meta,
+				Fields: common.MapStr{"message": "test"},
+			})
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, test.want, body)
+		})
+	}
+}
+
 func TestCollectPublishFailDeadLetterQueue(t *testing.T) {
 	client, err := NewClient(
 		ClientSettings{
@@ -495,6 +576,8 @@ func TestBulkEncodeEventsWithOpType(t *testing.T) {
 		{"_id": "", "message": "test 3", "bulkIndex": 4},
 		{"_id": "114", "op_type": e.OpTypeDelete, "message": "test 4", "bulkIndex": 6},
 		{"_id": "115", "op_type": e.OpTypeIndex, "message": "test 5", "bulkIndex": 7},
+		{"_id": "", "op_type": e.OpTypeUpdate, "message": "test 7", "bulkIndex": -1}, // this won't get encoded due to missing _id
+		{"_id": "116", "op_type": e.OpTypeUpdate, "message": "test 8", "bulkIndex": 9},
 	}
 
 	cfg := common.MustNewConfigFrom(common.MapStr{})
@@ -537,8 +620,8 @@ func TestBulkEncodeEventsWithOpType(t *testing.T) {
 	)
 
 	encoded, bulkItems := client.bulkEncodePublishRequest(*common.MustNewVersion(version.GetDefaultVersion()), events)
-	require.Equal(t, len(events)-1, len(encoded), "all events should have been encoded")
-	require.Equal(t, 9, len(bulkItems), "incomplete bulk")
+	require.Equal(t, len(events)-2, len(encoded), "all events should have been encoded")
+	require.Equal(t, 11, len(bulkItems), "incomplete bulk")
 
 	for i := 0; i < len(cases); i++ {
 		bulkEventIndex, _ := cases[i]["bulkIndex"].(int)
@@ -555,6 +638,9 @@ func TestBulkEncodeEventsWithOpType(t *testing.T) {
 			require.Equal(t, e.OpTypeIndex, caseOpType, caseMessage)
 		case eslegclient.BulkDeleteAction:
 			require.Equal(t, e.OpTypeDelete, caseOpType, caseMessage)
+		case eslegclient.BulkUpdateAction:
+			require.Equal(t, e.OpTypeUpdate, caseOpType, caseMessage)
+			require.IsType(t, eslegclient.BulkUpdateDoc{}, bulkItems[bulkEventIndex+1], caseMessage)
 		default:
 			require.FailNow(t, "unknown type")
 		}
@@ -562,6 +648,131 @@ func TestBulkEncodeEventsWithOpType(t *testing.T) {
 
 }
 
+func TestPublishAdaptiveSplitsLargeRequests(t *testing.T) {
+	const maxContentLength = 400
+
+	var requests, published int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintln(w, `{ "version": { "number": "7.10.0" } }`)
+		case "/_nodes/http":
+			fmt.Fprintf(w, `{"nodes":{"a":{"http":{"max_content_length_in_bytes":%d}},"b":{"http":{"max_content_length_in_bytes":%d}}}}`,
+				maxContentLength, 2*maxContentLength)
+		default:
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.LessOrEqual(t, len(body), maxContentLength)
+
+			items := strings.Count(string(body), "\n") / 2
+			requests++
+			published += items
+			fmt.Fprintf(w, `{"items":[%s]}`, strings.TrimSuffix(strings.Repeat(`{"create":{"status":201}},`, items), ","))
+		}
+	}))
+	defer ts.Close()
+
+	sizer := newBulkSizer(adaptiveBulkConfig{MinSize: 16, TargetLatency: time.Minute}, 64, nil)
+	client, err := NewClient(ClientSettings{
+		ConnectionSettings: eslegclient.ConnectionSettings{URL: ts.URL},
+		Index:              outil.MakeSelector(outil.ConstSelectorExpr("test", outil.SelectorLowerCase)),
+		sizer:              sizer,
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, client.Connect())
+	assert.Equal(t, maxContentLength, client.conn.MaxContentLength)
+
+	events := make([]publisher.Event, 16)
+	for i := range events {
+		events[i] = publisher.Event{Content: beat.Event{
+			Fields: common.MapStr{"message": fmt.Sprintf("event %v", i)},
+		}}
+	}
+
+	rest, err := client.publishEvents(context.Background(), events)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, 16, published)
+	assert.Greater(t, requests, 1, "the request should have been split")
+}
+
+func TestPublishAdaptiveOversizeEvent(t *testing.T) {
+	const maxBytes = 200
+
+	cases := map[string]struct {
+		action     string
+		deadLetter bool
+	}{
+		"drop policy drops the event": {
+			action: drop,
+		},
+		"dead letter policy retries the event as dead letter event": {
+			action:     dead_letter_index,
+			deadLetter: true,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			sizer := newBulkSizer(adaptiveBulkConfig{MinSize: 1, MaxBytes: maxBytes, TargetLatency: time.Minute}, 1, nil)
+			client, err := NewClient(ClientSettings{
+				ConnectionSettings: eslegclient.ConnectionSettings{URL: "http://localhost:9200"},
+				Index:              outil.MakeSelector(outil.ConstSelectorExpr("test", outil.SelectorLowerCase)),
+				NonIndexableAction: test.action,
+				sizer:              sizer,
+			}, nil)
+			require.NoError(t, err)
+			client.conn.MaxContentLength = maxBytes
+
+			events := []publisher.Event{{Content: beat.Event{
+				Fields: common.MapStr{"message": strings.Repeat("x", 5*maxBytes)},
+			}}}
+			rest, err := client.publishEvents(context.Background(), events)
+
+			if !test.deadLetter {
+				assert.NoError(t, err)
+				assert.Empty(t, rest)
+				return
+			}
+
+			assert.Equal(t, eslegclient.ErrTempBulkFailure, err)
+			require.Len(t, rest, 1)
+			assert.True(t, isDeadLetter(&rest[0]))
+			fields := rest[0].Content.Fields
+			assert.LessOrEqual(t, len(fields["message"].(string)), maxBytes/2)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, fields["error.type"])
+		})
+	}
+}
+
+func TestPublishEjectedHostCancelsBatch(t *testing.T) {
+	group := newHostHealthGroup(healthCheckConfig{
+		MaxLatency:   time.Second,
+		MaxErrorRate: 0.5,
+		MinRequests:  1,
+		Cooldown:     time.Minute,
+	}, nil)
+	group.get("http://other:9200", 1)
+	health := group.get("http://localhost:9200", 1)
+	health.observe(nil, time.Millisecond, true)
+	require.Equal(t, hostEjected, health.state)
+
+	client, err := NewClient(ClientSettings{
+		ConnectionSettings: eslegclient.ConnectionSettings{URL: "http://localhost:9200"},
+		Index:              outil.MakeSelector(outil.ConstSelectorExpr("test", outil.SelectorLowerCase)),
+		health:             health,
+	}, nil)
+	require.NoError(t, err)
+
+	batch := outest.NewBatch(beat.Event{Fields: common.MapStr{"message": "test"}})
+	err = client.Publish(context.Background(), batch)
+	assert.True(t, errors.Is(err, errHostEjected))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchCancelled, batch.Signals[0].Tag)
+
+	assert.True(t, errors.Is(client.Connect(), errHostEjected))
+}
+
 func TestClientWithAPIKey(t *testing.T) {
 	var headers http.Header
 
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/cfgtype"
 
 	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
 	"github.com/njcx/libbeat_v7/common/transport/kerberos"
@@ -43,17 +44,50 @@ type elasticsearchConfig struct {
 	MaxRetries         int                     `config:"max_retries"`
 	Backoff            Backoff                 `config:"backoff"`
 	NonIndexablePolicy *common.ConfigNamespace `config:"non_indexable_policy"`
+	AdaptiveBulk       adaptiveBulkConfig      `config:"adaptive_bulk"`
+	HealthCheck        healthCheckConfig       `config:"health_check"`
+	HostWeights        []hostWeightConfig      `config:"host_weights"`
+	Sniffing           sniffingConfig          `config:"sniffing"`
 
 	Transport httpcommon.HTTPTransportSettings `config:",inline"`
 }
 
+type adaptiveBulkConfig struct {
+	Enabled       bool             `config:"enabled"`
+	MinSize       int              `config:"min_size" validate:"min=1"`
+	MaxBytes      cfgtype.ByteSize `config:"max_bytes"`
+	TargetLatency time.Duration    `config:"target_latency" validate:"positive"`
+}
+
+type healthCheckConfig struct {
+	Enabled      bool          `config:"enabled"`
+	MaxLatency   time.Duration `config:"max_latency" validate:"positive"`
+	MaxErrorRate float64       `config:"max_error_rate"`
+	MinRequests  int           `config:"min_requests" validate:"min=1"`
+	Cooldown     time.Duration `config:"cooldown" validate:"positive"`
+}
+
+type hostWeightConfig struct {
+	Host   string `config:"host" validate:"required"`
+	Weight int    `config:"weight" validate:"min=1"`
+}
+
+type sniffingConfig struct {
+	Enabled    bool              `config:"enabled"`
+	Interval   time.Duration     `config:"interval" validate:"min=0"`
+	OnFailure  bool              `config:"on_failure"`
+	Roles      []string          `config:"roles"`
+	Attributes map[string]string `config:"attributes"`
+}
+
 type Backoff struct {
 	Init time.Duration
 	Max  time.Duration
 }
 
 const (
-	defaultBulkSize = 50
+	defaultBulkSize         = 50
+	defaultAdaptiveBulkSize = 3200
 )
 
 var (
@@ -69,6 +103,20 @@ var (
 		EscapeHTML:       false,
 		Kerberos:         nil,
 		LoadBalance:      true,
+		AdaptiveBulk: adaptiveBulkConfig{
+			MinSize:       defaultBulkSize,
+			TargetLatency: 2 * time.Second,
+		},
+		HealthCheck: healthCheckConfig{
+			MaxLatency:   10 * time.Second,
+			MaxErrorRate: 0.5,
+			MinRequests:  10,
+			Cooldown:     30 * time.Second,
+		},
+		Sniffing: sniffingConfig{
+			Interval:  5 * time.Minute,
+			OnFailure: true,
+		},
 		Backoff: Backoff{
 			Init: 1 * time.Second,
 			Max:  60 * time.Second,
@@ -82,5 +130,17 @@ func (c *elasticsearchConfig) Validate() error {
 		return fmt.Errorf("cannot set both api_key and username/password")
 	}
 
+	if c.AdaptiveBulk.Enabled && c.BulkMaxSize > 0 && c.AdaptiveBulk.MinSize > c.BulkMaxSize {
+		return fmt.Errorf("adaptive_bulk.min_size must not be larger than bulk_max_size")
+	}
+
+	if r := c.HealthCheck.MaxErrorRate; r <= 0 || r > 1 {
+		return fmt.Errorf("health_check.max_error_rate must be in the range (0, 1], got %v", r)
+	}
+
+	if c.Sniffing.Enabled && !c.LoadBalance {
+		return fmt.Errorf("sniffing requires loadbalance to be enabled")
+	}
+
 	return nil
 }
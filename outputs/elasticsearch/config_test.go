@@ -119,6 +119,20 @@ compression_level: 0
 	assert.Equal(t, 0, elasticsearchOutputConfig.CompressionLevel, "Explicit compression level should override defaults")
 }
 
+func TestAdaptiveBulkMinSizeLargerThanBulkMaxSize(t *testing.T) {
+	config := `
+bulk_max_size: 100
+adaptive_bulk:
+    enabled: true
+    min_size: 200
+`
+	c := common.MustNewConfigFrom(config)
+	_, err := readConfig(c)
+	if err == nil {
+		t.Fatalf("Can create test configuration from invalid input")
+	}
+}
+
 func readConfig(cfg *common.Config) (*elasticsearchConfig, error) {
 	c := defaultConfig
 	if err := cfg.Unpack(&c); err != nil {
@@ -18,6 +18,8 @@
 package elasticsearch
 
 import (
+	"fmt"
+
 	"github.com/njcx/libbeat_v7/beat"
 	"github.com/njcx/libbeat_v7/common"
 	"github.com/njcx/libbeat_v7/esleg/eslegclient"
@@ -40,7 +42,11 @@ func makeES(
 ) (outputs.Group, error) {
 	log := logp.NewLogger(logSelector)
 	if !cfg.HasField("bulk_max_size") {
-		cfg.SetInt("bulk_max_size", -1, defaultBulkSize)
+		bulkSize := defaultBulkSize
+		if enabled, _ := cfg.Bool("adaptive_bulk.enabled", -1); enabled {
+			bulkSize = defaultAdaptiveBulkSize
+		}
+		cfg.SetInt("bulk_max_size", -1, int64(bulkSize))
 	}
 
 	index, pipeline, err := buildSelectors(im, beat, cfg)
@@ -64,6 +70,11 @@ func makeES(
 		return outputs.Fail(err)
 	}
 
+	weights, err := readHostWeights(hosts, config.HostWeights)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
 	if proxyURL := config.Transport.Proxy.URL; proxyURL != nil && !config.Transport.Proxy.Disable {
 		log.Debugf("breaking down proxy URL. Scheme: '%s', host[:port]: '%s', path: '%s'", proxyURL.Scheme, proxyURL.Host, proxyURL.Path)
 		log.Infof("Using proxy URL: %s", proxyURL)
@@ -81,46 +92,159 @@ func makeES(
 		}
 	}
 
-	clients := make([]outputs.NetworkClient, len(hosts))
-	for i, host := range hosts {
+	var sizer *bulkSizer
+	if config.AdaptiveBulk.Enabled {
+		sizer = newBulkSizer(config.AdaptiveBulk, config.BulkMaxSize, observer)
+	}
+
+	var healthGroup *hostHealthGroup
+	if config.HealthCheck.Enabled {
+		healthGroup = newHostHealthGroup(config.HealthCheck, observer)
+	}
+
+	connectionSettings := func(esURL string) eslegclient.ConnectionSettings {
+		return eslegclient.ConnectionSettings{
+			URL:              esURL,
+			Beatname:         beat.Beat,
+			Kerberos:         config.Kerberos,
+			Username:         config.Username,
+			Password:         config.Password,
+			APIKey:           config.APIKey,
+			Parameters:       params,
+			Headers:          config.Headers,
+			CompressionLevel: config.CompressionLevel,
+			Observer:         observer,
+			EscapeHTML:       config.EscapeHTML,
+			Transport:        config.Transport,
+		}
+	}
+
+	var sniff *sniffer
+	onConnectFailure := func() {
+		if sniff != nil && config.Sniffing.OnFailure {
+			sniff.trigger()
+		}
+	}
+
+	// makeClients creates the clients of a host. A host with weight n is
+	// served by n times as many workers.
+	makeClients := func(esURL string, weight int) ([]outputs.NetworkClient, error) {
+		var health *hostHealth
+		if healthGroup != nil {
+			health = healthGroup.get(esURL, weight)
+		}
+
+		clients := make([]outputs.NetworkClient, 0, weight)
+		for i := 0; i < weight; i++ {
+			client, err := NewClient(ClientSettings{
+				ConnectionSettings: connectionSettings(esURL),
+				Index:              index,
+				Pipeline:           pipeline,
+				Observer:           observer,
+				NonIndexableAction: policy.action(),
+				sizer:              sizer,
+				health:             health,
+				onConnectFailure:   onConnectFailure,
+			}, &connectCallbackRegistry)
+			if err != nil {
+				return nil, err
+			}
+
+			clients = append(clients, outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max))
+		}
+		return clients, nil
+	}
+
+	var seeds []string
+	urlWeights := map[string]int{}
+	clients := make([]outputs.NetworkClient, 0, len(hosts))
+	for _, host := range hosts {
 		esURL, err := common.MakeURL(config.Protocol, config.Path, host, 9200)
 		if err != nil {
 			log.Errorf("Invalid host param set: %s, Error: %+v", host, err)
 			return outputs.Fail(err)
 		}
 
-		var client outputs.NetworkClient
-		client, err = NewClient(ClientSettings{
-			ConnectionSettings: eslegclient.ConnectionSettings{
-				URL:              esURL,
-				Beatname:         beat.Beat,
-				Kerberos:         config.Kerberos,
-				Username:         config.Username,
-				Password:         config.Password,
-				APIKey:           config.APIKey,
-				Parameters:       params,
-				Headers:          config.Headers,
-				CompressionLevel: config.CompressionLevel,
-				Observer:         observer,
-				EscapeHTML:       config.EscapeHTML,
-				Transport:        config.Transport,
-			},
-			Index:              index,
-			Pipeline:           pipeline,
-			Observer:           observer,
-			NonIndexableAction: policy.action(),
-		}, &connectCallbackRegistry)
+		if _, exists := urlWeights[esURL]; !exists {
+			seeds = append(seeds, esURL)
+		}
+		urlWeights[esURL] = weights[host]
+		if config.Sniffing.Enabled {
+			// The clients are created by the sniffer.
+			continue
+		}
+
+		hostClients, err := makeClients(esURL, weights[host])
 		if err != nil {
 			return outputs.Fail(err)
 		}
+		clients = append(clients, hostClients...)
+	}
+
+	if config.Sniffing.Enabled {
+		workers, err := cfg.Int("worker", -1)
+		if err != nil || workers < 1 {
+			workers = 1
+		}
+
+		// The health of a node is released once the sniffer removed its
+		// clients, such that it no longer counts as a healthy host.
+		removeHealth := func(esURL string) {
+			if healthGroup != nil {
+				healthGroup.remove(esURL)
+			}
+		}
+
+		sniff = newSniffer(config.Sniffing, seeds,
+			func(esURL string) (*eslegclient.Connection, error) {
+				settings := connectionSettings(esURL)
+				settings.Observer = nil
+				return eslegclient.NewConnection(settings)
+			},
+			func(esURL string) ([]outputs.NetworkClient, error) {
+				weight, exists := urlWeights[esURL]
+				if !exists {
+					weight = 1
+				}
+
+				var clients []outputs.NetworkClient
+				for i := 0; i < workers; i++ {
+					hostClients, err := makeClients(esURL, weight)
+					if err != nil {
+						removeHealth(esURL)
+						return nil, err
+					}
+					clients = append(clients, hostClients...)
+				}
+				return clients, nil
+			},
+			removeHealth,
+		)
 
-		client = outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max)
-		clients[i] = client
+		group, err := outputs.Success(config.BulkMaxSize, config.MaxRetries)
+		group.Dynamic = sniff
+		return group, err
 	}
 
 	return outputs.SuccessNet(config.LoadBalance, config.BulkMaxSize, config.MaxRetries, clients)
 }
 
+// readHostWeights returns the weight of each host. Hosts not listed in
+// host_weights have a weight of 1.
+func readHostWeights(hosts []string, config []hostWeightConfig) (map[string]int, error) {
+	weights := make(map[string]int, len(hosts))
+	for _, host := range hosts {
+		weights[host] = 1
+	}
+	for _, w := range config {
+		if _, exists := weights[w.Host]; !exists {
+			return nil, fmt.Errorf("host_weights entry %v does not match any of the configured hosts", w.Host)
+		}
+		weights[w.Host] = w.Weight
+	}
+	return weights, nil
+}
+
 func buildSelectors(
 	im outputs.IndexManager,
 	beat beat.Info,
@@ -0,0 +1,259 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+)
+
+type hostState uint8
+
+const (
+	hostHealthy hostState = iota
+	hostEjected
+	hostProbing
+)
+
+// healthDecay is the weight of the latest request in the moving averages of
+// the request latency and error rate of a host.
+const healthDecay = 0.2
+
+var errHostEjected = errors.New("host ejected after failing health checks")
+
+func (s hostState) String() string {
+	switch s {
+	case hostHealthy:
+		return "healthy"
+	case hostEjected:
+		return "ejected"
+	case hostProbing:
+		return "probing"
+	default:
+		return "unknown"
+	}
+}
+
+// hostHealthGroup tracks the health of all hosts of the output. Hosts whose
+// average latency or error rate exceed the configured limits are ejected for
+// the cooldown period, after which a single client probes whether they
+// recovered, while the other clients of the host stay ejected. The last healthy host is never ejected, such that events are
+// still published, subject to the generic backoff, if all hosts degrade.
+type hostHealthGroup struct {
+	mutex    sync.Mutex
+	config   healthCheckConfig
+	hosts    map[string]*hostHealth
+	observer outputs.HostHealthObserver
+	log      *logp.Logger
+}
+
+// hostHealth is the health of a single host, shared by all clients publishing
+// to it. All fields are protected by the mutex of the group.
+type hostHealth struct {
+	group  *hostHealthGroup
+	host   string
+	weight int
+
+	state        hostState
+	prober       *Client // client sending the probe while probing
+	requests     int
+	latency      time.Duration
+	errorRate    float64
+	ejectedUntil time.Time
+	ejections    uint64
+	removed      bool // set once the clients of the host have been removed
+}
+
+func newHostHealthGroup(config healthCheckConfig, observer outputs.Observer) *hostHealthGroup {
+	g := &hostHealthGroup{
+		config: config,
+		hosts:  map[string]*hostHealth{},
+		log:    logp.NewLogger(logSelector),
+	}
+	g.observer, _ = observer.(outputs.HostHealthObserver)
+	return g
+}
+
+// get returns the health of host, adding it to the group if required.
+func (g *hostHealthGroup) get(host string, weight int) *hostHealth {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	h, exists := g.hosts[host]
+	if !exists {
+		h = &hostHealth{group: g, host: host}
+		g.hosts[host] = h
+	}
+	h.weight = weight
+	h.report()
+	return h
+}
+
+// remove drops host from the group once all its clients have been removed, such
+// that it is no longer counted as a healthy peer nor reported. Clients of the
+// host still publishing keep updating the health, which is not reported
+// anymore.
+func (g *hostHealthGroup) remove(host string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	h, exists := g.hosts[host]
+	if !exists {
+		return
+	}
+	delete(g.hosts, host)
+	h.removed = true
+	if g.observer != nil {
+		g.observer.HostRemoved(host)
+	}
+}
+
+// healthyPeers returns the number of healthy hosts other than h. Only hosts
+// that still have clients are part of the group.
+func (g *hostHealthGroup) healthyPeers(h *hostHealth) int {
+	n := 0
+	for _, other := range g.hosts {
+		if other != h && other.state == hostHealthy {
+			n++
+		}
+	}
+	return n
+}
+
+// check returns an error if client must not publish to the host. Once the
+// cooldown of an ejected host has passed, the host is switched to probing and
+// only the first client calling check is let through, until its probe has
+// been resolved by observe or fail.
+func (h *hostHealth) check(client *Client) error {
+	g := h.group
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	switch h.state {
+	case hostHealthy:
+		return nil
+	case hostProbing:
+		if h.prober != client {
+			return fmt.Errorf("%w, probe in progress", errHostEjected)
+		}
+		return nil
+	}
+
+	if remaining := time.Until(h.ejectedUntil); remaining > 0 {
+		return fmt.Errorf("%w, retrying in %v", errHostEjected, remaining.Round(time.Second))
+	}
+
+	g.log.Infof("Cooldown of ejected host %v passed, probing it", h.host)
+	h.state = hostProbing
+	h.prober = client
+	h.report()
+	return nil
+}
+
+// observe records the result of a request sent to the host by client and
+// updates its state. While probing, only the request of the probing client
+// resolves the probe.
+func (h *hostHealth) observe(client *Client, latency time.Duration, failed bool) {
+	g := h.group
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	errValue := 0.0
+	if failed {
+		errValue = 1
+	}
+	if h.requests == 0 {
+		h.latency = latency
+		h.errorRate = errValue
+	} else {
+		h.latency += time.Duration(healthDecay * float64(latency-h.latency))
+		h.errorRate += healthDecay * (errValue - h.errorRate)
+	}
+	h.requests++
+
+	switch h.state {
+	case hostProbing:
+		if h.prober != client {
+			break
+		}
+		if failed || latency > g.config.MaxLatency {
+			h.eject()
+			break
+		}
+
+		g.log.Infof("Probe of host %v succeeded, marking it healthy", h.host)
+		h.state = hostHealthy
+		h.prober = nil
+		h.requests = 1
+		h.latency = latency
+		h.errorRate = 0
+	case hostHealthy:
+		if h.requests < g.config.MinRequests {
+			break
+		}
+		if h.errorRate <= g.config.MaxErrorRate && h.latency <= g.config.MaxLatency {
+			break
+		}
+		if g.healthyPeers(h) == 0 {
+			g.log.Debugf("Host %v is unhealthy, but it is the last healthy host", h.host)
+			break
+		}
+		h.eject()
+	}
+	h.report()
+}
+
+// fail marks a failed connection attempt of client, ejecting the host again
+// if client was probing it.
+func (h *hostHealth) fail(client *Client) {
+	g := h.group
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if h.state == hostProbing && h.prober == client {
+		h.eject()
+		h.report()
+	}
+}
+
+func (h *hostHealth) eject() {
+	g := h.group
+	g.log.Warnf("Ejecting host %v for %v (latency: %v, error rate: %.2f)",
+		h.host, g.config.Cooldown, h.latency, h.errorRate)
+	h.state = hostEjected
+	h.prober = nil
+	h.ejectedUntil = time.Now().Add(g.config.Cooldown)
+	h.ejections++
+}
+
+func (h *hostHealth) report() {
+	if st := h.group.observer; st != nil && !h.removed {
+		st.HostHealth(h.host, outputs.HostHealth{
+			State:     h.state.String(),
+			Weight:    h.weight,
+			Latency:   h.latency,
+			ErrorRate: h.errorRate,
+			Ejections: h.ejections,
+		})
+	}
+}
@@ -0,0 +1,233 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/outputs"
+)
+
+func TestHostHealth(t *testing.T) {
+	config := healthCheckConfig{
+		MaxLatency:   time.Second,
+		MaxErrorRate: 0.5,
+		MinRequests:  3,
+		Cooldown:     time.Minute,
+	}
+	client := &Client{}
+
+	newGroup := func(hosts ...string) (*hostHealthGroup, []*hostHealth) {
+		g := newHostHealthGroup(config, nil)
+		var health []*hostHealth
+		for _, host := range hosts {
+			health = append(health, g.get(host, 1))
+		}
+		return g, health
+	}
+
+	t.Run("failing host is ejected after min_requests", func(t *testing.T) {
+		_, health := newGroup("a", "b")
+		a := health[0]
+
+		a.observe(client, 10*time.Millisecond, true)
+		a.observe(client, 10*time.Millisecond, true)
+		assert.Equal(t, hostHealthy, a.state)
+		a.observe(client, 10*time.Millisecond, true)
+		assert.Equal(t, hostEjected, a.state)
+		assert.Equal(t, uint64(1), a.ejections)
+
+		err := a.check(client)
+		assert.True(t, errors.Is(err, errHostEjected))
+		assert.NoError(t, health[1].check(client))
+	})
+
+	t.Run("slow host is ejected", func(t *testing.T) {
+		_, health := newGroup("a", "b")
+		a := health[0]
+		for i := 0; i < 3; i++ {
+			a.observe(client, 5*time.Second, false)
+		}
+		assert.Equal(t, hostEjected, a.state)
+	})
+
+	t.Run("occasional errors do not eject a host", func(t *testing.T) {
+		_, health := newGroup("a", "b")
+		a := health[0]
+		for i := 0; i < 20; i++ {
+			a.observe(client, 10*time.Millisecond, i%5 == 4)
+		}
+		assert.Equal(t, hostHealthy, a.state)
+	})
+
+	t.Run("last healthy host is not ejected", func(t *testing.T) {
+		_, health := newGroup("a", "b")
+		a, b := health[0], health[1]
+		for i := 0; i < 3; i++ {
+			a.observe(client, 10*time.Millisecond, true)
+			b.observe(client, 10*time.Millisecond, true)
+		}
+		assert.Equal(t, hostEjected, a.state)
+		assert.Equal(t, hostHealthy, b.state)
+	})
+
+	t.Run("removed host is not a healthy peer", func(t *testing.T) {
+		g, health := newGroup("a", "b")
+		g.remove("a")
+
+		b := health[1]
+		for i := 0; i < 3; i++ {
+			b.observe(client, 10*time.Millisecond, true)
+		}
+		assert.Equal(t, hostHealthy, b.state)
+	})
+
+	t.Run("host is probed after cooldown", func(t *testing.T) {
+		_, health := newGroup("a", "b")
+		a := health[0]
+		for i := 0; i < 3; i++ {
+			a.observe(client, 10*time.Millisecond, true)
+		}
+		require.Equal(t, hostEjected, a.state)
+
+		a.ejectedUntil = time.Now()
+		require.NoError(t, a.check(client))
+		assert.Equal(t, hostProbing, a.state)
+
+		a.observe(client, 10*time.Millisecond, false)
+		assert.Equal(t, hostHealthy, a.state)
+		assert.Equal(t, 0.0, a.errorRate)
+	})
+
+	t.Run("single client probes after cooldown", func(t *testing.T) {
+		_, health := newGroup("a", "b")
+		a := health[0]
+		for i := 0; i < 3; i++ {
+			a.observe(client, 10*time.Millisecond, true)
+		}
+		a.ejectedUntil = time.Now()
+
+		const numClients = 8
+		clients := make([]*Client, numClients)
+		results := make(chan *Client, numClients)
+		var wg sync.WaitGroup
+		for i := range clients {
+			clients[i] = &Client{}
+			wg.Add(1)
+			go func(c *Client) {
+				defer wg.Done()
+				if a.check(c) == nil {
+					results <- c
+				}
+			}(clients[i])
+		}
+		wg.Wait()
+		close(results)
+
+		var probers []*Client
+		for c := range results {
+			probers = append(probers, c)
+		}
+		require.Len(t, probers, 1, "only one client must probe the host")
+		prober := probers[0]
+		assert.Equal(t, hostProbing, a.state)
+		assert.NoError(t, a.check(prober), "the prober must pass later checks")
+
+		for _, c := range clients {
+			if c != prober {
+				assert.True(t, errors.Is(a.check(c), errHostEjected))
+				a.observe(c, 10*time.Millisecond, false)
+			}
+		}
+		assert.Equal(t, hostProbing, a.state, "only the probe resolves the probing state")
+
+		a.observe(prober, 10*time.Millisecond, false)
+		assert.Equal(t, hostHealthy, a.state)
+		for _, c := range clients {
+			assert.NoError(t, a.check(c))
+		}
+	})
+
+	t.Run("failed probe ejects host again", func(t *testing.T) {
+		_, health := newGroup("a", "b")
+		a := health[0]
+		for i := 0; i < 3; i++ {
+			a.observe(client, 10*time.Millisecond, true)
+		}
+
+		a.ejectedUntil = time.Now()
+		require.NoError(t, a.check(client))
+		a.fail(client)
+		assert.Equal(t, hostEjected, a.state)
+		assert.Equal(t, uint64(2), a.ejections)
+		assert.Error(t, a.check(client))
+	})
+}
+
+type testHealthObserver struct {
+	outputs.Observer
+	hosts map[string]outputs.HostHealth
+}
+
+func (o *testHealthObserver) HostHealth(host string, health outputs.HostHealth) {
+	o.hosts[host] = health
+}
+
+func (o *testHealthObserver) HostRemoved(host string) {
+	delete(o.hosts, host)
+}
+
+func TestHostHealthRemoved(t *testing.T) {
+	observer := &testHealthObserver{
+		Observer: outputs.NewNilObserver(),
+		hosts:    map[string]outputs.HostHealth{},
+	}
+	g := newHostHealthGroup(healthCheckConfig{MaxLatency: time.Second, MinRequests: 1, Cooldown: time.Minute}, observer)
+	a := g.get("a", 1)
+	g.get("b", 2)
+	require.Len(t, observer.hosts, 2)
+
+	g.remove("a")
+	assert.NotContains(t, observer.hosts, "a")
+
+	// clients of the removed host still in flight must not report it again
+	a.observe(&Client{}, 10*time.Millisecond, false)
+	assert.NotContains(t, observer.hosts, "a")
+	assert.Equal(t, 2, observer.hosts["b"].Weight)
+
+	// a node added again by the sniffer starts with a fresh health
+	assert.True(t, a != g.get("a", 1))
+	assert.Contains(t, observer.hosts, "a")
+}
+
+func TestReadHostWeights(t *testing.T) {
+	hosts := []string{"a:9200", "b:9200", "b:9200"}
+
+	weights, err := readHostWeights(hosts, []hostWeightConfig{{Host: "b:9200", Weight: 3}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a:9200": 1, "b:9200": 3}, weights)
+
+	_, err = readHostWeights(hosts, []hostWeightConfig{{Host: "c:9200", Weight: 2}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,329 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/esleg/eslegclient"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+)
+
+// minSniffInterval limits how often connection failures trigger sniffing.
+const minSniffInterval = 10 * time.Second
+
+// sniffer discovers the nodes of the cluster using the nodes info API and
+// maintains the clients publishing to the nodes matching the configured roles
+// and attributes. Nodes are sniffed at startup, on the configured interval and,
+// if enabled, when a client fails to connect. If the nodes can not be sniffed
+// at startup, the configured hosts are used until sniffing succeeds.
+type sniffer struct {
+	config      sniffingConfig
+	seeds       []string
+	connect     func(url string) (*eslegclient.Connection, error)
+	makeClients func(url string) ([]outputs.NetworkClient, error)
+	onRemove    func(url string) // called after the clients of a node have been removed
+	log         *logp.Logger
+
+	failures chan struct{}
+	done     chan struct{}
+
+	// mutex protects the clients from being updated after Stop.
+	mutex       sync.Mutex
+	stopped     bool
+	add, remove func(outputs.Client)
+	nodes       map[string][]outputs.NetworkClient
+}
+
+type nodesInfo struct {
+	Nodes map[string]nodeInfo `json:"nodes"`
+}
+
+type nodeInfo struct {
+	Roles      []string          `json:"roles"`
+	Attributes map[string]string `json:"attributes"`
+	HTTP       struct {
+		PublishAddress string `json:"publish_address"`
+	} `json:"http"`
+}
+
+func newSniffer(
+	config sniffingConfig,
+	seeds []string,
+	connect func(url string) (*eslegclient.Connection, error),
+	makeClients func(url string) ([]outputs.NetworkClient, error),
+	onRemove func(url string),
+) *sniffer {
+	return &sniffer{
+		config:      config,
+		seeds:       seeds,
+		connect:     connect,
+		makeClients: makeClients,
+		onRemove:    onRemove,
+		log:         logp.NewLogger(logSelector),
+		failures:    make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		nodes:       map[string][]outputs.NetworkClient{},
+	}
+}
+
+// Start sniffs the nodes in the background, passing the clients of new nodes
+// to add and the clients of nodes that left the cluster to remove.
+func (s *sniffer) Start(add, remove func(outputs.Client)) {
+	s.mutex.Lock()
+	s.add, s.remove = add, remove
+	s.mutex.Unlock()
+
+	go s.run()
+}
+
+// Stop stops sniffing. A sniff request in progress is not waited for, but its
+// result is discarded.
+func (s *sniffer) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.stopped {
+		s.stopped = true
+		close(s.done)
+	}
+}
+
+// trigger requests sniffing after a client failed to connect.
+func (s *sniffer) trigger() {
+	select {
+	case s.failures <- struct{}{}:
+	default:
+	}
+}
+
+func (s *sniffer) run() {
+	if err := s.sniff(); err != nil {
+		s.log.Errorf("Failed to sniff nodes, using the configured hosts: %v", err)
+		s.update(s.seeds)
+	}
+	last := time.Now()
+
+	var tick <-chan time.Time
+	if s.config.Interval > 0 {
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-tick:
+		case <-s.failures:
+			if time.Since(last) < minSniffInterval {
+				continue
+			}
+			s.log.Debug("Sniffing nodes after connection failure")
+		}
+
+		if err := s.sniff(); err != nil {
+			s.log.Warnf("Failed to sniff nodes, keeping the current nodes: %v", err)
+		}
+		last = time.Now()
+	}
+}
+
+// sniff reads the nodes from the cluster and updates the clients.
+func (s *sniffer) sniff() error {
+	var errs []string
+	for _, host := range s.hosts() {
+		urls, err := s.readNodes(host)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", host, err))
+			continue
+		}
+		if len(urls) == 0 {
+			return errors.New("no node matches the sniffing filters")
+		}
+
+		s.log.Debugf("Sniffed nodes: %v", urls)
+		s.update(urls)
+		return nil
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// hosts returns the hosts to sniff the nodes from, starting with the nodes
+// currently in use, followed by the configured hosts.
+func (s *sniffer) hosts() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hosts := make([]string, 0, len(s.nodes)+len(s.seeds))
+	for node := range s.nodes {
+		hosts = append(hosts, node)
+	}
+	sort.Strings(hosts)
+	for _, seed := range s.seeds {
+		if _, exists := s.nodes[seed]; !exists {
+			hosts = append(hosts, seed)
+		}
+	}
+	return hosts
+}
+
+// readNodes returns the URLs of the nodes matching the sniffing filters.
+func (s *sniffer) readNodes(host string) ([]string, error) {
+	conn, err := s.connect(host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, body, err := conn.Request("GET", "/_nodes/http", "", map[string]string{
+		"filter_path": "nodes.*.roles,nodes.*.attributes,nodes.*.http.publish_address",
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var info nodesInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, node := range info.Nodes {
+		if node.HTTP.PublishAddress == "" || !s.matches(node) {
+			continue
+		}
+		nodeURL, err := common.MakeURL(hostURL.Scheme, hostURL.Path, publishAddress(node.HTTP.PublishAddress), 9200)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, nodeURL)
+	}
+	sort.Strings(urls)
+	return urls, nil
+}
+
+// matches returns true if the node has one of the configured roles and all of
+// the configured attributes. If no roles are configured, dedicated master
+// nodes are excluded.
+func (s *sniffer) matches(node nodeInfo) bool {
+	if len(s.config.Roles) == 0 {
+		if len(node.Roles) == 1 && node.Roles[0] == "master" {
+			return false
+		}
+	} else if !hasAnyRole(node.Roles, s.config.Roles) {
+		return false
+	}
+
+	for name, value := range s.config.Attributes {
+		if node.Attributes[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAnyRole(roles, wanted []string) bool {
+	for _, role := range roles {
+		for _, w := range wanted {
+			if role == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// publishAddress returns the address of a node from its HTTP publish address,
+// which has the form [hostname/]ip:port. The hostname is preferred if set,
+// such that TLS certificates can be verified.
+func publishAddress(addr string) string {
+	i := strings.Index(addr, "/")
+	if i < 0 {
+		return addr
+	}
+
+	hostname, ipAddr := addr[:i], addr[i+1:]
+	if hostname == "" {
+		return ipAddr
+	}
+	if _, port, err := net.SplitHostPort(ipAddr); err == nil {
+		return net.JoinHostPort(hostname, port)
+	}
+	return hostname
+}
+
+// update adds the clients of new nodes and removes the clients of nodes not
+// in urls.
+func (s *sniffer) update(urls []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopped {
+		return
+	}
+
+	active := make(map[string]bool, len(urls))
+	for _, nodeURL := range urls {
+		active[nodeURL] = true
+		if _, exists := s.nodes[nodeURL]; exists {
+			continue
+		}
+
+		clients, err := s.makeClients(nodeURL)
+		if err != nil {
+			s.log.Errorf("Failed to create clients for node %v: %v", nodeURL, err)
+			continue
+		}
+		s.log.Infof("Adding node %v", nodeURL)
+		s.nodes[nodeURL] = clients
+		for _, client := range clients {
+			s.add(client)
+		}
+	}
+
+	for nodeURL, clients := range s.nodes {
+		if active[nodeURL] {
+			continue
+		}
+		s.log.Infof("Removing node %v", nodeURL)
+		for _, client := range clients {
+			s.remove(client)
+		}
+		delete(s.nodes, nodeURL)
+		if s.onRemove != nil {
+			s.onRemove(nodeURL)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/esleg/eslegclient"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+const testNodesInfo = `{"nodes":{
+	"a":{"roles":["master"],"http":{"publish_address":"10.0.0.1:9200"}},
+	"b":{"roles":["data","ingest"],"attributes":{"zone":"a"},"http":{"publish_address":"es-b/10.0.0.2:9201"}},
+	"c":{"roles":["ingest"],"attributes":{"zone":"b"},"http":{"publish_address":"10.0.0.3:9200"}},
+	"d":{"roles":["data"],"attributes":{"zone":"b"},"http":{"publish_address":"[::1]:9200"}}
+}}`
+
+func TestSnifferReadNodes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/_nodes/http", r.URL.Path)
+		fmt.Fprintln(w, testNodesInfo)
+	}))
+	defer ts.Close()
+
+	cases := map[string]struct {
+		config sniffingConfig
+		want   []string
+	}{
+		"dedicated master nodes are excluded by default": {
+			want: []string{"http://10.0.0.3:9200", "http://[::1]:9200", "http://es-b:9201"},
+		},
+		"filter by role": {
+			config: sniffingConfig{Roles: []string{"ingest"}},
+			want:   []string{"http://10.0.0.3:9200", "http://es-b:9201"},
+		},
+		"filter by attribute": {
+			config: sniffingConfig{Attributes: map[string]string{"zone": "b"}},
+			want:   []string{"http://10.0.0.3:9200", "http://[::1]:9200"},
+		},
+		"filter by role and attribute": {
+			config: sniffingConfig{Roles: []string{"data"}, Attributes: map[string]string{"zone": "a"}},
+			want:   []string{"http://es-b:9201"},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			s := newSniffer(test.config, []string{ts.URL}, testSnifferConnect, nil, nil)
+			urls, err := s.readNodes(ts.URL)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, urls)
+		})
+	}
+}
+
+func TestSnifferUpdate(t *testing.T) {
+	var added, removed, removedNodes []string
+	s := newSniffer(sniffingConfig{}, nil, testSnifferConnect, func(url string) ([]outputs.NetworkClient, error) {
+		return []outputs.NetworkClient{
+			&testSnifferClient{url: url},
+			&testSnifferClient{url: url},
+		}, nil
+	}, func(url string) { removedNodes = append(removedNodes, url) })
+	s.add = func(client outputs.Client) { added = append(added, client.String()) }
+	s.remove = func(client outputs.Client) { removed = append(removed, client.String()) }
+
+	s.update([]string{"http://a:9200", "http://b:9200"})
+	assert.ElementsMatch(t, []string{"http://a:9200", "http://a:9200", "http://b:9200", "http://b:9200"}, added)
+	assert.Empty(t, removed)
+
+	added = nil
+	s.update([]string{"http://b:9200", "http://c:9200"})
+	assert.Equal(t, []string{"http://c:9200", "http://c:9200"}, added)
+	assert.Equal(t, []string{"http://a:9200", "http://a:9200"}, removed)
+	assert.Equal(t, []string{"http://a:9200"}, removedNodes)
+
+	added, removed = nil, nil
+	s.Stop()
+	s.update([]string{"http://d:9200"})
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
+
+func TestSnifferSniffError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	s := newSniffer(sniffingConfig{}, []string{ts.URL}, testSnifferConnect, nil, nil)
+	err := s.sniff()
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), ts.URL))
+}
+
+func TestPublishAddress(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.1:9200":                "10.0.0.1:9200",
+		"es-1/10.0.0.1:9200":           "es-1:9200",
+		"/10.0.0.1:9200":               "10.0.0.1:9200",
+		"es-1/[::1]:9200":              "es-1:9200",
+		"[2001:db8::1]:9200":           "[2001:db8::1]:9200",
+		"es-1.example.com/1.2.3.4:443": "es-1.example.com:443",
+	}
+	for addr, want := range cases {
+		assert.Equal(t, want, publishAddress(addr), addr)
+	}
+}
+
+func testSnifferConnect(url string) (*eslegclient.Connection, error) {
+	return eslegclient.NewConnection(eslegclient.ConnectionSettings{URL: url})
+}
+
+type testSnifferClient struct {
+	url string
+}
+
+func (c *testSnifferClient) Publish(_ context.Context, batch publisher.Batch) error {
+	batch.ACK()
+	return nil
+}
+
+func (c *testSnifferClient) Connect() error { return nil }
+func (c *testSnifferClient) Close() error   { return nil }
+func (c *testSnifferClient) String() string { return c.url }
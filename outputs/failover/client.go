@@ -0,0 +1,330 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package failover
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/testing"
+)
+
+type clientSettings struct {
+	MaxFailures   int
+	BackoffBudget time.Duration
+	ProbeInterval time.Duration
+}
+
+// client routes batches to the primary client while it is healthy. After too
+// many consecutive failures, or if the primary client has been failing for
+// longer than the backoff budget, batches are routed to the secondary client,
+// until a health probe reconnects the primary client successfully.
+//
+// The client is driven by a single output worker. Only the health probe
+// accesses the primary client concurrently, while batches are routed to the
+// secondary client, and Close can be called by the pipeline at any time.
+type client struct {
+	log       *logp.Logger
+	primary   outputs.Client
+	secondary *sharedClient
+	settings  clientSettings
+
+	failures     int
+	failingSince time.Time
+
+	// mu protects probe and closed, which are shared with Close.
+	mu     sync.Mutex
+	closed bool
+
+	// probe is not nil while batches are routed to the secondary client.
+	probe *probe
+}
+
+// probe periodically tries to connect the primary client in the background.
+type probe struct {
+	done     chan struct{}
+	finished chan struct{}
+	healthy  chan struct{}
+}
+
+// sharedClient serializes access to a secondary client, that is shared by
+// multiple failover clients.
+type sharedClient struct {
+	mu        sync.Mutex
+	client    outputs.Client
+	connected bool
+	refs      int
+}
+
+func newClient(
+	log *logp.Logger,
+	primary outputs.Client,
+	secondary *sharedClient,
+	settings clientSettings,
+) *client {
+	secondary.acquire()
+	return &client{
+		log:       log,
+		primary:   primary,
+		secondary: secondary,
+		settings:  settings,
+	}
+}
+
+func (c *client) Connect() error {
+	if p := c.currentProbe(); p != nil {
+		if c.checkPrimary(p) {
+			// The health probe has connected the primary client already.
+			return nil
+		}
+		return c.secondary.Connect()
+	}
+
+	err := connect(c.primary)
+	if err == nil {
+		c.resetFailures()
+		return nil
+	}
+
+	if c.onFailure(err) {
+		return c.secondary.Connect()
+	}
+	return err
+}
+
+func (c *client) Close() error {
+	c.mu.Lock()
+	p := c.probe
+	c.probe = nil
+	c.closed = true
+	c.mu.Unlock()
+
+	// Close the primary client before waiting for the probe, such that a
+	// connection attempt of the probe and its backoff are interrupted.
+	err := c.primary.Close()
+	if p != nil {
+		p.stop()
+	}
+	if serr := c.secondary.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	if p := c.currentProbe(); p != nil && !c.checkPrimary(p) {
+		return c.secondary.Publish(ctx, batch)
+	}
+
+	err := c.primary.Publish(ctx, batch)
+	if err == nil {
+		c.resetFailures()
+		return nil
+	}
+
+	// The primary client has already returned the batch to the pipeline. The
+	// events will be retried on the secondary client if we switch over now.
+	c.onFailure(err)
+	return err
+}
+
+func (c *client) Test(d testing.Driver) {
+	d.Run("primary", func(d testing.Driver) { testClient(d, c.primary) })
+	d.Run("secondary", func(d testing.Driver) { testClient(d, c.secondary.client) })
+}
+
+func (c *client) String() string {
+	return "failover(" + c.primary.String() + "," + c.secondary.client.String() + ")"
+}
+
+func (c *client) spilling() bool {
+	return c.currentProbe() != nil
+}
+
+func (c *client) currentProbe() *probe {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.probe
+}
+
+func (c *client) resetFailures() {
+	c.failures = 0
+	c.failingSince = time.Time{}
+}
+
+// onFailure records a failure of the primary client. It returns true if
+// batches are routed to the secondary client from now on.
+func (c *client) onFailure(err error) bool {
+	now := time.Now()
+	if c.failures == 0 {
+		c.failingSince = now
+	}
+	c.failures++
+
+	exceeded := c.settings.MaxFailures > 0 && c.failures >= c.settings.MaxFailures
+	outOfBudget := c.settings.BackoffBudget > 0 && now.Sub(c.failingSince) >= c.settings.BackoffBudget
+	if !exceeded && !outOfBudget {
+		return false
+	}
+
+	c.log.Warnf("Primary output %v failed %d times within %v, routing events to %v: %v",
+		c.primary, c.failures, now.Sub(c.failingSince), c.secondary.client, err)
+
+	c.resetFailures()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.probe = startProbe(c.log, c.primary, c.settings.ProbeInterval)
+	}
+	return true
+}
+
+// checkPrimary switches back to the primary client if the health probe p
+// succeeded. It returns true if batches are routed to the primary client.
+func (c *client) checkPrimary(p *probe) bool {
+	select {
+	case <-p.healthy:
+	default:
+		return false
+	}
+
+	<-p.finished
+	c.mu.Lock()
+	if c.probe == p {
+		c.probe = nil
+	}
+	c.mu.Unlock()
+	c.log.Infof("Primary output %v is healthy again, routing events back to it", c.primary)
+	return true
+}
+
+func startProbe(log *logp.Logger, client outputs.Client, interval time.Duration) *probe {
+	p := &probe{
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		healthy:  make(chan struct{}),
+	}
+	go p.run(log, client, interval)
+	return p
+}
+
+func (p *probe) run(log *logp.Logger, client outputs.Client, interval time.Duration) {
+	defer close(p.finished)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := connect(client); err != nil {
+			log.Debugf("Health probe of %v failed: %v", client, err)
+			continue
+		}
+
+		close(p.healthy)
+		return
+	}
+}
+
+func (p *probe) stop() {
+	close(p.done)
+	<-p.finished
+}
+
+func newSharedClient(client outputs.Client) *sharedClient {
+	return &sharedClient{client: client}
+}
+
+func (s *sharedClient) acquire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs++
+}
+
+func (s *sharedClient) Connect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connect()
+}
+
+func (s *sharedClient) connect() error {
+	if s.connected {
+		return nil
+	}
+	if err := connect(s.client); err != nil {
+		return err
+	}
+	s.connected = true
+	return nil
+}
+
+// Close closes the underlying client once the last failover client using it
+// has been closed.
+func (s *sharedClient) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	s.connected = false
+	return s.client.Close()
+}
+
+func (s *sharedClient) Publish(ctx context.Context, batch publisher.Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.connect(); err != nil {
+		batch.Cancelled()
+		return err
+	}
+
+	err := s.client.Publish(ctx, batch)
+	if err != nil {
+		s.connected = false
+	}
+	return err
+}
+
+func connect(client outputs.Client) error {
+	if c, ok := client.(outputs.Connectable); ok {
+		return c.Connect()
+	}
+	return nil
+}
+
+func testClient(d testing.Driver, client outputs.Client) {
+	t, ok := client.(testing.Testable)
+	if !ok {
+		d.Info("output", "client doesn't support testing")
+		return
+	}
+	t.Test(d)
+}
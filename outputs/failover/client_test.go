@@ -0,0 +1,328 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+// +build !integration
+
+package failover
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/outest"
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+type mockClient struct {
+	mu         sync.Mutex
+	name       string
+	connectErr error
+	publishErr error
+	connects   int
+	published  int
+	closed     int
+}
+
+func (m *mockClient) Connect() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connects++
+	return m.connectErr
+}
+
+func (m *mockClient) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed++
+	return nil
+}
+
+func (m *mockClient) Publish(_ context.Context, batch publisher.Batch) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.publishErr != nil {
+		batch.Retry()
+		return m.publishErr
+	}
+	m.published += len(batch.Events())
+	batch.ACK()
+	return nil
+}
+
+func (m *mockClient) String() string { return m.name }
+
+func (m *mockClient) setErrors(connectErr, publishErr error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connectErr = connectErr
+	m.publishErr = publishErr
+}
+
+func (m *mockClient) stats() (connects, published int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.connects, m.published
+}
+
+// blockingClient blocks connection attempts until it is closed, like a client
+// waiting for its backoff.
+type blockingClient struct {
+	mockClient
+	connecting chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newBlockingClient(name string, publishErr error) *blockingClient {
+	return &blockingClient{
+		mockClient: mockClient{name: name, publishErr: publishErr},
+		connecting: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+func (b *blockingClient) Connect() error {
+	select {
+	case b.connecting <- struct{}{}:
+	default:
+	}
+	<-b.done
+	return errors.New("closed")
+}
+
+func (b *blockingClient) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return b.mockClient.Close()
+}
+
+func newTestClient(primary, secondary *mockClient, settings clientSettings) *client {
+	return newClient(logp.NewLogger("test"), primary, newSharedClient(secondary), settings)
+}
+
+func testBatch() *outest.Batch {
+	return outest.NewBatch(beat.Event{Fields: common.MapStr{"message": "test"}})
+}
+
+func TestPublishToPrimary(t *testing.T) {
+	primary := &mockClient{name: "primary"}
+	secondary := &mockClient{name: "secondary"}
+	c := newTestClient(primary, secondary, clientSettings{MaxFailures: 3, ProbeInterval: time.Hour})
+	defer c.Close()
+
+	require.NoError(t, c.Connect())
+	require.NoError(t, c.Publish(context.Background(), testBatch()))
+
+	_, published := primary.stats()
+	assert.Equal(t, 1, published)
+	_, published = secondary.stats()
+	assert.Equal(t, 0, published)
+	assert.False(t, c.spilling())
+}
+
+func TestFailoverAfterMaxFailures(t *testing.T) {
+	primary := &mockClient{name: "primary", connectErr: errors.New("unavailable")}
+	secondary := &mockClient{name: "secondary"}
+	c := newTestClient(primary, secondary, clientSettings{MaxFailures: 3, ProbeInterval: time.Hour})
+	defer c.Close()
+
+	assert.Error(t, c.Connect())
+	assert.Error(t, c.Connect())
+	assert.False(t, c.spilling())
+
+	// third failure switches to the secondary client
+	require.NoError(t, c.Connect())
+	assert.True(t, c.spilling())
+
+	require.NoError(t, c.Publish(context.Background(), testBatch()))
+	_, published := secondary.stats()
+	assert.Equal(t, 1, published)
+}
+
+func TestFailoverOnPublishErrors(t *testing.T) {
+	primary := &mockClient{name: "primary"}
+	secondary := &mockClient{name: "secondary"}
+	c := newTestClient(primary, secondary, clientSettings{MaxFailures: 2, ProbeInterval: time.Hour})
+	defer c.Close()
+
+	require.NoError(t, c.Connect())
+	primary.setErrors(nil, errors.New("publish failed"))
+
+	batch := testBatch()
+	assert.Error(t, c.Publish(context.Background(), batch))
+	assert.Equal(t, []outest.BatchSignal{{Tag: outest.BatchRetry}}, batch.Signals)
+	assert.False(t, c.spilling())
+
+	assert.Error(t, c.Publish(context.Background(), testBatch()))
+	assert.True(t, c.spilling())
+
+	require.NoError(t, c.Connect())
+	require.NoError(t, c.Publish(context.Background(), testBatch()))
+	_, published := secondary.stats()
+	assert.Equal(t, 1, published)
+}
+
+func TestSuccessResetsFailures(t *testing.T) {
+	primary := &mockClient{name: "primary"}
+	secondary := &mockClient{name: "secondary"}
+	c := newTestClient(primary, secondary, clientSettings{MaxFailures: 2, ProbeInterval: time.Hour})
+	defer c.Close()
+
+	primary.setErrors(errors.New("unavailable"), nil)
+	assert.Error(t, c.Connect())
+
+	primary.setErrors(nil, nil)
+	require.NoError(t, c.Connect())
+
+	primary.setErrors(errors.New("unavailable"), nil)
+	assert.Error(t, c.Connect())
+	assert.False(t, c.spilling())
+}
+
+func TestFailoverAfterBackoffBudget(t *testing.T) {
+	primary := &mockClient{name: "primary", connectErr: errors.New("unavailable")}
+	secondary := &mockClient{name: "secondary"}
+	c := newTestClient(primary, secondary, clientSettings{
+		BackoffBudget: 20 * time.Millisecond,
+		ProbeInterval: time.Hour,
+	})
+	defer c.Close()
+
+	assert.Error(t, c.Connect())
+	assert.Error(t, c.Connect())
+	assert.False(t, c.spilling())
+
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, c.Connect())
+	assert.True(t, c.spilling())
+}
+
+func TestSwitchBackAfterProbe(t *testing.T) {
+	primary := &mockClient{name: "primary", connectErr: errors.New("unavailable")}
+	secondary := &mockClient{name: "secondary"}
+	c := newTestClient(primary, secondary, clientSettings{
+		MaxFailures:   1,
+		ProbeInterval: 10 * time.Millisecond,
+	})
+	defer c.Close()
+
+	require.NoError(t, c.Connect())
+	require.True(t, c.spilling())
+
+	// wait for at least one failed probe
+	require.Eventually(t, func() bool {
+		connects, _ := primary.stats()
+		return connects > 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, c.Publish(context.Background(), testBatch()))
+	assert.True(t, c.spilling())
+
+	primary.setErrors(nil, nil)
+	require.Eventually(t, func() bool {
+		select {
+		case <-c.currentProbe().healthy:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, c.Publish(context.Background(), testBatch()))
+	assert.False(t, c.spilling())
+
+	_, published := primary.stats()
+	assert.Equal(t, 1, published)
+	_, published = secondary.stats()
+	assert.Equal(t, 1, published)
+}
+
+func TestSharedSecondaryClosedOnce(t *testing.T) {
+	secondary := &mockClient{name: "secondary"}
+	shared := newSharedClient(secondary)
+	log := logp.NewLogger("test")
+	settings := clientSettings{MaxFailures: 1, ProbeInterval: time.Hour}
+
+	clients := []outputs.Client{
+		newClient(log, &mockClient{name: "a"}, shared, settings),
+		newClient(log, &mockClient{name: "b"}, shared, settings),
+	}
+
+	clients[0].Close()
+	assert.Equal(t, 0, secondary.closed)
+	clients[1].Close()
+	assert.Equal(t, 1, secondary.closed)
+}
+
+func TestCloseInterruptsProbe(t *testing.T) {
+	primary := newBlockingClient("primary", errors.New("unavailable"))
+	c := newClient(logp.NewLogger("test"), primary, newSharedClient(&mockClient{name: "secondary"}), clientSettings{
+		MaxFailures:   1,
+		ProbeInterval: time.Millisecond,
+	})
+
+	require.Error(t, c.Publish(context.Background(), testBatch()))
+	require.True(t, c.spilling())
+
+	select {
+	case <-primary.connecting:
+	case <-time.After(time.Second):
+		t.Fatal("health probe did not connect the primary client")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by the health probe")
+	}
+}
+
+func TestCloseWhilePublishing(t *testing.T) {
+	primary := &mockClient{name: "primary", connectErr: errors.New("unavailable")}
+	c := newTestClient(primary, &mockClient{name: "secondary"}, clientSettings{
+		MaxFailures:   1,
+		ProbeInterval: time.Millisecond,
+	})
+	require.NoError(t, c.Connect())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_ = c.Publish(context.Background(), testBatch())
+		}
+	}()
+
+	c.Close()
+	wg.Wait()
+	assert.False(t, c.spilling())
+}
@@ -0,0 +1,72 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package failover
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common"
+)
+
+type failoverConfig struct {
+	Primary   common.ConfigNamespace `config:"primary"`
+	Secondary common.ConfigNamespace `config:"secondary"`
+
+	// MaxFailures is the number of consecutive connection or publish failures
+	// of the primary output after which batches are routed to the secondary
+	// output.
+	MaxFailures int `config:"max_failures" validate:"min=0"`
+
+	// BackoffBudget is the maximum amount of time the primary output can fail
+	// continuously before batches are routed to the secondary output.
+	BackoffBudget time.Duration `config:"backoff_budget" validate:"min=0"`
+
+	// ProbeInterval configures how often the primary output is probed for
+	// its health while batches are routed to the secondary output.
+	ProbeInterval time.Duration `config:"probe_interval" validate:"min=0, nonzero"`
+}
+
+func defaultConfig() failoverConfig {
+	return failoverConfig{
+		MaxFailures:   3,
+		BackoffBudget: 0,
+		ProbeInterval: 30 * time.Second,
+	}
+}
+
+func (c *failoverConfig) Validate() error {
+	if !c.Primary.IsSet() {
+		return errors.New("no primary output configured")
+	}
+	if !c.Secondary.IsSet() {
+		return errors.New("no secondary output configured")
+	}
+
+	for _, name := range []string{c.Primary.Name(), c.Secondary.Name()} {
+		if name == outputName {
+			return fmt.Errorf("the %v output can not be nested", outputName)
+		}
+	}
+
+	if c.MaxFailures == 0 && c.BackoffBudget == 0 {
+		return errors.New("either max_failures or backoff_budget must be set")
+	}
+	return nil
+}
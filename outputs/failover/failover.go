@@ -0,0 +1,102 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package failover
+
+import (
+	"fmt"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+)
+
+const outputName = "failover"
+
+func init() {
+	outputs.RegisterType(outputName, makeFailover)
+}
+
+// makeFailover creates the primary and secondary outputs and combines them
+// into one group. Each client of the primary output is paired with a client of
+// the secondary output. Batches are routed to the secondary output while the
+// primary client is unhealthy.
+func makeFailover(
+	im outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *common.Config,
+) (outputs.Group, error) {
+	config := defaultConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return outputs.Fail(err)
+	}
+
+	primary, err := outputs.Load(im, beat, observer, config.Primary.Name(), config.Primary.Config())
+	if err != nil {
+		return outputs.Fail(fmt.Errorf("failed to load primary output: %w", err))
+	}
+
+	secondary, err := outputs.Load(im, beat, observer, config.Secondary.Name(), config.Secondary.Config())
+	if err != nil {
+		closeClients(primary.Clients)
+		return outputs.Fail(fmt.Errorf("failed to load secondary output: %w", err))
+	}
+
+	if len(primary.Clients) == 0 || len(secondary.Clients) == 0 {
+		closeClients(primary.Clients)
+		closeClients(secondary.Clients)
+		return outputs.Fail(outputs.ErrNoConnectionConfigured)
+	}
+
+	shared := make([]*sharedClient, len(secondary.Clients))
+	for i, client := range secondary.Clients {
+		shared[i] = newSharedClient(client)
+	}
+
+	log := logp.NewLogger(outputName)
+	settings := clientSettings{
+		MaxFailures:   config.MaxFailures,
+		BackoffBudget: config.BackoffBudget,
+		ProbeInterval: config.ProbeInterval,
+	}
+
+	clients := make([]outputs.Client, len(primary.Clients))
+	for i, client := range primary.Clients {
+		clients[i] = newClient(log, client, shared[i%len(shared)], settings)
+	}
+
+	// Close secondary clients not paired with any primary client.
+	for _, s := range shared {
+		if s.refs == 0 {
+			s.client.Close()
+		}
+	}
+
+	return outputs.Group{
+		Clients:   clients,
+		BatchSize: primary.BatchSize,
+		Retry:     primary.Retry,
+	}, nil
+}
+
+func closeClients(clients []outputs.Client) {
+	for _, client := range clients {
+		client.Close()
+	}
+}
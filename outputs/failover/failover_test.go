@@ -0,0 +1,109 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+// +build !integration
+
+package failover
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs"
+	_ "github.com/njcx/libbeat_v7/outputs/console"
+	_ "github.com/njcx/libbeat_v7/outputs/fileout"
+)
+
+func TestConfigValidate(t *testing.T) {
+	cases := map[string]struct {
+		config map[string]interface{}
+		ok     bool
+	}{
+		"valid": {
+			config: map[string]interface{}{
+				"primary.console": map[string]interface{}{},
+				"secondary.file":  map[string]interface{}{"path": "/tmp"},
+				"max_failures":    5,
+				"backoff_budget":  "1m",
+				"probe_interval":  "10s",
+			},
+			ok: true,
+		},
+		"missing primary": {
+			config: map[string]interface{}{
+				"secondary.file": map[string]interface{}{"path": "/tmp"},
+			},
+		},
+		"missing secondary": {
+			config: map[string]interface{}{
+				"primary.console": map[string]interface{}{},
+			},
+		},
+		"nested failover": {
+			config: map[string]interface{}{
+				"primary.failover": map[string]interface{}{},
+				"secondary.file":   map[string]interface{}{"path": "/tmp"},
+			},
+		},
+		"no failover condition": {
+			config: map[string]interface{}{
+				"primary.console": map[string]interface{}{},
+				"secondary.file":  map[string]interface{}{"path": "/tmp"},
+				"max_failures":    0,
+			},
+		},
+		"zero probe interval": {
+			config: map[string]interface{}{
+				"primary.console": map[string]interface{}{},
+				"secondary.file":  map[string]interface{}{"path": "/tmp"},
+				"probe_interval":  0,
+			},
+		},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			config := defaultConfig()
+			err := common.MustNewConfigFrom(test.config).Unpack(&config)
+			if test.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestMakeFailover(t *testing.T) {
+	cfg := common.MustNewConfigFrom(map[string]interface{}{
+		"primary.console": map[string]interface{}{},
+		"secondary.file":  map[string]interface{}{"path": t.TempDir()},
+	})
+
+	group, err := makeFailover(nil, beat.Info{Beat: "test"}, outputs.NewNilObserver(), cfg)
+	require.NoError(t, err)
+	require.Len(t, group.Clients, 1)
+
+	c := group.Clients[0].(*client)
+	assert.Equal(t, 1, c.secondary.refs)
+	assert.NoError(t, c.Close())
+}
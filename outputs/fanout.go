@@ -0,0 +1,79 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package outputs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/njcx/libbeat_v7/conditions"
+)
+
+// Fanout configures a set of named output groups, the publisher pipeline
+// publishes events to in parallel. Each output group has its own workers and
+// retry state.
+type Fanout struct {
+	Outputs []RoutedGroup
+	ACK     FanoutACKMode
+}
+
+// RoutedGroup is a named output group. Only events matching the condition are
+// published to the group. All events are published to the group if no
+// condition is configured.
+type RoutedGroup struct {
+	Name      string
+	Condition conditions.Condition
+	Group     Group
+}
+
+// FanoutACKMode configures when events published to multiple output groups are
+// ACKed to the producer.
+type FanoutACKMode uint8
+
+const (
+	// FanoutACKAll ACKs events once all output groups have processed them.
+	FanoutACKAll FanoutACKMode = iota
+
+	// FanoutACKAny ACKs events once at least one output group has published
+	// them successfully, or all output groups have dropped them.
+	FanoutACKAny
+)
+
+var fanoutACKModes = map[string]FanoutACKMode{
+	"all": FanoutACKAll,
+	"any": FanoutACKAny,
+}
+
+// Unpack parses the ACK mode from its string representation.
+func (m *FanoutACKMode) Unpack(s string) error {
+	mode, ok := fanoutACKModes[strings.ToLower(s)]
+	if !ok {
+		return fmt.Errorf("invalid ack mode '%v', must be 'all' or 'any'", s)
+	}
+	*m = mode
+	return nil
+}
+
+func (m FanoutACKMode) String() string {
+	for name, mode := range fanoutACKModes {
+		if mode == m {
+			return name
+		}
+	}
+	return fmt.Sprintf("FanoutACKMode(%d)", uint8(m))
+}
@@ -0,0 +1,71 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fanout
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/conditions"
+	"github.com/njcx/libbeat_v7/outputs"
+)
+
+type fanoutConfig struct {
+	ACK     outputs.FanoutACKMode `config:"ack"`
+	Outputs []routeConfig         `config:"outputs"`
+}
+
+// routeConfig configures a named output. Only events matching the `when`
+// condition are published to the output.
+type routeConfig struct {
+	Name   string                 `config:"name" validate:"required"`
+	When   *conditions.Config     `config:"when"`
+	Output common.ConfigNamespace `config:"output"`
+}
+
+func defaultConfig() fanoutConfig {
+	return fanoutConfig{
+		ACK: outputs.FanoutACKAll,
+	}
+}
+
+func (c *fanoutConfig) Validate() error {
+	if len(c.Outputs) == 0 {
+		return errors.New("no outputs configured")
+	}
+
+	names := map[string]bool{}
+	for _, route := range c.Outputs {
+		if names[route.Name] {
+			return fmt.Errorf("output name '%v' is used more than once", route.Name)
+		}
+		names[route.Name] = true
+	}
+	return nil
+}
+
+func (c *routeConfig) Validate() error {
+	if !c.Output.IsSet() {
+		return fmt.Errorf("no output configured for '%v'", c.Name)
+	}
+	if c.Output.Name() == outputName {
+		return fmt.Errorf("the %v output can not be nested", outputName)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fanout
+
+import (
+	"fmt"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/conditions"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+)
+
+const outputName = "fanout"
+
+func init() {
+	outputs.RegisterType(outputName, makeFanout)
+}
+
+// makeFanout loads all configured outputs. The publisher pipeline publishes
+// events to every output in parallel, each output having its own workers and
+// retry state.
+func makeFanout(
+	im outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *common.Config,
+) (outputs.Group, error) {
+	config := defaultConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return outputs.Fail(err)
+	}
+
+	log := logp.NewLogger(outputName)
+	fanout := &outputs.Fanout{ACK: config.ACK}
+	for _, route := range config.Outputs {
+		group, err := loadRoute(im, beat, observer, route)
+		if err != nil {
+			closeGroups(fanout.Outputs)
+			return outputs.Fail(err)
+		}
+
+		log.Infof("Publishing events to output '%v' of type %v", route.Name, route.Output.Name())
+		fanout.Outputs = append(fanout.Outputs, group)
+	}
+
+	return outputs.Group{Fanout: fanout}, nil
+}
+
+func loadRoute(
+	im outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	route routeConfig,
+) (outputs.RoutedGroup, error) {
+	var (
+		condition conditions.Condition
+		err       error
+	)
+	if route.When != nil {
+		condition, err = conditions.NewCondition(route.When)
+		if err != nil {
+			return outputs.RoutedGroup{}, fmt.Errorf("invalid condition for output '%v': %w", route.Name, err)
+		}
+	}
+
+	group, err := outputs.Load(im, beat, observer, route.Output.Name(), route.Output.Config())
+	if err != nil {
+		return outputs.RoutedGroup{}, fmt.Errorf("failed to load output '%v': %w", route.Name, err)
+	}
+	if group.Fanout != nil || len(group.Clients) == 0 {
+		for _, client := range group.Clients {
+			client.Close()
+		}
+		return outputs.RoutedGroup{}, fmt.Errorf("output '%v' has no clients", route.Name)
+	}
+
+	return outputs.RoutedGroup{
+		Name:      route.Name,
+		Condition: condition,
+		Group:     group,
+	}, nil
+}
+
+func closeGroups(groups []outputs.RoutedGroup) {
+	for _, routed := range groups {
+		for _, client := range routed.Group.Clients {
+			client.Close()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+// +build !integration
+
+package fanout
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/outputs"
+	_ "github.com/njcx/libbeat_v7/outputs/console"
+	_ "github.com/njcx/libbeat_v7/outputs/fileout"
+)
+
+func TestConfigValidate(t *testing.T) {
+	cases := map[string]struct {
+		config map[string]interface{}
+		ok     bool
+	}{
+		"valid": {
+			config: map[string]interface{}{
+				"ack": "any",
+				"outputs": []map[string]interface{}{
+					{"name": "a", "output.console": map[string]interface{}{}},
+					{"name": "b", "when.equals.kind": "alert", "output.file.path": "/tmp"},
+				},
+			},
+			ok: true,
+		},
+		"no outputs": {
+			config: map[string]interface{}{},
+		},
+		"invalid ack mode": {
+			config: map[string]interface{}{
+				"ack": "some",
+				"outputs": []map[string]interface{}{
+					{"name": "a", "output.console": map[string]interface{}{}},
+				},
+			},
+		},
+		"missing name": {
+			config: map[string]interface{}{
+				"outputs": []map[string]interface{}{
+					{"output.console": map[string]interface{}{}},
+				},
+			},
+		},
+		"duplicate name": {
+			config: map[string]interface{}{
+				"outputs": []map[string]interface{}{
+					{"name": "a", "output.console": map[string]interface{}{}},
+					{"name": "a", "output.file.path": "/tmp"},
+				},
+			},
+		},
+		"missing output": {
+			config: map[string]interface{}{
+				"outputs": []map[string]interface{}{
+					{"name": "a"},
+				},
+			},
+		},
+		"nested fanout": {
+			config: map[string]interface{}{
+				"outputs": []map[string]interface{}{
+					{"name": "a", "output.fanout.ack": "all"},
+				},
+			},
+		},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			config := defaultConfig()
+			err := common.MustNewConfigFrom(test.config).Unpack(&config)
+			if test.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestMakeFanout(t *testing.T) {
+	cfg := common.MustNewConfigFrom(map[string]interface{}{
+		"ack": "any",
+		"outputs": []map[string]interface{}{
+			{"name": "console", "output.console": map[string]interface{}{}},
+			{"name": "archive", "when.equals.kind": "alert", "output.file.path": t.TempDir()},
+		},
+	})
+
+	group, err := makeFanout(nil, beat.Info{Beat: "test"}, outputs.NewNilObserver(), cfg)
+	require.NoError(t, err)
+	require.NotNil(t, group.Fanout)
+	assert.Empty(t, group.Clients)
+	assert.Equal(t, outputs.FanoutACKAny, group.Fanout.ACK)
+
+	require.Len(t, group.Fanout.Outputs, 2)
+	assert.Equal(t, "console", group.Fanout.Outputs[0].Name)
+	assert.Nil(t, group.Fanout.Outputs[0].Condition)
+	assert.Equal(t, "archive", group.Fanout.Outputs[1].Name)
+	assert.NotNil(t, group.Fanout.Outputs[1].Condition)
+
+	for _, routed := range group.Fanout.Outputs {
+		assert.NotEmpty(t, routed.Group.Clients)
+		for _, client := range routed.Group.Clients {
+			client.Close()
+		}
+	}
+}
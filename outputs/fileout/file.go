@@ -36,12 +36,14 @@ func init() {
 }
 
 type fileOutput struct {
-	log      *logp.Logger
-	filePath string
-	beat     beat.Info
-	observer outputs.Observer
-	rotator  *file.Rotator
-	codec    codec.Codec
+	log        *logp.Logger
+	filePath   string
+	beat       beat.Info
+	observer   outputs.Observer
+	deadLetter outputs.DeadLetterQueue
+	rotator    *file.Rotator
+	codec      codec.Codec
+	separator  []byte
 }
 
 // makeFileout instantiates a new file output instance.
@@ -56,13 +58,19 @@ func makeFileout(
 		return outputs.Fail(err)
 	}
 
+	deadLetter, err := outputs.LoadDeadLetterQueue(beat, "file", observer, cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
 	// disable bulk support in publisher pipeline
 	cfg.SetInt("bulk_max_size", -1, -1)
 
 	fo := &fileOutput{
-		log:      logp.NewLogger("file"),
-		beat:     beat,
-		observer: observer,
+		log:        logp.NewLogger("file"),
+		beat:       beat,
+		observer:   observer,
+		deadLetter: deadLetter,
 	}
 	if err := fo.init(beat, config); err != nil {
 		return outputs.Fail(err)
@@ -99,6 +107,11 @@ func (out *fileOutput) init(beat beat.Info, c config) error {
 		return err
 	}
 
+	// Binary messages are self-delimiting and written back to back.
+	if !codec.IsBinary(out.codec) {
+		out.separator = []byte{'\n'}
+	}
+
 	out.log.Infof("Initialized file output. "+
 		"path=%v max_size_bytes=%v max_backups=%v permissions=%v",
 		path, c.RotateEveryKb*1024, c.NumberOfFiles, os.FileMode(c.Permissions))
@@ -131,11 +144,12 @@ func (out *fileOutput) Publish(_ context.Context, batch publisher.Batch) error {
 			}
 			out.log.Debugf("Failed event: %v", event)
 
+			out.deadLetter.Add(&event.Content, err)
 			dropped++
 			continue
 		}
 
-		if _, err = out.rotator.Write(append(serializedEvent, '\n')); err != nil {
+		if _, err = out.rotator.Write(append(serializedEvent, out.separator...)); err != nil {
 			st.WriteError(err)
 
 			if event.Guaranteed() {
@@ -144,14 +158,14 @@ func (out *fileOutput) Publish(_ context.Context, batch publisher.Batch) error {
 				out.log.Warnf("Writing event to file failed with: %+v", err)
 			}
 
+			out.deadLetter.Add(&event.Content, err)
 			dropped++
 			continue
 		}
 
-		st.WriteBytes(len(serializedEvent) + 1)
+		st.WriteBytes(len(serializedEvent) + len(out.separator))
 	}
 
-	st.Dropped(dropped)
 	st.Acked(len(events) - dropped)
 
 	return nil
@@ -0,0 +1,521 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
+	"github.com/njcx/libbeat_v7/common/transport/tlscommon"
+	"github.com/njcx/libbeat_v7/common/useragent"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/testing"
+)
+
+// client publishes batches of events to a single HTTP endpoint.
+type client struct {
+	log *logp.Logger
+
+	url      string
+	method   string
+	username string
+	password string
+
+	staticHeaders  nethttp.Header
+	dynamicHeaders []dynamicHeader
+
+	mode             batchMode
+	compressionLevel int
+	maxRetryAfter    time.Duration
+
+	index      string
+	codec      codec.Codec
+	observer   outputs.Observer
+	deadLetter outputs.DeadLetterQueue
+
+	transport httpcommon.HTTPTransportSettings
+	http      *nethttp.Client
+
+	// done is closed by Close to stop waiting for a Retry-After delay.
+	// Connect replaces it, as the client is reconnected after being closed
+	// on errors.
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+// clientSettings contains the settings for a client.
+type clientSettings struct {
+	URL        string
+	Beatname   string
+	Method     string
+	Parameters map[string]string
+	Headers    map[string]*fmtstr.EventFormatString
+	Username   string
+	Password   string
+
+	Mode             batchMode
+	CompressionLevel int
+
+	// MaxRetryAfter caps the time the client honors a Retry-After response
+	// header for.
+	MaxRetryAfter time.Duration
+
+	Index      string
+	Codec      codec.Codec
+	Observer   outputs.Observer
+	DeadLetter outputs.DeadLetterQueue
+
+	Transport httpcommon.HTTPTransportSettings
+}
+
+// dynamicHeader is a request header whose value is computed per event.
+type dynamicHeader struct {
+	name  string
+	value *fmtstr.EventFormatString
+}
+
+// request holds the events and encoded body of a single HTTP request.
+type request struct {
+	key    string
+	header nethttp.Header
+	events []publisher.Event
+	body   bytes.Buffer
+}
+
+var errTempFailure = errors.New("temporary http request failure")
+
+func newClient(s clientSettings) (*client, error) {
+	if s.Observer == nil {
+		s.Observer = outputs.NewNilObserver()
+	}
+	if s.DeadLetter == nil {
+		s.DeadLetter = outputs.NewDropQueue(s.Observer)
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse http output URL: %v", err)
+	}
+	if u.User != nil {
+		s.Username = u.User.Username()
+		s.Password, _ = u.User.Password()
+		u.User = nil
+	}
+	if len(s.Parameters) > 0 {
+		params := u.Query()
+		for k, v := range s.Parameters {
+			params.Set(k, v)
+		}
+		u.RawQuery = params.Encode()
+	}
+
+	if s.Beatname == "" {
+		s.Beatname = "Libbeat"
+	}
+
+	logger := logp.NewLogger(logSelector)
+	httpClient, err := s.Transport.Client(
+		httpcommon.WithLogger(logger),
+		httpcommon.WithIOStats(s.Observer),
+		httpcommon.WithKeepaliveSettings{IdleConnTimeout: 1 * time.Minute},
+		httpcommon.WithAPMHTTPInstrumentation(),
+		httpcommon.WithHeaderRoundTripper(map[string]string{
+			"User-Agent": useragent.UserAgent(s.Beatname, true),
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	static := nethttp.Header{}
+	var dynamic []dynamicHeader
+	for name, value := range s.Headers {
+		if value.IsConst() {
+			v, _ := value.Run(nil)
+			static.Set(name, v)
+		} else {
+			dynamic = append(dynamic, dynamicHeader{name: name, value: value})
+		}
+	}
+	sort.Slice(dynamic, func(i, j int) bool { return dynamic[i].name < dynamic[j].name })
+
+	return &client{
+		log:              logger,
+		url:              u.String(),
+		method:           s.Method,
+		username:         s.Username,
+		password:         s.Password,
+		staticHeaders:    static,
+		dynamicHeaders:   dynamic,
+		mode:             s.Mode,
+		compressionLevel: s.CompressionLevel,
+		maxRetryAfter:    s.MaxRetryAfter,
+		index:            strings.ToLower(s.Index),
+		codec:            s.Codec,
+		observer:         s.Observer,
+		deadLetter:       s.DeadLetter,
+		transport:        s.Transport,
+		http:             httpClient,
+		done:             make(chan struct{}),
+	}, nil
+}
+
+// Connect only resets the client after Close. Connections are established on
+// demand by the HTTP transport.
+func (c *client) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		c.done = make(chan struct{})
+	default:
+	}
+	return nil
+}
+
+func (c *client) Close() error {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.mu.Unlock()
+
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+func (c *client) String() string {
+	return "http(" + c.url + ")"
+}
+
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	rest, err := c.publishEvents(ctx, events)
+	if len(rest) == 0 {
+		batch.ACK()
+	} else {
+		c.observer.Failed(len(rest))
+		batch.RetryEvents(rest)
+	}
+	return err
+}
+
+// publishEvents sends all events to the configured endpoint. Events that
+// should be retried are returned. Events rejected by the endpoint with a
+// non-retryable 4xx status code are handed to the dead letter queue. If the
+// endpoint asks for a delay using Retry-After, publishEvents waits for it, or
+// until ctx is cancelled or the client is closed, instead of reporting an error
+// to the generic backoff.
+func (c *client) publishEvents(ctx context.Context, data []publisher.Event) ([]publisher.Event, error) {
+	c.mu.Lock()
+	done := c.done
+	c.mu.Unlock()
+
+	reqs := c.encodeRequests(data)
+
+	for i, req := range reqs {
+		status, retryAfter, err := c.send(req)
+		if err != nil {
+			return pendingEvents(reqs[i:]), err
+		}
+
+		switch {
+		case status < 300:
+			c.observer.Acked(len(req.events))
+
+		case retryableStatus(status):
+			if status == nethttp.StatusTooManyRequests {
+				c.observer.ErrTooMany(len(req.events))
+			}
+			if retryAfter > 0 {
+				c.log.Infof("Endpoint responded with %v, retrying after %v", status, retryAfter)
+				timer := time.NewTimer(retryAfter)
+				defer timer.Stop()
+				select {
+				case <-ctx.Done():
+				case <-done:
+				case <-timer.C:
+				}
+				return pendingEvents(reqs[i:]), nil
+			}
+			return pendingEvents(reqs[i:]), fmt.Errorf("%w: status=%v", errTempFailure, status)
+
+		default:
+			c.log.Warnf("Endpoint rejected %v events (status=%v): dropping events!", len(req.events), status)
+			reason := fmt.Errorf("request rejected with status %v", status)
+			for i := range req.events {
+				c.deadLetter.Add(&req.events[i].Content, reason)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// encodeRequests serializes events into requests according to the configured
+// batch mode. Events that can not be encoded are handed to the dead letter
+// queue. Events with different values for the per event headers are sent in
+// separate requests.
+func (c *client) encodeRequests(data []publisher.Event) []*request {
+	var (
+		reqs  []*request
+		byKey = map[string]*request{}
+	)
+
+	for i := range data {
+		event := &data[i]
+
+		header, key, err := c.eventHeaders(event)
+		if err != nil {
+			c.log.Errorf("Failed to format request headers: %+v", err)
+			c.deadLetter.Add(&event.Content, err)
+			continue
+		}
+
+		serialized, err := c.codec.Encode(c.index, &event.Content)
+		if err != nil {
+			c.log.Errorf("Failed to serialize the event: %+v", err)
+			c.log.Debugf("Failed event: %v", event)
+			c.deadLetter.Add(&event.Content, err)
+			continue
+		}
+
+		req := byKey[key]
+		if req == nil || c.mode == batchModeSingle {
+			req = &request{key: key, header: header}
+			reqs = append(reqs, req)
+			byKey[key] = req
+		}
+
+		switch c.mode {
+		case batchModeArray:
+			if len(req.events) == 0 {
+				req.body.WriteByte('[')
+			} else {
+				req.body.WriteByte(',')
+			}
+			req.body.Write(serialized)
+		case batchModeNDJSON:
+			req.body.Write(serialized)
+			req.body.WriteByte('\n')
+		default:
+			req.body.Write(serialized)
+		}
+		req.events = append(req.events, *event)
+	}
+
+	if c.mode == batchModeArray {
+		for _, req := range reqs {
+			req.body.WriteByte(']')
+		}
+	}
+	return reqs
+}
+
+// eventHeaders computes the per event headers. The returned key is equal for
+// all events sharing the same header values.
+func (c *client) eventHeaders(event *publisher.Event) (nethttp.Header, string, error) {
+	if len(c.dynamicHeaders) == 0 {
+		return nil, "", nil
+	}
+
+	header := nethttp.Header{}
+	var key strings.Builder
+	for _, h := range c.dynamicHeaders {
+		value, err := h.value.Run(&event.Content)
+		if err != nil {
+			return nil, "", fmt.Errorf("header '%v': %v", h.name, err)
+		}
+		header.Set(h.name, value)
+		key.WriteString(value)
+		key.WriteByte(0)
+	}
+	return header, key.String(), nil
+}
+
+func (c *client) send(req *request) (int, time.Duration, error) {
+	body, err := c.requestBody(req)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	httpReq, err := nethttp.NewRequest(c.method, c.url, body)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	httpReq.Header.Set("Content-Type", c.contentType())
+	if c.compressionLevel > 0 {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	for name, values := range c.staticHeaders {
+		httpReq.Header[name] = values
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	if c.username != "" || c.password != "" {
+		httpReq.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		c.log.Debugf("Request failed (status=%v): %s", resp.StatusCode, msg)
+	} else {
+		io.Copy(ioutil.Discard, resp.Body)
+	}
+
+	return resp.StatusCode, c.retryAfter(resp), nil
+}
+
+func (c *client) requestBody(req *request) (io.Reader, error) {
+	if c.compressionLevel == 0 {
+		return bytes.NewReader(req.body.Bytes()), nil
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.compressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(req.body.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func (c *client) contentType() string {
+	if c.mode == batchModeNDJSON {
+		return "application/x-ndjson"
+	}
+	return "application/json; charset=UTF-8"
+}
+
+// retryAfter parses the Retry-After response header. The header can contain
+// either the number of seconds to wait or a HTTP date. The returned duration
+// is capped to maxRetryAfter.
+func (c *client) retryAfter(resp *nethttp.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	var d time.Duration
+	if secs, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := nethttp.ParseTime(value); err == nil {
+		d = time.Until(t)
+	}
+
+	if d < 0 {
+		return 0
+	}
+	if c.maxRetryAfter > 0 && d > c.maxRetryAfter {
+		return c.maxRetryAfter
+	}
+	return d
+}
+
+// retryableStatus reports whether a request failing with the given status
+// code should be retried. All 5xx codes, 408 Request Timeout and 429 Too Many
+// Requests are considered temporary.
+func retryableStatus(status int) bool {
+	switch status {
+	case nethttp.StatusRequestTimeout, nethttp.StatusTooManyRequests:
+		return true
+	}
+	return status >= 500
+}
+
+func pendingEvents(reqs []*request) []publisher.Event {
+	var events []publisher.Event
+	for _, req := range reqs {
+		events = append(events, req.events...)
+	}
+	return events
+}
+
+func (c *client) Test(d testing.Driver) {
+	d.Run("http: "+c.url, func(d testing.Driver) {
+		u, err := url.Parse(c.url)
+		d.Fatal("parse url", err)
+
+		address := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			address = u.Hostname() + ":" + port
+		}
+
+		d.Run("connection", func(d testing.Driver) {
+			netDialer := transport.TestNetDialer(d, c.transport.Timeout)
+			_, err = netDialer.Dial("tcp", address)
+			d.Fatal("dial up", err)
+		})
+
+		if u.Scheme != "https" {
+			d.Warn("TLS", "secure connection disabled")
+			return
+		}
+
+		d.Run("TLS", func(d testing.Driver) {
+			tls, err := tlscommon.LoadTLSConfig(c.transport.TLS)
+			if err != nil {
+				d.Fatal("load tls config", err)
+			}
+
+			netDialer := transport.NetDialer(c.transport.Timeout)
+			tlsDialer := transport.TestTLSDialer(d, netDialer, tls, c.transport.Timeout)
+			_, err = tlsDialer.Dial("tcp", address)
+			d.Fatal("dial up", err)
+		})
+	})
+}
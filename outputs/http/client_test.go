@@ -0,0 +1,339 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
+	"github.com/njcx/libbeat_v7/outputs/codec/format"
+	"github.com/njcx/libbeat_v7/outputs/codec/json"
+	"github.com/njcx/libbeat_v7/outputs/outest"
+)
+
+type capturedRequest struct {
+	header nethttp.Header
+	body   string
+}
+
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []capturedRequest
+	handler  func(w nethttp.ResponseWriter, r *nethttp.Request, body string)
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		var reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			reader = gz
+		}
+		raw, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, capturedRequest{header: r.Header.Clone(), body: string(raw)})
+		handler := s.handler
+		s.mu.Unlock()
+
+		if handler != nil {
+			handler(w, r, string(raw))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) captured() []capturedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]capturedRequest(nil), s.requests...)
+}
+
+func newTestClient(t *testing.T, url string, mode batchMode, mod func(*clientSettings)) *client {
+	settings := clientSettings{
+		URL:    url,
+		Method: "POST",
+		Mode:   mode,
+		Codec: format.New(fmtstr.MustCompileEvent(
+			`{"message":"%{[message]}"}`,
+		)),
+		MaxRetryAfter: 50 * time.Millisecond,
+		Transport:     httpcommon.DefaultHTTPTransportSettings(),
+	}
+	if mod != nil {
+		mod(&settings)
+	}
+
+	c, err := newClient(settings)
+	require.NoError(t, err)
+	require.NoError(t, c.Connect())
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testEvents(messages ...string) []beat.Event {
+	events := make([]beat.Event, len(messages))
+	for i, msg := range messages {
+		events[i] = beat.Event{
+			Timestamp: time.Now(),
+			Fields:    common.MapStr{"message": msg, "tenant": "t" + msg},
+		}
+	}
+	return events
+}
+
+func TestBatchModes(t *testing.T) {
+	cases := map[string]struct {
+		mode        batchMode
+		contentType string
+		bodies      []string
+	}{
+		"array": {
+			mode:        batchModeArray,
+			contentType: "application/json; charset=UTF-8",
+			bodies:      []string{`[{"message":"a"},{"message":"b"}]`},
+		},
+		"ndjson": {
+			mode:        batchModeNDJSON,
+			contentType: "application/x-ndjson",
+			bodies:      []string{"{\"message\":\"a\"}\n{\"message\":\"b\"}\n"},
+		},
+		"single": {
+			mode:        batchModeSingle,
+			contentType: "application/json; charset=UTF-8",
+			bodies:      []string{`{"message":"a"}`, `{"message":"b"}`},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t)
+			c := newTestClient(t, server.URL, test.mode, nil)
+
+			batch := outest.NewBatch(testEvents("a", "b")...)
+			require.NoError(t, c.Publish(context.Background(), batch))
+
+			requests := server.captured()
+			require.Len(t, requests, len(test.bodies))
+			for i, req := range requests {
+				assert.Equal(t, test.bodies[i], req.body)
+				assert.Equal(t, test.contentType, req.header.Get("Content-Type"))
+			}
+
+			require.Len(t, batch.Signals, 1)
+			assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+		})
+	}
+}
+
+func TestJSONArrayWithCompression(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestClient(t, server.URL, batchModeArray, func(s *clientSettings) {
+		s.Codec = json.New("1.2.3", json.Config{})
+		s.CompressionLevel = 5
+	})
+
+	batch := outest.NewBatch(testEvents("a")...)
+	require.NoError(t, c.Publish(context.Background(), batch))
+
+	requests := server.captured()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "gzip", requests[0].header.Get("Content-Encoding"))
+	assert.True(t, strings.HasPrefix(requests[0].body, `[{"@timestamp":`))
+	assert.True(t, strings.HasSuffix(requests[0].body, `}]`))
+}
+
+func TestHeaders(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestClient(t, server.URL, batchModeArray, func(s *clientSettings) {
+		s.Username = "user"
+		s.Password = "secret"
+		s.Headers = map[string]*fmtstr.EventFormatString{
+			"X-Static": fmtstr.MustCompileEvent("static"),
+			"X-Tenant": fmtstr.MustCompileEvent("%{[tenant]}"),
+		}
+	})
+
+	batch := outest.NewBatch(testEvents("a", "b", "a")...)
+	require.NoError(t, c.Publish(context.Background(), batch))
+
+	requests := server.captured()
+	require.Len(t, requests, 2)
+
+	assert.Equal(t, "ta", requests[0].header.Get("X-Tenant"))
+	assert.Equal(t, `[{"message":"a"},{"message":"a"}]`, requests[0].body)
+	assert.Equal(t, "tb", requests[1].header.Get("X-Tenant"))
+	assert.Equal(t, `[{"message":"b"}]`, requests[1].body)
+
+	for _, req := range requests {
+		assert.Equal(t, "static", req.header.Get("X-Static"))
+		assert.True(t, strings.HasPrefix(req.header.Get("Authorization"), "Basic "))
+	}
+}
+
+func TestStatusHandling(t *testing.T) {
+	cases := map[string]struct {
+		status     int
+		retryAfter string
+		wantTag    outest.BatchSignalTag
+		wantErr    bool
+	}{
+		"drop on client error": {
+			status:  nethttp.StatusBadRequest,
+			wantTag: outest.BatchACK,
+		},
+		"retry on server error": {
+			status:  nethttp.StatusServiceUnavailable,
+			wantTag: outest.BatchRetryEvents,
+			wantErr: true,
+		},
+		"retry on too many requests": {
+			status:  nethttp.StatusTooManyRequests,
+			wantTag: outest.BatchRetryEvents,
+			wantErr: true,
+		},
+		"honor retry-after": {
+			status:     nethttp.StatusTooManyRequests,
+			retryAfter: "1",
+			wantTag:    outest.BatchRetryEvents,
+			wantErr:    false,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.handler = func(w nethttp.ResponseWriter, _ *nethttp.Request, _ string) {
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(test.status)
+			}
+			c := newTestClient(t, server.URL, batchModeSingle, nil)
+
+			batch := outest.NewBatch(testEvents("a", "b")...)
+			err := c.Publish(context.Background(), batch)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			require.Len(t, batch.Signals, 1)
+			assert.Equal(t, test.wantTag, batch.Signals[0].Tag)
+			if test.wantTag == outest.BatchRetryEvents {
+				// sending stops after the first failed request
+				assert.Len(t, server.captured(), 1)
+				assert.Len(t, batch.Signals[0].Events, 2)
+			}
+		})
+	}
+}
+
+func TestRetryAfterInterrupted(t *testing.T) {
+	cases := map[string]func(c *client, cancel context.CancelFunc){
+		"context cancelled": func(_ *client, cancel context.CancelFunc) { cancel() },
+		"client closed":     func(c *client, _ context.CancelFunc) { c.Close() },
+	}
+
+	for name, interrupt := range cases {
+		interrupt := interrupt
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.handler = func(w nethttp.ResponseWriter, _ *nethttp.Request, _ string) {
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(nethttp.StatusServiceUnavailable)
+			}
+			c := newTestClient(t, server.URL, batchModeArray, func(s *clientSettings) {
+				s.MaxRetryAfter = time.Minute
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			time.AfterFunc(50*time.Millisecond, func() { interrupt(c, cancel) })
+
+			start := time.Now()
+			batch := outest.NewBatch(testEvents("a", "b")...)
+			require.NoError(t, c.Publish(ctx, batch))
+			assert.True(t, time.Since(start) < 10*time.Second, "publish must stop waiting for Retry-After")
+
+			require.Len(t, batch.Signals, 1)
+			assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+			assert.Len(t, batch.Signals[0].Events, 2)
+		})
+	}
+}
+
+func TestReconnectAfterClose(t *testing.T) {
+	server := newTestServer(t)
+	server.handler = func(w nethttp.ResponseWriter, _ *nethttp.Request, _ string) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(nethttp.StatusServiceUnavailable)
+	}
+	c := newTestClient(t, server.URL, batchModeArray, nil)
+
+	// The backoff wrapper closes the client on errors, the client must wait
+	// for Retry-After again once it has been reconnected.
+	require.NoError(t, c.Close())
+	require.NoError(t, c.Connect())
+
+	start := time.Now()
+	require.NoError(t, c.Publish(context.Background(), outest.NewBatch(testEvents("a")...)))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestRetryAfterParsing(t *testing.T) {
+	c := &client{maxRetryAfter: time.Minute}
+
+	resp := &nethttp.Response{Header: nethttp.Header{}}
+	assert.Equal(t, time.Duration(0), c.retryAfter(resp))
+
+	resp.Header.Set("Retry-After", "10")
+	assert.Equal(t, 10*time.Second, c.retryAfter(resp))
+
+	resp.Header.Set("Retry-After", "3600")
+	assert.Equal(t, time.Minute, c.retryAfter(resp))
+
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Hour).UTC().Format(nethttp.TimeFormat))
+	assert.Equal(t, time.Duration(0), c.retryAfter(resp))
+
+	resp.Header.Set("Retry-After", "invalid")
+	assert.Equal(t, time.Duration(0), c.retryAfter(resp))
+}
@@ -0,0 +1,117 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+type httpConfig struct {
+	Protocol         string                               `config:"protocol"`
+	Path             string                               `config:"path"`
+	Method           string                               `config:"method"`
+	Params           map[string]string                    `config:"parameters"`
+	Headers          map[string]*fmtstr.EventFormatString `config:"headers"`
+	Username         string                               `config:"username"`
+	Password         string                               `config:"password"`
+	LoadBalance      bool                                 `config:"loadbalance"`
+	BatchMode        string                               `config:"batch_mode"`
+	CompressionLevel int                                  `config:"compression_level" validate:"min=0, max=9"`
+	BulkMaxSize      int                                  `config:"bulk_max_size"`
+	MaxRetries       int                                  `config:"max_retries"`
+	Backoff          backoff                              `config:"backoff"`
+	Codec            codec.Config                         `config:"codec"`
+
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+type backoff struct {
+	Init time.Duration
+	Max  time.Duration
+}
+
+type batchMode uint8
+
+const (
+	// batchModeArray sends all events of a request as one JSON array.
+	batchModeArray batchMode = iota
+
+	// batchModeNDJSON sends all events of a request newline delimited.
+	batchModeNDJSON
+
+	// batchModeSingle sends one request per event.
+	batchModeSingle
+)
+
+var batchModes = map[string]batchMode{
+	"array":  batchModeArray,
+	"ndjson": batchModeNDJSON,
+	"single": batchModeSingle,
+}
+
+const (
+	defaultBulkSize = 50
+)
+
+func defaultConfig() httpConfig {
+	return httpConfig{
+		Protocol:         "",
+		Path:             "",
+		Method:           "POST",
+		Params:           nil,
+		Headers:          nil,
+		LoadBalance:      true,
+		BatchMode:        "array",
+		CompressionLevel: 0,
+		BulkMaxSize:      defaultBulkSize,
+		MaxRetries:       3,
+		Backoff: backoff{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+		Transport: httpcommon.DefaultHTTPTransportSettings(),
+	}
+}
+
+func (c *httpConfig) Validate() error {
+	switch strings.ToUpper(c.Method) {
+	case "POST", "PUT":
+	default:
+		return fmt.Errorf("http method '%v' not supported", c.Method)
+	}
+
+	if _, ok := batchModes[strings.ToLower(c.BatchMode)]; !ok {
+		return fmt.Errorf("batch_mode '%v' unknown", c.BatchMode)
+	}
+
+	if c.Username != "" && c.Password == "" {
+		return fmt.Errorf("password must be set when username is configured")
+	}
+
+	return nil
+}
+
+func (c *httpConfig) batchMode() batchMode {
+	return batchModes[strings.ToLower(c.BatchMode)]
+}
@@ -0,0 +1,101 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+)
+
+func TestConfigAcceptValid(t *testing.T) {
+	tests := map[string]common.MapStr{
+		"default config is valid": common.MapStr{},
+		"ndjson with PUT": common.MapStr{
+			"batch_mode": "ndjson",
+			"method":     "put",
+		},
+		"event dependent headers": common.MapStr{
+			"headers": common.MapStr{
+				"X-Tenant": "%{[tenant]}",
+				"X-Token":  "static",
+			},
+		},
+		"gzip compression": common.MapStr{
+			"compression_level": 9,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			c := common.MustNewConfigFrom(test)
+			config := defaultConfig()
+			require.NoError(t, c.Unpack(&config))
+		})
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	tests := map[string]common.MapStr{
+		"unknown batch mode": common.MapStr{
+			"batch_mode": "lines",
+		},
+		"unsupported method": common.MapStr{
+			"method": "GET",
+		},
+		"compression level out of range": common.MapStr{
+			"compression_level": 10,
+		},
+		"username without password": common.MapStr{
+			"username": "user",
+		},
+		"invalid header format string": common.MapStr{
+			"headers": common.MapStr{
+				"X-Tenant": "%{[tenant",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			c := common.MustNewConfigFrom(test)
+			config := defaultConfig()
+			assert.Error(t, c.Unpack(&config))
+		})
+	}
+}
+
+func TestMakeHTTP(t *testing.T) {
+	cfg := common.MustNewConfigFrom(common.MapStr{
+		"hosts":      []string{"localhost:8080", "https://example.com/ingest"},
+		"batch_mode": "single",
+	})
+
+	group, err := makeHTTP(nil, beat.Info{Beat: "libbeat", IndexPrefix: "libbeat"}, nil, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, defaultBulkSize, group.BatchSize)
+	assert.Len(t, group.Clients, 2)
+	assert.Equal(t, "backoff(http(http://localhost:8080))", group.Clients[0].String())
+	assert.Equal(t, "backoff(http(https://example.com/ingest))", group.Clients[1].String())
+}
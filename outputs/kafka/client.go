@@ -29,6 +29,7 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/eapache/go-resiliency/breaker"
 
+	"github.com/njcx/libbeat_v7/beat"
 	"github.com/njcx/libbeat_v7/common/fmtstr"
 	"github.com/njcx/libbeat_v7/common/transport"
 	"github.com/njcx/libbeat_v7/logp"
@@ -40,16 +41,20 @@ import (
 )
 
 type client struct {
-	log      *logp.Logger
-	observer outputs.Observer
-	hosts    []string
-	topic    outil.Selector
-	key      *fmtstr.EventFormatString
-	index    string
-	codec    codec.Codec
-	config   sarama.Config
-	mux      sync.Mutex
-	done     chan struct{}
+	log        *logp.Logger
+	observer   outputs.Observer
+	deadLetter outputs.DeadLetterQueue
+	hosts      []string
+	topic      outil.Selector
+	router     *outil.Router
+	key        *fmtstr.EventFormatString
+	headers    []headerConfig
+	trace      bool
+	index      string
+	codec      codec.Codec
+	config     sarama.Config
+	mux        sync.Mutex
+	done       chan struct{}
 
 	producer sarama.AsyncProducer
 
@@ -57,38 +62,53 @@ type client struct {
 }
 
 type msgRef struct {
-	client *client
-	count  int32
-	total  int
-	failed []publisher.Event
-	batch  publisher.Batch
+	client  *client
+	count   int32
+	total   int
+	dropped int32
+	failed  []publisher.Event
+	batch   publisher.Batch
+
+	// txnDone is closed once all events of a transactional batch have been
+	// handled. The batch is then completed when the transaction is committed
+	// or aborted, instead of by the last event.
+	txnDone chan struct{}
 
 	err error
 }
 
 var (
 	errNoTopicsSelected = errors.New("no topic could be selected")
+	errDroppedByRoute   = errors.New("event dropped by route processors")
 )
 
 func newKafkaClient(
 	observer outputs.Observer,
+	deadLetter outputs.DeadLetterQueue,
 	hosts []string,
 	index string,
 	key *fmtstr.EventFormatString,
+	headers []headerConfig,
+	traceContext bool,
 	topic outil.Selector,
+	router *outil.Router,
 	writer codec.Codec,
 	cfg *sarama.Config,
 ) (*client, error) {
 	c := &client{
-		log:      logp.NewLogger(logSelector),
-		observer: observer,
-		hosts:    hosts,
-		topic:    topic,
-		key:      key,
-		index:    strings.ToLower(index),
-		codec:    writer,
-		config:   *cfg,
-		done:     make(chan struct{}),
+		log:        logp.NewLogger(logSelector),
+		observer:   observer,
+		deadLetter: deadLetter,
+		hosts:      hosts,
+		topic:      topic,
+		router:     router,
+		key:        key,
+		headers:    headers,
+		trace:      traceContext,
+		index:      strings.ToLower(index),
+		codec:      writer,
+		config:     *cfg,
+		done:       make(chan struct{}),
 	}
 	return c, nil
 }
@@ -122,17 +142,21 @@ func (c *client) Close() error {
 
 	// producer was not created before the close() was called.
 	if c.producer == nil {
-		return nil
+		return c.router.Close()
 	}
 
 	close(c.done)
 	c.producer.AsyncClose()
 	c.wg.Wait()
 	c.producer = nil
-	return nil
+	return c.router.Close()
 }
 
 func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
+	if c.config.Producer.Transaction.ID != "" {
+		return c.publishTransaction(batch)
+	}
+
 	events := batch.Events()
 	c.observer.NewBatch(len(events))
 
@@ -143,24 +167,102 @@ func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
 		failed: nil,
 		batch:  batch,
 	}
+	c.sendEvents(ref, events)
+
+	return nil
+}
+
+// publishTransaction publishes a batch in a single Kafka transaction. The
+// batch is only ACKed once the transaction has been committed. If any event
+// fails the transaction is aborted, so that consumers reading committed
+// messages never see a partial batch, and all its events are retried.
+func (c *client) publishTransaction(batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	if err := c.producer.BeginTxn(); err != nil {
+		batch.Retry()
+		c.observer.Failed(len(events))
+		return c.transactionFailed(fmt.Errorf("begin transaction: %w", err))
+	}
+
+	ref := &msgRef{
+		client:  c,
+		count:   int32(len(events)),
+		total:   len(events),
+		failed:  nil,
+		batch:   batch,
+		txnDone: make(chan struct{}),
+	}
+	sent := c.sendEvents(ref, events)
+	if len(events) == 0 {
+		close(ref.txnDone)
+	}
+	<-ref.txnDone
+
+	err := ref.err
+	if err == nil && len(ref.failed) > 0 {
+		// Only circuit breaker errors fail events without setting ref.err.
+		err = breaker.ErrBreakerOpen
+	}
+	if err == nil {
+		if err = c.producer.CommitTxn(); err == nil {
+			ref.finish()
+			return nil
+		}
+		err = fmt.Errorf("commit transaction: %w", err)
+	}
+
+	if abortErr := c.producer.AbortTxn(); abortErr != nil {
+		c.log.Errorf("Kafka: aborting transaction failed: %v", abortErr)
+	}
+	ref.err = err
+	ref.failed = sent
+	ref.finish()
+	return c.transactionFailed(err)
+}
+
+// transactionFailed closes the producer if a transaction failed with a fatal
+// error, and returns the error so that the output reconnects with a new
+// producer. Other errors only fail the current batch.
+func (c *client) transactionFailed(err error) error {
+	if c.producer.TxnStatus()&sarama.ProducerTxnFlagFatalError == 0 {
+		c.log.Errorf("Kafka transaction failed, events will be retried: %v", err)
+		return nil
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.producer.AsyncClose()
+	c.wg.Wait()
+	c.producer = nil
+	return err
+}
 
+// sendEvents hands the events of a batch to the producer, and returns the
+// events that were sent.
+func (c *client) sendEvents(ref *msgRef, events []publisher.Event) []publisher.Event {
+	sent := make([]publisher.Event, 0, len(events))
 	ch := c.producer.Input()
 	for i := range events {
 		d := &events[i]
 		msg, err := c.getEventMessage(d)
+		if err == errDroppedByRoute {
+			ref.done()
+			continue
+		}
 		if err != nil {
 			c.log.Errorf("Dropping event: %+v", err)
-			ref.done()
-			c.observer.Dropped(1)
+			ref.drop(d, err)
 			continue
 		}
 
 		msg.ref = ref
 		msg.initProducerMessage()
+		sent = append(sent, *d)
 		ch <- &msg.msg
 	}
-
-	return nil
+	return sent
 }
 
 func (c *client) String() string {
@@ -171,6 +273,23 @@ func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
 	event := &data.Content
 	msg := &message{partition: -1, data: *data}
 
+	enc := c.codec
+	route := c.router.Route(event)
+	if route != nil {
+		if route.Codec != nil {
+			enc = route.Codec
+		}
+
+		var err error
+		event, err = route.Process(event)
+		if err != nil {
+			return nil, fmt.Errorf("%v failed with %v", route, err)
+		}
+		if event == nil {
+			return nil, errDroppedByRoute
+		}
+	}
+
 	value, err := data.Cache.GetValue("partition")
 	if err == nil {
 		if c.log.IsDebug() {
@@ -192,7 +311,7 @@ func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
 	}
 
 	if msg.topic == "" {
-		topic, err := c.topic.Select(event)
+		topic, err := c.selectTopic(route, event)
 		if err != nil {
 			return nil, fmt.Errorf("setting kafka topic failed with %v", err)
 		}
@@ -205,7 +324,7 @@ func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
 		}
 	}
 
-	serializedEvent, err := c.codec.Encode(c.index, event)
+	serializedEvent, err := enc.Encode(c.index, event)
 	if err != nil {
 		if c.log.IsDebug() {
 			c.log.Debugf("failed event: %v", event)
@@ -222,15 +341,33 @@ func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
 		msg.ts = event.Timestamp
 	}
 
-	if c.key != nil {
-		if key, err := c.key.RunBytes(event); err == nil {
+	keyFmt := c.key
+	if route != nil && route.Key != nil {
+		keyFmt = route.Key
+	}
+	if keyFmt != nil {
+		if key, err := keyFmt.RunBytes(event); err == nil {
 			msg.key = key
 		}
 	}
 
+	msg.headers = c.eventHeaders(event)
+
 	return msg, nil
 }
 
+// selectTopic selects the topic configured for the route, falling back to the
+// output topic if the route does not configure one.
+func (c *client) selectTopic(route *outil.Route, event *beat.Event) (string, error) {
+	if route != nil && !route.Target.IsEmpty() {
+		topic, err := route.Target.Select(event)
+		if err != nil || topic != "" {
+			return topic, err
+		}
+	}
+	return c.topic.Select(event)
+}
+
 func (c *client) successWorker(ch <-chan *sarama.ProducerMessage) {
 	defer c.wg.Done()
 	defer c.log.Debug("Stop kafka ack worker")
@@ -339,12 +476,15 @@ func (r *msgRef) fail(msg *message, err error) {
 	switch err {
 	case sarama.ErrInvalidMessage:
 		r.client.log.Errorf("Kafka (topic=%v): dropping invalid message", msg.topic)
-		r.client.observer.Dropped(1)
+		r.drop(&msg.data, err)
+		return
 
 	case sarama.ErrMessageSizeTooLarge, sarama.ErrInvalidMessageSize:
 		r.client.log.Errorf("Kafka (topic=%v): dropping too large message of size %v.",
 			msg.topic,
 			len(msg.key)+len(msg.value))
+		r.drop(&msg.data, err)
+		return
 
 	case breaker.ErrBreakerOpen:
 		// Add this message to the failed list, but don't overwrite r.err since
@@ -362,19 +502,36 @@ func (r *msgRef) fail(msg *message, err error) {
 	r.dec()
 }
 
+// drop hands an event that can not be published to the dead letter queue.
+func (r *msgRef) drop(event *publisher.Event, err error) {
+	atomic.AddInt32(&r.dropped, 1)
+	r.client.deadLetter.Add(&event.Content, err)
+	r.dec()
+}
+
 func (r *msgRef) dec() {
 	i := atomic.AddInt32(&r.count, -1)
 	if i > 0 {
 		return
 	}
 
+	if r.txnDone != nil {
+		close(r.txnDone)
+		return
+	}
+	r.finish()
+}
+
+// finish ACKs the batch, or retries the failed events if there was an error.
+func (r *msgRef) finish() {
 	r.client.log.Debug("finished kafka batch")
 	stats := r.client.observer
 
+	dropped := int(atomic.LoadInt32(&r.dropped))
 	err := r.err
 	if err != nil {
 		failed := len(r.failed)
-		success := r.total - failed
+		success := r.total - failed - dropped
 		r.batch.RetryEvents(r.failed)
 
 		stats.Failed(failed)
@@ -385,7 +542,9 @@ func (r *msgRef) dec() {
 		r.client.log.Debugf("Kafka publish failed with: %+v", err)
 	} else {
 		r.batch.ACK()
-		stats.Acked(r.total)
+		if success := r.total - dropped; success > 0 {
+			stats.Acked(success)
+		}
 	}
 }
 
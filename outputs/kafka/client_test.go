@@ -0,0 +1,211 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/outputs"
+	_ "github.com/njcx/libbeat_v7/outputs/codec/format"
+	"github.com/njcx/libbeat_v7/outputs/codec/json"
+	"github.com/njcx/libbeat_v7/outputs/outil"
+	_ "github.com/njcx/libbeat_v7/processors/actions"
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+func TestEventMessageRouting(t *testing.T) {
+	cfg, err := common.NewConfigWithYAML([]byte(`
+topic: events
+routes:
+  - when.equals.event.kind: audit
+    topic: audit
+    key: '%{[event.kind]}'
+  - when.equals.log.level: debug
+    topic: debug-%{[agent.name]}
+    codec.format.string: '%{[message]}'
+    processors:
+      - drop_event.when.equals.message: noise
+`), "test")
+	require.NoError(t, err)
+
+	info := beat.Info{Beat: "test", Version: "1.2.3"}
+	topic, err := buildTopicSelector(cfg)
+	require.NoError(t, err)
+	router, err := outil.BuildRouterFromConfig(info, cfg, topicSettings)
+	require.NoError(t, err)
+
+	key := fmtstr.MustCompileEvent("%{[message]}")
+	c, err := newKafkaClient(outputs.NewNilObserver(), outputs.NewDropQueue(nil), nil, "test",
+		key, nil, false, topic, router, json.New("1.2.3", json.Config{}), sarama.NewConfig())
+	require.NoError(t, err)
+	defer c.Close()
+
+	tests := map[string]struct {
+		fields    common.MapStr
+		wantTopic string
+		wantKey   string
+		wantValue string
+		wantJSON  bool
+		wantErr   error
+	}{
+		"default route": {
+			fields:    common.MapStr{"message": "hello"},
+			wantTopic: "events",
+			wantKey:   "hello",
+			wantJSON:  true,
+			wantValue: `{"@timestamp":"2022-03-04T10:00:00.000Z","@metadata":{"beat":"test","type":"_doc","version":"1.2.3"},"message":"hello"}`,
+		},
+		"audit route": {
+			fields:    common.MapStr{"message": "login", "event": common.MapStr{"kind": "audit"}},
+			wantTopic: "audit",
+			wantKey:   "audit",
+			wantJSON:  true,
+			wantValue: `{"@timestamp":"2022-03-04T10:00:00.000Z","@metadata":{"beat":"test","type":"_doc","version":"1.2.3"},"event":{"kind":"audit"},"message":"login"}`,
+		},
+		"debug route": {
+			fields:    common.MapStr{"message": "details", "log": common.MapStr{"level": "debug"}, "agent": common.MapStr{"name": "host1"}},
+			wantTopic: "debug-host1",
+			wantKey:   "details",
+			wantValue: "details",
+		},
+		"dropped by route processors": {
+			fields:  common.MapStr{"message": "noise", "log": common.MapStr{"level": "debug"}},
+			wantErr: errDroppedByRoute,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			event := &publisher.Event{Content: beat.Event{
+				Timestamp: time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC),
+				Fields:    test.fields,
+			}}
+
+			msg, err := c.getEventMessage(event)
+			if test.wantErr != nil {
+				assert.Equal(t, test.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.wantTopic, msg.topic)
+			assert.Equal(t, test.wantKey, string(msg.key))
+			if test.wantJSON {
+				assert.JSONEq(t, test.wantValue, string(msg.value))
+			} else {
+				assert.Equal(t, test.wantValue, string(msg.value))
+			}
+		})
+	}
+}
+
+func TestEventMessageHeaders(t *testing.T) {
+	cfg, err := common.NewConfigWithYAML([]byte(`
+hosts: [localhost]
+topic: events
+trace_context: true
+headers:
+  - key: source
+    value: static
+  - key: dataset
+    value: '%{[event.dataset]}'
+  - key: tenant
+    value: '%{[@metadata.tenant]}'
+`), "test")
+	require.NoError(t, err)
+	config, err := readConfig(cfg)
+	require.NoError(t, err)
+
+	info := beat.Info{Beat: "test", Version: "1.2.3"}
+	topic, err := buildTopicSelector(cfg)
+	require.NoError(t, err)
+	router, err := outil.BuildRouterFromConfig(info, cfg, topicSettings)
+	require.NoError(t, err)
+
+	c, err := newKafkaClient(outputs.NewNilObserver(), outputs.NewDropQueue(nil), nil, "test",
+		nil, config.Headers, config.TraceContext, topic, router, json.New("1.2.3", json.Config{}), sarama.NewConfig())
+	require.NoError(t, err)
+	defer c.Close()
+
+	tests := map[string]struct {
+		meta   common.MapStr
+		fields common.MapStr
+		want   map[string]string
+	}{
+		"static header only": {
+			fields: common.MapStr{"message": "hello"},
+			want:   map[string]string{"source": "static"},
+		},
+		"headers from fields and metadata": {
+			meta:   common.MapStr{"tenant": "acme"},
+			fields: common.MapStr{"event": common.MapStr{"dataset": "nginx.access"}},
+			want: map[string]string{
+				"source":  "static",
+				"dataset": "nginx.access",
+				"tenant":  "acme",
+			},
+		},
+		"trace context from fields": {
+			fields: common.MapStr{
+				"trace": common.MapStr{"id": "0af7651916cd43dd8448eb211c80319c"},
+				"span":  common.MapStr{"id": "b7ad6b7169203331"},
+			},
+			want: map[string]string{
+				"source":      "static",
+				"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			},
+		},
+		"trace context from metadata": {
+			meta:   common.MapStr{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-00"},
+			fields: common.MapStr{"message": "hello"},
+			want: map[string]string{
+				"source":      "static",
+				"traceparent": "00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-00",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			event := &publisher.Event{Content: beat.Event{
+				Timestamp: time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC),
+				Meta:      test.meta,
+				Fields:    test.fields,
+			}}
+
+			msg, err := c.getEventMessage(event)
+			require.NoError(t, err)
+
+			headers := map[string]string{}
+			for _, h := range msg.headers {
+				headers[string(h.Key)] = string(h.Value)
+			}
+			assert.Equal(t, test.want, headers)
+		})
+	}
+}
@@ -70,6 +70,15 @@ type kafkaConfig struct {
 	Codec              codec.Config              `config:"codec"`
 	Sasl               kafka.SaslConfig          `config:"sasl"`
 	EnableFAST         bool                      `config:"enable_krb5_fast"`
+	Idempotent         bool                      `config:"idempotent"`
+	Transaction        transactionConfig         `config:"transaction"`
+	Headers            []headerConfig            `config:"headers"`
+	TraceContext       bool                      `config:"trace_context"`
+}
+
+type transactionConfig struct {
+	ID      string        `config:"id"`
+	Timeout time.Duration `config:"timeout" validate:"min=0"`
 }
 
 type metaConfig struct {
@@ -164,6 +173,23 @@ func (c *kafkaConfig) Validate() error {
 			return fmt.Errorf("compression_level must be between 0 and 9")
 		}
 	}
+
+	if len(c.Headers) > 0 || c.TraceContext {
+		version, _ := c.Version.Get()
+		if !version.IsAtLeast(sarama.V0_11_0_0) {
+			return fmt.Errorf("record headers require kafka version 0.11 or newer, configured version is '%v'", c.Version)
+		}
+	}
+
+	if c.Idempotent || c.Transaction.ID != "" {
+		version, _ := c.Version.Get()
+		if !version.IsAtLeast(sarama.V0_11_0_0) {
+			return fmt.Errorf("idempotent and transactional producers require kafka version 0.11 or newer, configured version is '%v'", c.Version)
+		}
+		if c.RequiredACKs != nil && *c.RequiredACKs != int(sarama.WaitForAll) {
+			return fmt.Errorf("idempotent and transactional producers require required_acks: -1")
+		}
+	}
 	return nil
 }
 
@@ -259,6 +285,21 @@ func newSaramaConfig(log *logp.Logger, config *kafkaConfig) (*sarama.Config, err
 	// configure per broker go channel buffering
 	k.ChannelBufferSize = config.ChanBufferSize
 
+	// configure idempotent delivery. The idempotent producer requires
+	// acknowledgement by all replicas, and at most one in-flight request per
+	// broker to keep the sequence numbers in order.
+	if config.Idempotent || config.Transaction.ID != "" {
+		k.Producer.Idempotent = true
+		k.Producer.RequiredAcks = sarama.WaitForAll
+		k.Net.MaxOpenRequests = 1
+	}
+	if config.Transaction.ID != "" {
+		k.Producer.Transaction.ID = config.Transaction.ID
+		if config.Transaction.Timeout > 0 {
+			k.Producer.Transaction.Timeout = config.Transaction.Timeout
+		}
+	}
+
 	// configure bulk size
 	k.Producer.Flush.MaxMessages = config.BulkMaxSize
 	if config.BulkFlushFrequency > 0 {
@@ -40,6 +40,14 @@ func TestConfigAcceptValid(t *testing.T) {
 			"compression": "lz4",
 			"version":     "1.0.0",
 		},
+		"idempotent producer": common.MapStr{
+			"idempotent":    true,
+			"required_acks": -1,
+		},
+		"transactional producer": common.MapStr{
+			"version":     "2.0",
+			"transaction": common.MapStr{"id": "beats-1", "timeout": "30s"},
+		},
 		"Kerberos with keytab": common.MapStr{
 			"kerberos": common.MapStr{
 				"auth_type":    "keytab",
@@ -88,6 +96,18 @@ func TestConfigInvalid(t *testing.T) {
 				"realm":        "ELASTIC",
 			},
 		},
+		"idempotent producer with kafka 0.10": common.MapStr{
+			"idempotent": true,
+			"version":    "0.10",
+		},
+		"transactional producer with kafka 0.10": common.MapStr{
+			"version":     "0.10",
+			"transaction": common.MapStr{"id": "beats-1"},
+		},
+		"idempotent producer without acks from all replicas": common.MapStr{
+			"idempotent":    true,
+			"required_acks": 1,
+		},
 	}
 
 	for name, test := range tests {
@@ -0,0 +1,92 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+)
+
+// traceParentHeader is the W3C trace context header added to the records if
+// trace_context is enabled.
+const traceParentHeader = "traceparent"
+
+type headerConfig struct {
+	Key   string                    `config:"key"   validate:"required"`
+	Value *fmtstr.EventFormatString `config:"value" validate:"required"`
+}
+
+// eventHeaders returns the record headers for an event. Headers whose value
+// can not be formatted, for example because a field is missing, are omitted.
+func (c *client) eventHeaders(event *beat.Event) []sarama.RecordHeader {
+	var headers []sarama.RecordHeader
+	for _, h := range c.headers {
+		value, err := h.Value.RunBytes(event)
+		if err != nil || len(value) == 0 {
+			if c.log.IsDebug() {
+				c.log.Debugf("omitting kafka header %v: %v", h.Key, err)
+			}
+			continue
+		}
+		headers = append(headers, sarama.RecordHeader{Key: []byte(h.Key), Value: value})
+	}
+
+	if c.trace {
+		if traceParent := traceParent(event); traceParent != "" {
+			headers = append(headers, sarama.RecordHeader{
+				Key:   []byte(traceParentHeader),
+				Value: []byte(traceParent),
+			})
+		}
+	}
+	return headers
+}
+
+// traceParent returns the W3C traceparent of an event. It uses the
+// traceparent in @metadata if present, otherwise it is built from the ECS
+// trace.id field and the span.id or transaction.id field.
+func traceParent(event *beat.Event) string {
+	if v, err := event.Meta.GetValue(traceParentHeader); err == nil {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+
+	traceID := stringField(event, "trace.id")
+	parentID := stringField(event, "span.id")
+	if parentID == "" {
+		parentID = stringField(event, "transaction.id")
+	}
+	if traceID == "" || parentID == "" {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", traceID, parentID)
+}
+
+func stringField(event *beat.Event, key string) string {
+	v, err := event.GetValue(key)
+	if err != nil {
+		return ""
+	}
+	s, _ := v.(string)
+	return s
+}
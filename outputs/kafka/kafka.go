@@ -57,6 +57,11 @@ func makeKafka(
 		return outputs.Fail(err)
 	}
 
+	router, err := outil.BuildRouterFromConfig(beat, cfg, topicSettings)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
 	libCfg, err := newSaramaConfig(log, config)
 	if err != nil {
 		return outputs.Fail(err)
@@ -72,7 +77,12 @@ func makeKafka(
 		return outputs.Fail(err)
 	}
 
-	client, err := newKafkaClient(observer, hosts, beat.IndexPrefix, config.Key, topic, codec, libCfg)
+	deadLetter, err := outputs.LoadDeadLetterQueue(beat, "kafka", observer, cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	client, err := newKafkaClient(observer, deadLetter, hosts, beat.IndexPrefix, config.Key, config.Headers, config.TraceContext, topic, router, codec, libCfg)
 	if err != nil {
 		return outputs.Fail(err)
 	}
@@ -84,12 +94,14 @@ func makeKafka(
 	return outputs.Success(config.BulkMaxSize, retry, client)
 }
 
+var topicSettings = outil.Settings{
+	Key:              "topic",
+	MultiKey:         "topics",
+	EnableSingleOnly: true,
+	FailEmpty:        true,
+	Case:             outil.SelectorKeepCase,
+}
+
 func buildTopicSelector(cfg *common.Config) (outil.Selector, error) {
-	return outil.BuildSelectorFromConfig(cfg, outil.Settings{
-		Key:              "topic",
-		MultiKey:         "topics",
-		EnableSingleOnly: true,
-		FailEmpty:        true,
-		Case:             outil.SelectorKeepCase,
-	})
+	return outil.BuildSelectorFromConfig(cfg, topicSettings)
 }
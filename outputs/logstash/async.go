@@ -38,6 +38,7 @@ type asyncClient struct {
 	*transport.Client
 	observer outputs.Observer
 	client   *v2.AsyncClient
+	enc      *eventEncoder
 	win      *window
 
 	connect func() error
@@ -59,6 +60,7 @@ func newAsyncClient(
 	beat beat.Info,
 	conn *transport.Client,
 	observer outputs.Observer,
+	deadLetter outputs.DeadLetterQueue,
 	config *Config,
 ) (*asyncClient, error) {
 
@@ -67,6 +69,7 @@ func newAsyncClient(
 		log:      log,
 		Client:   conn,
 		observer: observer,
+		enc:      newEventEncoder(log, beat, config.EscapeHTML, config.Index, deadLetter),
 	}
 
 	if config.SlowStart {
@@ -77,12 +80,10 @@ func newAsyncClient(
 		log.Warn(`The async Logstash client does not support the "ttl" option`)
 	}
 
-	enc := makeLogstashEventEncoder(log, beat, config.EscapeHTML, config.Index)
-
 	queueSize := config.Pipelining - 1
 	timeout := config.Timeout
 	compressLvl := config.CompressionLevel
-	clientFactory := makeClientFactory(queueSize, timeout, enc, compressLvl)
+	clientFactory := makeClientFactory(queueSize, timeout, rawEncoder, compressLvl)
 
 	var err error
 	c.client, err = clientFactory(c.Client)
@@ -140,6 +141,7 @@ func (c *asyncClient) Publish(_ context.Context, batch publisher.Batch) error {
 	events := batch.Events()
 	st.NewBatch(len(events))
 
+	events, window := c.enc.encode(events)
 	if len(events) == 0 {
 		batch.ACK()
 		return nil
@@ -163,16 +165,17 @@ func (c *asyncClient) Publish(_ context.Context, batch publisher.Batch) error {
 		)
 
 		if c.win == nil {
-			n = len(events)
-			err = c.sendEvents(ref, events)
+			n = len(window)
+			err = c.sendEvents(ref, window)
 		} else {
-			n, err = c.publishWindowed(ref, events)
+			n, err = c.publishWindowed(ref, window)
 		}
 
 		c.log.Debugf("%v events out of %v events sent to logstash host %s. Continue sending",
 			n, len(events), c.Host())
 
 		events = events[n:]
+		window = window[n:]
 		if err != nil {
 			_ = c.Close()
 			return err
@@ -188,9 +191,9 @@ func (c *asyncClient) String() string {
 
 func (c *asyncClient) publishWindowed(
 	ref *msgRef,
-	events []publisher.Event,
+	window []interface{},
 ) (int, error) {
-	batchSize := len(events)
+	batchSize := len(window)
 	windowSize := c.win.get()
 
 	c.log.Debugf("Try to publish %v events to logstash host %s with window size %v",
@@ -198,26 +201,22 @@ func (c *asyncClient) publishWindowed(
 
 	// prepare message payload
 	if batchSize > windowSize {
-		events = events[:windowSize]
+		window = window[:windowSize]
 	}
 
-	err := c.sendEvents(ref, events)
+	err := c.sendEvents(ref, window)
 	if err != nil {
 		return 0, err
 	}
 
-	return len(events), nil
+	return len(window), nil
 }
 
-func (c *asyncClient) sendEvents(ref *msgRef, events []publisher.Event) error {
+func (c *asyncClient) sendEvents(ref *msgRef, window []interface{}) error {
 	client := c.getClient()
 	if client == nil {
 		return errors.New("connection closed")
 	}
-	window := make([]interface{}, len(events))
-	for i := range events {
-		window[i] = &events[i].Content
-	}
 	ref.count.Inc()
 	return client.Send(ref.callback, window)
 }
@@ -55,7 +55,7 @@ func makeAsyncTestClient(conn *transport.Client) testClientDriver {
 	config := defaultConfig()
 	config.Timeout = 1 * time.Second
 	config.Pipelining = 3
-	client, err := newAsyncClient(beat.Info{}, conn, outputs.NewNilObserver(), &config)
+	client, err := newAsyncClient(beat.Info{}, conn, outputs.NewNilObserver(), outputs.NewDropQueue(nil), &config)
 	if err != nil {
 		panic(err)
 	}
@@ -22,20 +22,65 @@ import (
 
 	"github.com/njcx/libbeat_v7/beat"
 	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
 	"github.com/njcx/libbeat_v7/outputs/codec/json"
+	"github.com/njcx/libbeat_v7/publisher"
 )
 
-func makeLogstashEventEncoder(log *logp.Logger, info beat.Info, escapeHTML bool, index string) func(interface{}) ([]byte, error) {
-	enc := json.New(info.Version, json.Config{
-		Pretty:     false,
-		EscapeHTML: escapeHTML,
-	})
-	index = strings.ToLower(index)
-	return func(event interface{}) (d []byte, err error) {
-		d, err = enc.Encode(index, event.(*beat.Event))
+// eventEncoder serializes events before they are passed to the lumberjack
+// client. Encoding events upfront allows us to hand events that can not be
+// encoded to the dead letter queue, instead of failing the complete batch.
+type eventEncoder struct {
+	log        *logp.Logger
+	enc        *json.Encoder
+	index      string
+	deadLetter outputs.DeadLetterQueue
+}
+
+func newEventEncoder(
+	log *logp.Logger,
+	info beat.Info,
+	escapeHTML bool,
+	index string,
+	deadLetter outputs.DeadLetterQueue,
+) *eventEncoder {
+	return &eventEncoder{
+		log: log,
+		enc: json.New(info.Version, json.Config{
+			Pretty:     false,
+			EscapeHTML: escapeHTML,
+		}),
+		index:      strings.ToLower(index),
+		deadLetter: deadLetter,
+	}
+}
+
+// encode serializes all events. It returns the events that have been encoded
+// successfully and the list of encoded events to be passed to the lumberjack
+// client. The input slice is not modified, as it is still owned by the batch
+// and used when the complete batch is retried.
+func (e *eventEncoder) encode(events []publisher.Event) ([]publisher.Event, []interface{}) {
+	okEvents := make([]publisher.Event, 0, len(events))
+	window := make([]interface{}, 0, len(events))
+	for i := range events {
+		event := events[i]
+		d, err := e.enc.Encode(e.index, &event.Content)
 		if err != nil {
-			log.Debugf("Failed to encode event: %v", event)
+			e.log.Errorf("Failed to encode event: %+v", err)
+			e.log.Debugf("Failed event: %v", event.Content)
+			e.deadLetter.Add(&event.Content, err)
+			continue
 		}
-		return
+
+		buf := make([]byte, len(d))
+		copy(buf, d)
+		window = append(window, buf)
+		okEvents = append(okEvents, event)
 	}
+	return okEvents, window
+}
+
+// rawEncoder passes events encoded by eventEncoder to the lumberjack client.
+func rawEncoder(event interface{}) ([]byte, error) {
+	return event.([]byte), nil
 }
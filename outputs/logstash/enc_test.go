@@ -0,0 +1,59 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+// +build !integration
+
+package logstash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs/outest"
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+func TestEncodeDeadLetter(t *testing.T) {
+	deadLetter := &outest.DeadLetter{}
+	enc := newEventEncoder(logp.NewLogger("test"), beat.Info{Version: "1.2.3"}, false, "test", deadLetter)
+
+	events := []publisher.Event{
+		{Content: beat.Event{Fields: common.MapStr{"message": "a"}}},
+		{Content: beat.Event{Fields: common.MapStr{"message": "b", "invalid": make(chan int)}}},
+		{Content: beat.Event{Fields: common.MapStr{"message": "c"}}},
+	}
+
+	okEvents, window := enc.encode(events)
+	require.Len(t, okEvents, 2)
+	require.Len(t, window, 2)
+	assert.Equal(t, "a", okEvents[0].Content.Fields["message"])
+	assert.Equal(t, "c", okEvents[1].Content.Fields["message"])
+
+	require.Len(t, deadLetter.Events(), 1)
+	assert.Equal(t, "b", deadLetter.Events()[0].Fields["message"])
+
+	// the events of the batch must be left untouched for retries
+	for i, msg := range []string{"a", "b", "c"} {
+		assert.Equal(t, msg, events[i].Content.Fields["message"])
+	}
+}
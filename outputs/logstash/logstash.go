@@ -63,6 +63,11 @@ func makeLogstash(
 		Stats:   observer,
 	}
 
+	deadLetter, err := outputs.LoadDeadLetterQueue(beat, "logstash", observer, cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
 	clients := make([]outputs.NetworkClient, len(hosts))
 	for i, host := range hosts {
 		var client outputs.NetworkClient
@@ -73,9 +78,9 @@ func makeLogstash(
 		}
 
 		if config.Pipelining > 0 {
-			client, err = newAsyncClient(beat, conn, observer, config)
+			client, err = newAsyncClient(beat, conn, observer, deadLetter, config)
 		} else {
-			client, err = newSyncClient(beat, conn, observer, config)
+			client, err = newSyncClient(beat, conn, observer, deadLetter, config)
 		}
 		if err != nil {
 			return outputs.Fail(err)
@@ -34,6 +34,7 @@ type syncClient struct {
 	*transport.Client
 	client   *v2.SyncClient
 	observer outputs.Observer
+	enc      *eventEncoder
 	win      *window
 	ttl      time.Duration
 	ticker   *time.Ticker
@@ -43,6 +44,7 @@ func newSyncClient(
 	beat beat.Info,
 	conn *transport.Client,
 	observer outputs.Observer,
+	deadLetter outputs.DeadLetterQueue,
 	config *Config,
 ) (*syncClient, error) {
 	log := logp.NewLogger("logstash")
@@ -50,6 +52,7 @@ func newSyncClient(
 		log:      log,
 		Client:   conn,
 		observer: observer,
+		enc:      newEventEncoder(log, beat, config.EscapeHTML, config.Index, deadLetter),
 		ttl:      config.TTL,
 	}
 
@@ -61,9 +64,8 @@ func newSyncClient(
 	}
 
 	var err error
-	enc := makeLogstashEventEncoder(log, beat, config.EscapeHTML, config.Index)
 	c.client, err = v2.NewSyncClientWithConn(conn,
-		v2.JSONEncoder(enc),
+		v2.JSONEncoder(rawEncoder),
 		v2.Timeout(config.Timeout),
 		v2.CompressionLevel(config.CompressionLevel),
 	)
@@ -108,6 +110,7 @@ func (c *syncClient) Publish(_ context.Context, batch publisher.Batch) error {
 
 	st.NewBatch(len(events))
 
+	events, window := c.enc.encode(events)
 	if len(events) == 0 {
 		batch.ACK()
 		return nil
@@ -119,7 +122,7 @@ func (c *syncClient) Publish(_ context.Context, batch publisher.Batch) error {
 			select {
 			case <-c.ticker.C:
 				if err := c.reconnect(); err != nil {
-					batch.Retry()
+					batch.RetryEvents(events)
 					return err
 				}
 
@@ -137,15 +140,16 @@ func (c *syncClient) Publish(_ context.Context, batch publisher.Batch) error {
 		)
 
 		if c.win == nil {
-			n, err = c.sendEvents(events)
+			n, err = c.client.Send(window)
 		} else {
-			n, err = c.publishWindowed(events)
+			n, err = c.publishWindowed(window)
 		}
 
 		c.log.Debugf("%v events out of %v events sent to logstash host %s. Continue sending",
 			n, len(events), c.Host())
 
 		events = events[n:]
+		window = window[n:]
 		st.Acked(n)
 		if err != nil {
 			// return batch to pipeline before reporting/counting error
@@ -169,18 +173,18 @@ func (c *syncClient) Publish(_ context.Context, batch publisher.Batch) error {
 	return nil
 }
 
-func (c *syncClient) publishWindowed(events []publisher.Event) (int, error) {
-	batchSize := len(events)
+func (c *syncClient) publishWindowed(window []interface{}) (int, error) {
+	batchSize := len(window)
 	windowSize := c.win.get()
 	c.log.Debugf("Try to publish %v events to logstash host %s with window size %v",
 		batchSize, c.Host(), windowSize)
 
 	// prepare message payload
 	if batchSize > windowSize {
-		events = events[:windowSize]
+		window = window[:windowSize]
 	}
 
-	n, err := c.sendEvents(events)
+	n, err := c.client.Send(window)
 	if err != nil {
 		return n, err
 	}
@@ -188,11 +192,3 @@ func (c *syncClient) publishWindowed(events []publisher.Event) (int, error) {
 	c.win.tryGrowWindow(batchSize)
 	return n, nil
 }
-
-func (c *syncClient) sendEvents(events []publisher.Event) (int, error) {
-	window := make([]interface{}, len(events))
-	for i := range events {
-		window[i] = &events[i].Content
-	}
-	return c.client.Send(window)
-}
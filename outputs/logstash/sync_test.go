@@ -68,7 +68,7 @@ func makeTestClient(conn *transport.Client) testClientDriver {
 	config := defaultConfig()
 	config.Timeout = 1 * time.Second
 	config.TTL = 5 * time.Second
-	client, err := newSyncClient(beat.Info{}, conn, outputs.NewNilObserver(), &config)
+	client, err := newSyncClient(beat.Info{}, conn, outputs.NewNilObserver(), outputs.NewDropQueue(nil), &config)
 	if err != nil {
 		panic(err)
 	}
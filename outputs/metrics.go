@@ -17,10 +17,15 @@
 
 package outputs
 
-import "github.com/njcx/libbeat_v7/monitoring"
+import (
+	"sort"
+	"sync"
 
-// Stats implements the Observer interface, for collecting metrics on common
-// outputs events.
+	"github.com/njcx/libbeat_v7/monitoring"
+)
+
+// Stats implements the Observer, BulkSizeObserver and HostHealthObserver
+// interfaces, for collecting metrics on common outputs events.
 type Stats struct {
 	//
 	// Output event stats
@@ -33,8 +38,15 @@ type Stats struct {
 	active     *monitoring.Uint // events sent and waiting for ACK/fail from output
 	duplicates *monitoring.Uint // events sent and waiting for ACK/fail from output
 	dropped    *monitoring.Uint // total number of invalid events dropped by the output
+	deadLetter *monitoring.Uint // total number of invalid events written to the dead letter queue
 	tooMany    *monitoring.Uint // total number of too many requests replies from output
 
+	bulkEvents *monitoring.Uint // current maximum number of events per bulk request
+	bulkBytes  *monitoring.Uint // current maximum number of bytes per bulk request
+
+	hostsMutex sync.Mutex
+	hosts      map[string]HostHealth // health of the hosts reported by the output
+
 	//
 	// Output network connection stats
 	//
@@ -49,22 +61,28 @@ type Stats struct {
 // This function will create and register a number of metrics with the registry passed.
 // The registry must not be null.
 func NewStats(reg *monitoring.Registry) *Stats {
-	return &Stats{
+	s := &Stats{
 		batches:    monitoring.NewUint(reg, "events.batches"),
 		events:     monitoring.NewUint(reg, "events.total"),
 		acked:      monitoring.NewUint(reg, "events.acked"),
 		failed:     monitoring.NewUint(reg, "events.failed"),
 		dropped:    monitoring.NewUint(reg, "events.dropped"),
+		deadLetter: monitoring.NewUint(reg, "events.dead_letter"),
 		duplicates: monitoring.NewUint(reg, "events.duplicates"),
 		active:     monitoring.NewUint(reg, "events.active"),
 		tooMany:    monitoring.NewUint(reg, "events.toomany"),
 
+		bulkEvents: monitoring.NewUint(reg, "bulk.max_events"),
+		bulkBytes:  monitoring.NewUint(reg, "bulk.max_bytes"),
+
 		writeBytes:  monitoring.NewUint(reg, "write.bytes"),
 		writeErrors: monitoring.NewUint(reg, "write.errors"),
 
 		readBytes:  monitoring.NewUint(reg, "read.bytes"),
 		readErrors: monitoring.NewUint(reg, "read.errors"),
 	}
+	monitoring.NewFunc(reg, "hosts", s.reportHosts, monitoring.Report)
+	return s
 }
 
 // NewBatch updates active batch and event metrics.
@@ -112,6 +130,15 @@ func (s *Stats) Dropped(n int) {
 	}
 }
 
+// DeadLettered updates total number of events written to the dead letter
+// queue, because the output failed to publish them permanently.
+func (s *Stats) DeadLettered(n int) {
+	if s != nil {
+		s.active.Sub(uint64(n))
+		s.deadLetter.Add(uint64(n))
+	}
+}
+
 // Cancelled updates the active event metrics.
 func (s *Stats) Cancelled(n int) {
 	if s != nil {
@@ -126,6 +153,63 @@ func (s *Stats) ErrTooMany(n int) {
 	}
 }
 
+// BulkSize updates the current limits of bulk requests of outputs adapting
+// their request sizes.
+func (s *Stats) BulkSize(events, bytes int) {
+	if s != nil {
+		s.bulkEvents.Set(uint64(events))
+		s.bulkBytes.Set(uint64(bytes))
+	}
+}
+
+// HostHealth updates the health of a host reported by outputs balancing events
+// between multiple hosts.
+func (s *Stats) HostHealth(host string, health HostHealth) {
+	if s != nil {
+		s.hostsMutex.Lock()
+		defer s.hostsMutex.Unlock()
+		if s.hosts == nil {
+			s.hosts = map[string]HostHealth{}
+		}
+		s.hosts[host] = health
+	}
+}
+
+// HostRemoved stops reporting the health of a host no longer used by the
+// output.
+func (s *Stats) HostRemoved(host string) {
+	if s != nil {
+		s.hostsMutex.Lock()
+		defer s.hostsMutex.Unlock()
+		delete(s.hosts, host)
+	}
+}
+
+func (s *Stats) reportHosts(_ monitoring.Mode, V monitoring.Visitor) {
+	s.hostsMutex.Lock()
+	defer s.hostsMutex.Unlock()
+
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	hosts := make([]string, 0, len(s.hosts))
+	for host := range s.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+		health := s.hosts[host]
+		monitoring.ReportNamespace(V, host, func() {
+			monitoring.ReportString(V, "state", health.State)
+			monitoring.ReportInt(V, "weight", int64(health.Weight))
+			monitoring.ReportInt(V, "latency_ms", health.Latency.Milliseconds())
+			monitoring.ReportFloat(V, "error_rate", health.ErrorRate)
+			monitoring.ReportInt(V, "ejections", int64(health.Ejections))
+		})
+	}
+}
+
 // WriteError increases the write I/O error metrics.
 func (s *Stats) WriteError(err error) {
 	if s != nil {
@@ -0,0 +1,384 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/outputs/outil"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/testing"
+)
+
+type clientSettings struct {
+	URLs         []string
+	Options      []nats.Option
+	Observer     outputs.Observer
+	DeadLetter   outputs.DeadLetterQueue
+	Index        string
+	Subject      outil.Selector
+	Codec        codec.Codec
+	Timeout      time.Duration
+	FlushTimeout time.Duration
+	JetStream    jetStreamConfig
+}
+
+type client struct {
+	log          *logp.Logger
+	observer     outputs.Observer
+	deadLetter   outputs.DeadLetterQueue
+	urls         []string
+	options      []nats.Option
+	index        string
+	subject      outil.Selector
+	codec        codec.Codec
+	timeout      time.Duration
+	flushTimeout time.Duration
+	jetStream    jetStreamConfig
+
+	mux  sync.Mutex
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+// message is a NATS message together with the event it was encoded from.
+type message struct {
+	event *publisher.Event
+	msg   *nats.Msg
+}
+
+var (
+	errNoSubjectSelected = errors.New("no subject could be selected")
+	errNotConnected      = errors.New("nats client is not connected")
+	errAckTimeout        = errors.New("timeout waiting for JetStream acknowledgement")
+)
+
+func newNATSClient(s clientSettings) *client {
+	return &client{
+		log:          logp.NewLogger(logSelector),
+		observer:     s.Observer,
+		deadLetter:   s.DeadLetter,
+		urls:         s.URLs,
+		options:      s.Options,
+		index:        strings.ToLower(s.Index),
+		subject:      s.Subject,
+		codec:        s.Codec,
+		timeout:      s.Timeout,
+		flushTimeout: s.FlushTimeout,
+		jetStream:    s.JetStream,
+	}
+}
+
+func (c *client) Connect() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.log.Debugf("connect: %v", c.urls)
+
+	conn, err := nats.Connect(strings.Join(c.urls, ","), c.options...)
+	if err != nil {
+		c.log.Errorf("NATS connect fails with: %+v", err)
+		return err
+	}
+
+	if c.jetStream.Enabled {
+		opts := []nats.JSOpt{nats.PublishAsyncMaxPending(c.jetStream.MaxPending)}
+		if c.jetStream.Domain != "" {
+			opts = append(opts, nats.Domain(c.jetStream.Domain))
+		}
+
+		js, err := conn.JetStream(opts...)
+		if err != nil {
+			conn.Close()
+			c.log.Errorf("NATS JetStream initialization fails with: %+v", err)
+			return err
+		}
+		c.js = js
+	}
+
+	c.conn = conn
+	return nil
+}
+
+func (c *client) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.log.Debug("closed nats client")
+
+	if c.conn == nil {
+		return nil
+	}
+
+	c.conn.Close()
+	c.conn = nil
+	c.js = nil
+	return nil
+}
+
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	c.mux.Lock()
+	conn, js := c.conn, c.js
+	c.mux.Unlock()
+	if conn == nil {
+		batch.Retry()
+		c.observer.Failed(len(events))
+		return errNotConnected
+	}
+
+	msgs := make([]message, 0, len(events))
+	dropped := 0
+	for i := range events {
+		d := &events[i]
+		msg, err := c.getEventMessage(d)
+		if err != nil {
+			c.log.Errorf("Dropping event: %+v", err)
+			c.deadLetter.Add(&d.Content, err)
+			dropped++
+			continue
+		}
+		msgs = append(msgs, message{event: d, msg: msg})
+	}
+
+	var (
+		failed []publisher.Event
+		n      int
+		err    error
+	)
+	if js != nil {
+		failed, n, err = c.publishJetStream(ctx, js, msgs)
+	} else {
+		failed, n, err = c.publishCore(conn, msgs)
+	}
+	dropped += n
+
+	success := len(events) - len(failed) - dropped
+	if len(failed) > 0 {
+		batch.RetryEvents(failed)
+		c.observer.Failed(len(failed))
+	} else {
+		batch.ACK()
+	}
+	if success > 0 {
+		c.observer.Acked(success)
+	}
+
+	if err != nil {
+		c.log.Debugf("NATS publish failed with: %+v", err)
+	}
+	return err
+}
+
+// publishCore publishes the messages on the NATS connection and waits for the
+// server to have processed them. As core NATS has no delivery guarantee, all
+// messages are retried if the connection can not be flushed.
+func (c *client) publishCore(conn *nats.Conn, msgs []message) ([]publisher.Event, int, error) {
+	var (
+		sent    []message
+		dropped int
+	)
+	for i, m := range msgs {
+		err := conn.PublishMsg(m.msg)
+		if err == nil {
+			sent = append(sent, m)
+			continue
+		}
+
+		if c.drop(m, err) {
+			dropped++
+			continue
+		}
+		return eventsOf(append(sent, msgs[i:]...)), dropped, err
+	}
+
+	if err := conn.FlushTimeout(c.flushTimeout); err != nil {
+		return eventsOf(sent), dropped, err
+	}
+	return nil, dropped, nil
+}
+
+// publishJetStream publishes the messages to JetStream and waits for the
+// stream to acknowledge each message. Messages not acknowledged within the
+// ack timeout are retried.
+func (c *client) publishJetStream(
+	ctx context.Context,
+	js nats.JetStreamContext,
+	msgs []message,
+) ([]publisher.Event, int, error) {
+	type pending struct {
+		message
+		future nats.PubAckFuture
+	}
+
+	var (
+		failed  []publisher.Event
+		dropped int
+		first   error
+	)
+	fail := func(m message, err error) {
+		failed = append(failed, *m.event)
+		if first == nil {
+			first = err
+		}
+	}
+
+	inflight := make([]pending, 0, len(msgs))
+	for i, m := range msgs {
+		future, err := js.PublishMsgAsync(m.msg)
+		if err == nil {
+			inflight = append(inflight, pending{m, future})
+			continue
+		}
+
+		if c.drop(m, err) {
+			dropped++
+			continue
+		}
+		for _, m := range msgs[i:] {
+			fail(m, err)
+		}
+		break
+	}
+
+	timer := time.NewTimer(c.jetStream.AckTimeout)
+	defer timer.Stop()
+
+	expired := false
+	for _, p := range inflight {
+		if expired {
+			// don't wait anymore, but collect the acknowledgements that
+			// have been received already.
+			select {
+			case <-p.future.Ok():
+			case err := <-p.future.Err():
+				fail(p.message, err)
+			default:
+				fail(p.message, errAckTimeout)
+			}
+			continue
+		}
+
+		select {
+		case <-p.future.Ok():
+		case err := <-p.future.Err():
+			fail(p.message, err)
+		case <-timer.C:
+			expired = true
+			fail(p.message, errAckTimeout)
+		case <-ctx.Done():
+			expired = true
+			fail(p.message, ctx.Err())
+		}
+	}
+
+	return failed, dropped, first
+}
+
+// drop hands messages that will never be accepted by NATS to the dead letter
+// queue. It returns false if the error is not permanent.
+func (c *client) drop(m message, err error) bool {
+	switch err {
+	case nats.ErrMaxPayload:
+		c.log.Errorf("NATS (subject=%v): dropping too large message of size %v.", m.msg.Subject, len(m.msg.Data))
+	case nats.ErrBadSubject:
+		c.log.Errorf("NATS (subject=%v): dropping message with invalid subject", m.msg.Subject)
+	default:
+		return false
+	}
+
+	c.deadLetter.Add(&m.event.Content, err)
+	return true
+}
+
+func (c *client) getEventMessage(data *publisher.Event) (*nats.Msg, error) {
+	event := &data.Content
+
+	var subject string
+	value, err := data.Cache.GetValue("subject")
+	if err == nil {
+		if s, ok := value.(string); ok {
+			subject = s
+		}
+	}
+
+	if subject == "" {
+		subject, err = c.subject.Select(event)
+		if err != nil {
+			return nil, fmt.Errorf("setting nats subject failed with %v", err)
+		}
+		if subject == "" {
+			return nil, errNoSubjectSelected
+		}
+		if _, err := data.Cache.Put("subject", subject); err != nil {
+			return nil, fmt.Errorf("setting nats subject in publisher event failed: %v", err)
+		}
+	}
+
+	serializedEvent, err := c.codec.Encode(c.index, event)
+	if err != nil {
+		if c.log.IsDebug() {
+			c.log.Debugf("failed event: %v", event)
+		}
+		return nil, err
+	}
+
+	buf := make([]byte, len(serializedEvent))
+	copy(buf, serializedEvent)
+	return &nats.Msg{Subject: subject, Data: buf}, nil
+}
+
+func eventsOf(msgs []message) []publisher.Event {
+	events := make([]publisher.Event, len(msgs))
+	for i, m := range msgs {
+		events[i] = *m.event
+	}
+	return events
+}
+
+func (c *client) String() string {
+	return "nats(" + strings.Join(c.urls, ",") + ")"
+}
+
+func (c *client) Test(d testing.Driver) {
+	for _, serverURL := range c.urls {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			d.Fatal("parse url", err)
+		}
+
+		d.Run("NATS: "+u.Host, func(d testing.Driver) {
+			netDialer := transport.TestNetDialer(d, c.timeout)
+			_, err := netDialer.Dial("tcp", u.Host)
+			d.Error("dial up", err)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package nats
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec/format"
+	"github.com/njcx/libbeat_v7/outputs/outest"
+)
+
+func runServer(t *testing.T, opts *server.Options) *server.Server {
+	opts.Host = "127.0.0.1"
+	opts.Port = -1
+	opts.NoLog = true
+	opts.NoSigs = true
+	if opts.JetStream {
+		opts.StoreDir = t.TempDir()
+	}
+
+	s, err := server.NewServer(opts)
+	require.NoError(t, err)
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func newTestClient(t *testing.T, s *server.Server, cfg common.MapStr) (*client, *outest.DeadLetter) {
+	settings := common.MapStr{
+		"hosts":   []string{s.ClientURL()},
+		"subject": "logs.%{[tenant]}",
+	}
+	settings.DeepUpdate(cfg)
+
+	config, err := readConfig(common.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+
+	subject, err := buildSubjectSelector(common.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+
+	deadLetter := &outest.DeadLetter{}
+	c := newNATSClient(clientSettings{
+		URLs:         config.serverURLs(),
+		Observer:     outputs.NewNilObserver(),
+		DeadLetter:   deadLetter,
+		Subject:      subject,
+		Codec:        format.New(fmtstr.MustCompileEvent("%{[message]}")),
+		Timeout:      config.Timeout,
+		FlushTimeout: config.FlushTimeout,
+		JetStream:    config.JetStream,
+	})
+	require.NoError(t, c.Connect())
+	t.Cleanup(func() { c.Close() })
+	return c, deadLetter
+}
+
+func testEvents(tenant string, messages ...string) []beat.Event {
+	events := make([]beat.Event, len(messages))
+	for i, msg := range messages {
+		events[i] = beat.Event{
+			Timestamp: time.Now(),
+			Fields:    common.MapStr{"message": msg, "tenant": tenant},
+		}
+	}
+	return events
+}
+
+func subscribe(t *testing.T, s *server.Server, subject string) *nats.Subscription {
+	conn, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	sub, err := conn.SubscribeSync(subject)
+	require.NoError(t, err)
+	require.NoError(t, conn.Flush())
+	return sub
+}
+
+func TestPublishCore(t *testing.T) {
+	s := runServer(t, &server.Options{})
+	sub := subscribe(t, s, "logs.>")
+	c, _ := newTestClient(t, s, nil)
+
+	events := append(testEvents("a", "1", "2"), testEvents("b", "3")...)
+	batch := outest.NewBatch(events...)
+	require.NoError(t, c.Publish(context.Background(), batch))
+
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	var received []string
+	for range events {
+		msg, err := sub.NextMsg(5 * time.Second)
+		require.NoError(t, err)
+		received = append(received, msg.Subject+":"+string(msg.Data))
+	}
+	assert.Equal(t, []string{"logs.a:1", "logs.a:2", "logs.b:3"}, received)
+}
+
+func TestPublishDeadLetter(t *testing.T) {
+	s := runServer(t, &server.Options{MaxPayload: 16})
+	sub := subscribe(t, s, "logs.>")
+	c, deadLetter := newTestClient(t, s, nil)
+
+	events := testEvents("a", "small", strings.Repeat("x", 32))
+	events = append(events, beat.Event{Fields: common.MapStr{"message": "no subject"}})
+	batch := outest.NewBatch(events...)
+	require.NoError(t, c.Publish(context.Background(), batch))
+
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+	assert.Len(t, deadLetter.Events(), 2)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(msg.Data))
+}
+
+func TestPublishJetStream(t *testing.T) {
+	s := runServer(t, &server.Options{JetStream: true})
+
+	conn, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer conn.Close()
+	js, err := conn.JetStream()
+	require.NoError(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "LOGS", Subjects: []string{"logs.a"}})
+	require.NoError(t, err)
+
+	c, _ := newTestClient(t, s, common.MapStr{
+		"jetstream.enabled":     true,
+		"jetstream.ack_timeout": "5s",
+	})
+
+	t.Run("acknowledged by stream", func(t *testing.T) {
+		batch := outest.NewBatch(testEvents("a", "1", "2", "3")...)
+		require.NoError(t, c.Publish(context.Background(), batch))
+
+		require.Len(t, batch.Signals, 1)
+		assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+		info, err := js.StreamInfo("LOGS")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), info.State.Msgs)
+	})
+
+	t.Run("no stream retries events", func(t *testing.T) {
+		events := append(testEvents("a", "4"), testEvents("b", "5", "6")...)
+		batch := outest.NewBatch(events...)
+		require.Error(t, c.Publish(context.Background(), batch))
+
+		require.Len(t, batch.Signals, 1)
+		assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+		require.Len(t, batch.Signals[0].Events, 2)
+		for _, event := range batch.Signals[0].Events {
+			assert.Equal(t, "b", event.Content.Fields["tenant"])
+		}
+	})
+}
+
+func TestPublishNotConnected(t *testing.T) {
+	s := runServer(t, &server.Options{})
+	c, _ := newTestClient(t, s, nil)
+	require.NoError(t, c.Close())
+
+	batch := outest.NewBatch(testEvents("a", "1")...)
+	require.Error(t, c.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetry, batch.Signals[0].Tag)
+}
@@ -0,0 +1,146 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/transport/tlscommon"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+type backoffConfig struct {
+	Init time.Duration `config:"init"`
+	Max  time.Duration `config:"max"`
+}
+
+type natsConfig struct {
+	Hosts           []string          `config:"hosts"            validate:"required"`
+	Name            string            `config:"name"`
+	Username        string            `config:"username"`
+	Password        string            `config:"password"`
+	Token           string            `config:"token"`
+	CredentialsFile string            `config:"credentials_file"`
+	TLS             *tlscommon.Config `config:"ssl"`
+	Timeout         time.Duration     `config:"timeout"          validate:"nonzero,positive"`
+	FlushTimeout    time.Duration     `config:"flush_timeout"    validate:"nonzero,positive"`
+	JetStream       jetStreamConfig   `config:"jetstream"`
+	BulkMaxSize     int               `config:"bulk_max_size"`
+	MaxRetries      int               `config:"max_retries"      validate:"min=-1"`
+	Backoff         backoffConfig     `config:"backoff"`
+	Codec           codec.Config      `config:"codec"`
+}
+
+type jetStreamConfig struct {
+	Enabled    bool          `config:"enabled"`
+	Domain     string        `config:"domain"`
+	AckTimeout time.Duration `config:"ack_timeout" validate:"nonzero,positive"`
+	MaxPending int           `config:"max_pending" validate:"min=1"`
+}
+
+const defaultPort = 4222
+
+func defaultConfig() natsConfig {
+	return natsConfig{
+		Hosts:        nil,
+		TLS:          nil,
+		Timeout:      10 * time.Second,
+		FlushTimeout: 10 * time.Second,
+		JetStream: jetStreamConfig{
+			Enabled:    false,
+			AckTimeout: 30 * time.Second,
+			MaxPending: 4096,
+		},
+		BulkMaxSize: 2048,
+		MaxRetries:  3,
+		Backoff: backoffConfig{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+	}
+}
+
+func readConfig(cfg *common.Config) (*natsConfig, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *natsConfig) Validate() error {
+	if len(c.Hosts) == 0 {
+		return errors.New("no hosts configured")
+	}
+
+	auth := 0
+	if c.Username != "" || c.Password != "" {
+		auth++
+	}
+	if c.Token != "" {
+		auth++
+	}
+	if c.CredentialsFile != "" {
+		auth++
+	}
+	if auth > 1 {
+		return errors.New("only one of username/password, token or credentials_file can be configured")
+	}
+
+	for _, host := range c.Hosts {
+		if _, err := parseHost(host); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serverURLs returns the configured hosts as NATS server URLs. Hosts without
+// scheme use the nats scheme, hosts without port the default NATS port.
+func (c *natsConfig) serverURLs() []string {
+	urls := make([]string, len(c.Hosts))
+	for i, host := range c.Hosts {
+		u, _ := parseHost(host)
+		urls[i] = u.String()
+	}
+	return urls
+}
+
+func parseHost(host string) (*url.URL, error) {
+	if !strings.Contains(host, "://") {
+		host = "nats://" + host
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host '%v': %v", host, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid host '%v': missing host name", host)
+	}
+	if u.Port() == "" {
+		u.Host = fmt.Sprintf("%v:%v", u.Host, defaultPort)
+	}
+	return u, nil
+}
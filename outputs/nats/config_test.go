@@ -0,0 +1,111 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package nats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/njcx/libbeat_v7/common"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		cfg   common.MapStr
+		valid bool
+	}{
+		"default config with hosts": {
+			cfg:   common.MapStr{"hosts": []string{"localhost"}},
+			valid: true,
+		},
+		"no hosts": {
+			cfg:   common.MapStr{},
+			valid: false,
+		},
+		"username and password": {
+			cfg: common.MapStr{
+				"hosts":    []string{"localhost"},
+				"username": "beats",
+				"password": "secret",
+			},
+			valid: true,
+		},
+		"username and token": {
+			cfg: common.MapStr{
+				"hosts":    []string{"localhost"},
+				"username": "beats",
+				"token":    "abc",
+			},
+			valid: false,
+		},
+		"token and credentials file": {
+			cfg: common.MapStr{
+				"hosts":            []string{"localhost"},
+				"token":            "abc",
+				"credentials_file": "/etc/nats/beats.creds",
+			},
+			valid: false,
+		},
+		"jetstream": {
+			cfg: common.MapStr{
+				"hosts":                 []string{"localhost"},
+				"jetstream.enabled":     true,
+				"jetstream.ack_timeout": "5s",
+			},
+			valid: true,
+		},
+		"invalid jetstream ack timeout": {
+			cfg: common.MapStr{
+				"hosts":                 []string{"localhost"},
+				"jetstream.ack_timeout": "0s",
+			},
+			valid: false,
+		},
+		"invalid host": {
+			cfg:   common.MapStr{"hosts": []string{"nats://:4222"}},
+			valid: false,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			_, err := readConfig(common.MustNewConfigFrom(test.cfg))
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestServerURLs(t *testing.T) {
+	config, err := readConfig(common.MustNewConfigFrom(common.MapStr{
+		"hosts": []string{"localhost", "nats://a:4333", "tls://b", "[::1]"},
+	}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{
+			"nats://localhost:4222",
+			"nats://a:4333",
+			"tls://b:4222",
+			"nats://[::1]:4222",
+		}, config.serverURLs())
+	}
+}
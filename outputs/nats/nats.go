@@ -0,0 +1,138 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package nats
+
+import (
+	"github.com/nats-io/nats.go"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/transport/tlscommon"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/outputs/outil"
+)
+
+const (
+	logSelector = "nats"
+)
+
+func init() {
+	outputs.RegisterType("nats", makeNATS)
+}
+
+func makeNATS(
+	_ outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *common.Config,
+) (outputs.Group, error) {
+	log := logp.NewLogger(logSelector)
+	log.Debug("initialize nats output")
+
+	config, err := readConfig(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	subject, err := buildSubjectSelector(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	options, err := newOptions(log, beat, config)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	codec, err := codec.CreateEncoder(beat, config.Codec)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	deadLetter, err := outputs.LoadDeadLetterQueue(beat, "nats", observer, cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	client := newNATSClient(clientSettings{
+		URLs:         config.serverURLs(),
+		Options:      options,
+		Observer:     observer,
+		DeadLetter:   deadLetter,
+		Index:        beat.IndexPrefix,
+		Subject:      subject,
+		Codec:        codec,
+		Timeout:      config.Timeout,
+		FlushTimeout: config.FlushTimeout,
+		JetStream:    config.JetStream,
+	})
+
+	return outputs.Success(config.BulkMaxSize, config.MaxRetries,
+		outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max))
+}
+
+func buildSubjectSelector(cfg *common.Config) (outil.Selector, error) {
+	return outil.BuildSelectorFromConfig(cfg, outil.Settings{
+		Key:              "subject",
+		MultiKey:         "subjects",
+		EnableSingleOnly: true,
+		FailEmpty:        true,
+		Case:             outil.SelectorKeepCase,
+	})
+}
+
+func newOptions(log *logp.Logger, beat beat.Info, config *natsConfig) ([]nats.Option, error) {
+	name := config.Name
+	if name == "" {
+		name = beat.Beat
+	}
+
+	options := []nats.Option{
+		nats.Name(name),
+		nats.Timeout(config.Timeout),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Warnf("NATS connection lost: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.Infof("NATS connection re-established to %v", conn.ConnectedUrl())
+		}),
+	}
+
+	switch {
+	case config.Username != "":
+		options = append(options, nats.UserInfo(config.Username, config.Password))
+	case config.Token != "":
+		options = append(options, nats.Token(config.Token))
+	case config.CredentialsFile != "":
+		options = append(options, nats.UserCredentials(config.CredentialsFile))
+	}
+
+	tls, err := tlscommon.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	if tls != nil {
+		options = append(options, nats.Secure(tls.BuildModuleClientConfig("")))
+	}
+
+	return options, nil
+}
@@ -17,6 +17,8 @@
 
 package outputs
 
+import "time"
+
 // Observer provides an interface used by outputs to report common events on
 // documents/events being published and I/O workload.
 type Observer interface {
@@ -24,6 +26,7 @@ type Observer interface {
 	Acked(int)        // report number of acked events
 	Failed(int)       // report number of failed events
 	Dropped(int)      // report number of dropped events
+	DeadLettered(int) // report number of events written to the dead letter queue
 	Duplicate(int)    // report number of events detected as duplicates (e.g. on resends)
 	Cancelled(int)    // report number of cancelled events
 	WriteError(error) // report an I/O error on write
@@ -33,6 +36,31 @@ type Observer interface {
 	ErrTooMany(int)   // report too many requests response
 }
 
+// BulkSizeObserver is implemented by observers reporting the limits of bulk
+// requests of outputs adapting the size of their requests. Outputs check for it
+// using a type assertion on their Observer.
+type BulkSizeObserver interface {
+	BulkSize(events, bytes int) // report the current events and bytes limits of bulk requests
+}
+
+// HostHealthObserver is implemented by observers reporting the health of the
+// hosts of outputs balancing events between multiple hosts. Outputs check for it
+// using a type assertion on their Observer.
+type HostHealthObserver interface {
+	HostHealth(host string, health HostHealth) // report the health of a host
+	HostRemoved(host string)                   // report that all clients of a host have been removed
+}
+
+// HostHealth describes the health of a single host of an output balancing
+// events between multiple hosts.
+type HostHealth struct {
+	State     string        // healthy, ejected or probing
+	Weight    int           // relative share of the workers publishing to the host
+	Latency   time.Duration // moving average of the request latency
+	ErrorRate float64       // moving average of the share of failed requests
+	Ejections uint64        // number of times the host has been ejected
+}
+
 type emptyObserver struct{}
 
 var nilObserver = (*emptyObserver)(nil)
@@ -47,6 +75,7 @@ func (*emptyObserver) Acked(int)        {}
 func (*emptyObserver) Duplicate(int)    {}
 func (*emptyObserver) Failed(int)       {}
 func (*emptyObserver) Dropped(int)      {}
+func (*emptyObserver) DeadLettered(int) {}
 func (*emptyObserver) Cancelled(int)    {}
 func (*emptyObserver) WriteError(error) {}
 func (*emptyObserver) WriteBytes(int)   {}
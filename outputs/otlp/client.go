@@ -0,0 +1,199 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/testing"
+)
+
+// exporter sends OTLP log export requests to a collector.
+type exporter interface {
+	Connect() error
+	Close() error
+	Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error)
+	Test(d testing.Driver)
+	String() string
+}
+
+// exportError is returned by exporters if the collector rejected a request.
+// Requests are retried if the failure is temporary. If the collector asks
+// the client to slow down, throttle holds the time to wait before the
+// request is retried.
+type exportError struct {
+	err       error
+	retryable bool
+	throttle  time.Duration
+}
+
+func (e *exportError) Error() string { return e.err.Error() }
+func (e *exportError) Unwrap() error { return e.err }
+
+type client struct {
+	log         *logp.Logger
+	observer    outputs.Observer
+	deadLetter  outputs.DeadLetterQueue
+	converter   *converter
+	exporter    exporter
+	maxThrottle time.Duration
+
+	// done is closed by Close to stop waiting while the collector throttles
+	// requests. Connect replaces it, as the client is reconnected after being
+	// closed on errors.
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+func newClient(
+	observer outputs.Observer,
+	deadLetter outputs.DeadLetterQueue,
+	converter *converter,
+	exporter exporter,
+	maxThrottle time.Duration,
+) *client {
+	return &client{
+		log:         logp.NewLogger(logSelector),
+		observer:    observer,
+		deadLetter:  deadLetter,
+		converter:   converter,
+		exporter:    exporter,
+		maxThrottle: maxThrottle,
+		done:        make(chan struct{}),
+	}
+}
+
+func (c *client) Connect() error {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.done = make(chan struct{})
+	default:
+	}
+	c.mu.Unlock()
+
+	return c.exporter.Connect()
+}
+
+func (c *client) Close() error {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.mu.Unlock()
+
+	return c.exporter.Close()
+}
+
+func (c *client) String() string {
+	return "otlp(" + c.exporter.String() + ")"
+}
+
+func (c *client) Test(d testing.Driver) {
+	c.exporter.Test(d)
+}
+
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	req := c.converter.convert(events)
+	resp, err := c.exporter.Export(ctx, req)
+	if err != nil {
+		return c.handleError(ctx, batch, err)
+	}
+
+	acked := len(events)
+	if partial := resp.GetPartialSuccess(); partial != nil && partial.RejectedLogRecords > 0 {
+		// The collector does not tell which records have been rejected, and
+		// rejected records must not be retried.
+		rejected := int(partial.RejectedLogRecords)
+		if rejected > acked {
+			rejected = acked
+		}
+		c.log.Warnf("Collector rejected %v of %v log records: %v", rejected, len(events), partial.ErrorMessage)
+		c.observer.Dropped(rejected)
+		acked -= rejected
+	}
+
+	batch.ACK()
+	c.observer.Acked(acked)
+	return nil
+}
+
+func (c *client) handleError(ctx context.Context, batch publisher.Batch, err error) error {
+	events := batch.Events()
+
+	c.mu.Lock()
+	done := c.done
+	c.mu.Unlock()
+
+	var exportErr *exportError
+	if !errors.As(err, &exportErr) {
+		// transport failure
+		batch.Retry()
+		c.observer.Failed(len(events))
+		return err
+	}
+
+	if !exportErr.retryable {
+		c.log.Warnf("Collector rejected %v log records: %v", len(events), err)
+		reason := fmt.Errorf("log records rejected by collector: %w", err)
+		for i := range events {
+			c.deadLetter.Add(&events[i].Content, reason)
+		}
+		batch.ACK()
+		return nil
+	}
+
+	batch.Retry()
+	c.observer.Failed(len(events))
+
+	throttle := exportErr.throttle
+	if throttle <= 0 {
+		return err
+	}
+	if c.maxThrottle > 0 && throttle > c.maxThrottle {
+		throttle = c.maxThrottle
+	}
+
+	// The collector told us when to come back. Wait here instead of
+	// returning an error, so the generic backoff does not add to the delay
+	// requested by the collector.
+	c.observer.ErrTooMany(len(events))
+	c.log.Infof("Collector is throttling requests, retrying after %v", throttle)
+	timer := time.NewTimer(throttle)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-done:
+	case <-timer.C:
+	}
+	return nil
+}
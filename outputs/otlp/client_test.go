@@ -0,0 +1,322 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/outest"
+)
+
+func testEvents(messages ...string) []beat.Event {
+	events := make([]beat.Event, len(messages))
+	for i, msg := range messages {
+		events[i] = beat.Event{
+			Timestamp: time.Now(),
+			Fields:    common.MapStr{"message": msg},
+		}
+	}
+	return events
+}
+
+func newTestClient(t *testing.T, exp exporter) (*client, *outest.DeadLetter) {
+	deadLetter := &outest.DeadLetter{}
+	c := newClient(outputs.NewNilObserver(), deadLetter, testConverter(), exp, 10*time.Millisecond)
+	require.NoError(t, c.Connect())
+	t.Cleanup(func() { c.Close() })
+	return c, deadLetter
+}
+
+type httpResponse struct {
+	status     int
+	retryAfter string
+	body       *collogspb.ExportLogsServiceResponse
+}
+
+func newHTTPTestClient(t *testing.T, responses ...httpResponse) (*client, *outest.DeadLetter, *[]*collogspb.ExportLogsServiceRequest) {
+	var (
+		mu       sync.Mutex
+		requests []*collogspb.ExportLogsServiceRequest
+	)
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		assert.Equal(t, "/v1/logs", r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		raw, err := ioutil.ReadAll(gz)
+		require.NoError(t, err)
+
+		req := &collogspb.ExportLogsServiceRequest{}
+		require.NoError(t, proto.Unmarshal(raw, req))
+
+		mu.Lock()
+		resp := httpResponse{status: 200}
+		if len(requests) < len(responses) {
+			resp = responses[len(requests)]
+		}
+		requests = append(requests, req)
+		mu.Unlock()
+
+		if resp.retryAfter != "" {
+			w.Header().Set("Retry-After", resp.retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(resp.status)
+		if resp.body != nil {
+			raw, _ := proto.Marshal(resp.body)
+			w.Write(raw)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	exp, err := newHTTPExporter(httpExporterSettings{
+		URL:       server.URL + defaultHTTPPath,
+		Headers:   map[string]string{"Authorization": "secret"},
+		Gzip:      true,
+		Observer:  outputs.NewNilObserver(),
+		Transport: httpcommon.DefaultHTTPTransportSettings(),
+	})
+	require.NoError(t, err)
+
+	c, deadLetter := newTestClient(t, exp)
+	return c, deadLetter, &requests
+}
+
+func TestHTTPExport(t *testing.T) {
+	tests := map[string]struct {
+		response     httpResponse
+		wantErr      bool
+		wantSignal   outest.BatchSignalTag
+		deadLettered int
+	}{
+		"success": {
+			response:   httpResponse{status: 200},
+			wantSignal: outest.BatchACK,
+		},
+		"partial success": {
+			response: httpResponse{status: 200, body: &collogspb.ExportLogsServiceResponse{
+				PartialSuccess: &collogspb.ExportLogsPartialSuccess{RejectedLogRecords: 1},
+			}},
+			wantSignal: outest.BatchACK,
+		},
+		"unavailable": {
+			response:   httpResponse{status: 503},
+			wantErr:    true,
+			wantSignal: outest.BatchRetry,
+		},
+		"throttled": {
+			response:   httpResponse{status: 429, retryAfter: "1"},
+			wantSignal: outest.BatchRetry,
+		},
+		"bad request": {
+			response:     httpResponse{status: 400},
+			wantSignal:   outest.BatchACK,
+			deadLettered: 2,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			c, deadLetter, requests := newHTTPTestClient(t, test.response)
+
+			batch := outest.NewBatch(testEvents("a", "b")...)
+			err := c.Publish(context.Background(), batch)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			require.Len(t, batch.Signals, 1)
+			assert.Equal(t, test.wantSignal, batch.Signals[0].Tag)
+			assert.Len(t, deadLetter.Events(), test.deadLettered)
+
+			require.Len(t, *requests, 1)
+			records := (*requests)[0].ResourceLogs[0].ScopeLogs[0].LogRecords
+			require.Len(t, records, 2)
+			assert.Equal(t, "a", records[0].Body.GetStringValue())
+		})
+	}
+}
+
+func TestThrottleInterruptedByClose(t *testing.T) {
+	c, _, _ := newHTTPTestClient(t, httpResponse{status: 429, retryAfter: "60"})
+	c.maxThrottle = time.Minute
+	time.AfterFunc(50*time.Millisecond, func() { c.Close() })
+
+	start := time.Now()
+	batch := outest.NewBatch(testEvents("a", "b")...)
+	require.NoError(t, c.Publish(context.Background(), batch))
+	assert.True(t, time.Since(start) < 10*time.Second, "publish must stop waiting once the client is closed")
+
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetry, batch.Signals[0].Tag)
+}
+
+type testLogsServer struct {
+	collogspb.UnimplementedLogsServiceServer
+
+	mu       sync.Mutex
+	requests []*collogspb.ExportLogsServiceRequest
+	md       []metadata.MD
+	err      error
+}
+
+func (s *testLogsServer) Export(
+	ctx context.Context,
+	req *collogspb.ExportLogsServiceRequest,
+) (*collogspb.ExportLogsServiceResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.requests = append(s.requests, req)
+	s.md = append(s.md, md)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+func newGRPCTestClient(t *testing.T, err error) (*client, *outest.DeadLetter, *testLogsServer) {
+	l, lerr := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, lerr)
+
+	srv := &testLogsServer{err: err}
+	server := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(server, srv)
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+
+	exp := newGRPCExporter(grpcExporterSettings{
+		Target:  l.Addr().String(),
+		Headers: map[string]string{"authorization": "secret"},
+		Gzip:    true,
+		Timeout: 5 * time.Second,
+	})
+	c, deadLetter := newTestClient(t, exp)
+	return c, deadLetter, srv
+}
+
+func TestGRPCExport(t *testing.T) {
+	throttled, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(time.Second),
+	})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		err          error
+		wantErr      bool
+		wantSignal   outest.BatchSignalTag
+		deadLettered int
+	}{
+		"success": {
+			wantSignal: outest.BatchACK,
+		},
+		"unavailable": {
+			err:        status.Error(codes.Unavailable, "unavailable"),
+			wantErr:    true,
+			wantSignal: outest.BatchRetry,
+		},
+		"throttled": {
+			err:        throttled.Err(),
+			wantSignal: outest.BatchRetry,
+		},
+		"resource exhausted without retry info": {
+			err:          status.Error(codes.ResourceExhausted, "too large"),
+			wantSignal:   outest.BatchACK,
+			deadLettered: 2,
+		},
+		"invalid argument": {
+			err:          status.Error(codes.InvalidArgument, "invalid"),
+			wantSignal:   outest.BatchACK,
+			deadLettered: 2,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			c, deadLetter, srv := newGRPCTestClient(t, test.err)
+
+			batch := outest.NewBatch(testEvents("a", "b")...)
+			err := c.Publish(context.Background(), batch)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			require.Len(t, batch.Signals, 1)
+			assert.Equal(t, test.wantSignal, batch.Signals[0].Tag)
+			assert.Len(t, deadLetter.Events(), test.deadLettered)
+
+			require.Len(t, srv.requests, 1)
+			assert.Equal(t, []string{"secret"}, srv.md[0].Get("authorization"))
+			assert.Len(t, srv.requests[0].ResourceLogs[0].ScopeLogs[0].LogRecords, 2)
+		})
+	}
+}
+
+func TestGRPCTarget(t *testing.T) {
+	tests := []struct {
+		host   string
+		target string
+		secure bool
+	}{
+		{"localhost", "localhost:4317", false},
+		{"localhost:5000", "localhost:5000", false},
+		{"http://collector:4317", "collector:4317", false},
+		{"https://collector", "collector:4317", true},
+		{"[::1]", "[::1]:4317", false},
+	}
+
+	for _, test := range tests {
+		target, secure, err := grpcTarget(test.host)
+		if assert.NoError(t, err, test.host) {
+			assert.Equal(t, test.target, target, test.host)
+			assert.Equal(t, test.secure, secure, test.host)
+		}
+	}
+}
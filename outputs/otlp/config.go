@@ -0,0 +1,108 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
+)
+
+type otlpConfig struct {
+	Protocol    string            `config:"protocol"`
+	Path        string            `config:"path"`
+	Headers     map[string]string `config:"headers"`
+	Compression string            `config:"compression"`
+	Resource    resourceConfig    `config:"resource"`
+	LoadBalance bool              `config:"loadbalance"`
+	BulkMaxSize int               `config:"bulk_max_size"`
+	MaxRetries  int               `config:"max_retries"   validate:"min=-1"`
+	Backoff     backoff           `config:"backoff"`
+
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+// resourceConfig configures the resource attributes of exported log records.
+// Fields lists the event fields, including all nested fields, that are
+// reported as resource attributes instead of log record attributes.
+type resourceConfig struct {
+	Fields     []string          `config:"fields"`
+	Attributes map[string]string `config:"attributes"`
+}
+
+type backoff struct {
+	Init time.Duration
+	Max  time.Duration
+}
+
+const (
+	protocolGRPC = "grpc"
+	protocolHTTP = "http"
+
+	defaultGRPCPort = 4317
+	defaultHTTPPort = 4318
+	defaultHTTPPath = "/v1/logs"
+)
+
+func defaultConfig() otlpConfig {
+	transport := httpcommon.DefaultHTTPTransportSettings()
+	transport.Timeout = 30 * time.Second
+
+	return otlpConfig{
+		Protocol:    protocolGRPC,
+		Path:        defaultHTTPPath,
+		Compression: "gzip",
+		Resource: resourceConfig{
+			Fields: []string{"host", "cloud", "kubernetes"},
+		},
+		LoadBalance: true,
+		BulkMaxSize: 1024,
+		MaxRetries:  3,
+		Backoff: backoff{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+		Transport: transport,
+	}
+}
+
+func readConfig(cfg *common.Config) (*otlpConfig, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *otlpConfig) Validate() error {
+	switch c.Protocol {
+	case protocolGRPC, protocolHTTP:
+	default:
+		return fmt.Errorf("unsupported protocol '%v'", c.Protocol)
+	}
+
+	switch c.Compression {
+	case "gzip", "none":
+	default:
+		return fmt.Errorf("unsupported compression '%v'", c.Compression)
+	}
+
+	return nil
+}
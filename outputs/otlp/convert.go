@@ -0,0 +1,309 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+var severityNumbers = map[string]logspb.SeverityNumber{
+	"trace":         logspb.SeverityNumber_SEVERITY_NUMBER_TRACE,
+	"debug":         logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+	"info":          logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+	"informational": logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+	"notice":        logspb.SeverityNumber_SEVERITY_NUMBER_INFO2,
+	"warn":          logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+	"warning":       logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+	"err":           logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	"error":         logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	"crit":          logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+	"critical":      logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+	"fatal":         logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+	"alert":         logspb.SeverityNumber_SEVERITY_NUMBER_FATAL2,
+	"emerg":         logspb.SeverityNumber_SEVERITY_NUMBER_FATAL3,
+	"emergency":     logspb.SeverityNumber_SEVERITY_NUMBER_FATAL3,
+	"panic":         logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4,
+}
+
+// Event fields mapped to top-level log record fields.
+const (
+	fieldMessage = "message"
+	fieldLevel   = "log.level"
+	fieldTraceID = "trace.id"
+	fieldSpanID  = "span.id"
+)
+
+// converter converts events into OTLP log export requests. Events sharing the
+// same resource attributes are grouped into one ResourceLogs entry.
+type converter struct {
+	scope          *commonpb.InstrumentationScope
+	resourceFields []string
+	resourceAttrs  []*commonpb.KeyValue
+}
+
+func newConverter(beat beat.Info, config resourceConfig) *converter {
+	attrs := make([]*commonpb.KeyValue, 0, len(config.Attributes))
+	for k, v := range config.Attributes {
+		attrs = append(attrs, keyValue(k, stringValue(v)))
+	}
+	sortKeyValues(attrs)
+
+	return &converter{
+		scope: &commonpb.InstrumentationScope{
+			Name:    beat.Beat,
+			Version: beat.Version,
+		},
+		resourceFields: config.Fields,
+		resourceAttrs:  attrs,
+	}
+}
+
+func (c *converter) convert(events []publisher.Event) *collogspb.ExportLogsServiceRequest {
+	now := uint64(time.Now().UnixNano())
+
+	var resources []*logspb.ResourceLogs
+	byResource := map[string]*logspb.ScopeLogs{}
+	for i := range events {
+		event := &events[i].Content
+
+		fields := event.Fields.Clone()
+		resource := c.resource(fields)
+		key := resourceKey(resource)
+
+		scope, ok := byResource[key]
+		if !ok {
+			scope = &logspb.ScopeLogs{Scope: c.scope}
+			byResource[key] = scope
+			resources = append(resources, &logspb.ResourceLogs{
+				Resource:  &resourcepb.Resource{Attributes: resource},
+				ScopeLogs: []*logspb.ScopeLogs{scope},
+			})
+		}
+
+		record := c.logRecord(event.Timestamp, fields)
+		record.ObservedTimeUnixNano = now
+		scope.LogRecords = append(scope.LogRecords, record)
+	}
+
+	return &collogspb.ExportLogsServiceRequest{ResourceLogs: resources}
+}
+
+// resource removes the configured resource fields from fields and returns
+// them, together with the static resource attributes, as sorted attributes.
+func (c *converter) resource(fields common.MapStr) []*commonpb.KeyValue {
+	attrs := append([]*commonpb.KeyValue{}, c.resourceAttrs...)
+	for _, name := range c.resourceFields {
+		value, err := fields.GetValue(name)
+		if err != nil {
+			continue
+		}
+		fields.Delete(name)
+		attrs = appendAttributes(attrs, name, value)
+	}
+	sortKeyValues(attrs)
+	return attrs
+}
+
+func (c *converter) logRecord(ts time.Time, fields common.MapStr) *logspb.LogRecord {
+	record := &logspb.LogRecord{}
+	if !ts.IsZero() {
+		record.TimeUnixNano = uint64(ts.UnixNano())
+	}
+
+	if msg, ok := popString(fields, fieldMessage); ok {
+		record.Body = stringValue(msg)
+	}
+	if level, ok := popString(fields, fieldLevel); ok {
+		record.SeverityText = level
+		record.SeverityNumber = severityNumbers[strings.ToLower(level)]
+	}
+	if id, ok := popString(fields, fieldTraceID); ok {
+		if b, err := hex.DecodeString(id); err == nil && len(b) == 16 {
+			record.TraceId = b
+		}
+	}
+	if id, ok := popString(fields, fieldSpanID); ok {
+		if b, err := hex.DecodeString(id); err == nil && len(b) == 8 {
+			record.SpanId = b
+		}
+	}
+
+	record.Attributes = appendAttributes(nil, "", fields)
+	sortKeyValues(record.Attributes)
+	return record
+}
+
+func popString(fields common.MapStr, key string) (string, bool) {
+	value, err := fields.GetValue(key)
+	if err != nil {
+		return "", false
+	}
+	s, ok := value.(string)
+	if ok {
+		fields.Delete(key)
+	}
+	return s, ok
+}
+
+// appendAttributes flattens value into attributes with dotted keys.
+func appendAttributes(attrs []*commonpb.KeyValue, prefix string, value interface{}) []*commonpb.KeyValue {
+	var m map[string]interface{}
+	switch v := value.(type) {
+	case common.MapStr:
+		m = v
+	case map[string]interface{}:
+		m = v
+	default:
+		return append(attrs, keyValue(prefix, anyValue(value)))
+	}
+
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		attrs = appendAttributes(attrs, k, v)
+	}
+	return attrs
+}
+
+func anyValue(value interface{}) *commonpb.AnyValue {
+	switch v := value.(type) {
+	case nil:
+		return &commonpb.AnyValue{}
+	case string:
+		return stringValue(v)
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case int:
+		return intValue(int64(v))
+	case int8:
+		return intValue(int64(v))
+	case int16:
+		return intValue(int64(v))
+	case int32:
+		return intValue(int64(v))
+	case int64:
+		return intValue(v)
+	case uint:
+		return intValue(int64(v))
+	case uint8:
+		return intValue(int64(v))
+	case uint16:
+		return intValue(int64(v))
+	case uint32:
+		return intValue(int64(v))
+	case uint64:
+		return intValue(int64(v))
+	case float32:
+		return doubleValue(float64(v))
+	case float64:
+		return doubleValue(v)
+	case []byte:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: v}}
+	case time.Time:
+		return stringValue(v.UTC().Format(time.RFC3339Nano))
+	case common.Time:
+		return stringValue(time.Time(v).UTC().Format(time.RFC3339Nano))
+	case common.MapStr:
+		return kvlistValue(v)
+	case map[string]interface{}:
+		return kvlistValue(v)
+	case []string:
+		values := make([]*commonpb.AnyValue, len(v))
+		for i, s := range v {
+			values[i] = stringValue(s)
+		}
+		return arrayValue(values)
+	case []interface{}:
+		values := make([]*commonpb.AnyValue, len(v))
+		for i, elem := range v {
+			values[i] = anyValue(elem)
+		}
+		return arrayValue(values)
+	case []common.MapStr:
+		values := make([]*commonpb.AnyValue, len(v))
+		for i, elem := range v {
+			values[i] = kvlistValue(elem)
+		}
+		return arrayValue(values)
+	default:
+		return stringValue(fmt.Sprint(v))
+	}
+}
+
+func keyValue(key string, value *commonpb.AnyValue) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: value}
+}
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+func intValue(i int64) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+}
+
+func doubleValue(f float64) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f}}
+}
+
+func arrayValue(values []*commonpb.AnyValue) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{
+		ArrayValue: &commonpb.ArrayValue{Values: values},
+	}}
+}
+
+func kvlistValue(m map[string]interface{}) *commonpb.AnyValue {
+	values := make([]*commonpb.KeyValue, 0, len(m))
+	for k, v := range m {
+		values = append(values, keyValue(k, anyValue(v)))
+	}
+	sortKeyValues(values)
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{
+		KvlistValue: &commonpb.KeyValueList{Values: values},
+	}}
+}
+
+func sortKeyValues(kvs []*commonpb.KeyValue) {
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+}
+
+// resourceKey builds a key identifying the set of sorted resource attributes.
+func resourceKey(attrs []*commonpb.KeyValue) string {
+	var b strings.Builder
+	for _, kv := range attrs {
+		b.WriteString(kv.Key)
+		b.WriteByte('=')
+		b.WriteString(kv.Value.String())
+		b.WriteByte(0)
+	}
+	return b.String()
+}
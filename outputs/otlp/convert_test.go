@@ -0,0 +1,147 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+func testConverter() *converter {
+	return newConverter(
+		beat.Info{Beat: "testbeat", Version: "7.17.0"},
+		resourceConfig{
+			Fields:     []string{"host", "cloud", "kubernetes"},
+			Attributes: map[string]string{"deployment.environment": "test"},
+		},
+	)
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]interface{} {
+	m := map[string]interface{}{}
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			m[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			m[kv.Key] = v.IntValue
+		case *commonpb.AnyValue_BoolValue:
+			m[kv.Key] = v.BoolValue
+		case *commonpb.AnyValue_DoubleValue:
+			m[kv.Key] = v.DoubleValue
+		default:
+			m[kv.Key] = kv.Value
+		}
+	}
+	return m
+}
+
+func TestConvertLogRecord(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	event := beat.Event{
+		Timestamp: ts,
+		Fields: common.MapStr{
+			"message": "hello world",
+			"log":     common.MapStr{"level": "WARN", "file": common.MapStr{"path": "/var/log/app.log"}},
+			"host":    common.MapStr{"name": "web-1", "ip": []string{"10.0.0.1"}},
+			"trace":   common.MapStr{"id": "0102030405060708090a0b0c0d0e0f10"},
+			"span":    common.MapStr{"id": "0102030405060708"},
+			"http":    common.MapStr{"response": common.MapStr{"status_code": 200}},
+			"flag":    true,
+		},
+	}
+
+	req := testConverter().convert([]publisher.Event{{Content: event}})
+	require.Len(t, req.ResourceLogs, 1)
+
+	resource := attributes(req.ResourceLogs[0].Resource.Attributes)
+	assert.Equal(t, "web-1", resource["host.name"])
+	assert.Equal(t, "test", resource["deployment.environment"])
+	assert.Contains(t, resource, "host.ip")
+
+	require.Len(t, req.ResourceLogs[0].ScopeLogs, 1)
+	scope := req.ResourceLogs[0].ScopeLogs[0]
+	assert.Equal(t, "testbeat", scope.Scope.Name)
+	assert.Equal(t, "7.17.0", scope.Scope.Version)
+
+	require.Len(t, scope.LogRecords, 1)
+	record := scope.LogRecords[0]
+	assert.Equal(t, uint64(ts.UnixNano()), record.TimeUnixNano)
+	assert.NotZero(t, record.ObservedTimeUnixNano)
+	assert.Equal(t, "hello world", record.Body.GetStringValue())
+	assert.Equal(t, "WARN", record.SeverityText)
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_WARN, record.SeverityNumber)
+	assert.Equal(t, "0102030405060708090a0b0c0d0e0f10", hex.EncodeToString(record.TraceId))
+	assert.Equal(t, "0102030405060708", hex.EncodeToString(record.SpanId))
+
+	assert.Equal(t, map[string]interface{}{
+		"log.file.path":             "/var/log/app.log",
+		"http.response.status_code": int64(200),
+		"flag":                      true,
+	}, attributes(record.Attributes))
+
+	// the event itself must not be modified
+	assert.Equal(t, "hello world", event.Fields["message"])
+	assert.Contains(t, event.Fields, "host")
+}
+
+func TestConvertGroupsByResource(t *testing.T) {
+	newEvent := func(host, msg string) publisher.Event {
+		return publisher.Event{Content: beat.Event{
+			Timestamp: time.Now(),
+			Fields: common.MapStr{
+				"message": msg,
+				"host":    common.MapStr{"name": host},
+			},
+		}}
+	}
+
+	req := testConverter().convert([]publisher.Event{
+		newEvent("a", "1"),
+		newEvent("b", "2"),
+		newEvent("a", "3"),
+	})
+
+	require.Len(t, req.ResourceLogs, 2)
+	assert.Equal(t, "a", attributes(req.ResourceLogs[0].Resource.Attributes)["host.name"])
+	assert.Len(t, req.ResourceLogs[0].ScopeLogs[0].LogRecords, 2)
+	assert.Equal(t, "b", attributes(req.ResourceLogs[1].Resource.Attributes)["host.name"])
+	assert.Len(t, req.ResourceLogs[1].ScopeLogs[0].LogRecords, 1)
+}
+
+func TestConvertUnknownSeverity(t *testing.T) {
+	req := testConverter().convert([]publisher.Event{{Content: beat.Event{
+		Fields: common.MapStr{"log": common.MapStr{"level": "verbose"}},
+	}}})
+
+	record := req.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	assert.Equal(t, "verbose", record.SeverityText)
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, record.SeverityNumber)
+	assert.Zero(t, record.TimeUnixNano)
+	assert.Nil(t, record.Body)
+}
@@ -0,0 +1,178 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"context"
+	"net"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/common/transport/tlscommon"
+	"github.com/njcx/libbeat_v7/common/useragent"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/testing"
+)
+
+// grpcExporter exports logs using OTLP/gRPC.
+type grpcExporter struct {
+	log      *logp.Logger
+	target   string
+	metadata metadata.MD
+	timeout  time.Duration
+	dialOpts []grpc.DialOption
+	callOpts []grpc.CallOption
+
+	conn   *grpc.ClientConn
+	client collogspb.LogsServiceClient
+}
+
+type grpcExporterSettings struct {
+	Target   string
+	Beatname string
+	Headers  map[string]string
+	Gzip     bool
+	TLS      *tlscommon.TLSConfig
+	Timeout  time.Duration
+}
+
+func newGRPCExporter(s grpcExporterSettings) *grpcExporter {
+	creds := insecure.NewCredentials()
+	if s.TLS != nil {
+		host, _, _ := net.SplitHostPort(s.Target)
+		creds = credentials.NewTLS(s.TLS.BuildModuleClientConfig(host))
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUserAgent(useragent.UserAgent(s.Beatname, true)),
+	}
+
+	var callOpts []grpc.CallOption
+	if s.Gzip {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+
+	return &grpcExporter{
+		log:      logp.NewLogger(logSelector),
+		target:   s.Target,
+		metadata: metadata.New(s.Headers),
+		timeout:  s.Timeout,
+		dialOpts: dialOpts,
+		callOpts: callOpts,
+	}
+}
+
+func (e *grpcExporter) Connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, e.target, append(e.dialOpts, grpc.WithBlock())...)
+	if err != nil {
+		return err
+	}
+
+	e.conn = conn
+	e.client = collogspb.NewLogsServiceClient(conn)
+	return nil
+}
+
+func (e *grpcExporter) Close() error {
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn, e.client = nil, nil
+	return err
+}
+
+func (e *grpcExporter) String() string {
+	return e.target
+}
+
+func (e *grpcExporter) Export(
+	ctx context.Context,
+	req *collogspb.ExportLogsServiceRequest,
+) (*collogspb.ExportLogsServiceResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	if len(e.metadata) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, e.metadata)
+	}
+
+	resp, err := e.client.Export(ctx, req, e.callOpts...)
+	if err != nil {
+		st := status.Convert(err)
+		e.log.Debugf("Export failed (code=%v): %v", st.Code(), st.Message())
+
+		throttle := retryDelay(st)
+		return nil, &exportError{
+			err:       err,
+			retryable: retryableCode(st.Code(), throttle > 0),
+			throttle:  throttle,
+		}
+	}
+	return resp, nil
+}
+
+func (e *grpcExporter) Test(d testing.Driver) {
+	d.Run("otlp/grpc: "+e.target, func(d testing.Driver) {
+		netDialer := transport.TestNetDialer(d, e.timeout)
+		_, err := netDialer.Dial("tcp", e.target)
+		d.Fatal("dial up", err)
+	})
+}
+
+// retryableCode reports whether a request failing with the given status code
+// should be retried, as defined by the OTLP/gRPC specification.
+// ResourceExhausted is only retried if the server provided a retry delay.
+func retryableCode(code codes.Code, hasRetryInfo bool) bool {
+	switch code {
+	case codes.Canceled,
+		codes.DeadlineExceeded,
+		codes.Aborted,
+		codes.OutOfRange,
+		codes.Unavailable,
+		codes.DataLoss:
+		return true
+	case codes.ResourceExhausted:
+		return hasRetryInfo
+	}
+	return false
+}
+
+// retryDelay returns the delay requested by the server using the RetryInfo
+// status detail.
+func retryDelay(st *status.Status) time.Duration {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+			return info.RetryDelay.AsDuration()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,222 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
+	"github.com/njcx/libbeat_v7/common/useragent"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/testing"
+)
+
+// httpExporter exports logs using OTLP/HTTP with binary protobuf encoding.
+type httpExporter struct {
+	log       *logp.Logger
+	url       string
+	headers   map[string]string
+	gzip      bool
+	transport httpcommon.HTTPTransportSettings
+	http      *nethttp.Client
+}
+
+type httpExporterSettings struct {
+	URL       string
+	Beatname  string
+	Headers   map[string]string
+	Gzip      bool
+	Observer  outputs.Observer
+	Transport httpcommon.HTTPTransportSettings
+}
+
+func newHTTPExporter(s httpExporterSettings) (*httpExporter, error) {
+	logger := logp.NewLogger(logSelector)
+	httpClient, err := s.Transport.Client(
+		httpcommon.WithLogger(logger),
+		httpcommon.WithIOStats(s.Observer),
+		httpcommon.WithKeepaliveSettings{IdleConnTimeout: 1 * time.Minute},
+		httpcommon.WithHeaderRoundTripper(map[string]string{
+			"User-Agent": useragent.UserAgent(s.Beatname, true),
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpExporter{
+		log:       logger,
+		url:       s.URL,
+		headers:   s.Headers,
+		gzip:      s.Gzip,
+		transport: s.Transport,
+		http:      httpClient,
+	}, nil
+}
+
+// Connect is a no-op. Connections are established on demand by the HTTP
+// transport.
+func (e *httpExporter) Connect() error {
+	return nil
+}
+
+func (e *httpExporter) Close() error {
+	e.http.CloseIdleConnections()
+	return nil
+}
+
+func (e *httpExporter) String() string {
+	return e.url
+}
+
+func (e *httpExporter) Export(
+	ctx context.Context,
+	req *collogspb.ExportLogsServiceRequest,
+) (*collogspb.ExportLogsServiceResponse, error) {
+	body, err := e.requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodPost, e.url, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if e.gzip {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	for name, value := range e.headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := e.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		e.log.Debugf("Export failed (status=%v): %s", resp.StatusCode, raw)
+		return nil, &exportError{
+			err:       fmt.Errorf("export failed with status %v", resp.StatusCode),
+			retryable: retryableStatus(resp.StatusCode),
+			throttle:  retryAfter(resp),
+		}
+	}
+
+	result := &collogspb.ExportLogsServiceResponse{}
+	if len(raw) > 0 {
+		if err := proto.Unmarshal(raw, result); err != nil {
+			e.log.Debugf("Failed to decode export response: %v", err)
+		}
+	}
+	return result, nil
+}
+
+func (e *httpExporter) requestBody(req *collogspb.ExportLogsServiceRequest) (io.Reader, error) {
+	raw, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if !e.gzip {
+		return bytes.NewReader(raw), nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func (e *httpExporter) Test(d testing.Driver) {
+	d.Run("otlp/http: "+e.url, func(d testing.Driver) {
+		u, err := url.Parse(e.url)
+		d.Fatal("parse url", err)
+
+		address := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			address = u.Hostname() + ":" + port
+		}
+
+		netDialer := transport.TestNetDialer(d, e.transport.Timeout)
+		_, err = netDialer.Dial("tcp", address)
+		d.Fatal("dial up", err)
+	})
+}
+
+// retryableStatus reports whether a request failing with the given status
+// code should be retried, as defined by the OTLP/HTTP specification.
+func retryableStatus(status int) bool {
+	switch status {
+	case nethttp.StatusTooManyRequests,
+		nethttp.StatusBadGateway,
+		nethttp.StatusServiceUnavailable,
+		nethttp.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After response header. The header can contain
+// either the number of seconds to wait or a HTTP date.
+func retryAfter(resp *nethttp.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	var d time.Duration
+	if secs, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := nethttp.ParseTime(value); err == nil {
+		d = time.Until(t)
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,139 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/transport/tlscommon"
+	"github.com/njcx/libbeat_v7/outputs"
+)
+
+const logSelector = "otlp"
+
+func init() {
+	outputs.RegisterType("otlp", makeOTLP)
+}
+
+func makeOTLP(
+	_ outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *common.Config,
+) (outputs.Group, error) {
+	config, err := readConfig(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	hosts, err := outputs.ReadHostList(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	deadLetter, err := outputs.LoadDeadLetterQueue(beat, "otlp", observer, cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	converter := newConverter(beat, config.Resource)
+
+	clients := make([]outputs.NetworkClient, len(hosts))
+	for i, host := range hosts {
+		exporter, err := newExporter(beat, observer, config, host)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+
+		client := newClient(observer, deadLetter, converter, exporter, config.Backoff.Max)
+		clients[i] = outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max)
+	}
+
+	return outputs.SuccessNet(config.LoadBalance, config.BulkMaxSize, config.MaxRetries, clients)
+}
+
+func newExporter(beat beat.Info, observer outputs.Observer, config *otlpConfig, host string) (exporter, error) {
+	gzip := config.Compression == "gzip"
+
+	if config.Protocol == protocolHTTP {
+		scheme := "http"
+		if config.Transport.TLS.IsEnabled() {
+			scheme = "https"
+		}
+		hostURL, err := common.MakeURL(scheme, config.Path, host, defaultHTTPPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host '%v': %v", host, err)
+		}
+
+		return newHTTPExporter(httpExporterSettings{
+			URL:       hostURL,
+			Beatname:  beat.Beat,
+			Headers:   config.Headers,
+			Gzip:      gzip,
+			Observer:  observer,
+			Transport: config.Transport,
+		})
+	}
+
+	target, secure, err := grpcTarget(host)
+	if err != nil {
+		return nil, err
+	}
+
+	tls, err := tlscommon.LoadTLSConfig(config.Transport.TLS)
+	if err != nil {
+		return nil, err
+	}
+	if tls == nil && secure {
+		tls = &tlscommon.TLSConfig{} // enable with system default if TLS was not configured
+	}
+
+	return newGRPCExporter(grpcExporterSettings{
+		Target:   target,
+		Beatname: beat.Beat,
+		Headers:  config.Headers,
+		Gzip:     gzip,
+		TLS:      tls,
+		Timeout:  config.Transport.Timeout,
+	}), nil
+}
+
+// grpcTarget returns the host:port to connect to for a configured gRPC host.
+// Hosts can be given with http or https scheme. The https scheme enables TLS.
+func grpcTarget(host string) (string, bool, error) {
+	secure := false
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid host '%v': %v", host, err)
+		}
+		secure = u.Scheme == "https"
+		host = u.Host
+	}
+
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(defaultGRPCPort))
+	}
+	return host, secure, nil
+}
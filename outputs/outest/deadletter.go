@@ -0,0 +1,43 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package outest
+
+import (
+	"sync"
+
+	"github.com/njcx/libbeat_v7/beat"
+)
+
+// DeadLetter is a dead letter queue recording the events added to it.
+type DeadLetter struct {
+	mu     sync.Mutex
+	events []*beat.Event
+}
+
+func (q *DeadLetter) Add(event *beat.Event, _ error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.events = append(q.events, event)
+}
+
+// Events returns the events added to the queue so far.
+func (q *DeadLetter) Events() []*beat.Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*beat.Event(nil), q.events...)
+}
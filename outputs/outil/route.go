@@ -0,0 +1,208 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package outil
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/conditions"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/processors"
+)
+
+// Router assigns events to routes. Routes are checked in the configured
+// order, and the first route whose condition matches an event is used to
+// publish the event.
+type Router struct {
+	routes []*Route
+}
+
+// Route holds the settings used to publish the events assigned to it.
+// Settings that are not configured for a route are left empty, in which case
+// the output falls back to its own settings.
+type Route struct {
+	// Name identifies the route in log messages. It defaults to the position of
+	// the route in the routing table.
+	Name string
+
+	// Target selects the index or topic events are published to.
+	Target Selector
+
+	// Key formats the message key of the events of the route, for outputs
+	// supporting message keys. Key is nil if the route does not configure a
+	// key.
+	Key *fmtstr.EventFormatString
+
+	// Codec encodes the events of the route. Codec is nil if the route does
+	// not configure a codec.
+	Codec codec.Codec
+
+	// Processors are applied to the events of the route before they are
+	// encoded. Processors is nil if the route does not configure processors.
+	Processors *processors.Processors
+
+	cond conditions.Condition
+}
+
+type routeConfig struct {
+	Name       string                    `config:"name"`
+	When       *conditions.Config        `config:"when"`
+	Key        *fmtstr.EventFormatString `config:"key"`
+	Codec      codec.Config              `config:"codec"`
+	Processors processors.PluginConfig   `config:"processors"`
+}
+
+// BuildRouterFromConfig creates a router from the `routes` setting of an
+// output configuration. The target of each route is configured using the
+// Key and MultiKey of settings, the same way BuildSelectorFromConfig does.
+// A nil Router, which never selects a route, is returned if no routes are
+// configured.
+func BuildRouterFromConfig(
+	info beat.Info,
+	cfg *common.Config,
+	settings Settings,
+) (*Router, error) {
+	if !cfg.HasField("routes") {
+		return nil, nil
+	}
+
+	sub, err := cfg.Child("routes", -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var table []*common.Config
+	if err := sub.Unpack(&table); err != nil {
+		return nil, err
+	}
+
+	// targets are optional for routes
+	settings.FailEmpty = false
+
+	router := &Router{}
+	for i, config := range table {
+		route, err := buildRoute(info, config, settings, i)
+		if err != nil {
+			router.Close()
+			return nil, err
+		}
+		router.routes = append(router.routes, route)
+	}
+
+	return router, nil
+}
+
+func buildRoute(info beat.Info, cfg *common.Config, settings Settings, idx int) (*Route, error) {
+	config := routeConfig{Name: strconv.Itoa(idx)}
+	if err := cfg.Unpack(&config); err != nil {
+		return nil, err
+	}
+
+	route := &Route{Name: config.Name, Key: config.Key}
+
+	var err error
+	if config.When != nil {
+		route.cond, err = conditions.NewCondition(config.When)
+		if err != nil {
+			return nil, fmt.Errorf("%v in %v", err, cfg.PathOf("when"))
+		}
+	}
+
+	route.Target, err = BuildSelectorFromConfig(cfg, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Codec.Namespace.IsSet() {
+		route.Codec, err = codec.CreateEncoder(info, config.Codec)
+		if err != nil {
+			return nil, fmt.Errorf("%v in %v", err, cfg.PathOf("codec"))
+		}
+	}
+
+	if len(config.Processors) > 0 {
+		route.Processors, err = processors.New(config.Processors)
+		if err != nil {
+			return nil, fmt.Errorf("%v in %v", err, cfg.PathOf("processors"))
+		}
+	}
+
+	return route, nil
+}
+
+// Route returns the first route matching the event. If no route matches, or
+// the router is nil, Route returns nil.
+func (r *Router) Route(evt *beat.Event) *Route {
+	if r == nil {
+		return nil
+	}
+
+	for _, route := range r.routes {
+		if route.cond == nil || route.cond.Check(evt) {
+			return route
+		}
+	}
+	return nil
+}
+
+// Close releases the resources held by the processors of all routes.
+func (r *Router) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	var firstErr error
+	for _, route := range r.routes {
+		if route.Processors == nil {
+			continue
+		}
+		if err := route.Processors.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Process applies the processors of the route to a copy of the event, such
+// that the original event can be retried unmodified. If the event is dropped
+// by a processor, a nil event is returned.
+func (r *Route) Process(evt *beat.Event) (*beat.Event, error) {
+	if r.Processors == nil || len(r.Processors.List) == 0 {
+		return evt, nil
+	}
+
+	tmp := &beat.Event{
+		Timestamp:  evt.Timestamp,
+		Meta:       evt.Meta.Clone(),
+		Fields:     evt.Fields.Clone(),
+		Private:    evt.Private,
+		TimeSeries: evt.TimeSeries,
+	}
+	if evt.Meta == nil {
+		tmp.Meta = nil
+	}
+	return r.Processors.Run(tmp)
+}
+
+func (r *Route) String() string {
+	return "route(" + r.Name + ")"
+}
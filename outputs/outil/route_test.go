@@ -0,0 +1,189 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package outil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	_ "github.com/njcx/libbeat_v7/outputs/codec/format"
+	_ "github.com/njcx/libbeat_v7/outputs/codec/json"
+	_ "github.com/njcx/libbeat_v7/processors/actions"
+)
+
+var routeSettings = Settings{
+	Key:              "topic",
+	MultiKey:         "topics",
+	EnableSingleOnly: true,
+	FailEmpty:        true,
+	Case:             SelectorKeepCase,
+}
+
+const routesConfig = `
+routes:
+  - name: audit
+    when.equals.event.kind: audit
+    topic: audit-%{[event.dataset]}
+    codec.json:
+      pretty: false
+  - name: debug
+    when.equals.log.level: debug
+    topic: debug
+    codec.format:
+      string: '%{[message]}'
+    processors:
+      - add_fields:
+          target: ''
+          fields:
+            routed: true
+      - drop_event.when.equals.message: noise
+  - when.has_fields: ['trace.id']
+`
+
+func TestRouter(t *testing.T) {
+	router := buildTestRouter(t, routesConfig)
+
+	tests := map[string]struct {
+		event      common.MapStr
+		wantRoute  string
+		wantTarget string
+		wantCodec  bool
+	}{
+		"no matching route": {
+			event: common.MapStr{"message": "hello"},
+		},
+		"first matching route wins": {
+			event:      common.MapStr{"event": common.MapStr{"kind": "audit", "dataset": "auth"}, "log": common.MapStr{"level": "debug"}},
+			wantRoute:  "audit",
+			wantTarget: "audit-auth",
+			wantCodec:  true,
+		},
+		"second route": {
+			event:      common.MapStr{"log": common.MapStr{"level": "debug"}},
+			wantRoute:  "debug",
+			wantTarget: "debug",
+			wantCodec:  true,
+		},
+		"route without settings": {
+			event:     common.MapStr{"trace": common.MapStr{"id": "abc"}},
+			wantRoute: "2",
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			event := beat.Event{Timestamp: time.Now(), Fields: test.event}
+			route := router.Route(&event)
+			if test.wantRoute == "" {
+				assert.Nil(t, route)
+				return
+			}
+
+			require.NotNil(t, route)
+			assert.Equal(t, test.wantRoute, route.Name)
+			assert.Equal(t, test.wantCodec, route.Codec != nil)
+
+			target, err := route.Target.Select(&event)
+			require.NoError(t, err)
+			assert.Equal(t, test.wantTarget, target)
+		})
+	}
+}
+
+func TestRouteEncoding(t *testing.T) {
+	router := buildTestRouter(t, routesConfig)
+
+	event := beat.Event{
+		Timestamp: time.Now(),
+		Fields:    common.MapStr{"message": "debug message", "log": common.MapStr{"level": "debug"}},
+	}
+	route := router.Route(&event)
+	require.NotNil(t, route)
+
+	processed, err := route.Process(&event)
+	require.NoError(t, err)
+	require.NotNil(t, processed)
+	assert.Equal(t, true, processed.Fields["routed"])
+	assert.NotContains(t, event.Fields, "routed", "original event must not be modified")
+
+	encoded, err := route.Codec.Encode("test", processed)
+	require.NoError(t, err)
+	assert.Equal(t, "debug message", string(encoded))
+}
+
+func TestRouteProcessorsDropEvent(t *testing.T) {
+	router := buildTestRouter(t, routesConfig)
+
+	event := beat.Event{
+		Timestamp: time.Now(),
+		Fields:    common.MapStr{"message": "noise", "log": common.MapStr{"level": "debug"}},
+	}
+	route := router.Route(&event)
+	require.NotNil(t, route)
+
+	processed, err := route.Process(&event)
+	require.NoError(t, err)
+	assert.Nil(t, processed)
+}
+
+func TestRouterNotConfigured(t *testing.T) {
+	router, err := BuildRouterFromConfig(beat.Info{}, common.MustNewConfigFrom(common.MapStr{"topic": "test"}), routeSettings)
+	require.NoError(t, err)
+	assert.Nil(t, router)
+	assert.Nil(t, router.Route(&beat.Event{Fields: common.MapStr{}}))
+	assert.NoError(t, router.Close())
+}
+
+func TestRouterInitFail(t *testing.T) {
+	tests := map[string]string{
+		"routes no list":      `routes: 5`,
+		"invalid condition":   `routes: [{when.unknown.field: 1, topic: test}]`,
+		"invalid target":      `routes: [{topic: '%{[abc}'}]`,
+		"unknown codec":       `routes: [{topic: test, codec.unknown: {}}]`,
+		"unknown processor":   `routes: [{topic: test, processors: [{unknown: {}}]}]`,
+		"invalid target list": `routes: [{topics: [{default: test}]}]`,
+	}
+
+	for name, config := range tests {
+		config := config
+		t.Run(name, func(t *testing.T) {
+			cfg, err := common.NewConfigWithYAML([]byte(config), "test")
+			require.NoError(t, err)
+
+			_, err = BuildRouterFromConfig(beat.Info{}, cfg, routeSettings)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func buildTestRouter(t *testing.T, config string) *Router {
+	cfg, err := common.NewConfigWithYAML([]byte(config), "test")
+	require.NoError(t, err)
+
+	router, err := BuildRouterFromConfig(beat.Info{Beat: "test", Version: "1.2.3"}, cfg, routeSettings)
+	require.NoError(t, err)
+	require.NotNil(t, router)
+	t.Cleanup(func() { router.Close() })
+	return router
+}
@@ -53,6 +53,15 @@ type Group struct {
 	Clients   []Client
 	BatchSize int
 	Retry     int
+
+	// Dynamic optionally adds and removes clients of the group at runtime, in
+	// addition to the clients in Clients.
+	Dynamic DynamicClients
+
+	// Fanout configures the publisher pipeline to publish events to multiple
+	// output groups in parallel. If set, Clients, BatchSize and Retry are
+	// ignored.
+	Fanout *Fanout
 }
 
 // RegisterType registers a new output type.
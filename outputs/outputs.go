@@ -59,3 +59,16 @@ type Connectable interface {
 	// forever.
 	Connect() error
 }
+
+// DynamicClients is implemented by outputs whose set of clients changes at
+// runtime, for example because the hosts to publish to are discovered.
+type DynamicClients interface {
+	// Start begins watching for changes. The publisher pipeline starts a
+	// worker for every client passed to add and closes the worker of every
+	// client passed to remove.
+	Start(add, remove func(Client))
+
+	// Stop stops watching for changes. No more clients must be added or
+	// removed once Stop returns.
+	Stop()
+}
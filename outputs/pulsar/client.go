@@ -0,0 +1,389 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/outputs/outil"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/testing"
+)
+
+// pulsarClient is the subset of the pulsar client API used by the output.
+type pulsarClient interface {
+	CreateProducer(pulsar.ProducerOptions) (pulsar.Producer, error)
+	Close()
+}
+
+type clientSettings struct {
+	Hosts           []string
+	Observer        outputs.Observer
+	DeadLetter      outputs.DeadLetterQueue
+	Index           string
+	Key             *fmtstr.EventFormatString
+	Topic           outil.Selector
+	Codec           codec.Codec
+	ClientOptions   pulsar.ClientOptions
+	ProducerOptions pulsar.ProducerOptions
+	DialTimeout     time.Duration
+}
+
+type client struct {
+	log             *logp.Logger
+	observer        outputs.Observer
+	deadLetter      outputs.DeadLetterQueue
+	hosts           []string
+	index           string
+	key             *fmtstr.EventFormatString
+	topic           outil.Selector
+	codec           codec.Codec
+	clientOptions   pulsar.ClientOptions
+	producerOptions pulsar.ProducerOptions
+	dialTimeout     time.Duration
+
+	newClient func(pulsar.ClientOptions) (pulsarClient, error)
+
+	mux       sync.Mutex
+	client    pulsarClient
+	producers map[string]pulsar.Producer
+}
+
+type msgRef struct {
+	client  *client
+	count   int32
+	total   int
+	dropped int32
+	batch   publisher.Batch
+
+	mux    sync.Mutex
+	failed []publisher.Event
+	err    error
+}
+
+var (
+	errNoTopicsSelected = errors.New("no topic could be selected")
+	errNotConnected     = errors.New("pulsar client is not connected")
+)
+
+func newPulsarClient(s clientSettings) *client {
+	return &client{
+		log:             logp.NewLogger(logSelector),
+		observer:        s.Observer,
+		deadLetter:      s.DeadLetter,
+		hosts:           s.Hosts,
+		index:           strings.ToLower(s.Index),
+		key:             s.Key,
+		topic:           s.Topic,
+		codec:           s.Codec,
+		clientOptions:   s.ClientOptions,
+		producerOptions: s.ProducerOptions,
+		dialTimeout:     s.DialTimeout,
+		newClient: func(opts pulsar.ClientOptions) (pulsarClient, error) {
+			return pulsar.NewClient(opts)
+		},
+	}
+}
+
+func (c *client) Connect() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.log.Debugf("connect: %v", c.clientOptions.URL)
+
+	client, err := c.newClient(c.clientOptions)
+	if err != nil {
+		c.log.Errorf("Pulsar connect fails with: %+v", err)
+		return err
+	}
+	c.client = client
+	c.producers = map[string]pulsar.Producer{}
+
+	// Create the producer right away if the topic is fixed, so connection
+	// and authorization errors are reported by Connect.
+	if c.topic.IsConst() {
+		topic, err := c.topic.Select(nil)
+		if err == nil && topic != "" {
+			if _, err := c.createProducer(topic); err != nil {
+				c.closeClient()
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *client) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.log.Debug("closed pulsar client")
+
+	c.closeClient()
+	return nil
+}
+
+func (c *client) closeClient() {
+	if c.client == nil {
+		return
+	}
+
+	for _, producer := range c.producers {
+		producer.Close()
+	}
+	c.producers = nil
+	c.client.Close()
+	c.client = nil
+}
+
+// getProducer returns the producer for the given topic, creating it on first
+// use.
+func (c *client) getProducer(topic string) (pulsar.Producer, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if producer, ok := c.producers[topic]; ok {
+		return producer, nil
+	}
+	return c.createProducer(topic)
+}
+
+func (c *client) createProducer(topic string) (pulsar.Producer, error) {
+	if c.client == nil {
+		return nil, errNotConnected
+	}
+
+	opts := c.producerOptions
+	opts.Topic = topic
+	producer, err := c.client.CreateProducer(opts)
+	if err != nil {
+		c.log.Errorf("Pulsar (topic=%v): failed to create producer: %+v", topic, err)
+		return nil, err
+	}
+	c.producers[topic] = producer
+	return producer, nil
+}
+
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	if len(events) == 0 {
+		batch.ACK()
+		return nil
+	}
+
+	ref := &msgRef{
+		client: c,
+		count:  int32(len(events)),
+		total:  len(events),
+		batch:  batch,
+	}
+
+	for i := range events {
+		d := &events[i]
+		topic, msg, err := c.getEventMessage(d)
+		if err != nil {
+			c.log.Errorf("Dropping event: %+v", err)
+			ref.drop(d, err)
+			continue
+		}
+
+		producer, err := c.getProducer(topic)
+		if err != nil {
+			ref.failAll(events[i:], err)
+			return err
+		}
+
+		producer.SendAsync(ctx, msg, func(_ pulsar.MessageID, _ *pulsar.ProducerMessage, err error) {
+			if err != nil {
+				ref.fail(d, topic, err)
+				return
+			}
+			ref.done()
+		})
+	}
+
+	return nil
+}
+
+func (c *client) String() string {
+	return "pulsar(" + c.clientOptions.URL + ")"
+}
+
+func (c *client) getEventMessage(data *publisher.Event) (string, *pulsar.ProducerMessage, error) {
+	event := &data.Content
+
+	var topic string
+	value, err := data.Cache.GetValue("topic")
+	if err == nil {
+		if c.log.IsDebug() {
+			c.log.Debugf("got event.Meta[\"topic\"] = %v", value)
+		}
+		if s, ok := value.(string); ok {
+			topic = s
+		}
+	}
+
+	if topic == "" {
+		topic, err = c.topic.Select(event)
+		if err != nil {
+			return "", nil, fmt.Errorf("setting pulsar topic failed with %v", err)
+		}
+		if topic == "" {
+			return "", nil, errNoTopicsSelected
+		}
+		if _, err := data.Cache.Put("topic", topic); err != nil {
+			return "", nil, fmt.Errorf("setting pulsar topic in publisher event failed: %v", err)
+		}
+	}
+
+	serializedEvent, err := c.codec.Encode(c.index, event)
+	if err != nil {
+		if c.log.IsDebug() {
+			c.log.Debugf("failed event: %v", event)
+		}
+		return "", nil, err
+	}
+
+	buf := make([]byte, len(serializedEvent))
+	copy(buf, serializedEvent)
+
+	msg := &pulsar.ProducerMessage{
+		Payload:   buf,
+		EventTime: event.Timestamp,
+	}
+	if c.key != nil {
+		if key, err := c.key.Run(event); err == nil {
+			msg.Key = key
+		}
+	}
+
+	return topic, msg, nil
+}
+
+func (r *msgRef) done() {
+	r.dec()
+}
+
+func (r *msgRef) fail(event *publisher.Event, topic string, err error) {
+	var perr *pulsar.Error
+	if errors.As(err, &perr) {
+		switch perr.Result() {
+		case pulsar.InvalidMessage:
+			r.client.log.Errorf("Pulsar (topic=%v): dropping invalid message", topic)
+			r.drop(event, err)
+			return
+
+		case pulsar.MessageTooBig:
+			r.client.log.Errorf("Pulsar (topic=%v): dropping too large message", topic)
+			r.drop(event, err)
+			return
+		}
+	}
+
+	r.mux.Lock()
+	r.failed = append(r.failed, *event)
+	if r.err == nil {
+		// report the first error seen in the batch
+		r.err = err
+	}
+	r.mux.Unlock()
+	r.dec()
+}
+
+// failAll marks all events not yet sent as failed.
+func (r *msgRef) failAll(events []publisher.Event, err error) {
+	r.mux.Lock()
+	r.failed = append(r.failed, events...)
+	if r.err == nil {
+		r.err = err
+	}
+	r.mux.Unlock()
+
+	// the last pending event is accounted for by dec, which finishes the
+	// batch once all in-flight messages are done.
+	atomic.AddInt32(&r.count, -int32(len(events)-1))
+	r.dec()
+}
+
+// drop hands an event that can not be published to the dead letter queue.
+func (r *msgRef) drop(event *publisher.Event, err error) {
+	atomic.AddInt32(&r.dropped, 1)
+	r.client.deadLetter.Add(&event.Content, err)
+	r.dec()
+}
+
+func (r *msgRef) dec() {
+	i := atomic.AddInt32(&r.count, -1)
+	if i > 0 {
+		return
+	}
+
+	r.client.log.Debug("finished pulsar batch")
+	stats := r.client.observer
+
+	dropped := int(atomic.LoadInt32(&r.dropped))
+	r.mux.Lock()
+	failed, err := r.failed, r.err
+	r.mux.Unlock()
+
+	if err != nil {
+		success := r.total - len(failed) - dropped
+		r.batch.RetryEvents(failed)
+
+		stats.Failed(len(failed))
+		if success > 0 {
+			stats.Acked(success)
+		}
+
+		r.client.log.Debugf("Pulsar publish failed with: %+v", err)
+	} else {
+		r.batch.ACK()
+		if success := r.total - dropped; success > 0 {
+			stats.Acked(success)
+		}
+	}
+}
+
+func (c *client) Test(d testing.Driver) {
+	if strings.HasPrefix(c.clientOptions.URL, "pulsar+ssl") {
+		d.Warn("TLS", "Pulsar output doesn't support TLS testing")
+	}
+
+	for _, host := range c.hosts {
+		d.Run("Pulsar: "+host, func(d testing.Driver) {
+			netDialer := transport.TestNetDialer(d, c.dialTimeout)
+			_, err := netDialer.Dial("tcp", host)
+			d.Error("dial up", err)
+		})
+	}
+}
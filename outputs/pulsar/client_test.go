@@ -0,0 +1,293 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec/format"
+	"github.com/njcx/libbeat_v7/outputs/outest"
+)
+
+type stubClient struct {
+	mu        sync.Mutex
+	producers map[string]*stubProducer
+	createErr error
+	sendErr   func(msg *pulsar.ProducerMessage) error
+	closed    bool
+}
+
+type stubProducer struct {
+	client *stubClient
+	topic  string
+	closed bool
+	sent   []*pulsar.ProducerMessage
+}
+
+func (c *stubClient) CreateProducer(opts pulsar.ProducerOptions) (pulsar.Producer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.createErr != nil {
+		return nil, c.createErr
+	}
+	p := &stubProducer{client: c, topic: opts.Topic}
+	c.producers[opts.Topic] = p
+	return p, nil
+}
+
+func (c *stubClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+}
+
+func (c *stubClient) sent(topic string) []*pulsar.ProducerMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.producers[topic]; ok {
+		return p.sent
+	}
+	return nil
+}
+
+func (p *stubProducer) Topic() string { return p.topic }
+func (p *stubProducer) Name() string  { return "stub" }
+
+func (p *stubProducer) Send(ctx context.Context, msg *pulsar.ProducerMessage) (pulsar.MessageID, error) {
+	var (
+		id  pulsar.MessageID
+		err error
+	)
+	p.SendAsync(ctx, msg, func(i pulsar.MessageID, _ *pulsar.ProducerMessage, e error) {
+		id, err = i, e
+	})
+	return id, err
+}
+
+func (p *stubProducer) SendAsync(
+	_ context.Context,
+	msg *pulsar.ProducerMessage,
+	callback func(pulsar.MessageID, *pulsar.ProducerMessage, error),
+) {
+	p.client.mu.Lock()
+	p.sent = append(p.sent, msg)
+	sendErr := p.client.sendErr
+	p.client.mu.Unlock()
+
+	var err error
+	if sendErr != nil {
+		err = sendErr(msg)
+	}
+	go callback(nil, msg, err)
+}
+
+func (p *stubProducer) LastSequenceID() int64 { return 0 }
+func (p *stubProducer) Flush() error          { return nil }
+func (p *stubProducer) Close()                { p.closed = true }
+
+func newTestClient(t *testing.T, cfg common.MapStr) (*client, *stubClient, *outest.DeadLetter) {
+	config, err := readConfig(common.MustNewConfigFrom(cfg))
+	require.NoError(t, err)
+
+	topic, err := buildTopicSelector(common.MustNewConfigFrom(cfg))
+	require.NoError(t, err)
+
+	deadLetter := &outest.DeadLetter{}
+	c := newPulsarClient(clientSettings{
+		Hosts:      config.hostPorts(),
+		Observer:   outputs.NewNilObserver(),
+		DeadLetter: deadLetter,
+		Key:        config.Key,
+		Topic:      topic,
+		Codec:      format.New(fmtstr.MustCompileEvent("%{[message]}")),
+	})
+
+	stub := &stubClient{producers: map[string]*stubProducer{}}
+	c.newClient = func(pulsar.ClientOptions) (pulsarClient, error) {
+		return stub, nil
+	}
+	return c, stub, deadLetter
+}
+
+func publishAndWait(t *testing.T, c *client, events ...beat.Event) *outest.Batch {
+	done := make(chan struct{})
+	batch := outest.NewBatch(events...)
+	batch.OnSignal = func(_ outest.BatchSignal) { close(done) }
+
+	c.Publish(context.Background(), batch)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for batch to be finished")
+	}
+	return batch
+}
+
+func testEvent(msg string, fields common.MapStr) beat.Event {
+	event := beat.Event{
+		Timestamp: time.Now(),
+		Fields:    common.MapStr{"message": msg},
+	}
+	event.Fields.DeepUpdate(fields)
+	return event
+}
+
+func TestPublishACK(t *testing.T) {
+	c, stub, _ := newTestClient(t, common.MapStr{
+		"hosts": []string{"localhost"},
+		"topic": "test",
+		"key":   "%{[id]}",
+	})
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	// constant topics create the producer on connect
+	require.Contains(t, stub.producers, "test")
+
+	batch := publishAndWait(t, c,
+		testEvent("a", common.MapStr{"id": "1"}),
+		testEvent("b", common.MapStr{"id": "2"}),
+	)
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	sent := stub.sent("test")
+	require.Len(t, sent, 2)
+	assert.Equal(t, "a", string(sent[0].Payload))
+	assert.Equal(t, "1", sent[0].Key)
+	assert.Equal(t, "b", string(sent[1].Payload))
+	assert.Equal(t, "2", sent[1].Key)
+}
+
+func TestPublishTopicSelection(t *testing.T) {
+	c, stub, _ := newTestClient(t, common.MapStr{
+		"hosts": []string{"localhost"},
+		"topic": "logs-%{[tenant]}",
+	})
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	assert.Empty(t, stub.producers)
+
+	batch := publishAndWait(t, c,
+		testEvent("a", common.MapStr{"tenant": "x"}),
+		testEvent("b", common.MapStr{"tenant": "y"}),
+		testEvent("c", common.MapStr{"tenant": "x"}),
+	)
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	assert.Len(t, stub.sent("logs-x"), 2)
+	assert.Len(t, stub.sent("logs-y"), 1)
+}
+
+func TestPublishRetryFailed(t *testing.T) {
+	c, stub, _ := newTestClient(t, common.MapStr{
+		"hosts": []string{"localhost"},
+		"topic": "test",
+	})
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	stub.sendErr = func(msg *pulsar.ProducerMessage) error {
+		if string(msg.Payload) == "b" {
+			return errors.New("send timeout")
+		}
+		return nil
+	}
+
+	batch := publishAndWait(t, c,
+		testEvent("a", nil),
+		testEvent("b", nil),
+		testEvent("c", nil),
+	)
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+	require.Len(t, batch.Signals[0].Events, 1)
+	assert.Equal(t, "b", batch.Signals[0].Events[0].Content.Fields["message"])
+}
+
+func TestPublishProducerFailure(t *testing.T) {
+	c, stub, _ := newTestClient(t, common.MapStr{
+		"hosts": []string{"localhost"},
+		"topic": "%{[topic]}",
+	})
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	stub.createErr = errors.New("not authorized")
+
+	batch := outest.NewBatch(
+		testEvent("a", common.MapStr{"topic": "x"}),
+		testEvent("b", common.MapStr{"topic": "y"}),
+	)
+	err := c.Publish(context.Background(), batch)
+	require.Error(t, err)
+
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+	assert.Len(t, batch.Signals[0].Events, 2)
+}
+
+func TestPublishDeadLetterNoTopic(t *testing.T) {
+	c, stub, deadLetter := newTestClient(t, common.MapStr{
+		"hosts": []string{"localhost"},
+		"topic": "%{[topic]}",
+	})
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	batch := publishAndWait(t, c,
+		testEvent("a", common.MapStr{"topic": "x"}),
+		testEvent("b", nil),
+	)
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+	assert.Len(t, stub.sent("x"), 1)
+
+	require.Len(t, deadLetter.Events(), 1)
+	assert.Equal(t, "b", deadLetter.Events()[0].Fields["message"])
+}
+
+func TestClose(t *testing.T) {
+	c, stub, _ := newTestClient(t, common.MapStr{
+		"hosts": []string{"localhost"},
+		"topic": "test",
+	})
+	require.NoError(t, c.Connect())
+	producer := stub.producers["test"]
+
+	require.NoError(t, c.Close())
+	assert.True(t, producer.closed)
+	assert.True(t, stub.closed)
+
+	// closing twice is a no-op
+	require.NoError(t, c.Close())
+}
@@ -0,0 +1,172 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pulsar
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/common/transport/tlscommon"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+type backoffConfig struct {
+	Init time.Duration `config:"init"`
+	Max  time.Duration `config:"max"`
+}
+
+type pulsarConfig struct {
+	Hosts              []string                  `config:"hosts"               validate:"required"`
+	TLS                *tlscommon.Config         `config:"ssl"`
+	Token              string                    `config:"token"`
+	TokenFile          string                    `config:"token_file"`
+	Key                *fmtstr.EventFormatString `config:"key"`
+	ConnectionTimeout  time.Duration             `config:"connection_timeout"  validate:"min=1"`
+	OperationTimeout   time.Duration             `config:"operation_timeout"   validate:"min=1"`
+	SendTimeout        time.Duration             `config:"send_timeout"        validate:"min=0"`
+	MaxPendingMessages int                       `config:"max_pending_messages" validate:"min=0"`
+	Compression        string                    `config:"compression"`
+	CompressionLevel   string                    `config:"compression_level"`
+	Batching           batchingConfig            `config:"batching"`
+	ProducerName       string                    `config:"producer_name"`
+	BulkMaxSize        int                       `config:"bulk_max_size"`
+	MaxRetries         int                       `config:"max_retries"         validate:"min=-1,nonzero"`
+	Backoff            backoffConfig             `config:"backoff"`
+	Codec              codec.Config              `config:"codec"`
+}
+
+type batchingConfig struct {
+	Enabled         bool          `config:"enabled"`
+	MaxPublishDelay time.Duration `config:"max_publish_delay" validate:"positive"`
+	MaxMessages     uint          `config:"max_messages"      validate:"min=1"`
+	MaxSizeKb       uint          `config:"max_size_kb"       validate:"min=1"`
+}
+
+var compressionTypes = map[string]pulsar.CompressionType{
+	"none": pulsar.NoCompression,
+	"lz4":  pulsar.LZ4,
+	"zlib": pulsar.ZLib,
+	"zstd": pulsar.ZSTD,
+}
+
+var compressionLevels = map[string]pulsar.CompressionLevel{
+	"default": pulsar.Default,
+	"faster":  pulsar.Faster,
+	"better":  pulsar.Better,
+}
+
+const (
+	defaultPort    = 6650
+	defaultTLSPort = 6651
+)
+
+func defaultConfig() pulsarConfig {
+	return pulsarConfig{
+		Hosts:              nil,
+		TLS:                nil,
+		ConnectionTimeout:  10 * time.Second,
+		OperationTimeout:   30 * time.Second,
+		SendTimeout:        30 * time.Second,
+		MaxPendingMessages: 0, // use library default
+		Compression:        "lz4",
+		CompressionLevel:   "default",
+		Batching: batchingConfig{
+			Enabled:         true,
+			MaxPublishDelay: 10 * time.Millisecond,
+			MaxMessages:     1000,
+			MaxSizeKb:       128,
+		},
+		BulkMaxSize: 2048,
+		MaxRetries:  3,
+		Backoff: backoffConfig{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+	}
+}
+
+func readConfig(cfg *common.Config) (*pulsarConfig, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *pulsarConfig) Validate() error {
+	if len(c.Hosts) == 0 {
+		return errors.New("no hosts configured")
+	}
+
+	if _, ok := compressionTypes[strings.ToLower(c.Compression)]; !ok {
+		return fmt.Errorf("compression '%v' unknown", c.Compression)
+	}
+	if _, ok := compressionLevels[strings.ToLower(c.CompressionLevel)]; !ok {
+		return fmt.Errorf("compression_level '%v' unknown", c.CompressionLevel)
+	}
+
+	if c.Token != "" && c.TokenFile != "" {
+		return errors.New("token and token_file can not be configured at the same time")
+	}
+
+	if c.TLS.IsEnabled() && len(c.TLS.CAs) > 1 {
+		return errors.New("pulsar supports only one certificate authority file")
+	}
+	if c.TLS.IsEnabled() && len(c.TLS.CAs) == 1 && strings.HasPrefix(strings.TrimSpace(c.TLS.CAs[0]), "-") {
+		return errors.New("pulsar requires ssl.certificate_authorities to be a file path")
+	}
+	if (c.Token != "" || c.TokenFile != "") && c.TLS.IsEnabled() && c.TLS.Certificate.Certificate != "" {
+		return errors.New("token and TLS client certificate authentication can not be combined")
+	}
+
+	return nil
+}
+
+// serviceURL builds the pulsar service URL from the configured hosts.
+func (c *pulsarConfig) serviceURL() string {
+	scheme := "pulsar"
+	if c.TLS.IsEnabled() {
+		scheme = "pulsar+ssl"
+	}
+	return scheme + "://" + strings.Join(c.hostPorts(), ",")
+}
+
+// hostPorts returns the configured hosts without URL scheme. Hosts without
+// port use the default pulsar port.
+func (c *pulsarConfig) hostPorts() []string {
+	port := defaultPort
+	if c.TLS.IsEnabled() {
+		port = defaultTLSPort
+	}
+
+	hosts := make([]string, len(c.Hosts))
+	for i, host := range c.Hosts {
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "pulsar+ssl://"), "pulsar://")
+		if !strings.Contains(host, ":") || strings.HasSuffix(host, "]") {
+			host = fmt.Sprintf("%v:%v", host, port)
+		}
+		hosts[i] = host
+	}
+	return hosts
+}
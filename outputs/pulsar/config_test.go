@@ -0,0 +1,131 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pulsar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/njcx/libbeat_v7/common"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		cfg   common.MapStr
+		valid bool
+	}{
+		"default config with hosts": {
+			cfg:   common.MapStr{"hosts": []string{"localhost"}},
+			valid: true,
+		},
+		"no hosts": {
+			cfg:   common.MapStr{},
+			valid: false,
+		},
+		"zstd compression": {
+			cfg: common.MapStr{
+				"hosts":             []string{"localhost"},
+				"compression":       "zstd",
+				"compression_level": "better",
+			},
+			valid: true,
+		},
+		"unknown compression": {
+			cfg: common.MapStr{
+				"hosts":       []string{"localhost"},
+				"compression": "snappy",
+			},
+			valid: false,
+		},
+		"unknown compression level": {
+			cfg: common.MapStr{
+				"hosts":             []string{"localhost"},
+				"compression_level": "best",
+			},
+			valid: false,
+		},
+		"token and token_file": {
+			cfg: common.MapStr{
+				"hosts":      []string{"localhost"},
+				"token":      "abc",
+				"token_file": "/etc/pulsar/token",
+			},
+			valid: false,
+		},
+		"multiple certificate authorities": {
+			cfg: common.MapStr{
+				"hosts":                       []string{"localhost"},
+				"ssl.certificate_authorities": []string{"/etc/ca1.pem", "/etc/ca2.pem"},
+			},
+			valid: false,
+		},
+		"invalid max_retries": {
+			cfg: common.MapStr{
+				"hosts":       []string{"localhost"},
+				"max_retries": -2,
+			},
+			valid: false,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			_, err := readConfig(common.MustNewConfigFrom(test.cfg))
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestServiceURL(t *testing.T) {
+	tests := map[string]struct {
+		cfg common.MapStr
+		url string
+	}{
+		"default port": {
+			cfg: common.MapStr{"hosts": []string{"localhost"}},
+			url: "pulsar://localhost:6650",
+		},
+		"multiple hosts": {
+			cfg: common.MapStr{"hosts": []string{"pulsar://a:6660", "b"}},
+			url: "pulsar://a:6660,b:6650",
+		},
+		"tls": {
+			cfg: common.MapStr{
+				"hosts":       []string{"a", "b:7000"},
+				"ssl.enabled": true,
+			},
+			url: "pulsar+ssl://a:6651,b:7000",
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			config, err := readConfig(common.MustNewConfigFrom(test.cfg))
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.url, config.serviceURL())
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pulsar
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar/log"
+
+	"github.com/njcx/libbeat_v7/logp"
+)
+
+// pulsarLogger forwards the pulsar client logs to the beats logger.
+type pulsarLogger struct {
+	*logp.Logger
+}
+
+func (l pulsarLogger) SubLogger(fields log.Fields) log.Logger {
+	return pulsarLogger{l.with(fields)}
+}
+
+func (l pulsarLogger) WithFields(fields log.Fields) log.Entry {
+	return pulsarLogger{l.with(fields)}
+}
+
+func (l pulsarLogger) WithField(name string, value interface{}) log.Entry {
+	return pulsarLogger{l.Logger.With(name, value)}
+}
+
+func (l pulsarLogger) WithError(err error) log.Entry {
+	return pulsarLogger{l.Logger.With("error", err)}
+}
+
+func (l pulsarLogger) with(fields log.Fields) *logp.Logger {
+	args := make([]interface{}, 0, 2*len(fields))
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	return l.Logger.With(args...)
+}
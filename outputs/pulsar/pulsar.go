@@ -0,0 +1,162 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pulsar
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/transport/tlscommon"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/outputs/outil"
+)
+
+const (
+	logSelector = "pulsar"
+)
+
+func init() {
+	outputs.RegisterType("pulsar", makePulsar)
+}
+
+func makePulsar(
+	_ outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *common.Config,
+) (outputs.Group, error) {
+	log := logp.NewLogger(logSelector)
+	log.Debug("initialize pulsar output")
+
+	config, err := readConfig(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	topic, err := buildTopicSelector(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	clientOptions, err := newClientOptions(log, config)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	codec, err := codec.CreateEncoder(beat, config.Codec)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	deadLetter, err := outputs.LoadDeadLetterQueue(beat, "pulsar", observer, cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	client := newPulsarClient(clientSettings{
+		Hosts:           config.hostPorts(),
+		Observer:        observer,
+		DeadLetter:      deadLetter,
+		Index:           beat.IndexPrefix,
+		Key:             config.Key,
+		Topic:           topic,
+		Codec:           codec,
+		ClientOptions:   clientOptions,
+		DialTimeout:     config.ConnectionTimeout,
+		ProducerOptions: newProducerOptions(config),
+	})
+
+	return outputs.Success(config.BulkMaxSize, config.MaxRetries,
+		outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max))
+}
+
+func buildTopicSelector(cfg *common.Config) (outil.Selector, error) {
+	return outil.BuildSelectorFromConfig(cfg, outil.Settings{
+		Key:              "topic",
+		MultiKey:         "topics",
+		EnableSingleOnly: true,
+		FailEmpty:        true,
+		Case:             outil.SelectorKeepCase,
+	})
+}
+
+func newClientOptions(log *logp.Logger, config *pulsarConfig) (pulsar.ClientOptions, error) {
+	opts := pulsar.ClientOptions{
+		URL:               config.serviceURL(),
+		ConnectionTimeout: config.ConnectionTimeout,
+		OperationTimeout:  config.OperationTimeout,
+		Logger:            pulsarLogger{log},
+	}
+
+	tlsConfig, err := tlscommon.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return opts, err
+	}
+
+	if tlsConfig != nil {
+		if len(config.TLS.CAs) > 0 {
+			opts.TLSTrustCertsFilePath = config.TLS.CAs[0]
+		}
+
+		switch tlsConfig.Verification {
+		case tlscommon.VerifyNone:
+			opts.TLSAllowInsecureConnection = true
+		case tlscommon.VerifyCertificate:
+			opts.TLSValidateHostname = false
+		default:
+			opts.TLSValidateHostname = true
+		}
+
+		// mutual TLS authentication using the configured client certificate
+		if len(tlsConfig.Certificates) > 0 {
+			cert := tlsConfig.Certificates[0]
+			opts.Authentication = pulsar.NewAuthenticationFromTLSCertSupplier(func() (*tls.Certificate, error) {
+				return &cert, nil
+			})
+		}
+	}
+
+	switch {
+	case config.Token != "":
+		opts.Authentication = pulsar.NewAuthenticationToken(config.Token)
+	case config.TokenFile != "":
+		opts.Authentication = pulsar.NewAuthenticationTokenFromFile(config.TokenFile)
+	}
+
+	return opts, nil
+}
+
+func newProducerOptions(config *pulsarConfig) pulsar.ProducerOptions {
+	return pulsar.ProducerOptions{
+		Name:                    config.ProducerName,
+		SendTimeout:             config.SendTimeout,
+		MaxPendingMessages:      config.MaxPendingMessages,
+		CompressionType:         compressionTypes[strings.ToLower(config.Compression)],
+		CompressionLevel:        compressionLevels[strings.ToLower(config.CompressionLevel)],
+		DisableBatching:         !config.Batching.Enabled,
+		BatchingMaxPublishDelay: config.Batching.MaxPublishDelay,
+		BatchingMaxMessages:     config.Batching.MaxMessages,
+		BatchingMaxSize:         config.Batching.MaxSizeKb * 1024,
+	}
+}
@@ -24,11 +24,12 @@ import (
 	"github.com/gomodule/redigo/redis"
 
 	b "github.com/njcx/libbeat_v7/common/backoff"
+	"github.com/njcx/libbeat_v7/outputs"
 	"github.com/njcx/libbeat_v7/publisher"
 )
 
 type backoffClient struct {
-	client *client
+	client outputs.NetworkClient
 
 	reason failReason
 
@@ -50,7 +51,7 @@ const (
 	failOther
 )
 
-func newBackoffClient(client *client, init, max time.Duration) *backoffClient {
+func newBackoffClient(client outputs.NetworkClient, init, max time.Duration) *backoffClient {
 	done := make(chan struct{})
 	backoff := b.NewEqualJitterBackoff(done, init, max)
 	return &backoffClient{
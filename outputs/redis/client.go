@@ -20,6 +20,7 @@ package redis
 import (
 	"context"
 	"errors"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -46,18 +47,28 @@ type publishFn func(
 	data []publisher.Event,
 ) ([]publisher.Event, error)
 
+// commandArgs returns the arguments of the command publishing an encoded
+// event under key.
+type commandArgs func(key string, event *beat.Event, serialized []byte) []interface{}
+
 type client struct {
 	log *logp.Logger
 	*transport.Client
-	observer outputs.Observer
-	index    string
-	dataType redisDataType
-	db       int
-	key      outil.Selector
-	password string
-	publish  publishFn
-	codec    codec.Codec
-	timeout  time.Duration
+	observer   outputs.Observer
+	deadLetter outputs.DeadLetterQueue
+	index      string
+	dataType   redisDataType
+	stream     streamConfig
+	db         int
+	key        outil.Selector
+	password   string
+	publish    publishFn
+	codec      codec.Codec
+	timeout    time.Duration
+
+	// primaryOnly rejects connections to replicas. It is set for primaries
+	// discovered via sentinel, which may be demoted before we connect.
+	primaryOnly bool
 }
 
 type redisDataType uint16
@@ -65,27 +76,31 @@ type redisDataType uint16
 const (
 	redisListType redisDataType = iota
 	redisChannelType
+	redisStreamType
 )
 
 func newClient(
 	tc *transport.Client,
 	observer outputs.Observer,
+	deadLetter outputs.DeadLetterQueue,
 	timeout time.Duration,
 	pass string,
-	db int, key outil.Selector, dt redisDataType,
+	db int, key outil.Selector, dt redisDataType, stream streamConfig,
 	index string, codec codec.Codec,
 ) *client {
 	return &client{
-		log:      logp.NewLogger("redis"),
-		Client:   tc,
-		observer: observer,
-		timeout:  timeout,
-		password: pass,
-		index:    strings.ToLower(index),
-		db:       db,
-		dataType: dt,
-		key:      key,
-		codec:    codec,
+		log:        logp.NewLogger("redis"),
+		Client:     tc,
+		observer:   observer,
+		deadLetter: deadLetter,
+		timeout:    timeout,
+		password:   pass,
+		index:      strings.ToLower(index),
+		db:         db,
+		dataType:   dt,
+		stream:     stream,
+		key:        key,
+		codec:      codec,
 	}
 }
 
@@ -104,9 +119,15 @@ func (c *client) Connect() error {
 		}
 	}()
 
-	if err = initRedisConn(conn, c.password, c.db); err == nil {
-		c.publish, err = c.makePublish(conn)
+	if err = initRedisConn(conn, c.password, c.db); err != nil {
+		return err
 	}
+	if c.primaryOnly {
+		if err = checkPrimaryRole(conn); err != nil {
+			return err
+		}
+	}
+	c.publish, err = c.makePublish(conn)
 	return err
 }
 
@@ -130,6 +151,46 @@ func initRedisConn(c redis.Conn, pwd string, db int) error {
 	return nil
 }
 
+// dialRedis opens an authenticated connection to a redis server that is not
+// managed by a client, e.g. a sentinel or a cluster node.
+func dialRedis(
+	transp transport.Config,
+	host string,
+	port int,
+	timeout time.Duration,
+	pwd string,
+) (redis.Conn, error) {
+	tc, err := transport.NewClient(transp, "tcp", host, port)
+	if err != nil {
+		return nil, err
+	}
+	if err := tc.Connect(); err != nil {
+		return nil, err
+	}
+
+	conn := redis.NewConn(tc, timeout, timeout)
+	if err := initRedisConn(conn, pwd, 0); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// checkPrimaryRole returns an error if the server is not a primary.
+func checkPrimaryRole(c redis.Conn) error {
+	role, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(role) == 0 {
+		return errors.New("empty ROLE response")
+	}
+	if name, _ := redis.String(role[0], nil); name != "master" {
+		return fmt.Errorf("redis server has role %v, expected a primary", name)
+	}
+	return nil
+}
+
 func (c *client) Close() error {
 	c.log.Debug("close connection")
 	return c.Client.Close()
@@ -163,8 +224,11 @@ func (c *client) String() string {
 func (c *client) makePublish(
 	conn redis.Conn,
 ) (publishFn, error) {
-	if c.dataType == redisChannelType {
+	switch c.dataType {
+	case redisChannelType:
 		return c.makePublishPUBLISH(conn)
+	case redisStreamType:
+		return c.publishEventsPipeline(conn, "XADD", c.stream.xaddArgs), nil
 	}
 	return c.makePublishRPUSH(conn)
 }
@@ -172,7 +236,7 @@ func (c *client) makePublish(
 func (c *client) makePublishRPUSH(conn redis.Conn) (publishFn, error) {
 	if !c.key.IsConst() {
 		// TODO: more clever bulk handling batching events with same key
-		return c.publishEventsPipeline(conn, "RPUSH"), nil
+		return c.publishEventsPipeline(conn, "RPUSH", pushArgs), nil
 	}
 
 	var major, minor int
@@ -213,11 +277,11 @@ func (c *client) makePublishRPUSH(conn redis.Conn) (publishFn, error) {
 	if multiValue {
 		return c.publishEventsBulk(conn, "RPUSH"), nil
 	}
-	return c.publishEventsPipeline(conn, "RPUSH"), nil
+	return c.publishEventsPipeline(conn, "RPUSH", pushArgs), nil
 }
 
 func (c *client) makePublishPUBLISH(conn redis.Conn) (publishFn, error) {
-	return c.publishEventsPipeline(conn, "PUBLISH"), nil
+	return c.publishEventsPipeline(conn, "PUBLISH", pushArgs), nil
 }
 
 func (c *client) publishEventsBulk(conn redis.Conn, command string) publishFn {
@@ -227,8 +291,7 @@ func (c *client) publishEventsBulk(conn redis.Conn, command string) publishFn {
 		args := make([]interface{}, 1, len(data)+1)
 		args[0] = dest
 
-		okEvents, args := serializeEvents(c.log, args, 1, data, c.index, c.codec)
-		c.observer.Dropped(len(data) - len(okEvents))
+		okEvents, args := serializeEvents(c.log, c.deadLetter, args, data, c.index, c.codec)
 		if (len(args) - 1) == 0 {
 			return nil, nil
 		}
@@ -246,105 +309,107 @@ func (c *client) publishEventsBulk(conn redis.Conn, command string) publishFn {
 	}
 }
 
-func (c *client) publishEventsPipeline(conn redis.Conn, command string) publishFn {
+func (c *client) publishEventsPipeline(conn redis.Conn, command string, argsFn commandArgs) publishFn {
 	return func(key outil.Selector, data []publisher.Event) ([]publisher.Event, error) {
 		var okEvents []publisher.Event
 		serialized := make([]interface{}, 0, len(data))
-		okEvents, serialized = serializeEvents(c.log, serialized, 0, data, c.index, c.codec)
-		c.observer.Dropped(len(data) - len(okEvents))
+		okEvents, serialized = serializeEvents(c.log, c.deadLetter, serialized, data, c.index, c.codec)
 		if len(serialized) == 0 {
 			return nil, nil
 		}
 
 		data = okEvents[:0]
-		dropped := 0
+		args := make([][]interface{}, 0, len(serialized))
 		for i, serializedEvent := range serialized {
 			eventKey, err := key.Select(&okEvents[i].Content)
 			if err != nil {
 				c.log.Errorf("Failed to set redis key: %+v", err)
-				dropped++
+				c.deadLetter.Add(&okEvents[i].Content, err)
 				continue
 			}
 
 			data = append(data, okEvents[i])
-			if err := conn.Send(command, eventKey, serializedEvent); err != nil {
-				c.log.Errorf("Failed to execute %v: %+v", command, err)
-				return okEvents, err
-			}
+			args = append(args, argsFn(eventKey, &okEvents[i].Content, serializedEvent.([]byte)))
 		}
-		c.observer.Dropped(dropped)
 
-		if err := conn.Flush(); err != nil {
+		failed, err := sendPipeline(c.log, conn, command, data, args)
+		c.observer.Acked(len(data) - len(failed))
+		return failed, err
+	}
+}
+
+// pushArgs returns the arguments of the RPUSH and PUBLISH commands.
+func pushArgs(key string, _ *beat.Event, serialized []byte) []interface{} {
+	return []interface{}{key, serialized}
+}
+
+// sendPipeline sends one command per event in a single pipeline and returns
+// the events the command failed for.
+func sendPipeline(
+	log *logp.Logger,
+	conn redis.Conn,
+	command string,
+	data []publisher.Event,
+	args [][]interface{},
+) ([]publisher.Event, error) {
+	for i := range args {
+		if err := conn.Send(command, args[i]...); err != nil {
+			log.Errorf("Failed to execute %v: %+v", command, err)
 			return data, err
 		}
+	}
 
-		failed := data[:0]
-		var lastErr error
-		for i := range serialized {
-			_, err := conn.Receive()
-			if err != nil {
-				if _, ok := err.(redis.Error); ok {
-					c.log.Errorf("Failed to %v event to list with %+v",
-						command, err)
-					failed = append(failed, data[i])
-					lastErr = err
-				} else {
-					c.log.Errorf("Failed to %v multiple events to list with %+v",
-						command, err)
-					failed = append(failed, data[i:]...)
-					lastErr = err
-					break
-				}
+	if err := conn.Flush(); err != nil {
+		return data, err
+	}
+
+	failed := data[:0]
+	var lastErr error
+	for i := range data {
+		_, err := conn.Receive()
+		if err != nil {
+			if _, ok := err.(redis.Error); ok {
+				log.Errorf("Failed to %v event to list with %+v",
+					command, err)
+				failed = append(failed, data[i])
+				lastErr = err
+			} else {
+				log.Errorf("Failed to %v multiple events to list with %+v",
+					command, err)
+				failed = append(failed, data[i:]...)
+				lastErr = err
+				break
 			}
 		}
-
-		c.observer.Acked(len(okEvents) - len(failed))
-		return failed, lastErr
 	}
+	return failed, lastErr
 }
 
+// serializeEvents encodes all events, appending the encoded events to `to`.
+// Events failing to be encoded are handed to the dead letter queue.
 func serializeEvents(
 	log *logp.Logger,
+	deadLetter outputs.DeadLetterQueue,
 	to []interface{},
-	i int,
 	data []publisher.Event,
 	index string,
 	codec codec.Codec,
 ) ([]publisher.Event, []interface{}) {
-
-	succeeded := data
-	for _, d := range data {
+	succeeded := data[:0]
+	for i := range data {
+		d := data[i]
 		serializedEvent, err := codec.Encode(index, &d.Content)
 		if err != nil {
 			log.Errorf("Encoding event failed with error: %+v", err)
 			log.Debugf("Failed event: %v", d.Content)
-			goto failLoop
-		}
-
-		buf := make([]byte, len(serializedEvent))
-		copy(buf, serializedEvent)
-		to = append(to, buf)
-		i++
-	}
-	return succeeded, to
-
-failLoop:
-	succeeded = data[:i]
-	rest := data[i+1:]
-	for _, d := range rest {
-		serializedEvent, err := codec.Encode(index, &d.Content)
-		if err != nil {
-			log.Errorf("Encoding event failed with error: %+v", err)
-			log.Debugf("Failed event: %v", d.Content)
-			i++
+			deadLetter.Add(&d.Content, err)
 			continue
 		}
 
 		buf := make([]byte, len(serializedEvent))
 		copy(buf, serializedEvent)
 		to = append(to, buf)
-		i++
+		succeeded = append(succeeded, d)
 	}
-
 	return succeeded, to
 }
@@ -0,0 +1,313 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/outputs/outil"
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+// clusterSlots is the number of hash slots keys are distributed on in a
+// redis cluster.
+const clusterSlots = 16384
+
+// redisHost is a configured redis endpoint with its connection settings.
+type redisHost struct {
+	transport transport.Config
+	host      string
+	password  string
+}
+
+// clusterClient publishes events to a redis cluster. Events are routed to the
+// primary serving the hash slot of their key. The slot map is read from the
+// seed hosts on connect. Redirects (MOVED, ASK) fail the affected events and
+// force a reconnect, which refreshes the slot map.
+type clusterClient struct {
+	log        *logp.Logger
+	observer   outputs.Observer
+	deadLetter outputs.DeadLetterQueue
+	seeds      []redisHost
+	timeout    time.Duration
+	key        outil.Selector
+	index      string
+	codec      codec.Codec
+	command    string
+	args       commandArgs
+
+	// seed is the host the slot map was read from. Connections to the
+	// cluster nodes use its transport settings and password.
+	seed  *redisHost
+	slots []string
+	conns map[string]redis.Conn
+}
+
+// clusterBatch holds the events of a batch routed to one cluster node.
+type clusterBatch struct {
+	events []publisher.Event
+	args   [][]interface{}
+}
+
+func newClusterClient(
+	seeds []redisHost,
+	observer outputs.Observer,
+	deadLetter outputs.DeadLetterQueue,
+	timeout time.Duration,
+	key outil.Selector, dt redisDataType, stream streamConfig,
+	index string, codec codec.Codec,
+) *clusterClient {
+	c := &clusterClient{
+		log:        logp.NewLogger("redis"),
+		observer:   observer,
+		deadLetter: deadLetter,
+		seeds:      seeds,
+		timeout:    timeout,
+		key:        key,
+		index:      strings.ToLower(index),
+		codec:      codec,
+		conns:      map[string]redis.Conn{},
+	}
+
+	switch dt {
+	case redisChannelType:
+		c.command, c.args = "PUBLISH", pushArgs
+	case redisStreamType:
+		c.command, c.args = "XADD", stream.xaddArgs
+	default:
+		c.command, c.args = "RPUSH", pushArgs
+	}
+	return c
+}
+
+func (c *clusterClient) Connect() error {
+	c.log.Debug("connect")
+
+	var err error
+	for i := range c.seeds {
+		seed := &c.seeds[i]
+		var slots []string
+		if slots, err = c.readSlots(seed); err == nil {
+			c.seed, c.slots = seed, slots
+			return nil
+		}
+		c.log.Warnf("Failed to read the cluster slots from %v: %+v", seed.host, err)
+	}
+	return err
+}
+
+func (c *clusterClient) readSlots(seed *redisHost) ([]string, error) {
+	conn, err := dialRedis(seed.transport, seed.host, defaultPort, c.timeout, seed.password)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	seedHost, _, err := net.SplitHostPort(seed.host)
+	if err != nil {
+		seedHost = seed.host
+	}
+	return parseClusterSlots(ranges, seedHost)
+}
+
+// parseClusterSlots returns the address of the primary serving each hash
+// slot from a CLUSTER SLOTS response. Unassigned slots are left empty.
+func parseClusterSlots(ranges []interface{}, seedHost string) ([]string, error) {
+	invalid := errors.New("invalid CLUSTER SLOTS response")
+
+	slots := make([]string, clusterSlots)
+	for _, r := range ranges {
+		info, err := redis.Values(r, nil)
+		if err != nil || len(info) < 3 {
+			return nil, invalid
+		}
+		start, err := redis.Int(info[0], nil)
+		if err != nil {
+			return nil, invalid
+		}
+		end, err := redis.Int(info[1], nil)
+		if err != nil || start < 0 || start > end || end >= clusterSlots {
+			return nil, invalid
+		}
+		primary, err := redis.Values(info[2], nil)
+		if err != nil || len(primary) < 2 {
+			return nil, invalid
+		}
+		host, err := redis.String(primary[0], nil)
+		if err != nil {
+			return nil, invalid
+		}
+		port, err := redis.Int(primary[1], nil)
+		if err != nil {
+			return nil, invalid
+		}
+
+		// An empty or unknown endpoint means the node can be reached on
+		// the host we asked.
+		if host == "" || host == "?" {
+			host = seedHost
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = addr
+		}
+	}
+	return slots, nil
+}
+
+func (c *clusterClient) Close() error {
+	c.log.Debug("close connections")
+
+	var err error
+	for addr, conn := range c.conns {
+		if cerr := conn.Close(); cerr != nil {
+			err = cerr
+		}
+		delete(c.conns, addr)
+	}
+	c.slots = nil
+	return err
+}
+
+func (c *clusterClient) Publish(_ context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	serialized := make([]interface{}, 0, len(events))
+	okEvents, serialized := serializeEvents(c.log, c.deadLetter, serialized, events, c.index, c.codec)
+
+	nodes := map[string]*clusterBatch{}
+	var order []string
+	for i := range okEvents {
+		event := &okEvents[i].Content
+		key, err := c.key.Select(event)
+		if err != nil {
+			c.log.Errorf("Failed to set redis key: %+v", err)
+			c.deadLetter.Add(event, err)
+			continue
+		}
+
+		addr := c.slots[hashSlot(key)]
+		b := nodes[addr]
+		if b == nil {
+			b = &clusterBatch{}
+			nodes[addr] = b
+			order = append(order, addr)
+		}
+		b.events = append(b.events, okEvents[i])
+		b.args = append(b.args, c.args(key, event, serialized[i].([]byte)))
+	}
+
+	var failed []publisher.Event
+	var lastErr error
+	for _, addr := range order {
+		b := nodes[addr]
+		rest, err := c.publishNode(addr, b)
+		c.observer.Acked(len(b.events) - len(rest))
+		if err != nil {
+			failed = append(failed, rest...)
+			lastErr = err
+		}
+	}
+
+	if len(failed) > 0 {
+		c.observer.Failed(len(failed))
+		batch.RetryEvents(failed)
+		return lastErr
+	}
+	batch.ACK()
+	return nil
+}
+
+func (c *clusterClient) publishNode(addr string, b *clusterBatch) ([]publisher.Event, error) {
+	if addr == "" {
+		return b.events, errors.New("hash slot not served by any cluster node")
+	}
+
+	conn, err := c.nodeConn(addr)
+	if err != nil {
+		c.log.Errorf("Failed to connect to cluster node %v: %+v", addr, err)
+		return b.events, err
+	}
+	return sendPipeline(c.log, conn, c.command, b.events, b.args)
+}
+
+func (c *clusterClient) nodeConn(addr string) (redis.Conn, error) {
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+
+	conn, err := dialRedis(c.seed.transport, addr, defaultPort, c.timeout, c.seed.password)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+func (c *clusterClient) String() string {
+	hosts := make([]string, len(c.seeds))
+	for i, seed := range c.seeds {
+		hosts[i] = seed.host
+	}
+	return fmt.Sprintf("redis(cluster %v)", strings.Join(hosts, ","))
+}
+
+// hashSlot returns the cluster hash slot of key. If the key contains a hash
+// tag, only the tag is hashed, so keys sharing a tag map to the same slot.
+func hashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 implements the CRC16-XMODEM checksum used by redis cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,85 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashSlot(t *testing.T) {
+	tests := map[string]int{
+		"123456789":     12739,
+		"somekey":       11058,
+		"foo{hash_tag}": 2515,
+		"bar{hash_tag}": 2515,
+	}
+	for key, slot := range tests {
+		assert.Equal(t, slot, hashSlot(key), key)
+	}
+
+	assert.Equal(t, hashSlot("user1000"), hashSlot("{user1000}.following"))
+	assert.Equal(t, hashSlot("{bar"), hashSlot("foo{{bar}}zap"), "only the first tag is used")
+	assert.Equal(t, int(crc16("foo{}{bar}"))%clusterSlots, hashSlot("foo{}{bar}"),
+		"empty tags hash the full key")
+}
+
+func TestParseClusterSlots(t *testing.T) {
+	node := func(host string, port int64) []interface{} {
+		return []interface{}{[]byte(host), port, []byte("id")}
+	}
+
+	t.Run("valid response", func(t *testing.T) {
+		slots, err := parseClusterSlots([]interface{}{
+			[]interface{}{int64(0), int64(5460), node("10.0.0.1", 7000), node("10.0.0.4", 7003)},
+			[]interface{}{int64(5461), int64(10922), node("", 7001)},
+			[]interface{}{int64(10923), int64(16383), node("?", 7002)},
+		}, "seed")
+		require.NoError(t, err)
+		require.Len(t, slots, clusterSlots)
+
+		assert.Equal(t, "10.0.0.1:7000", slots[0])
+		assert.Equal(t, "10.0.0.1:7000", slots[5460])
+		assert.Equal(t, "seed:7001", slots[5461])
+		assert.Equal(t, "seed:7001", slots[10922])
+		assert.Equal(t, "seed:7002", slots[16383])
+	})
+
+	t.Run("unassigned slots are empty", func(t *testing.T) {
+		slots, err := parseClusterSlots([]interface{}{
+			[]interface{}{int64(0), int64(100), node("10.0.0.1", 7000)},
+		}, "seed")
+		require.NoError(t, err)
+		assert.Equal(t, "", slots[101])
+	})
+
+	t.Run("invalid responses", func(t *testing.T) {
+		invalid := [][]interface{}{
+			{[]interface{}{int64(0), int64(100)}},
+			{[]interface{}{int64(100), int64(0), node("10.0.0.1", 7000)}},
+			{[]interface{}{int64(0), int64(clusterSlots), node("10.0.0.1", 7000)}},
+			{[]interface{}{int64(0), int64(100), []interface{}{[]byte("10.0.0.1")}}},
+		}
+		for _, ranges := range invalid {
+			_, err := parseClusterSlots(ranges, "seed")
+			assert.Error(t, err)
+		}
+	})
+}
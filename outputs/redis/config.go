@@ -18,6 +18,7 @@
 package redis
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -27,6 +28,7 @@ import (
 )
 
 type redisConfig struct {
+	Hosts       []string              `config:"hosts"`
 	Password    string                `config:"password"`
 	Index       string                `config:"index"`
 	Key         string                `config:"key"`
@@ -39,9 +41,29 @@ type redisConfig struct {
 	Codec       codec.Config          `config:"codec"`
 	Db          int                   `config:"db"`
 	DataType    string                `config:"datatype"`
+	Stream      streamConfig          `config:"stream"`
+	Cluster     clusterConfig         `config:"cluster"`
+	Sentinel    sentinelConfig        `config:"sentinel"`
+	Worker      int                   `config:"worker" validate:"min=1"`
 	Backoff     backoff               `config:"backoff"`
 }
 
+type streamConfig struct {
+	MaxLen      int    `config:"maxlen" validate:"min=0"`
+	Approximate bool   `config:"approximate"`
+	Layout      string `config:"layout"`
+	Field       string `config:"field"`
+}
+
+type clusterConfig struct {
+	Enabled bool `config:"enabled"`
+}
+
+type sentinelConfig struct {
+	MasterName string `config:"master_name"`
+	Password   string `config:"password"`
+}
+
 type backoff struct {
 	Init time.Duration
 	Max  time.Duration
@@ -56,6 +78,12 @@ var (
 		TLS:         nil,
 		Db:          0,
 		DataType:    "list",
+		Stream: streamConfig{
+			Approximate: true,
+			Layout:      streamLayoutPayload,
+			Field:       "event",
+		},
+		Worker: 1,
 		Backoff: backoff{
 			Init: 1 * time.Second,
 			Max:  60 * time.Second,
@@ -65,10 +93,28 @@ var (
 
 func (c *redisConfig) Validate() error {
 	switch c.DataType {
-	case "", "list", "channel":
+	case "", "list", "channel", "stream":
 	default:
 		return fmt.Errorf("redis data type %v not supported", c.DataType)
 	}
 
+	switch c.Stream.Layout {
+	case "", streamLayoutPayload, streamLayoutFields:
+	default:
+		return fmt.Errorf("redis stream layout %v not supported", c.Stream.Layout)
+	}
+	if c.DataType == "stream" && c.Stream.Layout == streamLayoutPayload && c.Stream.Field == "" {
+		return errors.New("redis stream field must be set for the payload layout")
+	}
+
+	if c.Cluster.Enabled {
+		if c.Sentinel.MasterName != "" {
+			return errors.New("redis cluster and sentinel can not be configured at the same time")
+		}
+		if c.Db != 0 {
+			return errors.New("redis cluster only supports db 0")
+		}
+	}
+
 	return nil
 }
@@ -37,6 +37,15 @@ func TestValidate(t *testing.T) {
 		{"Invalid Datatype", redisConfig{Key: "test", DataType: "something"}, false},
 		{"List Datatype", redisConfig{Key: "test", DataType: "list"}, true},
 		{"Channel Datatype", redisConfig{Key: "test", DataType: "channel"}, true},
+		{"Stream Datatype", redisConfig{Key: "test", DataType: "stream", Stream: streamConfig{Layout: "payload", Field: "event"}}, true},
+		{"Stream fields layout", redisConfig{Key: "test", DataType: "stream", Stream: streamConfig{Layout: "fields"}}, true},
+		{"Invalid stream layout", redisConfig{Key: "test", DataType: "stream", Stream: streamConfig{Layout: "something"}}, false},
+		{"Stream payload without field", redisConfig{Key: "test", DataType: "stream", Stream: streamConfig{Layout: "payload"}}, false},
+
+		{"Cluster", redisConfig{Key: "test", Cluster: clusterConfig{Enabled: true}}, true},
+		{"Cluster with db", redisConfig{Key: "test", Db: 1, Cluster: clusterConfig{Enabled: true}}, false},
+		{"Sentinel", redisConfig{Key: "test", Db: 1, Sentinel: sentinelConfig{MasterName: "mymaster"}}, true},
+		{"Cluster and sentinel", redisConfig{Key: "test", Cluster: clusterConfig{Enabled: true}, Sentinel: sentinelConfig{MasterName: "mymaster"}}, false},
 	}
 
 	for _, test := range tests {
@@ -88,6 +88,8 @@ func makeRedis(
 		dataType = redisListType
 	case "channel":
 		dataType = redisChannelType
+	case "stream":
+		dataType = redisStreamType
 	default:
 		return outputs.Fail(errors.New("Bad Redis data type"))
 	}
@@ -107,67 +109,139 @@ func makeRedis(
 		return outputs.Fail(err)
 	}
 
+	deadLetter, err := outputs.LoadDeadLetterQueue(beat, "redis", observer, cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	transp := transport.Config{
+		Timeout: config.Timeout,
+		Proxy:   &config.Proxy,
+		TLS:     tls,
+		Stats:   observer,
+	}
+
+	if config.Cluster.Enabled || config.Sentinel.MasterName != "" {
+		clients, err := makeTopologyClients(beat, observer, deadLetter, &config, transp, key, dataType)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+		return outputs.SuccessNet(config.LoadBalance, config.BulkMaxSize, config.MaxRetries, clients)
+	}
+
 	clients := make([]outputs.NetworkClient, len(hosts))
 	for i, h := range hosts {
-		hasScheme := true
-		if parts := strings.SplitN(h, "://", 2); len(parts) != 2 {
-			h = fmt.Sprintf("%s://%s", redisScheme, h)
-			hasScheme = false
+		host, err := parseHost(h, transp, config.Password)
+		if err != nil {
+			return outputs.Fail(err)
 		}
 
-		hostUrl, err := url.Parse(h)
+		conn, err := transport.NewClient(host.transport, "tcp", host.host, defaultPort)
 		if err != nil {
 			return outputs.Fail(err)
 		}
 
-		if hostUrl.Host == "" {
-			return outputs.Fail(fmt.Errorf("invalid redis url host %s", hostUrl.Host))
+		enc, err := codec.CreateEncoder(beat, config.Codec)
+		if err != nil {
+			return outputs.Fail(err)
 		}
 
-		if hostUrl.Scheme != redisScheme && hostUrl.Scheme != tlsRedisScheme {
-			return outputs.Fail(fmt.Errorf("invalid redis url scheme %s", hostUrl.Scheme))
-		}
+		client := newClient(conn, observer, deadLetter, config.Timeout,
+			host.password, config.Db, key, dataType, config.Stream, config.Index, enc)
+		clients[i] = newBackoffClient(client, config.Backoff.Init, config.Backoff.Max)
+	}
 
-		transp := transport.Config{
-			Timeout: config.Timeout,
-			Proxy:   &config.Proxy,
-			TLS:     tls,
-			Stats:   observer,
-		}
+	return outputs.SuccessNet(config.LoadBalance, config.BulkMaxSize, config.MaxRetries, clients)
+}
 
-		switch hostUrl.Scheme {
-		case redisScheme:
-			if hasScheme {
-				transp.TLS = nil // disable TLS if user explicitely set `redis` scheme
-			}
-		case tlsRedisScheme:
-			if transp.TLS == nil {
-				transp.TLS = &tlscommon.TLSConfig{} // enable with system default if TLS was not configured
+// makeTopologyClients creates one client per worker for a redis cluster or a
+// sentinel managed primary. Every client uses all configured hosts as cluster
+// seeds or sentinels.
+func makeTopologyClients(
+	beat beat.Info,
+	observer outputs.Observer,
+	deadLetter outputs.DeadLetterQueue,
+	config *redisConfig,
+	transp transport.Config,
+	key outil.Selector,
+	dataType redisDataType,
+) ([]outputs.NetworkClient, error) {
+	// Sentinels authenticate with their own password, the primary with the
+	// output password.
+	defaultPassword := config.Password
+	if !config.Cluster.Enabled {
+		defaultPassword = config.Sentinel.Password
+	}
+
+	clients := make([]outputs.NetworkClient, config.Worker)
+	for i := range clients {
+		hosts := make([]redisHost, len(config.Hosts))
+		for j, h := range config.Hosts {
+			host, err := parseHost(h, transp, defaultPassword)
+			if err != nil {
+				return nil, err
 			}
+			hosts[j] = host
 		}
 
-		conn, err := transport.NewClient(transp, "tcp", hostUrl.Host, defaultPort)
+		enc, err := codec.CreateEncoder(beat, config.Codec)
 		if err != nil {
-			return outputs.Fail(err)
+			return nil, err
 		}
 
-		pass := config.Password
-		hostPass, passSet := hostUrl.User.Password()
-		if passSet {
-			pass = hostPass
+		var client outputs.NetworkClient
+		if config.Cluster.Enabled {
+			client = newClusterClient(hosts, observer, deadLetter, config.Timeout,
+				key, dataType, config.Stream, config.Index, enc)
+		} else {
+			primary := newClient(nil, observer, deadLetter, config.Timeout,
+				config.Password, config.Db, key, dataType, config.Stream, config.Index, enc)
+			client = newSentinelClient(primary, config.Sentinel.MasterName, hosts)
 		}
+		clients[i] = newBackoffClient(client, config.Backoff.Init, config.Backoff.Max)
+	}
+	return clients, nil
+}
 
-		enc, err := codec.CreateEncoder(beat, config.Codec)
-		if err != nil {
-			return outputs.Fail(err)
+// parseHost reads the address, TLS settings and password of a configured
+// host URL. The password defaults to pass if the URL does not contain one.
+func parseHost(h string, transp transport.Config, pass string) (redisHost, error) {
+	hasScheme := true
+	if parts := strings.SplitN(h, "://", 2); len(parts) != 2 {
+		h = fmt.Sprintf("%s://%s", redisScheme, h)
+		hasScheme = false
+	}
+
+	hostUrl, err := url.Parse(h)
+	if err != nil {
+		return redisHost{}, err
+	}
+
+	if hostUrl.Host == "" {
+		return redisHost{}, fmt.Errorf("invalid redis url host %s", hostUrl.Host)
+	}
+
+	if hostUrl.Scheme != redisScheme && hostUrl.Scheme != tlsRedisScheme {
+		return redisHost{}, fmt.Errorf("invalid redis url scheme %s", hostUrl.Scheme)
+	}
+
+	switch hostUrl.Scheme {
+	case redisScheme:
+		if hasScheme {
+			transp.TLS = nil // disable TLS if user explicitely set `redis` scheme
+		}
+	case tlsRedisScheme:
+		if transp.TLS == nil {
+			transp.TLS = &tlscommon.TLSConfig{} // enable with system default if TLS was not configured
 		}
+	}
 
-		client := newClient(conn, observer, config.Timeout,
-			pass, config.Db, key, dataType, config.Index, enc)
-		clients[i] = newBackoffClient(client, config.Backoff.Init, config.Backoff.Max)
+	hostPass, passSet := hostUrl.User.Password()
+	if passSet {
+		pass = hostPass
 	}
 
-	return outputs.SuccessNet(config.LoadBalance, config.BulkMaxSize, config.MaxRetries, clients)
+	return redisHost{transport: transp, host: hostUrl.Host, password: pass}, nil
 }
 
 func buildKeySelector(cfg *common.Config) (outil.Selector, error) {
@@ -47,7 +47,27 @@ func clientsLen(required int) checker {
 func clientPassword(index int, pass string) checker {
 	return func(t *testing.T, group outputs.Group) {
 		redisClient := group.Clients[index].(*backoffClient)
-		assert.Equal(t, redisClient.client.password, pass)
+		assert.Equal(t, redisClient.client.(*client).password, pass)
+	}
+}
+
+func clusterSeeds(index int, seeds int) checker {
+	return func(t *testing.T, group outputs.Group) {
+		redisClient := group.Clients[index].(*backoffClient)
+		assert.Len(t, redisClient.client.(*clusterClient).seeds, seeds)
+	}
+}
+
+func sentinelPasswords(index int, primary string, sentinels ...string) checker {
+	return func(t *testing.T, group outputs.Group) {
+		redisClient := group.Clients[index].(*backoffClient)
+		sentinel := redisClient.client.(*sentinelClient)
+		assert.Equal(t, primary, sentinel.password)
+		if assert.Len(t, sentinel.sentinels, len(sentinels)) {
+			for i, pass := range sentinels {
+				assert.Equal(t, pass, sentinel.sentinels[i].password)
+			}
+		}
 	}
 }
 
@@ -101,6 +121,28 @@ func TestMakeRedis(t *testing.T) {
 				clientPassword(1, "mypassword"),
 			),
 		},
+		"Cluster": {
+			config: map[string]interface{}{
+				"hosts":           []string{"redis://localhost:7000", "localhost:7001"},
+				"cluster.enabled": true,
+				"worker":          2,
+			},
+			valid:  true,
+			checks: checks(clientsLen(2), clusterSeeds(0, 2)),
+		},
+		"Sentinel": {
+			config: map[string]interface{}{
+				"hosts":                []string{"localhost:26379", "redis://:sentinelPassword@localhost:26380"},
+				"password":             "primaryPassword",
+				"sentinel.master_name": "mymaster",
+				"sentinel.password":    "defaultSentinelPassword",
+			},
+			valid: true,
+			checks: checks(
+				clientsLen(1),
+				sentinelPasswords(0, "primaryPassword", "defaultSentinelPassword", "sentinelPassword"),
+			),
+		},
 	}
 	beatInfo := beat.Info{Beat: "libbeat", Version: "1.2.3"}
 	for name, test := range tests {
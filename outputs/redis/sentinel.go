@@ -0,0 +1,114 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/njcx/libbeat_v7/common/transport"
+)
+
+const defaultSentinelPort = 26379
+
+// sentinelClient publishes events to a primary managed by redis sentinel.
+// The primary address is looked up from the sentinels on every connect, so
+// after a failover the client follows the newly promoted primary once
+// publishing to the old one fails.
+type sentinelClient struct {
+	*client
+	masterName string
+	sentinels  []redisHost
+}
+
+func newSentinelClient(client *client, masterName string, sentinels []redisHost) *sentinelClient {
+	client.primaryOnly = true
+	return &sentinelClient{
+		client:     client,
+		masterName: masterName,
+		sentinels:  sentinels,
+	}
+}
+
+func (s *sentinelClient) Connect() error {
+	sentinel, addr, err := s.resolvePrimary()
+	if err != nil {
+		return err
+	}
+	s.log.Debugf("sentinel %v reports primary %v at %v", sentinel.host, s.masterName, addr)
+
+	tc, err := transport.NewClient(sentinel.transport, "tcp", addr, defaultPort)
+	if err != nil {
+		return err
+	}
+	if s.client.Client != nil {
+		s.client.Client.Close()
+	}
+	s.client.Client = tc
+	return s.client.Connect()
+}
+
+// resolvePrimary asks the sentinels in order for the address of the primary.
+// The first sentinel answering is moved to the front of the list.
+func (s *sentinelClient) resolvePrimary() (*redisHost, string, error) {
+	var err error
+	for i := range s.sentinels {
+		var addr string
+		if addr, err = s.queryPrimary(&s.sentinels[i]); err == nil {
+			s.sentinels[0], s.sentinels[i] = s.sentinels[i], s.sentinels[0]
+			return &s.sentinels[0], addr, nil
+		}
+		s.log.Warnf("Failed to query sentinel %v for primary %v: %+v",
+			s.sentinels[i].host, s.masterName, err)
+	}
+	return nil, "", err
+}
+
+func (s *sentinelClient) queryPrimary(sentinel *redisHost) (string, error) {
+	conn, err := dialRedis(sentinel.transport, sentinel.host, defaultSentinelPort, s.timeout, sentinel.password)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	addr, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", s.masterName))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("primary %v is not monitored by the sentinel", s.masterName)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(addr) != 2 {
+		return "", errors.New("invalid SENTINEL get-master-addr-by-name response")
+	}
+	return net.JoinHostPort(addr[0], addr[1]), nil
+}
+
+func (s *sentinelClient) Close() error {
+	if s.client.Client == nil {
+		return nil
+	}
+	return s.client.Close()
+}
+
+func (s *sentinelClient) String() string {
+	return "redis(sentinel " + s.masterName + ")"
+}
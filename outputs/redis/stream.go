@@ -0,0 +1,77 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+)
+
+const (
+	streamLayoutPayload = "payload"
+	streamLayoutFields  = "fields"
+)
+
+// xaddArgs returns the arguments of the XADD command adding an event to the
+// stream stored at key. Entries get an ID generated by redis and the stream is
+// trimmed to MaxLen entries if configured.
+func (s *streamConfig) xaddArgs(key string, event *beat.Event, serialized []byte) []interface{} {
+	args := []interface{}{key}
+	if s.MaxLen > 0 {
+		args = append(args, "MAXLEN")
+		if s.Approximate {
+			args = append(args, "~")
+		}
+		args = append(args, s.MaxLen)
+	}
+	args = append(args, "*")
+
+	if s.Layout == streamLayoutFields {
+		return appendEventFields(args, event)
+	}
+	return append(args, s.Field, serialized)
+}
+
+// appendEventFields appends one field-value pair per flattened event field.
+// Strings are stored as is, all other values are JSON encoded.
+func appendEventFields(args []interface{}, event *beat.Event) []interface{} {
+	fields := event.Fields.Flatten()
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args = append(args, "@timestamp", event.Timestamp.UTC().Format(common.TsLayout))
+	for _, k := range keys {
+		switch v := fields[k].(type) {
+		case string:
+			args = append(args, k, v)
+		default:
+			value, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			args = append(args, k, value)
+		}
+	}
+	return args
+}
@@ -0,0 +1,73 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+)
+
+func TestXAddArgs(t *testing.T) {
+	event := &beat.Event{
+		Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Fields: common.MapStr{
+			"message": "hello",
+			"host":    common.MapStr{"name": "test"},
+			"count":   3,
+		},
+	}
+	payload := []byte(`{"message":"hello"}`)
+
+	tests := map[string]struct {
+		config streamConfig
+		want   []interface{}
+	}{
+		"payload without trimming": {
+			config: streamConfig{Layout: streamLayoutPayload, Field: "event"},
+			want:   []interface{}{"key", "*", "event", payload},
+		},
+		"payload with exact trimming": {
+			config: streamConfig{MaxLen: 1000, Layout: streamLayoutPayload, Field: "event"},
+			want:   []interface{}{"key", "MAXLEN", 1000, "*", "event", payload},
+		},
+		"payload with approximate trimming": {
+			config: streamConfig{MaxLen: 1000, Approximate: true, Layout: streamLayoutPayload, Field: "event"},
+			want:   []interface{}{"key", "MAXLEN", "~", 1000, "*", "event", payload},
+		},
+		"fields": {
+			config: streamConfig{Layout: streamLayoutFields},
+			want: []interface{}{"key", "*",
+				"@timestamp", "2020-01-02T03:04:05.000Z",
+				"count", []byte("3"),
+				"host.name", "test",
+				"message", "hello",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.config.xaddArgs("key", event, payload))
+		})
+	}
+}
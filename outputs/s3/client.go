@@ -0,0 +1,520 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gofrs/uuid"
+
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/testing"
+)
+
+// s3API is the subset of the S3 API used by the output.
+type s3API interface {
+	HeadBucket(context.Context, *s3.HeadBucketInput, ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(context.Context, *s3.CompleteMultipartUploadInput, ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+type clientSettings struct {
+	API              s3API
+	Observer         outputs.Observer
+	DeadLetter       outputs.DeadLetterQueue
+	Index            string
+	Bucket           string
+	Prefix           *fmtstr.EventFormatString
+	StorageClass     string
+	Codec            codec.Codec
+	MaxObjectSize    int64
+	PartSize         int
+	FlushInterval    time.Duration
+	CompressionLevel int
+}
+
+type client struct {
+	log              *logp.Logger
+	api              s3API
+	observer         outputs.Observer
+	deadLetter       outputs.DeadLetterQueue
+	index            string
+	bucket           string
+	prefix           *fmtstr.EventFormatString
+	storageClass     types.StorageClass
+	codec            codec.Codec
+	maxObjectSize    int64
+	partSize         int
+	flushInterval    time.Duration
+	compressionLevel int
+
+	mux     sync.Mutex
+	objects map[string]*object
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// object is an object that is still being written. Events are appended to a
+// gzip stream, which is uploaded in parts of at least the configured part size.
+// The object is committed once it reaches the maximum object size or has been
+// open for longer than the flush interval.
+type object struct {
+	prefix   string
+	key      string
+	created  time.Time
+	buf      bytes.Buffer
+	gz       *gzip.Writer
+	uploadID string
+	parts    []types.CompletedPart
+	uploaded int64
+
+	// events written to the object, grouped by the batch they belong to.
+	// Batches are only ACKed after all objects they have contributed to have
+	// been committed.
+	batches []objectBatch
+}
+
+type objectBatch struct {
+	ref    *batchRef
+	events []publisher.Event
+}
+
+type batchRef struct {
+	client  *client
+	count   int32
+	total   int
+	dropped int32
+	batch   publisher.Batch
+
+	mux    sync.Mutex
+	failed []publisher.Event
+	err    error
+}
+
+const contentType = "application/x-ndjson"
+
+func newS3Client(s clientSettings) *client {
+	return &client{
+		log:              logp.NewLogger(logSelector),
+		api:              s.API,
+		observer:         s.Observer,
+		deadLetter:       s.DeadLetter,
+		index:            strings.ToLower(s.Index),
+		bucket:           s.Bucket,
+		prefix:           s.Prefix,
+		storageClass:     types.StorageClass(s.StorageClass),
+		codec:            s.Codec,
+		maxObjectSize:    s.MaxObjectSize,
+		partSize:         s.PartSize,
+		flushInterval:    s.FlushInterval,
+		compressionLevel: s.CompressionLevel,
+		objects:          map[string]*object{},
+	}
+}
+
+// Connect starts the background worker committing objects once the flush
+// interval has passed. Connect is called again by the pipeline after a failed
+// publish, in which case the running worker is kept.
+func (c *client) Connect() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.done != nil {
+		return nil
+	}
+
+	c.done = make(chan struct{})
+	c.wg.Add(1)
+	go c.run(c.done)
+	return nil
+}
+
+// Close stops the background worker and commits all open objects.
+func (c *client) Close() error {
+	c.mux.Lock()
+	done := c.done
+	c.done = nil
+	c.mux.Unlock()
+
+	if done != nil {
+		close(done)
+		c.wg.Wait()
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	var firstErr error
+	for _, obj := range c.objects {
+		if err := c.commit(context.Background(), obj); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.log.Debug("closed s3 client")
+	return firstErr
+}
+
+func (c *client) run(done <-chan struct{}) {
+	defer c.wg.Done()
+
+	interval := c.flushInterval
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.commitExpired(time.Now())
+		}
+	}
+}
+
+// commitExpired commits all objects that have been open for longer than the
+// flush interval.
+func (c *client) commitExpired(now time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, obj := range c.objects {
+		if now.Sub(obj.created) >= c.flushInterval {
+			c.commit(context.Background(), obj)
+		}
+	}
+}
+
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	if len(events) == 0 {
+		batch.ACK()
+		return nil
+	}
+
+	// The reference held by Publish is released once all events have been
+	// handed to their objects.
+	ref := &batchRef{
+		client: c,
+		count:  1,
+		total:  len(events),
+		batch:  batch,
+	}
+	defer ref.dec()
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for i := range events {
+		event := &events[i]
+
+		prefix, err := c.prefix.Run(&event.Content)
+		if err != nil {
+			err = fmt.Errorf("failed to format object prefix: %v", err)
+			c.log.Errorf("Dropping event: %+v", err)
+			ref.drop(event, err)
+			continue
+		}
+
+		serializedEvent, err := c.codec.Encode(c.index, &event.Content)
+		if err != nil {
+			if c.log.IsDebug() {
+				c.log.Debugf("failed event: %v", event)
+			}
+			c.log.Errorf("Dropping event: %+v", err)
+			ref.drop(event, err)
+			continue
+		}
+
+		obj, err := c.getObject(prefix)
+		if err != nil {
+			ref.fail(events[i:], err)
+			return err
+		}
+		obj.add(ref, event, serializedEvent)
+
+		if obj.buf.Len() >= c.partSize {
+			if err := c.uploadPart(ctx, obj); err != nil {
+				c.fail(ctx, obj, err)
+				ref.fail(events[i+1:], err)
+				return err
+			}
+		}
+
+		if obj.size() >= c.maxObjectSize {
+			if err := c.commit(ctx, obj); err != nil {
+				ref.fail(events[i+1:], err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *client) String() string {
+	return "s3(" + c.bucket + ")"
+}
+
+// getObject returns the open object for the given prefix, starting a new
+// object if none is open.
+func (c *client) getObject(prefix string) (*object, error) {
+	if obj, ok := c.objects[prefix]; ok {
+		return obj, nil
+	}
+
+	created := time.Now()
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%v-%v-%v.ndjson.gz", c.index, created.UTC().Format("20060102T150405Z"), id)
+	key := name
+	if p := strings.Trim(prefix, "/"); p != "" {
+		key = p + "/" + name
+	}
+
+	obj := &object{prefix: prefix, key: key, created: created}
+	obj.gz, err = gzip.NewWriterLevel(&obj.buf, c.compressionLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	c.objects[prefix] = obj
+	return obj, nil
+}
+
+// uploadPart uploads the buffered data of an object as the next part of its
+// multipart upload. The multipart upload is created on first use.
+func (c *client) uploadPart(ctx context.Context, obj *object) error {
+	if obj.uploadID == "" {
+		out, err := c.api.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:       aws.String(c.bucket),
+			Key:          aws.String(obj.key),
+			ContentType:  aws.String(contentType),
+			StorageClass: c.storageClass,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create multipart upload for %v: %w", obj.key, err)
+		}
+		obj.uploadID = aws.ToString(out.UploadId)
+	}
+
+	partNumber := int32(len(obj.parts) + 1)
+	out, err := c.api.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(c.bucket),
+		Key:           aws.String(obj.key),
+		UploadId:      aws.String(obj.uploadID),
+		PartNumber:    partNumber,
+		Body:          bytes.NewReader(obj.buf.Bytes()),
+		ContentLength: int64(obj.buf.Len()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload part %v of %v: %w", partNumber, obj.key, err)
+	}
+
+	obj.parts = append(obj.parts, types.CompletedPart{
+		ETag:       out.ETag,
+		PartNumber: partNumber,
+	})
+	obj.uploaded += int64(obj.buf.Len())
+	obj.buf.Reset()
+	return nil
+}
+
+// commit finishes the object and ACKs all events written to it. Objects that
+// fit into a single part are uploaded with one request, larger objects finish
+// their multipart upload.
+func (c *client) commit(ctx context.Context, obj *object) error {
+	delete(c.objects, obj.prefix)
+
+	if err := obj.gz.Close(); err != nil {
+		c.fail(ctx, obj, err)
+		return err
+	}
+
+	if obj.uploadID == "" {
+		_, err := c.api.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(c.bucket),
+			Key:           aws.String(obj.key),
+			Body:          bytes.NewReader(obj.buf.Bytes()),
+			ContentLength: int64(obj.buf.Len()),
+			ContentType:   aws.String(contentType),
+			StorageClass:  c.storageClass,
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to upload %v: %w", obj.key, err)
+			c.fail(ctx, obj, err)
+			return err
+		}
+	} else {
+		if err := c.uploadPart(ctx, obj); err != nil {
+			c.fail(ctx, obj, err)
+			return err
+		}
+
+		_, err := c.api.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(c.bucket),
+			Key:             aws.String(obj.key),
+			UploadId:        aws.String(obj.uploadID),
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: obj.parts},
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to complete multipart upload of %v: %w", obj.key, err)
+			c.fail(ctx, obj, err)
+			return err
+		}
+	}
+
+	c.log.Debugf("committed object %v (%v events, %v bytes)", obj.key, obj.count(), obj.size())
+	for _, b := range obj.batches {
+		b.ref.dec()
+	}
+	return nil
+}
+
+// fail aborts the upload of an object and returns all events written to it to
+// the pipeline for retrying.
+func (c *client) fail(ctx context.Context, obj *object, err error) {
+	delete(c.objects, obj.prefix)
+	c.log.Errorf("S3 (bucket=%v): %+v", c.bucket, err)
+
+	if obj.uploadID != "" {
+		_, abortErr := c.api.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(c.bucket),
+			Key:      aws.String(obj.key),
+			UploadId: aws.String(obj.uploadID),
+		})
+		if abortErr != nil {
+			c.log.Errorf("Failed to abort multipart upload of %v: %+v", obj.key, abortErr)
+		}
+	}
+
+	for _, b := range obj.batches {
+		b.ref.fail(b.events, err)
+		b.ref.dec()
+	}
+}
+
+// add appends an event to the object, taking a reference on the batch the
+// first time the batch writes to the object.
+func (o *object) add(ref *batchRef, event *publisher.Event, serializedEvent []byte) {
+	o.gz.Write(append(serializedEvent, '\n'))
+
+	if n := len(o.batches); n == 0 || o.batches[n-1].ref != ref {
+		atomic.AddInt32(&ref.count, 1)
+		o.batches = append(o.batches, objectBatch{ref: ref})
+	}
+	b := &o.batches[len(o.batches)-1]
+	b.events = append(b.events, *event)
+}
+
+// size returns the compressed size of the object written so far.
+func (o *object) size() int64 {
+	return o.uploaded + int64(o.buf.Len())
+}
+
+func (o *object) count() int {
+	n := 0
+	for _, b := range o.batches {
+		n += len(b.events)
+	}
+	return n
+}
+
+// fail marks events as failed. Failed events are retried once all objects the
+// batch contributed to have been committed or failed.
+func (r *batchRef) fail(events []publisher.Event, err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.failed = append(r.failed, events...)
+	if r.err == nil {
+		// report the first error seen in the batch
+		r.err = err
+	}
+}
+
+// drop hands an event that can not be published to the dead letter queue.
+func (r *batchRef) drop(event *publisher.Event, err error) {
+	atomic.AddInt32(&r.dropped, 1)
+	r.client.deadLetter.Add(&event.Content, err)
+}
+
+func (r *batchRef) dec() {
+	i := atomic.AddInt32(&r.count, -1)
+	if i > 0 {
+		return
+	}
+
+	r.client.log.Debug("finished s3 batch")
+	stats := r.client.observer
+
+	dropped := int(atomic.LoadInt32(&r.dropped))
+	r.mux.Lock()
+	failed, err := r.failed, r.err
+	r.mux.Unlock()
+
+	if err != nil {
+		success := r.total - len(failed) - dropped
+		r.batch.RetryEvents(failed)
+
+		stats.Failed(len(failed))
+		if success > 0 {
+			stats.Acked(success)
+		}
+
+		r.client.log.Debugf("S3 publish failed with: %+v", err)
+	} else {
+		r.batch.ACK()
+		if success := r.total - dropped; success > 0 {
+			stats.Acked(success)
+		}
+	}
+}
+
+func (c *client) Test(d testing.Driver) {
+	d.Run("S3: "+c.bucket, func(d testing.Driver) {
+		_, err := c.api.HeadBucket(context.Background(), &s3.HeadBucketInput{
+			Bucket: aws.String(c.bucket),
+		})
+		d.Error("access bucket", err)
+	})
+}
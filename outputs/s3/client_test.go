@@ -0,0 +1,382 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec/json"
+	"github.com/njcx/libbeat_v7/outputs/outest"
+)
+
+const testBucket = "archive"
+
+// fakeS3 is a minimal S3-compatible server supporting the requests used by
+// the output.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	aborted  int
+	multi    int
+	failPut  bool
+	failPart bool
+	nextID   int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: map[string][]byte{},
+		uploads: map[string]map[int][]byte{},
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/"+testBucket+"/")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%v</Bucket><Key>%v</Key><UploadId>%v</UploadId></InitiateMultipartUploadResult>",
+			testBucket, key, id)
+
+	case r.Method == http.MethodPut && uploadID != "":
+		if f.failPart {
+			writeError(w)
+			return
+		}
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[uploadID][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%v"`, n))
+
+	case r.Method == http.MethodPost && uploadID != "":
+		parts := f.uploads[uploadID]
+		numbers := make([]int, 0, len(parts))
+		for n := range parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+
+		var object []byte
+		for _, n := range numbers {
+			object = append(object, parts[n]...)
+		}
+		f.objects[key] = object
+		f.multi++
+		delete(f.uploads, uploadID)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%v</Bucket><Key>%v</Key></CompleteMultipartUploadResult>",
+			testBucket, key)
+
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		if f.failPut {
+			writeError(w)
+			return
+		}
+		f.objects[key] = body
+		w.Header().Set("ETag", `"object"`)
+
+	default:
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+	}
+}
+
+func writeError(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusForbidden)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: "AccessDenied", Message: "Access Denied"})
+}
+
+// lines returns the decompressed lines of all objects by key.
+func (f *fakeS3) lines(t *testing.T) map[string][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects := map[string][]string{}
+	for key, data := range f.objects {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		var lines []string
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		require.NoError(t, scanner.Err())
+		objects[key] = lines
+	}
+	return objects
+}
+
+func newTestClient(t *testing.T, fake *fakeS3, settings clientSettings) (*client, *outest.DeadLetter) {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	config, err := readConfig(common.MustNewConfigFrom(common.MapStr{
+		"bucket":            testBucket,
+		"endpoint":          srv.URL,
+		"force_path_style":  true,
+		"access_key_id":     "key",
+		"secret_access_key": "secret",
+	}))
+	require.NoError(t, err)
+
+	api, err := newS3API(beat.Info{Beat: "test"}, outputs.NewNilObserver(), config)
+	require.NoError(t, err)
+
+	deadLetter := &outest.DeadLetter{}
+	settings.API = api
+	settings.Observer = outputs.NewNilObserver()
+	settings.DeadLetter = deadLetter
+	settings.Index = "test"
+	settings.Bucket = testBucket
+	settings.Codec = json.New("1.2.3", json.Config{})
+	settings.CompressionLevel = 5
+	if settings.Prefix == nil {
+		settings.Prefix = fmtstr.MustCompileEvent("test/%{+yyyy}/%{+MM}/%{+dd}")
+	}
+	if settings.PartSize == 0 {
+		settings.PartSize = minPartSize
+	}
+	if settings.MaxObjectSize == 0 {
+		settings.MaxObjectSize = 1024 * 1024 * 1024
+	}
+	if settings.FlushInterval == 0 {
+		settings.FlushInterval = time.Hour
+	}
+
+	c := newS3Client(settings)
+	require.NoError(t, c.Connect())
+	return c, deadLetter
+}
+
+func newSignaledBatch(events ...beat.Event) (*outest.Batch, chan outest.BatchSignal) {
+	batch := outest.NewBatch(events...)
+	signals := make(chan outest.BatchSignal, 1)
+	batch.OnSignal = func(sig outest.BatchSignal) { signals <- sig }
+	return batch, signals
+}
+
+func waitSignal(t *testing.T, signals chan outest.BatchSignal) outest.BatchSignal {
+	select {
+	case sig := <-signals:
+		return sig
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for batch to be finished")
+		return outest.BatchSignal{}
+	}
+}
+
+func testEvent(ts time.Time, msg string) beat.Event {
+	return beat.Event{
+		Timestamp: ts,
+		Fields:    common.MapStr{"message": msg},
+	}
+}
+
+func TestPublishCommitsAfterFlushInterval(t *testing.T) {
+	fake := newFakeS3()
+	c, _ := newTestClient(t, fake, clientSettings{FlushInterval: 200 * time.Millisecond})
+	defer c.Close()
+
+	day1 := time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	batch, signals := newSignaledBatch(
+		testEvent(day1, "first"),
+		testEvent(day2, "second"),
+		testEvent(day1, "third"),
+	)
+
+	require.NoError(t, c.Publish(context.Background(), batch))
+	select {
+	case <-signals:
+		t.Fatal("batch must not be ACKed before the objects are committed")
+	default:
+	}
+
+	sig := waitSignal(t, signals)
+	assert.Equal(t, outest.BatchACK, sig.Tag)
+
+	objects := fake.lines(t)
+	require.Len(t, objects, 2)
+	for key, lines := range objects {
+		switch {
+		case strings.HasPrefix(key, "test/2022/03/04/test-"):
+			require.Len(t, lines, 2)
+			assert.Contains(t, lines[0], `"message":"first"`)
+			assert.Contains(t, lines[1], `"message":"third"`)
+		case strings.HasPrefix(key, "test/2022/03/05/test-"):
+			require.Len(t, lines, 1)
+			assert.Contains(t, lines[0], `"message":"second"`)
+		default:
+			t.Fatalf("unexpected object key %v", key)
+		}
+		assert.True(t, strings.HasSuffix(key, ".ndjson.gz"), key)
+	}
+	assert.Equal(t, 0, fake.multi)
+}
+
+func TestPublishMultipartRollover(t *testing.T) {
+	fake := newFakeS3()
+	c, _ := newTestClient(t, fake, clientSettings{
+		PartSize:      16 * 1024,
+		MaxObjectSize: 64 * 1024,
+	})
+
+	rnd := rand.New(rand.NewSource(1))
+	ts := time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	var batches []chan outest.BatchSignal
+	for i := 0; i < 10; i++ {
+		events := make([]beat.Event, 20)
+		for j := range events {
+			msg := make([]byte, 1024)
+			rnd.Read(msg)
+			events[j] = testEvent(ts, base64.StdEncoding.EncodeToString(msg))
+		}
+
+		batch, signals := newSignaledBatch(events...)
+		require.NoError(t, c.Publish(context.Background(), batch))
+		batches = append(batches, signals)
+	}
+	require.NoError(t, c.Close())
+
+	for _, signals := range batches {
+		assert.Equal(t, outest.BatchACK, waitSignal(t, signals).Tag)
+	}
+
+	total := 0
+	for _, lines := range fake.lines(t) {
+		total += len(lines)
+	}
+	assert.Equal(t, 200, total)
+	assert.Greater(t, len(fake.objects), 1)
+	assert.Greater(t, fake.multi, 0)
+	assert.Empty(t, fake.uploads)
+}
+
+func TestPublishFailureRetriesEvents(t *testing.T) {
+	ts := time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	t.Run("put object", func(t *testing.T) {
+		fake := newFakeS3()
+		fake.failPut = true
+		c, _ := newTestClient(t, fake, clientSettings{})
+
+		batch, signals := newSignaledBatch(testEvent(ts, "a"), testEvent(ts, "b"))
+		require.NoError(t, c.Publish(context.Background(), batch))
+		assert.Error(t, c.Close())
+
+		sig := waitSignal(t, signals)
+		assert.Equal(t, outest.BatchRetryEvents, sig.Tag)
+		assert.Len(t, sig.Events, 2)
+		assert.Empty(t, fake.objects)
+	})
+
+	t.Run("upload part", func(t *testing.T) {
+		fake := newFakeS3()
+		fake.failPart = true
+		c, _ := newTestClient(t, fake, clientSettings{PartSize: 1})
+		defer c.Close()
+
+		// an event larger than the gzip window forces a part upload
+		rnd := rand.New(rand.NewSource(1))
+		msg := make([]byte, 128*1024)
+		rnd.Read(msg)
+
+		batch, signals := newSignaledBatch(testEvent(ts, "a"), testEvent(ts, string(msg)), testEvent(ts, "c"))
+		assert.Error(t, c.Publish(context.Background(), batch))
+
+		sig := waitSignal(t, signals)
+		assert.Equal(t, outest.BatchRetryEvents, sig.Tag)
+		assert.Len(t, sig.Events, 3)
+		assert.Equal(t, 1, fake.aborted)
+		assert.Empty(t, fake.objects)
+	})
+}
+
+func TestPublishDropsEventsWithoutPrefix(t *testing.T) {
+	fake := newFakeS3()
+	c, deadLetter := newTestClient(t, fake, clientSettings{
+		Prefix: fmtstr.MustCompileEvent("%{[service]}"),
+	})
+
+	ts := time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC)
+	withService := testEvent(ts, "with service")
+	withService.Fields["service"] = "billing"
+
+	batch, signals := newSignaledBatch(withService, testEvent(ts, "without service"))
+	require.NoError(t, c.Publish(context.Background(), batch))
+	require.NoError(t, c.Close())
+
+	assert.Equal(t, outest.BatchACK, waitSignal(t, signals).Tag)
+	assert.Len(t, deadLetter.Events(), 1)
+
+	objects := fake.lines(t)
+	require.Len(t, objects, 1)
+	for key, lines := range objects {
+		assert.True(t, strings.HasPrefix(key, "billing/test-"), key)
+		assert.Len(t, lines, 1)
+	}
+}
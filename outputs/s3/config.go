@@ -0,0 +1,111 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/cfgtype"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+type s3Config struct {
+	Bucket                string                    `config:"bucket"            validate:"required"`
+	Region                string                    `config:"region"`
+	Endpoint              string                    `config:"endpoint"`
+	ForcePathStyle        bool                      `config:"force_path_style"`
+	AccessKeyID           string                    `config:"access_key_id"`
+	SecretAccessKey       string                    `config:"secret_access_key"`
+	SessionToken          string                    `config:"session_token"`
+	CredentialProfileName string                    `config:"credential_profile_name"`
+	Prefix                *fmtstr.EventFormatString `config:"prefix"`
+	StorageClass          string                    `config:"storage_class"`
+	MaxObjectSize         cfgtype.ByteSize          `config:"max_object_size"`
+	PartSize              cfgtype.ByteSize          `config:"part_size"`
+	FlushInterval         time.Duration             `config:"flush_interval"    validate:"nonzero,positive"`
+	CompressionLevel      int                       `config:"compression_level" validate:"min=1, max=9"`
+	BulkMaxSize           int                       `config:"bulk_max_size"`
+	MaxRetries            int                       `config:"max_retries"       validate:"min=-1"`
+	Backoff               backoff                   `config:"backoff"`
+	Codec                 codec.Config              `config:"codec"`
+
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+type backoff struct {
+	Init time.Duration
+	Max  time.Duration
+}
+
+const (
+	// minPartSize is the smallest part size accepted by S3 for all but the
+	// last part of a multipart upload.
+	minPartSize = 5 * 1024 * 1024
+
+	// maxParts is the maximum number of parts of a multipart upload.
+	maxParts = 10000
+
+	defaultRegion = "us-east-1"
+)
+
+func defaultConfig() s3Config {
+	return s3Config{
+		MaxObjectSize:    128 * 1024 * 1024,
+		PartSize:         minPartSize,
+		FlushInterval:    5 * time.Minute,
+		CompressionLevel: 5,
+		BulkMaxSize:      2048,
+		MaxRetries:       3,
+		Backoff: backoff{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+		Transport: httpcommon.DefaultHTTPTransportSettings(),
+	}
+}
+
+func readConfig(cfg *common.Config) (*s3Config, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *s3Config) Validate() error {
+	if (c.AccessKeyID == "") != (c.SecretAccessKey == "") {
+		return errors.New("access_key_id and secret_access_key must be configured together")
+	}
+
+	if c.PartSize < minPartSize {
+		return fmt.Errorf("part_size must be at least %v bytes", minPartSize)
+	}
+	if c.MaxObjectSize < c.PartSize {
+		return errors.New("max_object_size must not be smaller than part_size")
+	}
+	if int64(c.MaxObjectSize)/int64(c.PartSize) >= maxParts {
+		return fmt.Errorf("max_object_size must not exceed %v parts of part_size", maxParts)
+	}
+
+	return nil
+}
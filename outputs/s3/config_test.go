@@ -0,0 +1,82 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package s3
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/njcx/libbeat_v7/common"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		cfg   common.MapStr
+		valid bool
+	}{
+		"default config": {
+			cfg:   common.MapStr{"bucket": "archive"},
+			valid: true,
+		},
+		"missing bucket": {
+			cfg:   common.MapStr{},
+			valid: false,
+		},
+		"static credentials": {
+			cfg:   common.MapStr{"bucket": "archive", "access_key_id": "key", "secret_access_key": "secret"},
+			valid: true,
+		},
+		"access key without secret": {
+			cfg:   common.MapStr{"bucket": "archive", "access_key_id": "key"},
+			valid: false,
+		},
+		"part size below S3 minimum": {
+			cfg:   common.MapStr{"bucket": "archive", "part_size": "1MiB"},
+			valid: false,
+		},
+		"object size below part size": {
+			cfg:   common.MapStr{"bucket": "archive", "part_size": "16MiB", "max_object_size": "8MiB"},
+			valid: false,
+		},
+		"too many parts": {
+			cfg:   common.MapStr{"bucket": "archive", "part_size": "5MiB", "max_object_size": "100GiB"},
+			valid: false,
+		},
+		"zero flush interval": {
+			cfg:   common.MapStr{"bucket": "archive", "flush_interval": "0s"},
+			valid: false,
+		},
+		"invalid compression level": {
+			cfg:   common.MapStr{"bucket": "archive", "compression_level": 0},
+			valid: false,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			_, err := readConfig(common.MustNewConfigFrom(test.cfg))
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
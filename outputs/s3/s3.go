@@ -0,0 +1,143 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package s3
+
+import (
+	"context"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/common/transport/httpcommon"
+	"github.com/njcx/libbeat_v7/common/useragent"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+const logSelector = "s3"
+
+func init() {
+	outputs.RegisterType("s3", makeS3)
+}
+
+func makeS3(
+	_ outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *common.Config,
+) (outputs.Group, error) {
+	config, err := readConfig(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	deadLetter, err := outputs.LoadDeadLetterQueue(beat, "s3", observer, cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	enc, err := codec.CreateEncoder(beat, config.Codec)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	prefix := config.Prefix
+	if prefix == nil {
+		prefix, err = fmtstr.CompileEvent(beat.Beat + "/%{+yyyy}/%{+MM}/%{+dd}")
+		if err != nil {
+			return outputs.Fail(err)
+		}
+	}
+
+	api, err := newS3API(beat, observer, config)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	client := newS3Client(clientSettings{
+		API:              api,
+		Observer:         observer,
+		DeadLetter:       deadLetter,
+		Index:            beat.IndexPrefix,
+		Bucket:           config.Bucket,
+		Prefix:           prefix,
+		StorageClass:     config.StorageClass,
+		Codec:            enc,
+		MaxObjectSize:    int64(config.MaxObjectSize),
+		PartSize:         int(config.PartSize),
+		FlushInterval:    config.FlushInterval,
+		CompressionLevel: config.CompressionLevel,
+	})
+
+	return outputs.Success(config.BulkMaxSize, config.MaxRetries,
+		outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max))
+}
+
+// newS3API creates the S3 API client. Credentials configured in the output
+// take precedence over the default AWS credential chain.
+func newS3API(beat beat.Info, observer outputs.Observer, config *s3Config) (*s3.Client, error) {
+	httpClient, err := config.Transport.Client(
+		httpcommon.WithLogger(logp.NewLogger(logSelector)),
+		httpcommon.WithIOStats(observer),
+		httpcommon.WithKeepaliveSettings{IdleConnTimeout: 1 * time.Minute},
+		httpcommon.WithHeaderRoundTripper(map[string]string{
+			"User-Agent": useragent.UserAgent(beat.Beat, true),
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if config.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(config.Region))
+	}
+	if config.CredentialProfileName != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(config.CredentialProfileName))
+	}
+	if config.AccessKeyID != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(config.AccessKeyID, config.SecretAccessKey, config.SessionToken)))
+	}
+
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	// TLS and proxy settings are taken from the output configuration.
+	awsConfig.HTTPClient = httpClient
+	if awsConfig.Region == "" {
+		awsConfig.Region = defaultRegion
+	}
+
+	return s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.UsePathStyle = config.ForcePathStyle
+		if config.Endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(config.Endpoint)
+		}
+	}), nil
+}
+
+// ensure the SDK client implements the API used by the output.
+var _ s3API = (*s3.Client)(nil)
@@ -0,0 +1,173 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+type clientSettings struct {
+	Observer   outputs.Observer
+	DeadLetter outputs.DeadLetterQueue
+	Index      string
+	Codec      codec.Codec
+	Formatter  *formatter
+	Framing    string
+	Datagram   bool
+	Timeout    time.Duration
+}
+
+type client struct {
+	*transport.Client
+	log        *logp.Logger
+	observer   outputs.Observer
+	deadLetter outputs.DeadLetterQueue
+	index      string
+	codec      codec.Codec
+	formatter  *formatter
+	framing    string
+	datagram   bool
+	timeout    time.Duration
+
+	buf bytes.Buffer
+	msg bytes.Buffer
+}
+
+func newClient(conn *transport.Client, s clientSettings) *client {
+	return &client{
+		Client:     conn,
+		log:        logp.NewLogger(logSelector),
+		observer:   s.Observer,
+		deadLetter: s.DeadLetter,
+		index:      strings.ToLower(s.Index),
+		codec:      s.Codec,
+		formatter:  s.Formatter,
+		framing:    s.Framing,
+		datagram:   s.Datagram,
+		timeout:    s.Timeout,
+	}
+}
+
+func (c *client) Connect() error {
+	c.log.Debugf("connect: %v", c.Client.Host())
+	return c.Client.Connect()
+}
+
+func (c *client) Close() error {
+	c.log.Debug("close connection")
+	return c.Client.Close()
+}
+
+func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	if c.timeout > 0 {
+		c.Client.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+
+	var (
+		err     error
+		dropped int
+		pending []publisher.Event
+	)
+	c.buf.Reset()
+	for i := range events {
+		event := &events[i]
+		if err := c.format(&c.msg, event); err != nil {
+			c.log.Errorf("Dropping event: %+v", err)
+			c.deadLetter.Add(&event.Content, err)
+			dropped++
+			continue
+		}
+
+		if c.datagram {
+			// Each syslog message is sent in its own datagram.
+			if _, err = c.Client.Write(c.msg.Bytes()); err != nil {
+				pending = events[i:]
+				break
+			}
+			continue
+		}
+
+		c.frame(&c.buf, c.msg.Bytes())
+		pending = append(pending, *event)
+	}
+
+	if !c.datagram && c.buf.Len() > 0 {
+		if _, err = c.Client.Write(c.buf.Bytes()); err == nil {
+			pending = nil
+		}
+	}
+
+	if err != nil {
+		c.log.Errorf("Failed to publish events caused by: %+v", err)
+		batch.RetryEvents(pending)
+		c.observer.Failed(len(pending))
+		if acked := len(events) - len(pending) - dropped; acked > 0 {
+			c.observer.Acked(acked)
+		}
+		return err
+	}
+
+	batch.ACK()
+	if acked := len(events) - dropped; acked > 0 {
+		c.observer.Acked(acked)
+	}
+	return nil
+}
+
+// format writes the syslog message for event to buf.
+func (c *client) format(buf *bytes.Buffer, event *publisher.Event) error {
+	serialized, err := c.codec.Encode(c.index, &event.Content)
+	if err != nil {
+		return err
+	}
+
+	buf.Reset()
+	c.formatter.Format(buf, &event.Content, bytes.TrimRight(serialized, "\r\n"))
+	return nil
+}
+
+// frame appends msg to buf using the configured stream framing, as described
+// in RFC 6587.
+func (c *client) frame(buf *bytes.Buffer, msg []byte) {
+	switch c.framing {
+	case framingNewline:
+		buf.Write(bytes.ReplaceAll(msg, []byte{'\n'}, []byte{' '}))
+		buf.WriteByte('\n')
+	default:
+		buf.WriteString(strconv.Itoa(len(msg)))
+		buf.WriteByte(' ')
+		buf.Write(msg)
+	}
+}
+
+func (c *client) String() string {
+	return "syslog(" + c.Client.String() + ")"
+}
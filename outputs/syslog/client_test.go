@@ -0,0 +1,174 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec/format"
+	"github.com/njcx/libbeat_v7/outputs/outest"
+)
+
+func newTestClient(t *testing.T, network, addr string, cfg common.MapStr) *client {
+	cfg = cfg.Clone()
+	cfg["network"] = network
+	config, err := readConfig(common.MustNewConfigFrom(cfg))
+	require.NoError(t, err)
+
+	conn, err := transport.NewClient(transport.Config{Timeout: time.Second}, network, addr, defaultPort)
+	require.NoError(t, err)
+
+	c := newClient(conn, clientSettings{
+		Observer:   outputs.NewNilObserver(),
+		DeadLetter: outputs.NewDropQueue(nil),
+		Codec:      format.New(fmtstr.MustCompileEvent("%{[message]}")),
+		Formatter:  newTestFormatter(t, cfg),
+		Framing:    config.Framing,
+		Datagram:   config.isUDP(),
+		Timeout:    time.Second,
+	})
+	require.NoError(t, c.Connect())
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testEvents(messages ...string) []beat.Event {
+	events := make([]beat.Event, len(messages))
+	for i, msg := range messages {
+		events[i] = beat.Event{
+			Timestamp: testTimestamp,
+			Fields:    common.MapStr{"message": msg},
+		}
+	}
+	return events
+}
+
+func acceptTCP(t *testing.T) (net.Listener, <-chan net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	ch := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			ch <- conn
+		}
+	}()
+	return l, ch
+}
+
+func TestPublishTCPOctetCounting(t *testing.T) {
+	l, accepted := acceptTCP(t)
+	c := newTestClient(t, "tcp", l.Addr().String(), common.MapStr{})
+
+	batch := outest.NewBatch(testEvents("hello", "multi\nline")...)
+	require.NoError(t, c.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	conn := <-accepted
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	for _, want := range []string{"hello", "multi\nline"} {
+		length, err := r.ReadString(' ')
+		require.NoError(t, err)
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		require.NoError(t, err)
+
+		msg := make([]byte, n)
+		_, err = io.ReadFull(r, msg)
+		require.NoError(t, err)
+		assert.Equal(t, "<14>1 2021-03-04T05:06:07.123456Z beathost testbeat - - - "+want, string(msg))
+	}
+}
+
+func TestPublishTCPNewline(t *testing.T) {
+	l, accepted := acceptTCP(t)
+	c := newTestClient(t, "tcp", l.Addr().String(), common.MapStr{
+		"framing": "newline",
+		"format":  "rfc3164",
+	})
+
+	batch := outest.NewBatch(testEvents("hello", "multi\nline")...)
+	require.NoError(t, c.Publish(context.Background(), batch))
+
+	conn := <-accepted
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	for _, want := range []string{"hello", "multi line"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "<14>Mar  4 05:06:07 beathost testbeat: "+want+"\n", line)
+	}
+}
+
+func TestPublishUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	c := newTestClient(t, "udp", pc.LocalAddr().String(), common.MapStr{})
+
+	batch := outest.NewBatch(testEvents("a", "b")...)
+	require.NoError(t, c.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for _, want := range []string{"a", "b"} {
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "<14>1 2021-03-04T05:06:07.123456Z beathost testbeat - - - "+want, string(buf[:n]))
+	}
+}
+
+func TestPublishRetryOnWriteError(t *testing.T) {
+	l, accepted := acceptTCP(t)
+	c := newTestClient(t, "tcp", l.Addr().String(), common.MapStr{})
+	(<-accepted).Close()
+
+	// close the client connection to force the write to fail
+	require.NoError(t, c.Client.Close())
+
+	batch := outest.NewBatch(testEvents("a", "b")...)
+	require.Error(t, c.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+	assert.Len(t, batch.Signals[0].Events, 2)
+}
@@ -0,0 +1,155 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/common/transport/tlscommon"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+type syslogConfig struct {
+	Network        string                    `config:"network"`
+	Format         string                    `config:"format"`
+	Framing        string                    `config:"framing"`
+	Facility       *fmtstr.EventFormatString `config:"facility"`
+	Severity       *fmtstr.EventFormatString `config:"severity"`
+	Hostname       *fmtstr.EventFormatString `config:"hostname"`
+	AppName        *fmtstr.EventFormatString `config:"app_name"`
+	ProcID         *fmtstr.EventFormatString `config:"proc_id"`
+	MsgID          *fmtstr.EventFormatString `config:"msg_id"`
+	StructuredData []structuredDataConfig    `config:"structured_data"`
+	LoadBalance    bool                      `config:"loadbalance"`
+	BulkMaxSize    int                       `config:"bulk_max_size"`
+	Timeout        time.Duration             `config:"timeout"`
+	MaxRetries     int                       `config:"max_retries"       validate:"min=-1"`
+	TLS            *tlscommon.Config         `config:"ssl"`
+	Proxy          transport.ProxyConfig     `config:",inline"`
+	Backoff        backoff                   `config:"backoff"`
+	Codec          codec.Config              `config:"codec"`
+}
+
+// structuredDataConfig configures an SD-ELEMENT of RFC 5424 messages. The
+// parameters of the element are read from the configured event fields.
+type structuredDataConfig struct {
+	ID     string   `config:"id"     validate:"required"`
+	Fields []string `config:"fields" validate:"required"`
+}
+
+type backoff struct {
+	Init time.Duration
+	Max  time.Duration
+}
+
+const (
+	formatRFC5424 = "rfc5424"
+	formatRFC3164 = "rfc3164"
+
+	framingOctetCounting = "octet_counting"
+	framingNewline       = "newline"
+)
+
+func defaultConfig() syslogConfig {
+	return syslogConfig{
+		Network:     "tcp",
+		Format:      formatRFC5424,
+		Framing:     framingOctetCounting,
+		Facility:    fmtstr.MustCompileEvent("user"),
+		Severity:    fmtstr.MustCompileEvent("informational"),
+		LoadBalance: false,
+		BulkMaxSize: 2048,
+		Timeout:     30 * time.Second,
+		MaxRetries:  3,
+		Backoff: backoff{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+	}
+}
+
+func readConfig(cfg *common.Config) (*syslogConfig, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *syslogConfig) Validate() error {
+	switch c.Network {
+	case "tcp", "tcp4", "tcp6":
+	case "udp", "udp4", "udp6":
+		if c.TLS.IsEnabled() {
+			return errors.New("ssl is not supported with the udp network")
+		}
+		if c.Proxy.URL != "" {
+			return errors.New("proxy_url is not supported with the udp network")
+		}
+	default:
+		return fmt.Errorf("unsupported network '%v'", c.Network)
+	}
+
+	switch c.Format {
+	case formatRFC5424, formatRFC3164:
+	default:
+		return fmt.Errorf("unsupported format '%v'", c.Format)
+	}
+
+	switch c.Framing {
+	case framingOctetCounting, framingNewline:
+	default:
+		return fmt.Errorf("unsupported framing '%v'", c.Framing)
+	}
+
+	if len(c.StructuredData) > 0 && c.Format != formatRFC5424 {
+		return errors.New("structured_data requires the rfc5424 format")
+	}
+	for _, sd := range c.StructuredData {
+		if !validSDName(sd.ID) {
+			return fmt.Errorf("invalid structured data id '%v'", sd.ID)
+		}
+	}
+
+	return nil
+}
+
+func (c *syslogConfig) isUDP() bool {
+	return strings.HasPrefix(c.Network, "udp")
+}
+
+// validSDName checks an SD-ID or PARAM-NAME to consist of at most 32
+// printable ASCII characters other than '=', ' ', ']' and '"'.
+func validSDName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		if b <= 32 || b >= 127 || b == '=' || b == ']' || b == '"' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,309 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common/fmtstr"
+	"github.com/njcx/libbeat_v7/logp"
+)
+
+const (
+	defaultFacility = 1 // user
+	defaultSeverity = 6 // informational
+
+	nilValue = "-"
+)
+
+var facilities = map[string]int{
+	"kern":         0,
+	"kernel":       0,
+	"user":         1,
+	"mail":         2,
+	"daemon":       3,
+	"auth":         4,
+	"syslog":       5,
+	"lpr":          6,
+	"news":         7,
+	"uucp":         8,
+	"cron":         9,
+	"authpriv":     10,
+	"ftp":          11,
+	"ntp":          12,
+	"security":     13,
+	"audit":        13,
+	"console":      14,
+	"solaris-cron": 15,
+	"local0":       16,
+	"local1":       17,
+	"local2":       18,
+	"local3":       19,
+	"local4":       20,
+	"local5":       21,
+	"local6":       22,
+	"local7":       23,
+}
+
+var severities = map[string]int{
+	"emerg":         0,
+	"emergency":     0,
+	"panic":         0,
+	"alert":         1,
+	"crit":          2,
+	"critical":      2,
+	"err":           3,
+	"error":         3,
+	"warn":          4,
+	"warning":       4,
+	"notice":        5,
+	"info":          6,
+	"informational": 6,
+	"debug":         7,
+}
+
+// formatter creates syslog messages from events. The MSG part of the syslog
+// message is passed in already encoded by the outputs codec.
+type formatter struct {
+	log            *logp.Logger
+	rfc3164        bool
+	facility       *fmtstr.EventFormatString
+	severity       *fmtstr.EventFormatString
+	hostname       *fmtstr.EventFormatString
+	appName        *fmtstr.EventFormatString
+	procID         *fmtstr.EventFormatString
+	msgID          *fmtstr.EventFormatString
+	structuredData []structuredDataConfig
+
+	defaultHostname string
+	defaultAppName  string
+}
+
+func newFormatter(log *logp.Logger, beat beat.Info, config *syslogConfig) *formatter {
+	return &formatter{
+		log:             log,
+		rfc3164:         config.Format == formatRFC3164,
+		facility:        config.Facility,
+		severity:        config.Severity,
+		hostname:        config.Hostname,
+		appName:         config.AppName,
+		procID:          config.ProcID,
+		msgID:           config.MsgID,
+		structuredData:  config.StructuredData,
+		defaultHostname: beat.Hostname,
+		defaultAppName:  beat.Beat,
+	}
+}
+
+// Format appends the syslog message for event to buf.
+func (f *formatter) Format(buf *bytes.Buffer, event *beat.Event, msg []byte) {
+	pri := f.priority(event)
+	if f.rfc3164 {
+		f.formatRFC3164(buf, pri, event, msg)
+	} else {
+		f.formatRFC5424(buf, pri, event, msg)
+	}
+}
+
+// formatRFC5424 writes the message as
+// `<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG`.
+func (f *formatter) formatRFC5424(buf *bytes.Buffer, pri int, event *beat.Event, msg []byte) {
+	fmt.Fprintf(buf, "<%d>1 ", pri)
+	if event.Timestamp.IsZero() {
+		buf.WriteString(nilValue)
+	} else {
+		buf.WriteString(event.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(header(f.run(f.hostname, event, f.defaultHostname), 255))
+	buf.WriteByte(' ')
+	buf.WriteString(header(f.run(f.appName, event, f.defaultAppName), 48))
+	buf.WriteByte(' ')
+	buf.WriteString(header(f.run(f.procID, event, ""), 128))
+	buf.WriteByte(' ')
+	buf.WriteString(header(f.run(f.msgID, event, ""), 32))
+	buf.WriteByte(' ')
+	f.writeStructuredData(buf, event)
+	if len(msg) > 0 {
+		buf.WriteByte(' ')
+		buf.Write(msg)
+	}
+}
+
+// formatRFC3164 writes the message as `<PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG`.
+func (f *formatter) formatRFC3164(buf *bytes.Buffer, pri int, event *beat.Event, msg []byte) {
+	ts := event.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	fmt.Fprintf(buf, "<%d>", pri)
+	buf.WriteString(ts.Format(time.Stamp))
+	buf.WriteByte(' ')
+	buf.WriteString(header(f.run(f.hostname, event, f.defaultHostname), 255))
+	buf.WriteByte(' ')
+	buf.WriteString(tag(f.run(f.appName, event, f.defaultAppName)))
+	if pid := f.run(f.procID, event, ""); pid != "" {
+		buf.WriteByte('[')
+		buf.WriteString(header(pid, 128))
+		buf.WriteByte(']')
+	}
+	buf.WriteString(": ")
+	buf.Write(msg)
+}
+
+func (f *formatter) writeStructuredData(buf *bytes.Buffer, event *beat.Event) {
+	written := false
+	for _, sd := range f.structuredData {
+		params := 0
+		for _, field := range sd.Fields {
+			value, err := event.GetValue(field)
+			if err != nil || value == nil {
+				continue
+			}
+
+			if params == 0 {
+				buf.WriteByte('[')
+				buf.WriteString(sd.ID)
+			}
+			params++
+
+			buf.WriteByte(' ')
+			buf.WriteString(paramName(field))
+			buf.WriteString(`="`)
+			writeParamValue(buf, fmt.Sprint(value))
+			buf.WriteByte('"')
+		}
+		if params > 0 {
+			buf.WriteByte(']')
+			written = true
+		}
+	}
+
+	if !written {
+		buf.WriteString(nilValue)
+	}
+}
+
+func (f *formatter) priority(event *beat.Event) int {
+	facility := lookup(facilities, f.run(f.facility, event, ""), 23, defaultFacility)
+	severity := lookup(severities, f.run(f.severity, event, ""), 7, defaultSeverity)
+	return facility*8 + severity
+}
+
+// run evaluates the format string, returning def if the format string is not
+// configured or can not be evaluated for the event.
+func (f *formatter) run(fs *fmtstr.EventFormatString, event *beat.Event, def string) string {
+	if fs == nil {
+		return def
+	}
+
+	s, err := fs.Run(event)
+	if err != nil {
+		if f.log.IsDebug() {
+			f.log.Debugf("Failed to evaluate format string, using default: %v", err)
+		}
+		return def
+	}
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// lookup returns the numeric code for name, which can be either the code
+// itself or one of the known names.
+func lookup(names map[string]int, name string, max, def int) int {
+	if name == "" {
+		return def
+	}
+	if code, ok := names[strings.ToLower(name)]; ok {
+		return code
+	}
+	if code, err := strconv.Atoi(name); err == nil && code >= 0 && code <= max {
+		return code
+	}
+	return def
+}
+
+// header sanitizes a header field to printable US-ASCII without spaces,
+// truncated to max bytes.
+func header(s string, max int) string {
+	if s == "" {
+		return nilValue
+	}
+
+	b := []byte(s)
+	if len(b) > max {
+		b = b[:max]
+	}
+	for i, c := range b {
+		if c <= 32 || c >= 127 {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// tag sanitizes the RFC 3164 TAG, which is limited to 32 alphanumeric
+// characters.
+func tag(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s) && b.Len() < 32; i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' {
+			b.WriteByte(c)
+		}
+	}
+	if b.Len() == 0 {
+		return nilValue
+	}
+	return b.String()
+}
+
+// paramName sanitizes an event field name for use as PARAM-NAME.
+func paramName(s string) string {
+	b := []byte(s)
+	if len(b) > 32 {
+		b = b[:32]
+	}
+	for i, c := range b {
+		if c <= 32 || c >= 127 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// writeParamValue writes a PARAM-VALUE, escaping '"', '\' and ']'.
+func writeParamValue(buf *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', ']':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
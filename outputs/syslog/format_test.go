@@ -0,0 +1,150 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package syslog
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/logp"
+)
+
+var testTimestamp = time.Date(2021, 3, 4, 5, 6, 7, 123456000, time.UTC)
+
+func newTestFormatter(t *testing.T, cfg common.MapStr) *formatter {
+	config, err := readConfig(common.MustNewConfigFrom(cfg))
+	require.NoError(t, err)
+
+	info := beat.Info{Beat: "testbeat", Hostname: "beathost"}
+	return newFormatter(logp.NewLogger(logSelector), info, config)
+}
+
+func formatEvent(f *formatter, event beat.Event, msg string) string {
+	var buf bytes.Buffer
+	f.Format(&buf, &event, []byte(msg))
+	return buf.String()
+}
+
+func TestFormatRFC5424(t *testing.T) {
+	tests := map[string]struct {
+		cfg   common.MapStr
+		event common.MapStr
+		want  string
+	}{
+		"defaults": {
+			cfg:  common.MapStr{},
+			want: "<14>1 2021-03-04T05:06:07.123456Z beathost testbeat - - - msg",
+		},
+		"fields from event": {
+			cfg: common.MapStr{
+				"facility": "%{[log.syslog.facility.name]}",
+				"severity": "%{[log.level]}",
+				"hostname": "%{[host.name]}",
+				"app_name": "%{[service.name]}",
+				"proc_id":  "%{[process.pid]}",
+				"msg_id":   "%{[event.action]}",
+			},
+			event: common.MapStr{
+				"log.syslog.facility.name": "local4",
+				"log.level":                "error",
+				"host.name":                "web 01",
+				"service.name":             "nginx",
+				"process.pid":              42,
+				"event.action":             "access",
+			},
+			want: "<163>1 2021-03-04T05:06:07.123456Z web_01 nginx 42 access - msg",
+		},
+		"numeric facility and severity": {
+			cfg: common.MapStr{
+				"facility": "%{[facility]}",
+				"severity": "%{[severity]}",
+			},
+			event: common.MapStr{"facility": 3, "severity": "2"},
+			want:  "<26>1 2021-03-04T05:06:07.123456Z beathost testbeat - - - msg",
+		},
+		"missing fields use defaults": {
+			cfg: common.MapStr{
+				"facility": "%{[facility]}",
+				"severity": "%{[severity]:warning}",
+				"hostname": "%{[host.name]}",
+			},
+			want: "<12>1 2021-03-04T05:06:07.123456Z beathost testbeat - - - msg",
+		},
+		"unknown severity uses default": {
+			cfg:   common.MapStr{"severity": "%{[severity]}"},
+			event: common.MapStr{"severity": "fatal"},
+			want:  "<14>1 2021-03-04T05:06:07.123456Z beathost testbeat - - - msg",
+		},
+		"structured data": {
+			cfg: common.MapStr{
+				"structured_data": []common.MapStr{
+					{"id": "event@32473", "fields": []string{"event.kind", "user.name"}},
+					{"id": "missing@32473", "fields": []string{"not.there"}},
+				},
+			},
+			event: common.MapStr{
+				"event.kind": "alert",
+				"user.name":  `a "quoted" \ name]`,
+			},
+			want: `<14>1 2021-03-04T05:06:07.123456Z beathost testbeat - - ` +
+				`[event@32473 event.kind="alert" user.name="a \"quoted\" \\ name\]"] msg`,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			f := newTestFormatter(t, test.cfg)
+			fields := common.MapStr{}
+			for k, v := range test.event {
+				fields.Put(k, v)
+			}
+
+			event := beat.Event{Timestamp: testTimestamp, Fields: fields}
+			assert.Equal(t, test.want, formatEvent(f, event, "msg"))
+		})
+	}
+}
+
+func TestFormatRFC3164(t *testing.T) {
+	f := newTestFormatter(t, common.MapStr{
+		"format":   "rfc3164",
+		"facility": "daemon",
+		"severity": "notice",
+		"app_name": "%{[service.name]}",
+		"proc_id":  "%{[process.pid]}",
+	})
+
+	event := beat.Event{
+		Timestamp: testTimestamp,
+		Fields: common.MapStr{
+			"service": common.MapStr{"name": "my app!"},
+			"process": common.MapStr{"pid": 7},
+		},
+	}
+	assert.Equal(t, "<29>Mar  4 05:06:07 beathost myapp[7]: msg", formatEvent(f, event, "msg"))
+
+	event.Fields.Delete("process")
+	assert.Equal(t, "<29>Mar  4 05:06:07 beathost myapp: msg", formatEvent(f, event, "msg"))
+}
@@ -0,0 +1,110 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package syslog
+
+import (
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/transport"
+	"github.com/njcx/libbeat_v7/common/transport/tlscommon"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/outputs/codec"
+)
+
+const (
+	logSelector = "syslog"
+
+	defaultPort    = 514
+	defaultTLSPort = 6514
+)
+
+func init() {
+	outputs.RegisterType("syslog", makeSyslog)
+}
+
+func makeSyslog(
+	_ outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *common.Config,
+) (outputs.Group, error) {
+	log := logp.NewLogger(logSelector)
+
+	config, err := readConfig(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	hosts, err := outputs.ReadHostList(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	tls, err := tlscommon.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	transp := transport.Config{
+		Timeout: config.Timeout,
+		TLS:     tls,
+		Stats:   observer,
+	}
+	port := defaultPort
+	if tls != nil {
+		port = defaultTLSPort
+	}
+	if !config.isUDP() {
+		transp.Proxy = &config.Proxy
+	}
+
+	enc, err := codec.CreateEncoder(beat, config.Codec)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	deadLetter, err := outputs.LoadDeadLetterQueue(beat, "syslog", observer, cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	formatter := newFormatter(log, beat, config)
+
+	clients := make([]outputs.NetworkClient, len(hosts))
+	for i, host := range hosts {
+		conn, err := transport.NewClient(transp, config.Network, host, port)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+
+		client := newClient(conn, clientSettings{
+			Observer:   observer,
+			DeadLetter: deadLetter,
+			Index:      beat.IndexPrefix,
+			Codec:      enc,
+			Formatter:  formatter,
+			Framing:    config.Framing,
+			Datagram:   config.isUDP(),
+			Timeout:    config.Timeout,
+		})
+		clients[i] = outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max)
+	}
+
+	return outputs.SuccessNet(config.LoadBalance, config.BulkMaxSize, config.MaxRetries, clients)
+}
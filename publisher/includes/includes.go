@@ -19,15 +19,28 @@ package includes
 
 import (
 	// import queue types
+	_ "github.com/njcx/libbeat_v7/outputs/codec/avro"
+	_ "github.com/njcx/libbeat_v7/outputs/codec/cbor"
 	_ "github.com/njcx/libbeat_v7/outputs/codec/format"
 	_ "github.com/njcx/libbeat_v7/outputs/codec/json"
+	_ "github.com/njcx/libbeat_v7/outputs/codec/msgpack"
+	_ "github.com/njcx/libbeat_v7/outputs/codec/protobuf"
 	_ "github.com/njcx/libbeat_v7/outputs/console"
 	_ "github.com/njcx/libbeat_v7/outputs/elasticsearch"
+	_ "github.com/njcx/libbeat_v7/outputs/failover"
+	_ "github.com/njcx/libbeat_v7/outputs/fanout"
 	_ "github.com/njcx/libbeat_v7/outputs/fileout"
+	_ "github.com/njcx/libbeat_v7/outputs/http"
 	_ "github.com/njcx/libbeat_v7/outputs/kafka"
 	_ "github.com/njcx/libbeat_v7/outputs/logstash"
+	_ "github.com/njcx/libbeat_v7/outputs/nats"
+	_ "github.com/njcx/libbeat_v7/outputs/otlp"
+	_ "github.com/njcx/libbeat_v7/outputs/pulsar"
 	_ "github.com/njcx/libbeat_v7/outputs/redis"
+	_ "github.com/njcx/libbeat_v7/outputs/s3"
+	_ "github.com/njcx/libbeat_v7/outputs/syslog"
 	_ "github.com/njcx/libbeat_v7/publisher/queue/diskqueue"
+	_ "github.com/njcx/libbeat_v7/publisher/queue/hybridqueue"
 	_ "github.com/njcx/libbeat_v7/publisher/queue/memqueue"
 	_ "github.com/njcx/libbeat_v7/publisher/queue/spool"
 )
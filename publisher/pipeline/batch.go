@@ -37,6 +37,12 @@ type batch struct {
 	events   []publisher.Event
 }
 
+// dropper is optionally implemented by the original batch, to distinguish
+// dropped from ACKed events.
+type dropper interface {
+	Drop()
+}
+
 type batchContext struct {
 	observer outputObserver
 	retryer  *retryer
@@ -81,7 +87,11 @@ func (b *batch) ACK() {
 }
 
 func (b *batch) Drop() {
-	b.original.ACK()
+	if d, ok := b.original.(dropper); ok {
+		d.Drop()
+	} else {
+		b.original.ACK()
+	}
 	releaseBatch(b)
 }
 
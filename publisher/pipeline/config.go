@@ -34,6 +34,16 @@ type Config struct {
 
 	// Event queue
 	Queue common.ConfigNamespace `config:"queue"`
+
+	// Named queues clients can publish to instead of the default queue
+	Queues []InputQueueConfig `config:"queues"`
+}
+
+// InputQueueConfig configures a named queue, which clients select by setting
+// beat.ClientConfig.Queue.
+type InputQueueConfig struct {
+	Name  string                 `config:"name" validate:"required"`
+	Queue common.ConfigNamespace `config:"queue"`
 }
 
 // validateClientConfig checks a ClientConfig can be used with (*Pipeline).ConnectWith.
@@ -41,13 +41,17 @@ type outputController struct {
 
 	retryer  *retryer
 	consumer *eventConsumer
+	waiter   *consumerWaiter
 	out      *outputGroup
 }
 
 // outputGroup configures a group of load balanced outputs with shared work queue.
+// If fanout is set, batches are published to multiple output groups instead.
 type outputGroup struct {
 	workQueue workQueue
 	outputs   []outputWorker
+	dynamic   *dynamicWorkers
+	fanout    *fanoutGroup
 
 	batchSize  int
 	timeToLive int // event lifetime
@@ -77,7 +81,8 @@ func newOutputController(
 
 	ctx := &batchContext{}
 	c.consumer = newEventConsumer(monitors.Logger, queue, ctx)
-	c.retryer = newRetryer(monitors.Logger, observer, c.workQueue, c.consumer)
+	c.waiter = newConsumerWaiter(c.consumer)
+	c.retryer = newRetryer(monitors.Logger, observer, c.workQueue, c.waiter.handle())
 	ctx.observer = observer
 	ctx.retryer = c.retryer
 
@@ -89,53 +94,47 @@ func newOutputController(
 func (c *outputController) Close() error {
 	c.consumer.sigPause()
 	c.consumer.close()
+	if c.out != nil {
+		c.out.stopDynamic()
+	}
 	c.retryer.close()
 	close(c.workQueue)
 
 	if c.out != nil {
-		for _, out := range c.out.outputs {
-			out.Close()
-		}
+		c.out.close()
 	}
 
 	return nil
 }
 
 func (c *outputController) Set(outGrp outputs.Group) {
-	// create new output group with the shared work queue
-	clients := outGrp.Clients
-	worker := make([]outputWorker, len(clients))
-	for i, client := range clients {
-		logger := logp.NewLogger("publisher_pipeline_output")
-		worker[i] = makeClientWorker(c.observer, c.workQueue, client, logger, c.monitors.Tracer)
-	}
-	grp := &outputGroup{
-		workQueue:  c.workQueue,
-		outputs:    worker,
-		timeToLive: outGrp.Retry + 1,
-		batchSize:  outGrp.BatchSize,
+	var grp *outputGroup
+	if outGrp.Fanout != nil {
+		grp = c.makeFanoutGroup(outGrp.Fanout)
+	} else {
+		grp = c.makeOutputGroup(outGrp)
 	}
 
 	// update consumer and retryer
 	c.consumer.sigPause()
 	if c.out != nil {
-		for range c.out.outputs {
+		c.out.stopDynamic()
+		for i := 0; i < c.out.numWorkers(); i++ {
 			c.retryer.sigOutputRemoved()
 		}
 	}
-	for range clients {
+	for i := 0; i < grp.numWorkers(); i++ {
 		c.retryer.sigOutputAdded()
 	}
 	c.consumer.updOutput(grp)
 
 	// close old group, so events are send to new workQueue via retryer
 	if c.out != nil {
-		for _, w := range c.out.outputs {
-			w.Close()
-		}
+		c.out.close()
 	}
 
 	c.out = grp
+	grp.startDynamic()
 
 	// restart consumer (potentially blocked by retryer)
 	c.consumer.sigContinue()
@@ -143,6 +142,82 @@ func (c *outputController) Set(outGrp outputs.Group) {
 	c.observer.updateOutputGroup()
 }
 
+func (c *outputController) makeOutputGroup(outGrp outputs.Group) *outputGroup {
+	// create new output group with the shared work queue
+	clients := outGrp.Clients
+	worker := make([]outputWorker, len(clients))
+	for i, client := range clients {
+		logger := logp.NewLogger("publisher_pipeline_output")
+		worker[i] = makeClientWorker(c.observer, c.workQueue, client, logger, c.monitors.Tracer)
+	}
+	grp := &outputGroup{
+		workQueue:  c.workQueue,
+		outputs:    worker,
+		timeToLive: outGrp.Retry + 1,
+		batchSize:  outGrp.BatchSize,
+	}
+	if outGrp.Dynamic != nil {
+		grp.dynamic = newDynamicWorkers(outGrp.Dynamic, c.retryer, func(client outputs.Client) outputWorker {
+			logger := logp.NewLogger("publisher_pipeline_output")
+			return makeClientWorker(c.observer, c.workQueue, client, logger, c.monitors.Tracer)
+		})
+	}
+	return grp
+}
+
+func (c *outputController) makeFanoutGroup(fanout *outputs.Fanout) *outputGroup {
+	// The fanout group reads batches from the shared work queue and forwards
+	// them to the work queues of the configured output groups.
+	return &outputGroup{
+		workQueue:  c.workQueue,
+		fanout:     newFanoutGroup(c, fanout),
+		timeToLive: fanoutTimeToLive(fanout),
+		batchSize:  fanoutBatchSize(fanout),
+	}
+}
+
+// numWorkers returns the number of workers reading from the shared work queue.
+func (g *outputGroup) numWorkers() int {
+	if g.fanout != nil {
+		return 1
+	}
+	n := len(g.outputs)
+	if g.dynamic != nil {
+		n += g.dynamic.numWorkers()
+	}
+	return n
+}
+
+// startDynamic starts adding and removing the workers of clients discovered
+// at runtime. The workers signal the retryer themselves while running.
+func (g *outputGroup) startDynamic() {
+	if g.dynamic != nil {
+		g.dynamic.start()
+	}
+}
+
+// stopDynamic stops adding and removing workers, such that the number of
+// workers is stable while the group is being replaced.
+func (g *outputGroup) stopDynamic() {
+	if g.dynamic != nil {
+		g.dynamic.stop()
+	}
+}
+
+func (g *outputGroup) close() {
+	if g.fanout != nil {
+		g.fanout.close()
+		return
+	}
+	for _, w := range g.outputs {
+		w.Close()
+	}
+	if g.dynamic != nil {
+		g.dynamic.stop()
+		g.dynamic.close()
+	}
+}
+
 func makeWorkQueue() workQueue {
 	return workQueue(make(chan publisher.Batch, 0))
 }
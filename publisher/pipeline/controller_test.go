@@ -115,3 +115,94 @@ func TestOutputReload(t *testing.T) {
 		})
 	}
 }
+
+func TestOutputDynamicClients(t *testing.T) {
+	queueFactory := func(ackListener queue.ACKListener) (queue.Queue, error) {
+		return memqueue.NewQueue(
+			logp.L(),
+			memqueue.Settings{
+				ACKListener: ackListener,
+				Events:      100,
+			}), nil
+	}
+
+	pipeline, err := New(
+		beat.Info{},
+		Monitors{},
+		queueFactory,
+		outputs.Group{},
+		Settings{},
+	)
+	require.NoError(t, err)
+	defer pipeline.Close()
+
+	dynamic := &mockDynamicClients{}
+	pipeline.output.Set(outputs.Group{Dynamic: dynamic})
+	add, remove := dynamic.callbacks()
+	require.NotNil(t, add, "dynamic clients must be started")
+
+	pipelineClient, err := pipeline.Connect()
+	require.NoError(t, err)
+	defer pipelineClient.Close()
+
+	makeCountingClient := func(count *atomic.Uint) outputs.Client {
+		return newMockClient(func(batch publisher.Batch) error {
+			count.Add(uint(len(batch.Events())))
+			batch.ACK()
+			return nil
+		})
+	}
+
+	var first, second atomic.Uint
+	firstClient := makeCountingClient(&first)
+	add(firstClient)
+	for i := 0; i < 10; i++ {
+		pipelineClient.Publish(beat.Event{})
+	}
+	require.True(t, waitUntilTrue(5*time.Second, func() bool {
+		return first.Load() == 10
+	}))
+
+	remove(firstClient)
+	add(makeCountingClient(&second))
+	for i := 0; i < 10; i++ {
+		pipelineClient.Publish(beat.Event{})
+	}
+	require.True(t, waitUntilTrue(5*time.Second, func() bool {
+		return second.Load() == 10
+	}))
+	require.Equal(t, uint(10), first.Load())
+
+	pipeline.output.Set(outputs.Group{})
+	require.True(t, dynamic.isStopped(), "dynamic clients must be stopped when the output is replaced")
+}
+
+type mockDynamicClients struct {
+	mu          sync.Mutex
+	add, remove func(outputs.Client)
+	stopped     bool
+}
+
+func (m *mockDynamicClients) Start(add, remove func(outputs.Client)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add, m.remove = add, remove
+}
+
+func (m *mockDynamicClients) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+}
+
+func (m *mockDynamicClients) callbacks() (add, remove func(outputs.Client)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.add, m.remove
+}
+
+func (m *mockDynamicClients) isStopped() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stopped
+}
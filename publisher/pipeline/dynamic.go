@@ -0,0 +1,113 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"sync"
+
+	"github.com/njcx/libbeat_v7/outputs"
+)
+
+// dynamicWorkers runs the workers of the clients an output adds and removes
+// at runtime. While running, the retryer is signaled for every worker being
+// added or removed.
+type dynamicWorkers struct {
+	clients    outputs.DynamicClients
+	retryer    *retryer
+	makeWorker func(outputs.Client) outputWorker
+	stopOnce   sync.Once
+
+	mu      sync.Mutex
+	workers map[outputs.Client]outputWorker
+	stopped bool
+}
+
+func newDynamicWorkers(
+	clients outputs.DynamicClients,
+	retryer *retryer,
+	makeWorker func(outputs.Client) outputWorker,
+) *dynamicWorkers {
+	return &dynamicWorkers{
+		clients:    clients,
+		retryer:    retryer,
+		makeWorker: makeWorker,
+		workers:    map[outputs.Client]outputWorker{},
+	}
+}
+
+func (d *dynamicWorkers) start() {
+	d.clients.Start(d.add, d.remove)
+}
+
+// stop stops the output from adding or removing clients. The workers keep
+// running until close is called.
+func (d *dynamicWorkers) stop() {
+	d.stopOnce.Do(func() {
+		d.clients.Stop()
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.stopped = true
+	})
+}
+
+// close stops all workers.
+func (d *dynamicWorkers) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for client, w := range d.workers {
+		w.Close()
+		delete(d.workers, client)
+	}
+}
+
+// numWorkers returns the number of running workers.
+func (d *dynamicWorkers) numWorkers() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.workers)
+}
+
+func (d *dynamicWorkers) add(client outputs.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, exists := d.workers[client]; exists {
+		return
+	}
+	if d.stopped {
+		client.Close()
+		return
+	}
+	d.workers[client] = d.makeWorker(client)
+	d.retryer.sigOutputAdded()
+}
+
+func (d *dynamicWorkers) remove(client outputs.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w, exists := d.workers[client]
+	if !exists || d.stopped {
+		return
+	}
+	w.Close()
+	delete(d.workers, client)
+	d.retryer.sigOutputRemoved()
+}
@@ -0,0 +1,446 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"sync"
+
+	"github.com/njcx/libbeat_v7/conditions"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+// fanoutGroup publishes batches to multiple output groups in parallel. Batches
+// pulled from the queue are split into one or more sub-batches per output
+// group, based on the groups routing condition and batch size. Each output
+// group has its own work queue, retryer and output workers, such that
+// failures in one group do not affect the retry state of the other groups.
+// The original batch is ACKed once the sub-batches have been processed
+// according to the configured ACK mode.
+type fanoutGroup struct {
+	logger  logger
+	in      workQueue
+	ackMode outputs.FanoutACKMode
+	outputs []*fanoutOutput
+
+	// pending tracks batches not yet ACKed, such that they can be returned to
+	// the pipeline if the group is closed.
+	mu      sync.Mutex
+	pending map[*fanoutBatch]struct{}
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type fanoutOutput struct {
+	name      string
+	condition conditions.Condition
+	batchSize int
+	ttl       int
+
+	ctx       *batchContext
+	retryer   *retryer
+	workQueue workQueue
+	workers   []outputWorker
+	dynamic   *dynamicWorkers
+
+	// in receives sub-batches from the router. The forwarder buffers
+	// sub-batches until a worker is ready and pauses the consumer if the
+	// output group is lagging behind.
+	in          chan Batch
+	retryWait   *waitHandle
+	forwardWait *waitHandle
+}
+
+// fanoutBatch tracks the sub-batches created for a batch pulled from the
+// queue.
+type fanoutBatch struct {
+	group *fanoutGroup
+	root  publisher.Batch
+
+	mu      sync.Mutex
+	parts   []int  // number of unfinished sub-batches per output group
+	dropped []bool // output group dropped at least one sub-batch
+	pending int    // number of output groups with unfinished sub-batches
+	acked   bool   // at least one output group published all events
+	done    bool
+}
+
+// fanoutPart is used as queue.Batch by the sub-batches of a fanoutBatch.
+type fanoutPart struct {
+	parent *fanoutBatch
+	output int
+	events []publisher.Event
+}
+
+// consumerWaiter combines the wait signals of multiple components. The
+// consumer is paused as long as at least one component asks it to wait.
+type consumerWaiter struct {
+	mu       sync.Mutex
+	consumer interruptor
+	waiting  int
+}
+
+// waitHandle is the interruptor of a single component sharing a
+// consumerWaiter.
+type waitHandle struct {
+	waiter  *consumerWaiter
+	waiting bool
+}
+
+func newFanoutGroup(c *outputController, fanout *outputs.Fanout) *fanoutGroup {
+	g := &fanoutGroup{
+		logger:  c.monitors.Logger,
+		in:      c.workQueue,
+		ackMode: fanout.ACK,
+		pending: map[*fanoutBatch]struct{}{},
+		done:    make(chan struct{}),
+	}
+
+	for _, routed := range fanout.Outputs {
+		o := &fanoutOutput{
+			name:        routed.Name,
+			condition:   routed.Condition,
+			batchSize:   routed.Group.BatchSize,
+			ttl:         routed.Group.Retry + 1,
+			workQueue:   makeWorkQueue(),
+			in:          make(chan Batch),
+			retryWait:   c.waiter.handle(),
+			forwardWait: c.waiter.handle(),
+		}
+		o.retryer = newRetryer(c.monitors.Logger, c.observer, o.workQueue, o.retryWait)
+		o.ctx = &batchContext{observer: c.observer, retryer: o.retryer}
+
+		for _, client := range routed.Group.Clients {
+			logger := logp.NewLogger("publisher_pipeline_output").With("output", routed.Name)
+			o.workers = append(o.workers,
+				makeClientWorker(c.observer, o.workQueue, client, logger, c.monitors.Tracer))
+			o.retryer.sigOutputAdded()
+		}
+		if routed.Group.Dynamic != nil {
+			name := routed.Name
+			o.dynamic = newDynamicWorkers(routed.Group.Dynamic, o.retryer, func(client outputs.Client) outputWorker {
+				logger := logp.NewLogger("publisher_pipeline_output").With("output", name)
+				return makeClientWorker(c.observer, o.workQueue, client, logger, c.monitors.Tracer)
+			})
+		}
+
+		g.outputs = append(g.outputs, o)
+	}
+
+	g.wg.Add(1 + len(g.outputs))
+	go g.route()
+	for _, o := range g.outputs {
+		go g.forward(o)
+		if o.dynamic != nil {
+			o.dynamic.start()
+		}
+	}
+	return g
+}
+
+// fanoutBatchSize returns the size of the batches to pull from the queue. A
+// size <= 0 pulls all available events.
+func fanoutBatchSize(fanout *outputs.Fanout) int {
+	size := 0
+	for _, routed := range fanout.Outputs {
+		if routed.Group.BatchSize <= 0 {
+			return 0
+		}
+		if routed.Group.BatchSize > size {
+			size = routed.Group.BatchSize
+		}
+	}
+	return size
+}
+
+// fanoutTimeToLive returns the time to live for batches pulled from the
+// queue. The TTL only applies if the batch is forwarded to a single output
+// group after the output has been reloaded.
+func fanoutTimeToLive(fanout *outputs.Fanout) int {
+	ttl := 0
+	for _, routed := range fanout.Outputs {
+		if routed.Group.Retry+1 > ttl {
+			ttl = routed.Group.Retry + 1
+		}
+	}
+	return ttl
+}
+
+// close stops all output groups. Batches not yet ACKed are returned to the
+// pipeline, so they can be published by the next output.
+func (g *fanoutGroup) close() {
+	for _, o := range g.outputs {
+		for _, w := range o.workers {
+			w.Close()
+		}
+		if o.dynamic != nil {
+			o.dynamic.stop()
+			o.dynamic.close()
+		}
+	}
+
+	close(g.done)
+	g.wg.Wait()
+
+	for _, o := range g.outputs {
+		o.retryer.close()
+		o.retryWait.sigUnWait()
+		o.forwardWait.sigUnWait()
+	}
+
+	g.mu.Lock()
+	pending := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+
+	for b := range pending {
+		b.cancel()
+	}
+}
+
+func (g *fanoutGroup) route() {
+	defer g.wg.Done()
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case batch, ok := <-g.in:
+			if !ok {
+				return
+			}
+			if batch != nil {
+				g.dispatch(batch)
+			}
+		}
+	}
+}
+
+func (g *fanoutGroup) dispatch(root publisher.Batch) {
+	events := root.Events()
+	b := &fanoutBatch{
+		group:   g,
+		root:    root,
+		parts:   make([]int, len(g.outputs)),
+		dropped: make([]bool, len(g.outputs)),
+	}
+
+	batches := make([][]Batch, len(g.outputs))
+	for i, o := range g.outputs {
+		selected := o.selectEvents(events)
+		for len(selected) > 0 {
+			n := len(selected)
+			if o.batchSize > 0 && n > o.batchSize {
+				n = o.batchSize
+			}
+
+			part := &fanoutPart{parent: b, output: i, events: selected[:n:n]}
+			batches[i] = append(batches[i], newBatch(o.ctx, part, o.ttl))
+			selected = selected[n:]
+		}
+
+		if len(batches[i]) > 0 {
+			b.parts[i] = len(batches[i])
+			b.pending++
+		}
+	}
+
+	if b.pending == 0 {
+		// no output group selected any of the events
+		root.ACK()
+		return
+	}
+
+	g.mu.Lock()
+	g.pending[b] = struct{}{}
+	g.mu.Unlock()
+
+	for i, o := range g.outputs {
+		for _, batch := range batches[i] {
+			select {
+			case <-g.done:
+				return
+			case o.in <- batch:
+			}
+		}
+	}
+}
+
+func (g *fanoutGroup) forward(o *fanoutOutput) {
+	defer g.wg.Done()
+
+	var (
+		out     workQueue
+		active  Batch
+		buffer  []Batch
+		blocked bool
+	)
+
+	for {
+		select {
+		case <-g.done:
+			return
+
+		case batch := <-o.in:
+			buffer = append(buffer, batch)
+			active = buffer[0]
+			out = o.workQueue
+
+		case out <- active:
+			buffer = buffer[1:]
+			if len(buffer) == 0 {
+				out, active = nil, nil
+			} else {
+				active = buffer[0]
+			}
+		}
+
+		if lagging := blockConsumer(len(o.workers), len(buffer)); lagging != blocked {
+			blocked = lagging
+			if blocked {
+				g.logger.Debugf("fanout: output %v is lagging behind, pausing consumer", o.name)
+				o.forwardWait.sigWait()
+			} else {
+				o.forwardWait.sigUnWait()
+			}
+		}
+	}
+}
+
+func (g *fanoutGroup) remove(b *fanoutBatch) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.pending, b)
+}
+
+// selectEvents returns a copy of all events matching the routing condition.
+// Each sub-batch requires its own copy, as batches modify their events on
+// retry.
+func (o *fanoutOutput) selectEvents(events []publisher.Event) []publisher.Event {
+	selected := make([]publisher.Event, 0, len(events))
+	for i := range events {
+		if o.condition == nil || o.condition.Check(&events[i].Content) {
+			selected = append(selected, events[i])
+		}
+	}
+	return selected
+}
+
+func (b *fanoutBatch) finish(output int, acked bool) {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return
+	}
+
+	if !acked {
+		b.dropped[output] = true
+	}
+	b.parts[output]--
+	if b.parts[output] > 0 {
+		b.mu.Unlock()
+		return
+	}
+
+	b.pending--
+	if !b.dropped[output] {
+		b.acked = true
+	}
+
+	complete := b.pending == 0 ||
+		(b.acked && b.group.ackMode == outputs.FanoutACKAny)
+	if !complete {
+		b.mu.Unlock()
+		return
+	}
+	b.done = true
+	b.mu.Unlock()
+
+	b.group.remove(b)
+	if b.acked {
+		b.root.ACK()
+	} else {
+		b.root.Drop()
+	}
+}
+
+// cancel returns the batch to the pipeline, if it has not been ACKed yet.
+// Signals from sub-batches still in progress are ignored from now on.
+func (b *fanoutBatch) cancel() {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return
+	}
+	b.done = true
+	b.mu.Unlock()
+
+	b.root.Cancelled()
+}
+
+func (p *fanoutPart) Events() []publisher.Event {
+	return p.events
+}
+
+func (p *fanoutPart) ACK() {
+	p.parent.finish(p.output, true)
+}
+
+func (p *fanoutPart) Drop() {
+	p.parent.finish(p.output, false)
+}
+
+func newConsumerWaiter(consumer interruptor) *consumerWaiter {
+	return &consumerWaiter{consumer: consumer}
+}
+
+func (w *consumerWaiter) handle() *waitHandle {
+	return &waitHandle{waiter: w}
+}
+
+func (h *waitHandle) sigWait() {
+	w := h.waiter
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if h.waiting {
+		return
+	}
+	h.waiting = true
+	w.waiting++
+	if w.waiting == 1 {
+		w.consumer.sigWait()
+	}
+}
+
+func (h *waitHandle) sigUnWait() {
+	w := h.waiter
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !h.waiting {
+		return
+	}
+	h.waiting = false
+	w.waiting--
+	if w.waiting == 0 {
+		w.consumer.sigUnWait()
+	}
+}
@@ -0,0 +1,252 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/common/acker"
+	"github.com/njcx/libbeat_v7/common/atomic"
+	"github.com/njcx/libbeat_v7/conditions"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+	"github.com/njcx/libbeat_v7/publisher/queue/memqueue"
+)
+
+type fanoutTestOutput struct {
+	published atomic.Uint
+	publishFn mockPublishFn
+}
+
+func newFanoutTestOutput(fn func(publisher.Batch) bool) *fanoutTestOutput {
+	o := &fanoutTestOutput{}
+	o.publishFn = func(batch publisher.Batch) error {
+		if fn != nil && !fn(batch) {
+			batch.Retry()
+			return nil
+		}
+		o.published.Add(uint(len(batch.Events())))
+		batch.ACK()
+		return nil
+	}
+	return o
+}
+
+func (o *fanoutTestOutput) group(name string, condition conditions.Condition) outputs.RoutedGroup {
+	return outputs.RoutedGroup{
+		Name:      name,
+		Condition: condition,
+		Group: outputs.Group{
+			Clients:   []outputs.Client{newMockNetworkClient(o.publishFn)},
+			BatchSize: 10,
+			Retry:     -1,
+		},
+	}
+}
+
+func runFanoutPipeline(
+	t *testing.T,
+	fanout *outputs.Fanout,
+	events []beat.Event,
+) (p *Pipeline, acked *atomic.Uint, cleanup func()) {
+	queueFactory := func(ackListener queue.ACKListener) (queue.Queue, error) {
+		return memqueue.NewQueue(
+			logp.L(),
+			memqueue.Settings{
+				ACKListener: ackListener,
+				Events:      len(events),
+			}), nil
+	}
+
+	pipeline, err := New(beat.Info{}, Monitors{}, queueFactory, outputs.Group{Fanout: fanout}, Settings{})
+	require.NoError(t, err)
+
+	acked = &atomic.Uint{}
+	client, err := pipeline.ConnectWith(beat.ClientConfig{
+		ACKHandler: acker.RawCounting(func(n int) { acked.Add(uint(n)) }),
+	})
+	require.NoError(t, err)
+
+	for _, event := range events {
+		client.Publish(event)
+	}
+
+	return pipeline, acked, func() {
+		client.Close()
+		pipeline.Close()
+	}
+}
+
+func makeFanoutTestEvents(n int) []beat.Event {
+	events := make([]beat.Event, n)
+	for i := range events {
+		kind := "event"
+		if i%2 == 0 {
+			kind = "alert"
+		}
+		events[i] = beat.Event{
+			Timestamp: time.Now(),
+			Fields:    common.MapStr{"kind": kind},
+		}
+	}
+	return events
+}
+
+func TestFanoutPublishesToAllOutputs(t *testing.T) {
+	a := newFanoutTestOutput(nil)
+	b := newFanoutTestOutput(nil)
+
+	_, acked, cleanup := runFanoutPipeline(t, &outputs.Fanout{
+		Outputs: []outputs.RoutedGroup{a.group("a", nil), b.group("b", nil)},
+	}, makeFanoutTestEvents(100))
+	defer cleanup()
+
+	require.True(t, waitUntilTrue(5*time.Second, func() bool {
+		return acked.Load() == 100
+	}))
+	assert.Equal(t, uint(100), a.published.Load())
+	assert.Equal(t, uint(100), b.published.Load())
+}
+
+func TestFanoutRouting(t *testing.T) {
+	var config conditions.Config
+	require.NoError(t, common.MustNewConfigFrom(map[string]interface{}{
+		"equals.kind": "alert",
+	}).Unpack(&config))
+	condition, err := conditions.NewCondition(&config)
+	require.NoError(t, err)
+
+	all := newFanoutTestOutput(nil)
+	alerts := newFanoutTestOutput(nil)
+
+	_, acked, cleanup := runFanoutPipeline(t, &outputs.Fanout{
+		Outputs: []outputs.RoutedGroup{all.group("all", nil), alerts.group("alerts", condition)},
+	}, makeFanoutTestEvents(100))
+	defer cleanup()
+
+	require.True(t, waitUntilTrue(5*time.Second, func() bool {
+		return acked.Load() == 100
+	}))
+	assert.Equal(t, uint(100), all.published.Load())
+	assert.Equal(t, uint(50), alerts.published.Load())
+}
+
+func TestFanoutIndependentRetries(t *testing.T) {
+	var mu sync.Mutex
+	failures := 5
+	flaky := newFanoutTestOutput(func(publisher.Batch) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			return false
+		}
+		return true
+	})
+	stable := newFanoutTestOutput(nil)
+
+	_, acked, cleanup := runFanoutPipeline(t, &outputs.Fanout{
+		Outputs: []outputs.RoutedGroup{flaky.group("flaky", nil), stable.group("stable", nil)},
+	}, makeFanoutTestEvents(100))
+	defer cleanup()
+
+	require.True(t, waitUntilTrue(5*time.Second, func() bool {
+		return acked.Load() == 100
+	}))
+	assert.Equal(t, uint(100), flaky.published.Load())
+	assert.Equal(t, uint(100), stable.published.Load())
+}
+
+func TestFanoutACKMode(t *testing.T) {
+	cases := map[string]struct {
+		mode          outputs.FanoutACKMode
+		ackedBlocking bool
+	}{
+		"all": {mode: outputs.FanoutACKAll, ackedBlocking: false},
+		"any": {mode: outputs.FanoutACKAny, ackedBlocking: true},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			release := make(chan struct{})
+			slow := newFanoutTestOutput(func(publisher.Batch) bool {
+				<-release
+				return true
+			})
+			fast := newFanoutTestOutput(nil)
+
+			_, acked, cleanup := runFanoutPipeline(t, &outputs.Fanout{
+				ACK:     test.mode,
+				Outputs: []outputs.RoutedGroup{slow.group("slow", nil), fast.group("fast", nil)},
+			}, makeFanoutTestEvents(10))
+			defer cleanup()
+
+			require.True(t, waitUntilTrue(5*time.Second, func() bool {
+				return fast.published.Load() == 10
+			}))
+
+			// give the pipeline some time to ACK events
+			time.Sleep(50 * time.Millisecond)
+			assert.Equal(t, test.ackedBlocking, acked.Load() == 10)
+
+			close(release)
+			require.True(t, waitUntilTrue(5*time.Second, func() bool {
+				return acked.Load() == 10 && slow.published.Load() == 10
+			}))
+		})
+	}
+}
+
+func TestFanoutCancelOnReload(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	blocked := newFanoutTestOutput(func(publisher.Batch) bool {
+		<-release
+		return false
+	})
+
+	pipeline, acked, cleanup := runFanoutPipeline(t, &outputs.Fanout{
+		Outputs: []outputs.RoutedGroup{blocked.group("blocked", nil)},
+	}, makeFanoutTestEvents(10))
+	defer cleanup()
+
+	// batches pending in the fanout group must be published by the new output
+	var published atomic.Uint
+	pipeline.output.Set(outputs.Group{
+		Clients: []outputs.Client{newMockNetworkClient(func(batch publisher.Batch) error {
+			published.Add(uint(len(batch.Events())))
+			batch.ACK()
+			return nil
+		})},
+	})
+
+	require.True(t, waitUntilTrue(5*time.Second, func() bool {
+		return acked.Load() == 10
+	}))
+	assert.Equal(t, uint(10), published.Load())
+	assert.Equal(t, uint(0), blocked.published.Load())
+}
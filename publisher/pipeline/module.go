@@ -39,6 +39,10 @@ var publishDisabled = false
 
 const defaultQueueType = "mem"
 
+// defaultQueueName selects the default queue in beat.ClientConfig.Queue when
+// named queues are configured.
+const defaultQueueName = "default"
+
 // Monitors configures visibility for observing state and progress of the
 // pipeline.
 type Monitors struct {
@@ -99,6 +103,13 @@ func LoadWithSettings(
 	if err != nil {
 		return nil, err
 	}
+	if len(config.Queues) > 0 {
+		queueBuilder, err = createInputQueuesBuilder(
+			queueBuilder, config.Queues, monitors, settings.InputQueueSize)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	out, err := loadOutput(monitors, makeOutput)
 	if err != nil {
@@ -173,6 +184,60 @@ func createQueueBuilder(
 	monitors Monitors,
 	inQueueSize int,
 ) (func(queue.ACKListener) (queue.Queue, error), error) {
+	queueType, queueFactory, err := createQueueFactory(config, monitors, inQueueSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if monitors.Telemetry != nil {
+		queueReg := monitors.Telemetry.NewRegistry("queue")
+		monitoring.NewString(queueReg, "name").Set(queueType)
+	}
+
+	return queueFactory, nil
+}
+
+// createInputQueuesBuilder returns a builder combining the default queue with
+// the named queues configured in `queues`.
+func createInputQueuesBuilder(
+	defaultQueue queueFactory,
+	configs []InputQueueConfig,
+	monitors Monitors,
+	inQueueSize int,
+) (func(queue.ACKListener) (queue.Queue, error), error) {
+	factories := []inputQueueFactory{{name: defaultQueueName, factory: defaultQueue}}
+	names := map[string]bool{defaultQueueName: true}
+	for _, config := range configs {
+		if names[config.Name] {
+			return nil, fmt.Errorf("queue name '%v' is used more than once", config.Name)
+		}
+		names[config.Name] = true
+
+		queueType, queueFactory, err := createQueueFactory(config.Queue, monitors, inQueueSize)
+		if err != nil {
+			return nil, fmt.Errorf("queue '%v': %w", config.Name, err)
+		}
+		factories = append(factories, inputQueueFactory{
+			name:    config.Name,
+			typ:     queueType,
+			factory: queueFactory,
+		})
+	}
+
+	log := monitors.Logger
+	if log == nil {
+		log = logp.L()
+	}
+	return func(ackListener queue.ACKListener) (queue.Queue, error) {
+		return newInputQueues(log, ackListener, factories)
+	}, nil
+}
+
+func createQueueFactory(
+	config common.ConfigNamespace,
+	monitors Monitors,
+	inQueueSize int,
+) (string, queueFactory, error) {
 	queueType := defaultQueueType
 	if b := config.Name(); b != "" {
 		queueType = b
@@ -180,7 +245,7 @@ func createQueueBuilder(
 
 	queueFactory := queue.FindFactory(queueType)
 	if queueFactory == nil {
-		return nil, fmt.Errorf("'%v' is no valid queue type", queueType)
+		return "", nil, fmt.Errorf("'%v' is no valid queue type", queueType)
 	}
 
 	queueConfig := config.Config()
@@ -188,12 +253,7 @@ func createQueueBuilder(
 		queueConfig = common.NewConfig()
 	}
 
-	if monitors.Telemetry != nil {
-		queueReg := monitors.Telemetry.NewRegistry("queue")
-		monitoring.NewString(queueReg, "name").Set(queueType)
-	}
-
-	return func(ackListener queue.ACKListener) (queue.Queue, error) {
+	return queueType, func(ackListener queue.ACKListener) (queue.Queue, error) {
 		return queueFactory(ackListener, monitors.Logger, queueConfig, inQueueSize)
 	}, nil
 }
@@ -21,6 +21,7 @@
 package pipeline
 
 import (
+	"fmt"
 	"reflect"
 	"sync"
 	"time"
@@ -48,6 +49,8 @@ import (
 // The output controller configures a (potentially reloadable) set of load
 // balanced output clients. Events will be pulled from the queue and pushed to
 // the output clients using a shared work queue for the active outputs.Group.
+// If the outputs.Group configures a fanout, events are published to multiple
+// output groups in parallel, each having its own work queue and retry state.
 // Processors in the pipeline are executed in the clients go-routine, before
 // entering the queue. No filtering/processing will occur on the output side.
 //
@@ -177,6 +180,9 @@ func New(
 	if err != nil {
 		return nil, err
 	}
+	if reporter, ok := p.queue.(queue.MetricsReporter); ok && monitors.Metrics != nil {
+		reporter.RegisterMetrics(monitors.Metrics.GetRegistry("pipeline"))
+	}
 
 	maxEvents := p.queue.BufferConfig().MaxEvents
 	if maxEvents <= 0 {
@@ -258,6 +264,11 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 		return nil, err
 	}
 
+	makeProducer, err := p.producerFactory(cfg.Queue)
+	if err != nil {
+		return nil, err
+	}
+
 	p.eventer.mutex.Lock()
 	p.eventer.modifyable = false
 	p.eventer.mutex.Unlock()
@@ -334,7 +345,7 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 
 	client.acker = ackHandler
 	client.waiter = waiter
-	client.producer = p.queue.Producer(producerCfg)
+	client.producer = makeProducer(producerCfg)
 
 	p.observer.clientConnected()
 
@@ -345,6 +356,20 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 	return client, nil
 }
 
+// producerFactory returns the function connecting clients to the queue with
+// the given name, or to the default queue if name is empty.
+func (p *Pipeline) producerFactory(name string) (func(queue.ProducerConfig) queue.Producer, error) {
+	if name == "" {
+		return p.queue.Producer, nil
+	}
+	if iq, ok := p.queue.(*inputQueues); ok {
+		if q, ok := iq.byName[name]; ok {
+			return q.producer, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown queue '%v'", name)
+}
+
 func (p *Pipeline) registerSignalPropagation(c *client) {
 	p.guardStartSigPropagation.Do(func() {
 		p.sigNewClient = make(chan *client, 1)
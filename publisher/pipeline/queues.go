@@ -0,0 +1,346 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/joeshaw/multierror"
+
+	"github.com/njcx/libbeat_v7/common/atomic"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/monitoring"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+)
+
+// inputQueues is a queue.Queue combining the default queue with the named
+// queues configured in `queues`, so that inputs publishing to their own
+// queue are isolated from each other. Clients select a queue with
+// beat.ClientConfig.Queue.
+//
+// Each queue is read by a fetcher go-routine that keeps one batch ready for
+// the consumer. The consumer returns the ready batches in round-robin order,
+// so that a busy queue can not starve the others.
+type inputQueues struct {
+	logger *logp.Logger
+
+	// queues[0] is the default queue.
+	queues []*inputQueue
+	byName map[string]*inputQueue
+
+	// mutex serializes the consumers, and protects the scheduling state of
+	// the queues and next.
+	mutex sync.Mutex
+
+	// Index of the queue the next batch is taken from, if it has one ready.
+	next int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type inputQueue struct {
+	name  string
+	typ   string
+	queue queue.Queue
+
+	consumer queue.Consumer
+
+	// Batch sizes requested from the fetcher, and the batches it read.
+	requests chan int
+	batches  chan queue.Batch
+
+	// pending is true while the fetcher has a request it has not answered,
+	// closed is true after the fetcher stopped.
+	pending bool
+	closed  bool
+
+	published monitoring.Uint
+	acked     monitoring.Uint
+}
+
+type inputQueuesConsumer struct {
+	queues *inputQueues
+	closed atomic.Bool
+	done   chan struct{}
+}
+
+type inputQueueProducer struct {
+	queue    *inputQueue
+	producer queue.Producer
+}
+
+type inputQueueACKListener struct {
+	queue    *inputQueue
+	listener queue.ACKListener
+}
+
+// inputQueueFactory creates one of the queues of an inputQueues.
+type inputQueueFactory struct {
+	name    string
+	typ     string
+	factory queueFactory
+}
+
+func newInputQueues(
+	logger *logp.Logger,
+	ackListener queue.ACKListener,
+	factories []inputQueueFactory,
+) (*inputQueues, error) {
+	iq := &inputQueues{
+		logger: logger,
+		byName: map[string]*inputQueue{},
+		done:   make(chan struct{}),
+	}
+	for _, f := range factories {
+		q := &inputQueue{name: f.name, typ: f.typ}
+		var err error
+		q.queue, err = f.factory(inputQueueACKListener{queue: q, listener: ackListener})
+		if err != nil {
+			iq.closeQueues()
+			return nil, fmt.Errorf("creating queue '%v': %w", f.name, err)
+		}
+		q.consumer = q.queue.Consumer()
+		q.requests = make(chan int, 1)
+		q.batches = make(chan queue.Batch)
+		iq.queues = append(iq.queues, q)
+		iq.byName[f.name] = q
+	}
+
+	for _, q := range iq.queues {
+		q := q
+		iq.wg.Add(1)
+		go func() {
+			defer iq.wg.Done()
+			q.fetch(iq.done)
+		}()
+	}
+	return iq, nil
+}
+
+func (iq *inputQueues) Close() error {
+	close(iq.done)
+	for _, q := range iq.queues {
+		q.consumer.Close()
+	}
+	iq.wg.Wait()
+	return iq.closeQueues()
+}
+
+func (iq *inputQueues) closeQueues() error {
+	var errs multierror.Errors
+	for _, q := range iq.queues {
+		if err := q.queue.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing queue '%v': %w", q.name, err))
+		}
+	}
+	return errs.Err()
+}
+
+// BufferConfig reports the total number of events of all queues, or 0 if any
+// of them is not bounded by an event count.
+func (iq *inputQueues) BufferConfig() queue.BufferConfig {
+	total := 0
+	for _, q := range iq.queues {
+		maxEvents := q.queue.BufferConfig().MaxEvents
+		if maxEvents <= 0 {
+			return queue.BufferConfig{MaxEvents: 0}
+		}
+		total += maxEvents
+	}
+	return queue.BufferConfig{MaxEvents: total}
+}
+
+// Producer connects to the default queue.
+func (iq *inputQueues) Producer(cfg queue.ProducerConfig) queue.Producer {
+	return iq.queues[0].queue.Producer(cfg)
+}
+
+func (iq *inputQueues) Consumer() queue.Consumer {
+	return &inputQueuesConsumer{queues: iq, done: make(chan struct{})}
+}
+
+// RegisterMetrics reports the metrics of the default queue as usual, and the
+// metrics of each named queue under queues.<name> in the pipeline registry.
+func (iq *inputQueues) RegisterMetrics(reg *monitoring.Registry) {
+	if reporter, ok := iq.queues[0].queue.(queue.MetricsReporter); ok {
+		reporter.RegisterMetrics(reg)
+	}
+
+	reg.Remove("queues")
+	queuesReg := reg.NewRegistry("queues")
+	for _, q := range iq.queues[1:] {
+		queueReg := queuesReg.NewRegistry(q.name)
+		monitoring.NewString(queueReg, "type").Set(q.typ)
+		monitoring.NewUint(queueReg, "max_events").Set(uint64(q.queue.BufferConfig().MaxEvents))
+		queueReg.Add("published", &q.published, monitoring.Reported)
+		queueReg.Add("acked", &q.acked, monitoring.Reported)
+		if reporter, ok := q.queue.(queue.MetricsReporter); ok {
+			reporter.RegisterMetrics(queueReg)
+		}
+	}
+}
+
+// producer connects a producer to this queue, counting the published events.
+func (q *inputQueue) producer(cfg queue.ProducerConfig) queue.Producer {
+	return &inputQueueProducer{queue: q, producer: q.queue.Producer(cfg)}
+}
+
+// fetch reads a batch from the queue for every request of the consumer,
+// until the queue is closed.
+func (q *inputQueue) fetch(done <-chan struct{}) {
+	defer close(q.batches)
+	for {
+		var size int
+		select {
+		case <-done:
+			return
+		case size = <-q.requests:
+		}
+
+		batch, err := q.consumer.Get(size)
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-done:
+			return
+		case q.batches <- batch:
+		}
+	}
+}
+
+func (c *inputQueuesConsumer) Get(sz int) (queue.Batch, error) {
+	iq := c.queues
+	iq.mutex.Lock()
+	defer iq.mutex.Unlock()
+
+	for {
+		if c.closed.Load() {
+			return nil, io.EOF
+		}
+
+		// Ask every idle fetcher for a batch, so that any queue with events
+		// available can serve the next one.
+		var cases []reflect.SelectCase
+		var open []int
+		for index, q := range iq.queues {
+			if q.closed {
+				continue
+			}
+			if !q.pending {
+				q.requests <- sz
+				q.pending = true
+			}
+			cases = append(cases, reflect.SelectCase{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(q.batches),
+			})
+			open = append(open, index)
+		}
+		if len(open) == 0 {
+			return nil, io.EOF
+		}
+
+		// Take the first ready batch, starting at the queue after the one
+		// that served the previous batch.
+		for i := range iq.queues {
+			index := (iq.next + i) % len(iq.queues)
+			q := iq.queues[index]
+			if q.closed {
+				continue
+			}
+			select {
+			case batch, ok := <-q.batches:
+				if batch, ok := iq.received(index, batch, ok); ok {
+					return batch, nil
+				}
+			default:
+			}
+		}
+
+		// No batch is ready, wait for the first one.
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(c.done),
+		})
+		chosen, recv, recvOK := reflect.Select(cases)
+		if chosen == len(open) {
+			return nil, io.EOF
+		}
+		var batch queue.Batch
+		if recvOK {
+			batch = recv.Interface().(queue.Batch)
+		}
+		if batch, ok := iq.received(open[chosen], batch, recvOK); ok {
+			return batch, nil
+		}
+	}
+}
+
+// received updates the scheduling state after a fetcher answered a request.
+// It returns false if the fetcher stopped instead of returning a batch.
+func (iq *inputQueues) received(index int, batch queue.Batch, ok bool) (queue.Batch, bool) {
+	q := iq.queues[index]
+	q.pending = false
+	if !ok {
+		q.closed = true
+		return nil, false
+	}
+	iq.next = (index + 1) % len(iq.queues)
+	return batch, true
+}
+
+func (c *inputQueuesConsumer) Close() error {
+	if c.closed.Swap(true) {
+		return errors.New("already closed")
+	}
+	close(c.done)
+	return nil
+}
+
+func (p *inputQueueProducer) Publish(event publisher.Event) bool {
+	if p.producer.Publish(event) {
+		p.queue.published.Inc()
+		return true
+	}
+	return false
+}
+
+func (p *inputQueueProducer) TryPublish(event publisher.Event) bool {
+	if p.producer.TryPublish(event) {
+		p.queue.published.Inc()
+		return true
+	}
+	return false
+}
+
+func (p *inputQueueProducer) Cancel() int {
+	return p.producer.Cancel()
+}
+
+func (l inputQueueACKListener) OnACK(n int) {
+	l.queue.acked.Add(uint64(n))
+	l.listener.OnACK(n)
+}
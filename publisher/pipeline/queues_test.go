@@ -0,0 +1,154 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/outputs"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+	"github.com/njcx/libbeat_v7/publisher/queue/memqueue"
+)
+
+func TestInputQueuesSchedulesFairly(t *testing.T) {
+	iq := makeTestInputQueues(t, "quiet")
+	defer iq.Close()
+
+	// Fill the default queue before publishing a few events to the quiet one.
+	publishTestEvents(iq.queues[0].producer(queue.ProducerConfig{}), "default", 100)
+	publishTestEvents(iq.queues[1].producer(queue.ProducerConfig{}), "quiet", 10)
+
+	consumer := iq.Consumer()
+	defer consumer.Close()
+
+	// The quiet queue must be served long before the default queue is drained.
+	counts := map[string]int{}
+	for i := 0; i < 5 && counts["quiet"] < 10; i++ {
+		batch, err := consumer.Get(10)
+		require.NoError(t, err)
+		for _, event := range batch.Events() {
+			source, _ := event.Content.Fields.GetValue("queue")
+			counts[source.(string)]++
+		}
+		batch.ACK()
+	}
+	assert.Equal(t, 10, counts["quiet"])
+	assert.Less(t, counts["default"], 100)
+}
+
+func TestInputQueuesConsumerClose(t *testing.T) {
+	iq := makeTestInputQueues(t, "a")
+	defer iq.Close()
+
+	consumer := iq.Consumer()
+	done := make(chan error)
+	go func() {
+		_, err := consumer.Get(10)
+		done <- err
+	}()
+	consumer.Close()
+	assert.Error(t, <-done)
+
+	// Events published after the consumer was closed are available to the
+	// next consumer.
+	publishTestEvents(iq.queues[1].producer(queue.ProducerConfig{}), "a", 1)
+	consumer = iq.Consumer()
+	defer consumer.Close()
+	batch, err := consumer.Get(10)
+	require.NoError(t, err)
+	assert.Len(t, batch.Events(), 1)
+}
+
+func TestConnectWithQueue(t *testing.T) {
+	var config Config
+	err := common.MustNewConfigFrom(map[string]interface{}{
+		"queues": []map[string]interface{}{
+			{"name": "tenant-a", "queue.mem.events": 64},
+		},
+	}).Unpack(&config)
+	require.NoError(t, err)
+
+	defaultQueue, err := createQueueBuilder(common.ConfigNamespace{}, Monitors{}, 0)
+	require.NoError(t, err)
+	builder, err := createInputQueuesBuilder(defaultQueue, config.Queues, Monitors{}, 0)
+	require.NoError(t, err)
+
+	p, err := New(beat.Info{}, Monitors{}, builder, outputs.Group{}, Settings{})
+	require.NoError(t, err)
+	defer p.Close()
+
+	for _, name := range []string{"", "default", "tenant-a"} {
+		client, err := p.ConnectWith(beat.ClientConfig{Queue: name})
+		require.NoError(t, err, "queue '%v'", name)
+		client.Close()
+	}
+
+	_, err = p.ConnectWith(beat.ClientConfig{Queue: "tenant-b"})
+	assert.Error(t, err)
+}
+
+func TestInputQueuesConfigErrors(t *testing.T) {
+	defaultQueue, err := createQueueBuilder(common.ConfigNamespace{}, Monitors{}, 0)
+	require.NoError(t, err)
+
+	testCases := map[string][]InputQueueConfig{
+		"duplicate name": {{Name: "a"}, {Name: "a"}},
+		"default name":   {{Name: "default"}},
+	}
+	for name, configs := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := createInputQueuesBuilder(defaultQueue, configs, Monitors{}, 0)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func makeTestInputQueues(t *testing.T, names ...string) *inputQueues {
+	memQueue := func(ackListener queue.ACKListener) (queue.Queue, error) {
+		return memqueue.NewQueue(logp.L(), memqueue.Settings{
+			ACKListener: ackListener,
+			Events:      128,
+		}), nil
+	}
+	factories := []inputQueueFactory{{name: defaultQueueName, factory: memQueue}}
+	for _, name := range names {
+		factories = append(factories, inputQueueFactory{name: name, typ: "mem", factory: memQueue})
+	}
+	iq, err := newInputQueues(logp.L(), nopACKListener{}, factories)
+	require.NoError(t, err)
+	return iq
+}
+
+func publishTestEvents(producer queue.Producer, source string, count int) {
+	for i := 0; i < count; i++ {
+		producer.Publish(publisher.Event{
+			Content: beat.Event{Fields: common.MapStr{"queue": source}},
+		})
+	}
+}
+
+type nopACKListener struct{}
+
+func (nopACKListener) OnACK(int) {}
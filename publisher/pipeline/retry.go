@@ -110,11 +110,20 @@ func (r *retryer) sigOutputRemoved() {
 }
 
 func (r *retryer) retry(b Batch) {
-	r.in <- batchEvent{tag: retryBatch, batch: b}
+	r.send(batchEvent{tag: retryBatch, batch: b})
 }
 
 func (r *retryer) cancelled(b Batch) {
-	r.in <- batchEvent{tag: cancelledBatch, batch: b}
+	r.send(batchEvent{tag: cancelledBatch, batch: b})
+}
+
+// send forwards the batch to the retryer loop. Batches returned by outputs
+// still shutting down after the retryer has been closed are ignored.
+func (r *retryer) send(evt batchEvent) {
+	select {
+	case r.in <- evt:
+	case <-r.done:
+	}
 }
 
 func (r *retryer) loop() {
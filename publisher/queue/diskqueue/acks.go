@@ -157,10 +157,11 @@ func (dqa *diskQueueACKs) addFrames(frames []*readFrame) {
 				// segment boundary list and reset the byte index to immediately
 				// after the segment header.
 				delete(dqa.segmentBoundaries, dqa.nextFrameID)
-				if dqa.nextFrameID != 0 {
+				if dqa.nextFrameID != 0 || newSegment.id != dqa.nextPosition.segmentID {
 					// Special case if this is the first frame of a new session:
 					// don't overwrite nextPosition, since it may contain the saved
-					// position of the previous session.
+					// position of the previous session (unless that segment was
+					// evicted by the retention policies before it was read).
 					dqa.nextPosition = queuePosition{segmentID: newSegment.id}
 				}
 				if dqa.nextPosition.byteIndex == 0 {
@@ -211,6 +211,24 @@ func TestAddFrames(t *testing.T) {
 				},
 			},
 		},
+		"The first frame after the queue opens resets an evicted position": {
+			// If the segment of the saved position was evicted by the retention
+			// policies before it was read, the first frame of the new run
+			// comes from a later segment and the position moves there.
+			frameID:  0,
+			position: queuePosition{5, 1000, 10},
+			steps: []addFramesTestStep{
+				{
+					"Add the beginning of segment 7 as the next frame",
+					[]*readFrame{
+						rf(7, 0, true, 100),
+					},
+					frameID(1),
+					&queuePosition{7, segmentHeaderSize + 100, 1},
+					segmentIDRef(6),
+				},
+			},
+		},
 		"Segments with old schema versions have the correct positions": {
 			steps: []addFramesTestStep{
 				{
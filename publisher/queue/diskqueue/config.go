@@ -68,6 +68,29 @@ type Settings struct {
 	// use exponential backoff up to the specified limit.
 	RetryInterval    time.Duration
 	MaxRetryInterval time.Duration
+
+	// Compression is the algorithm used to compress each event written to
+	// disk: "none", "lz4", "snappy" or "zstd". Defaults to "none".
+	Compression string
+
+	// EncryptionKey enables AES-GCM encryption of the events written to disk
+	// if set. The AES-256 key is derived from the given secret, which should
+	// be read from the keystore.
+	EncryptionKey string
+
+	// MaxAge is the retention period of events on disk. Segments whose most
+	// recent event is older than this are deleted without being sent to the
+	// output. A value of 0 keeps events until they are acknowledged.
+	MaxAge time.Duration
+
+	// MaxEvents is the maximum number of events the queue should hold. When
+	// it is exceeded, the oldest segments are deleted without being sent to
+	// the output. A value of 0 means the event count is unbounded.
+	MaxEvents int
+
+	// A listener that should be sent the number of events dropped by the
+	// retention policies (MaxAge and MaxEvents).
+	EvictionListener queue.ACKListener
 }
 
 // userConfig holds the parameters for a disk queue that are configurable
@@ -82,6 +105,12 @@ type userConfig struct {
 
 	RetryInterval    *time.Duration `config:"retry_interval" validate:"positive"`
 	MaxRetryInterval *time.Duration `config:"max_retry_interval" validate:"positive"`
+
+	Compression   string `config:"compression"`
+	EncryptionKey string `config:"encryption_key"`
+
+	MaxAge    time.Duration `config:"max_age"`
+	MaxEvents int           `config:"max_events" validate:"min=0"`
 }
 
 func (c *userConfig) Validate() error {
@@ -114,6 +143,24 @@ func (c *userConfig) Validate() error {
 			*c.MaxRetryInterval, *c.RetryInterval)
 	}
 
+	if c.MaxAge < 0 {
+		return fmt.Errorf("disk queue max_age (%v) can't be negative", c.MaxAge)
+	}
+
+	return validateFrameEncoding(c.Compression, c.EncryptionKey)
+}
+
+func validateFrameEncoding(compression, encryptionKey string) error {
+	if _, ok := compressionTypes[compression]; !ok {
+		return fmt.Errorf(
+			"disk queue compression '%v' is not supported, must be one of none, lz4, snappy or zstd",
+			compression)
+	}
+	if encryptionKey != "" && len(encryptionKey) < minEncryptionKeyLength {
+		return fmt.Errorf(
+			"disk queue encryption_key must be at least %d characters long",
+			minEncryptionKeyLength)
+	}
 	return nil
 }
 
@@ -165,6 +212,12 @@ func SettingsForUserConfig(config *common.Config) (Settings, error) {
 		settings.MaxRetryInterval = *userConfig.MaxRetryInterval
 	}
 
+	settings.Compression = userConfig.Compression
+	settings.EncryptionKey = userConfig.EncryptionKey
+
+	settings.MaxAge = userConfig.MaxAge
+	settings.MaxEvents = userConfig.MaxEvents
+
 	return settings, nil
 }
 
@@ -189,12 +242,34 @@ func (settings Settings) segmentPath(segmentID segmentID) string {
 		fmt.Sprintf("%v.seg", segmentID))
 }
 
+// frameEncoding returns the encoding used for segments created with the
+// current settings.
+func (settings Settings) frameEncoding() frameEncoding {
+	return frameEncoding{
+		compression: compressionTypes[settings.Compression],
+		encrypted:   settings.EncryptionKey != "",
+	}
+}
+
 // maxValidFrameSize returns the size of the largest possible frame that
 // can be stored with the current queue settings.
 func (settings Settings) maxValidFrameSize() uint64 {
 	return settings.MaxSegmentSize - segmentHeaderSize
 }
 
+// retentionCheckInterval returns how often the core loop should look for
+// segments that exceeded MaxAge, or 0 if there is no age limit.
+func (settings Settings) retentionCheckInterval() time.Duration {
+	if settings.MaxAge <= 0 {
+		return 0
+	}
+	interval := settings.MaxAge / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	return interval
+}
+
 // Given a retry interval, nextRetryInterval returns the next higher level
 // of backoff.
 func (settings Settings) nextRetryInterval(
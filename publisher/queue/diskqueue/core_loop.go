@@ -17,7 +17,10 @@
 
 package diskqueue
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // This file contains the queue's "core loop" -- the central goroutine
 // that owns all queue state that is not encapsulated in one of the
@@ -26,6 +29,14 @@ import "fmt"
 // logical "state transition diagram" for queue operation.
 
 func (dq *diskQueue) run() {
+	// If there is an age limit, periodically check for expired segments.
+	var retentionTick <-chan time.Time
+	if interval := dq.settings.retentionCheckInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		retentionTick = ticker.C
+	}
+
 	// Wake up the reader and deleter loops if there are segments to process
 	// from a previous instantiation of the queue.
 	dq.maybeReadPending()
@@ -55,6 +66,10 @@ func (dq *diskQueue) run() {
 		case writerLoopResponse := <-dq.writerLoop.responseChan:
 			dq.handleWriterLoopResponse(writerLoopResponse)
 
+			// The new frames may have pushed the queue over settings.MaxEvents.
+			dq.maybeEvictSegments()
+			dq.maybeDeleteACKed()
+
 			// The writer loop completed a request, so check if there is more
 			// data to be sent.
 			dq.maybeWritePending()
@@ -84,6 +99,10 @@ func (dq *diskQueue) run() {
 			// If there were blocked producers waiting for more queue space,
 			// we might be able to unblock them now.
 			dq.maybeUnblockProducers()
+
+		case <-retentionTick:
+			dq.maybeEvictSegments()
+			dq.maybeDeleteACKed()
 		}
 	}
 }
@@ -129,10 +148,14 @@ func (dq *diskQueue) handleWriterLoopResponse(response writerLoopResponse) {
 	// The writer loop response contains the number of bytes written to
 	// each segment that appeared in the request. Entries always appear in
 	// the same sequence as (the beginning of) segments.writing.
+	now := time.Now()
 	for index, segmentEntry := range response.segments {
 		// Update the segment with its new size.
 		dq.segments.writing[index].byteCount += segmentEntry.bytesWritten
 		dq.segments.writing[index].frameCount += segmentEntry.framesWritten
+		if segmentEntry.framesWritten > 0 {
+			dq.segments.writing[index].modTime = now
+		}
 	}
 
 	// If there is more than one segment in the response, then all but the
@@ -388,6 +411,62 @@ func (dq *diskQueue) maybeReadPending() {
 	dq.reading = true
 }
 
+// maybeEvictSegments enforces settings.MaxAge and settings.MaxEvents by
+// moving the oldest segments of the reading list directly to the acked list,
+// where they are deleted without ever being sent to a consumer. Segments that
+// are still being written, or that were already (partly) read during this
+// session, are never evicted: their events are delivered as usual.
+func (dq *diskQueue) maybeEvictSegments() {
+	maxAge := dq.settings.MaxAge
+	maxEvents := uint64(dq.settings.MaxEvents)
+	if maxAge <= 0 && maxEvents == 0 {
+		return
+	}
+	reading := dq.segments.reading
+
+	// The first reading segment can't be evicted once the reader loop has
+	// started on it.
+	start := 0
+	if len(reading) > 0 && (dq.reading || reading[0].framesRead > 0) {
+		start = 1
+	}
+
+	now := time.Now()
+	eventCount := dq.segments.eventCount()
+	evictedEvents := uint64(0)
+	end := start
+	for ; end < len(reading); end++ {
+		segment := reading[end]
+		expired := maxAge > 0 && now.Sub(segment.modTime) > maxAge
+		overflow := maxEvents > 0 && eventCount > maxEvents
+		if !expired && !overflow {
+			// Later segments are newer and there is enough room, we're done.
+			break
+		}
+		eventCount -= segment.pendingFrameCount()
+		evictedEvents += segment.pendingFrameCount()
+	}
+	if end == start {
+		return
+	}
+
+	evicted := reading[start:end]
+	dq.segments.acked = append(dq.segments.acked, evicted...)
+	dq.segments.reading = append(reading[:start:start], reading[end:]...)
+	if start == 0 {
+		// The next read starts at the beginning of the new first segment.
+		dq.segments.nextReadPosition = 0
+	}
+
+	dq.logger.Warnf(
+		"Dropped %d events in %d segments exceeding the queue retention limits",
+		evictedEvents, len(evicted))
+	dq.evicted.Add(evictedEvents)
+	if dq.settings.EvictionListener != nil {
+		dq.settings.EvictionListener.OnACK(int(evictedEvents))
+	}
+}
+
 // If the acked list is nonempty, and there are no outstanding deletion
 // requests, send one.
 func (dq *diskQueue) maybeDeleteACKed() {
@@ -431,7 +510,10 @@ func (dq *diskQueue) enqueueWriteFrame(frame *writeFrame) {
 	// we need to create a new writing segment.
 	if segment == nil ||
 		newSegmentSize > dq.settings.MaxSegmentSize {
-		segment = &queueSegment{id: dq.segments.nextID}
+		segment = &queueSegment{
+			id:       dq.segments.nextID,
+			encoding: dq.settings.frameEncoding(),
+		}
 		dq.segments.writing = append(dq.segments.writing, segment)
 		dq.segments.nextID++
 		// Reset the on-disk size to its initial value, the file's header size
@@ -20,6 +20,7 @@ package diskqueue
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/njcx/libbeat_v7/logp"
 )
@@ -488,9 +489,9 @@ func TestMaybeReadPending(t *testing.T) {
 			expectedRequest: &readerLoopRequest{
 				segment:      &queueSegment{id: 1},
 				startFrameID: 5,
-				// startPosition is 8, the end of the segment header in the
+				// startPosition is 12, the end of the segment header in the
 				// current file schema.
-				startPosition: 8,
+				startPosition: 12,
 				endPosition:   1000,
 			},
 		},
@@ -533,7 +534,7 @@ func TestMaybeReadPending(t *testing.T) {
 			},
 			expectedRequest: &readerLoopRequest{
 				segment:       &queueSegment{id: 1},
-				startPosition: 8,
+				startPosition: 12,
 				endPosition:   1000,
 			},
 		},
@@ -573,7 +574,7 @@ func TestMaybeReadPending(t *testing.T) {
 			},
 			expectedRequest: &readerLoopRequest{
 				segment:       &queueSegment{id: 2},
-				startPosition: 8,
+				startPosition: 12,
 				endPosition:   500,
 			},
 			expectedACKingSegment: segmentIDRef(1),
@@ -607,6 +608,22 @@ func TestMaybeReadPending(t *testing.T) {
 				endPosition:   1000,
 			},
 		},
+		"reading the beginning of a schema 1 segment file uses the right header size": {
+			segments: diskQueueSegments{
+				reading: []*queueSegment{
+					{
+						id:            1,
+						byteCount:     1000,
+						schemaVersion: makeUint32Ptr(1)},
+				},
+			},
+			expectedRequest: &readerLoopRequest{
+				segment: &queueSegment{id: 1},
+				// The header size for schema version 1 was 8 bytes.
+				startPosition: 8,
+				endPosition:   1000,
+			},
+		},
 	}
 
 	for description, test := range testCases {
@@ -661,6 +678,157 @@ func TestMaybeReadPending(t *testing.T) {
 	}
 }
 
+func TestMaybeEvictSegments(t *testing.T) {
+	// maybeEvictSegments should:
+	// - Do nothing if neither settings.MaxAge nor settings.MaxEvents is set.
+	// - Move the oldest reading segments whose last write is older than
+	//   settings.MaxAge, or that keep the queue above settings.MaxEvents,
+	//   to the acked list, and add their unacknowledged events to the
+	//   evicted count.
+	// - Never evict the first reading segment if it is being read, and
+	//   reset nextReadPosition if the first reading segment was evicted.
+	// - Never evict segments that are still being written or acknowledged.
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+
+	testCases := map[string]struct {
+		maxAge    time.Duration
+		maxEvents int
+
+		// The segment structure to start with before calling
+		// maybeEvictSegments
+		segments diskQueueSegments
+		// The value of the diskQueue.reading flag
+		reading bool
+
+		// The segment IDs we expect in the acked and reading lists afterwards
+		expectedACKed   []segmentID
+		expectedReading []segmentID
+		// The number of events we expect to be reported as evicted
+		expectedEvicted uint64
+	}{
+		"no retention limits": {
+			segments: diskQueueSegments{
+				reading: []*queueSegment{
+					{id: 1, frameCount: 10, modTime: old},
+				},
+			},
+			expectedReading: []segmentID{1},
+		},
+		"evict expired segments": {
+			maxAge: time.Hour,
+			segments: diskQueueSegments{
+				reading: []*queueSegment{
+					{id: 1, frameCount: 10, modTime: old},
+					{id: 2, frameCount: 20, modTime: old},
+					{id: 3, frameCount: 30, modTime: now},
+				},
+				nextReadPosition: 100,
+			},
+			expectedACKed:   []segmentID{1, 2},
+			expectedReading: []segmentID{3},
+			expectedEvicted: 30,
+		},
+		"don't count events acknowledged in a previous session": {
+			maxAge: time.Hour,
+			segments: diskQueueSegments{
+				reading: []*queueSegment{
+					{id: 1, frameCount: 10, previouslyACKed: 4, modTime: old},
+				},
+			},
+			expectedACKed:   []segmentID{1},
+			expectedEvicted: 6,
+		},
+		"don't evict a segment that is being read": {
+			maxAge: time.Hour,
+			segments: diskQueueSegments{
+				reading: []*queueSegment{
+					{id: 1, frameCount: 10, modTime: old},
+					{id: 2, frameCount: 20, modTime: old},
+					{id: 3, frameCount: 30, modTime: now},
+				},
+			},
+			reading:         true,
+			expectedACKed:   []segmentID{2},
+			expectedReading: []segmentID{1, 3},
+			expectedEvicted: 20,
+		},
+		"don't evict a segment that was partly read": {
+			maxAge: time.Hour,
+			segments: diskQueueSegments{
+				reading: []*queueSegment{
+					{id: 1, frameCount: 10, framesRead: 5, modTime: old},
+				},
+			},
+			expectedReading: []segmentID{1},
+		},
+		"don't evict writing or acking segments": {
+			maxAge: time.Hour,
+			segments: diskQueueSegments{
+				acking:  []*queueSegment{{id: 1, frameCount: 10, modTime: old}},
+				writing: []*queueSegment{{id: 2, frameCount: 10, modTime: old}},
+			},
+		},
+		"evict the oldest segments above the event limit": {
+			maxEvents: 50,
+			segments: diskQueueSegments{
+				acking: []*queueSegment{{id: 1, frameCount: 10}},
+				reading: []*queueSegment{
+					{id: 2, frameCount: 20},
+					{id: 3, frameCount: 20},
+					{id: 4, frameCount: 20},
+				},
+				writing: []*queueSegment{{id: 5, frameCount: 10}},
+			},
+			expectedACKed:   []segmentID{2, 3},
+			expectedReading: []segmentID{4},
+			expectedEvicted: 40,
+		},
+		"keep segments below the event limit": {
+			maxEvents: 50,
+			segments: diskQueueSegments{
+				reading: []*queueSegment{
+					{id: 1, frameCount: 20},
+					{id: 2, frameCount: 30},
+				},
+			},
+			expectedReading: []segmentID{1, 2},
+		},
+	}
+
+	for description, test := range testCases {
+		settings := DefaultSettings()
+		settings.MaxAge = test.maxAge
+		settings.MaxEvents = test.maxEvents
+		dq := &diskQueue{
+			logger:   logp.L(),
+			settings: settings,
+			segments: test.segments,
+			reading:  test.reading,
+		}
+		dq.maybeEvictSegments()
+
+		if ids := segmentIDs(dq.segments.acked); !equalSegmentIDs(ids, test.expectedACKed) {
+			t.Errorf("%s: expected acked segments %v, got %v",
+				description, test.expectedACKed, ids)
+		}
+		if ids := segmentIDs(dq.segments.reading); !equalSegmentIDs(ids, test.expectedReading) {
+			t.Errorf("%s: expected reading segments %v, got %v",
+				description, test.expectedReading, ids)
+		}
+		if evicted := dq.evicted.Get(); evicted != test.expectedEvicted {
+			t.Errorf("%s: expected %v evicted events, got %v",
+				description, test.expectedEvicted, evicted)
+		}
+		evictedFirst := len(test.expectedACKed) > 0 &&
+			test.segments.reading[0].id == test.expectedACKed[0]
+		if evictedFirst && dq.segments.nextReadPosition != 0 {
+			t.Errorf("%s: expected nextReadPosition to be reset, got %v",
+				description, dq.segments.nextReadPosition)
+		}
+	}
+}
+
 func TestMaybeWritePending(t *testing.T) {
 	// maybeWritePending should:
 	// - If diskQueue.writing is true, do nothing and return immediately.
@@ -981,6 +1149,26 @@ func segmentWithSize(size int) *queueSegment {
 	return &queueSegment{byteCount: uint64(size)}
 }
 
+func segmentIDs(segments []*queueSegment) []segmentID {
+	ids := []segmentID{}
+	for _, segment := range segments {
+		ids = append(ids, segment.id)
+	}
+	return ids
+}
+
+func equalSegmentIDs(ids0 []segmentID, ids1 []segmentID) bool {
+	if len(ids0) != len(ids1) {
+		return false
+	}
+	for i := range ids0 {
+		if ids0[i] != ids1[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func equalReaderLoopRequests(
 	r0 readerLoopRequest, r1 readerLoopRequest,
 ) bool {
@@ -0,0 +1,255 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// frameEncoding describes how serialized events are transformed before they
+// are written to a segment. It is stored in the segment header (since schema
+// version 2) so segments remain readable when the queue settings change.
+type frameEncoding struct {
+	compression compressionType
+	encrypted   bool
+}
+
+type compressionType uint8
+
+// Note: the values are stored in segment headers. Never change the order,
+// only add new types.
+const (
+	compressionNone compressionType = iota
+	compressionLZ4
+	compressionSnappy
+	compressionZstd
+)
+
+var compressionTypes = map[string]compressionType{
+	"":       compressionNone,
+	"none":   compressionNone,
+	"lz4":    compressionLZ4,
+	"snappy": compressionSnappy,
+	"zstd":   compressionZstd,
+}
+
+func (c compressionType) String() string {
+	switch c {
+	case compressionNone:
+		return "none"
+	case compressionLZ4:
+		return "lz4"
+	case compressionSnappy:
+		return "snappy"
+	case compressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+// Bit layout of the options field in the segment header.
+const (
+	optionsCompressionMask = 0xff
+	optionsEncrypted       = 1 << 8
+)
+
+// Minimum length of the encryption key. The AES-256 key is derived from the
+// configured key using SHA-256.
+const minEncryptionKeyLength = 16
+
+func (e frameEncoding) options() uint32 {
+	options := uint32(e.compression)
+	if e.encrypted {
+		options |= optionsEncrypted
+	}
+	return options
+}
+
+func frameEncodingFromOptions(options uint32) (frameEncoding, error) {
+	e := frameEncoding{
+		compression: compressionType(options & optionsCompressionMask),
+		encrypted:   options&optionsEncrypted != 0,
+	}
+	if e.compression > compressionZstd {
+		return e, fmt.Errorf("unknown compression type %d", e.compression)
+	}
+	if options&^(optionsCompressionMask|optionsEncrypted) != 0 {
+		return e, fmt.Errorf("unknown segment options %#x", options)
+	}
+	return e, nil
+}
+
+// frameCodec compresses and encrypts serialized events according to the
+// frameEncoding of their segment. It is shared by the producers and the
+// reader loop and safe for concurrent use.
+type frameCodec struct {
+	aead cipher.AEAD
+
+	zstdOnce    sync.Once
+	zstdErr     error
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+}
+
+func newFrameCodec(encryptionKey string) (*frameCodec, error) {
+	c := &frameCodec{}
+	if encryptionKey != "" {
+		key := sha256.Sum256([]byte(encryptionKey))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		c.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// encode compresses and then encrypts the serialized event. The result may
+// share memory with data.
+func (c *frameCodec) encode(e frameEncoding, data []byte) ([]byte, error) {
+	var err error
+	switch e.compression {
+	case compressionNone:
+	case compressionLZ4:
+		data = compressLZ4(data)
+	case compressionSnappy:
+		data = snappy.Encode(nil, data)
+	case compressionZstd:
+		if err = c.initZstd(); err != nil {
+			return nil, err
+		}
+		data = c.zstdEncoder.EncodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression type %d", e.compression)
+	}
+
+	if !e.encrypted {
+		return data, nil
+	}
+	if c.aead == nil {
+		return nil, errors.New("no encryption key configured")
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// decode reverses encode, decrypting and then decompressing the frame data.
+func (c *frameCodec) decode(e frameEncoding, data []byte) ([]byte, error) {
+	if e.encrypted {
+		if c.aead == nil {
+			return nil, errors.New("segment is encrypted but no encryption key is configured")
+		}
+		nonceSize := c.aead.NonceSize()
+		if len(data) < nonceSize+c.aead.Overhead() {
+			return nil, errors.New("encrypted frame is too short")
+		}
+		var err error
+		data, err = c.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decrypt frame: %w", err)
+		}
+	}
+
+	switch e.compression {
+	case compressionNone:
+		return data, nil
+	case compressionLZ4:
+		return decompressLZ4(data)
+	case compressionSnappy:
+		return snappy.Decode(nil, data)
+	case compressionZstd:
+		if err := c.initZstd(); err != nil {
+			return nil, err
+		}
+		return c.zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression type %d", e.compression)
+	}
+}
+
+func (c *frameCodec) initZstd() error {
+	c.zstdOnce.Do(func() {
+		c.zstdEncoder, c.zstdErr = zstd.NewWriter(nil)
+		if c.zstdErr != nil {
+			return
+		}
+		c.zstdDecoder, c.zstdErr = zstd.NewReader(nil)
+	})
+	return c.zstdErr
+}
+
+func (c *frameCodec) close() {
+	if c.zstdEncoder != nil {
+		c.zstdEncoder.Close()
+	}
+	if c.zstdDecoder != nil {
+		c.zstdDecoder.Close()
+	}
+}
+
+// compressLZ4 compresses data as LZ4 block, prefixed with the uncompressed
+// length as uvarint. Data that does not shrink is stored as is with a length
+// prefix of 0.
+func compressLZ4(data []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+lz4.CompressBlockBound(len(data)))
+	n := binary.PutUvarint(buf, uint64(len(data)))
+	compressed, err := lz4.CompressBlock(data, buf[n:], nil)
+	if err != nil || compressed == 0 || compressed >= len(data) {
+		n = binary.PutUvarint(buf, 0)
+		return append(buf[:n], data...)
+	}
+	return buf[:n+compressed]
+}
+
+func decompressLZ4(data []byte) ([]byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errors.New("invalid lz4 frame header")
+	}
+	if length == 0 {
+		return data[n:], nil
+	}
+	buf := make([]byte, length)
+	decompressed, err := lz4.UncompressBlock(data[n:], buf)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decompress lz4 frame: %w", err)
+	}
+	if uint64(decompressed) != length {
+		return nil, fmt.Errorf("lz4 frame length mismatch (%d vs %d)", decompressed, length)
+	}
+	return buf, nil
+}
@@ -0,0 +1,226 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+)
+
+const testEncryptionKey = "0123456789abcdef"
+
+func TestFrameCodecRoundTrip(t *testing.T) {
+	random := make([]byte, 1024)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	inputs := map[string][]byte{
+		"empty":          {},
+		"compressible":   bytes.Repeat([]byte("security telemetry "), 100),
+		"incompressible": random,
+	}
+
+	codec, err := newFrameCodec(testEncryptionKey)
+	require.NoError(t, err)
+	defer codec.close()
+
+	for name, compression := range compressionTypes {
+		for _, encrypted := range []bool{false, true} {
+			encoding := frameEncoding{compression: compression, encrypted: encrypted}
+			for inputName, input := range inputs {
+				encoded, err := codec.encode(encoding, input)
+				require.NoError(t, err, "%v/%v/%v", name, encrypted, inputName)
+
+				if encrypted && len(input) > 0 {
+					assert.False(t, bytes.Contains(encoded, input[:16]),
+						"%v/%v/%v: encrypted frame contains plain text", name, encrypted, inputName)
+				}
+
+				decoded, err := codec.decode(encoding, encoded)
+				require.NoError(t, err, "%v/%v/%v", name, encrypted, inputName)
+				assert.Equal(t, len(input), len(decoded), "%v/%v/%v", name, encrypted, inputName)
+				assert.True(t, bytes.Equal(input, decoded), "%v/%v/%v", name, encrypted, inputName)
+			}
+		}
+	}
+}
+
+func TestFrameCodecCompresses(t *testing.T) {
+	input := bytes.Repeat([]byte("security telemetry "), 100)
+	codec, err := newFrameCodec("")
+	require.NoError(t, err)
+	defer codec.close()
+
+	for _, compression := range []compressionType{compressionLZ4, compressionSnappy, compressionZstd} {
+		encoded, err := codec.encode(frameEncoding{compression: compression}, input)
+		require.NoError(t, err)
+		assert.Less(t, len(encoded), len(input)/4, "compression type %v", compression)
+	}
+}
+
+func TestFrameCodecDecryptFails(t *testing.T) {
+	encoding := frameEncoding{encrypted: true}
+
+	codec, err := newFrameCodec(testEncryptionKey)
+	require.NoError(t, err)
+	encoded, err := codec.encode(encoding, []byte("secret"))
+	require.NoError(t, err)
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := newFrameCodec("fedcba9876543210")
+		require.NoError(t, err)
+		_, err = other.decode(encoding, encoded)
+		assert.Error(t, err)
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		other, err := newFrameCodec("")
+		require.NoError(t, err)
+		_, err = other.decode(encoding, encoded)
+		assert.Error(t, err)
+	})
+
+	t.Run("modified data", func(t *testing.T) {
+		modified := append([]byte{}, encoded...)
+		modified[len(modified)-1] ^= 1
+		_, err := codec.decode(encoding, modified)
+		assert.Error(t, err)
+	})
+
+	t.Run("truncated data", func(t *testing.T) {
+		_, err := codec.decode(encoding, encoded[:10])
+		assert.Error(t, err)
+	})
+}
+
+func TestFrameEncodingOptions(t *testing.T) {
+	for _, compression := range compressionTypes {
+		for _, encrypted := range []bool{false, true} {
+			encoding := frameEncoding{compression: compression, encrypted: encrypted}
+			decoded, err := frameEncodingFromOptions(encoding.options())
+			require.NoError(t, err)
+			assert.Equal(t, encoding, decoded)
+		}
+	}
+
+	_, err := frameEncodingFromOptions(0x42)
+	assert.Error(t, err, "unknown compression type")
+	_, err = frameEncodingFromOptions(1 << 20)
+	assert.Error(t, err, "unknown option flag")
+}
+
+func TestSegmentHeaderVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string, values ...uint32) string {
+		var buf bytes.Buffer
+		for _, v := range values {
+			binary.Write(&buf, binary.LittleEndian, v)
+		}
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+		return path
+	}
+
+	tests := map[string]struct {
+		values   []uint32
+		expected segmentHeader
+	}{
+		"schema 1": {
+			values:   []uint32{1, 5},
+			expected: segmentHeader{version: 1, frameCount: 5},
+		},
+		"schema 2": {
+			values: []uint32{2, 5, uint32(compressionSnappy) | optionsEncrypted},
+			expected: segmentHeader{
+				version:    2,
+				frameCount: 5,
+				encoding:   frameEncoding{compression: compressionSnappy, encrypted: true},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		file, err := os.Open(write(name, test.values...))
+		require.NoError(t, err)
+		header, err := readSegmentHeader(file)
+		file.Close()
+		require.NoError(t, err, name)
+		assert.Equal(t, test.expected, *header, name)
+	}
+
+	file, err := os.Open(write("unknown", 3, 5, 0))
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = readSegmentHeader(file)
+	assert.Error(t, err)
+}
+
+// TestReopenWithDifferentEncoding checks that segments written with one
+// compression setting remain readable after the setting changes.
+func TestReopenWithDifferentEncoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	settings := DefaultSettings()
+	settings.Path = dir
+	settings.Compression = "lz4"
+	settings.EncryptionKey = testEncryptionKey
+
+	event := publisher.Event{Content: beat.Event{Fields: common.MapStr{"message": "hello"}}}
+
+	// Write an event with the first encoding, and close the segment once it
+	// has been written.
+	dq, err := NewQueue(logp.L(), settings)
+	require.NoError(t, err)
+	written := make(chan int, 1)
+	producer := dq.Producer(queue.ProducerConfig{ACK: func(count int) { written <- count }})
+	require.True(t, producer.Publish(event))
+	<-written
+	require.NoError(t, dq.Close())
+
+	// Reopen with a different compression and read it back.
+	settings.Compression = "zstd"
+	dq, err = NewQueue(logp.L(), settings)
+	require.NoError(t, err)
+	defer dq.Close()
+
+	batch, err := dq.Consumer().Get(1)
+	require.NoError(t, err)
+	events := batch.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, event.Content.Fields, events[0].Content.Fields)
+	batch.ACK()
+}
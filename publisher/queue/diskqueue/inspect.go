@@ -0,0 +1,259 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/njcx/libbeat_v7/publisher"
+)
+
+// This file provides offline access to the queue's files on disk, used by
+// tools to inspect and repair a queue. None of these functions may be
+// called while a queue is open on the same directory.
+
+// SegmentInfo describes a segment file in the queue directory.
+type SegmentInfo struct {
+	ID   uint64
+	Path string
+	Size int64
+
+	// The schema version and the frame count from the segment header. The
+	// frame count is 0 if the segment was not closed cleanly.
+	Version    uint32
+	FrameCount uint32
+
+	// The compression and encryption of the data frames.
+	Compression string
+	Encrypted   bool
+
+	header segmentHeader
+}
+
+// Position is the position of the oldest event in the queue that has not
+// been acknowledged yet, as stored in the queue's state file.
+type Position struct {
+	SegmentID  uint64
+	ByteIndex  uint64
+	FrameIndex uint64
+}
+
+// Frame is a data frame read from a segment file.
+type Frame struct {
+	// The index of the frame within its segment.
+	Index uint64
+
+	// The offset of the frame in the segment file and its size on disk,
+	// including the frame header and footer.
+	Offset uint64
+	Size   uint64
+
+	Event publisher.Event
+}
+
+// SegmentScan is the result of reading all frames of a segment.
+type SegmentScan struct {
+	// The number of valid frames in the segment.
+	Frames uint64
+
+	// The size of the segment header plus all valid frames. If the segment is
+	// corrupt, this is the offset of the first invalid frame.
+	ValidSize uint64
+
+	// The reason the first invalid frame could not be read, nil if the whole
+	// segment is valid.
+	Err error
+}
+
+// ReadPosition returns the queue position stored in the state file.
+func ReadPosition(settings Settings) (Position, error) {
+	position, err := queuePositionFromPath(settings.stateFilePath())
+	if err != nil {
+		return Position{}, err
+	}
+	return Position{
+		SegmentID:  uint64(position.segmentID),
+		ByteIndex:  position.byteIndex,
+		FrameIndex: position.frameIndex,
+	}, nil
+}
+
+// ListSegments returns all segment files in the queue directory, ordered
+// by id. Segments with unreadable headers are returned with an error.
+func ListSegments(settings Settings) ([]SegmentInfo, error) {
+	dir := settings.directoryPath()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read queue directory '%s': %w", dir, err)
+	}
+
+	var segments []SegmentInfo
+	var errs []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.EqualFold(filepath.Ext(name), ".seg") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, filepath.Ext(name)), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		segment, err := readSegmentInfo(filepath.Join(dir, name))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("segment %v: %v", id, err))
+			continue
+		}
+		segment.ID = id
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].ID < segments[j].ID })
+
+	if len(errs) > 0 {
+		return segments, errors.New(strings.Join(errs, "; "))
+	}
+	return segments, nil
+}
+
+func readSegmentInfo(path string) (SegmentInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return SegmentInfo{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return SegmentInfo{}, err
+	}
+	header, err := readSegmentHeader(autoRetryReader{file})
+	if err != nil {
+		return SegmentInfo{}, fmt.Errorf("couldn't read segment header: %w", err)
+	}
+
+	return SegmentInfo{
+		Path:        path,
+		Size:        stat.Size(),
+		Version:     header.version,
+		FrameCount:  header.frameCount,
+		Compression: header.encoding.compression.String(),
+		Encrypted:   header.encoding.encrypted,
+		header:      *header,
+	}, nil
+}
+
+// headerSize returns the size of the segment header, which depends on the
+// schema version.
+func (s SegmentInfo) headerSize() uint64 {
+	version := s.Version
+	segment := queueSegment{schemaVersion: &version}
+	return segment.headerSize()
+}
+
+// ScanSegment reads all frames of the segment in order, calling fn for each
+// valid frame. Reading stops at the first invalid frame, which is reported
+// in the returned SegmentScan. An error is returned if the segment can not
+// be read at all, or if fn returns an error.
+func ScanSegment(settings Settings, segment SegmentInfo, fn func(Frame) error) (SegmentScan, error) {
+	if segment.Encrypted && settings.EncryptionKey == "" {
+		return SegmentScan{}, errors.New("segment is encrypted but no encryption key is configured")
+	}
+	codec, err := newFrameCodec(settings.EncryptionKey)
+	if err != nil {
+		return SegmentScan{}, err
+	}
+	defer codec.close()
+
+	file, err := os.Open(segment.Path)
+	if err != nil {
+		return SegmentScan{}, err
+	}
+	defer file.Close()
+
+	offset := segment.headerSize()
+	if _, err := file.Seek(int64(offset), io.SeekStart); err != nil {
+		return SegmentScan{}, err
+	}
+
+	reader := &readerLoop{decoder: newEventDecoder(codec)}
+	reader.decoder.useJSON = segment.Version == 0
+	reader.decoder.encoding = segment.header.encoding
+
+	scan := SegmentScan{ValidSize: offset}
+	for uint64(segment.Size) > scan.ValidSize {
+		frame, err := reader.nextFrame(file, uint64(segment.Size)-scan.ValidSize)
+		if err != nil {
+			scan.Err = err
+			return scan, nil
+		}
+
+		if fn != nil {
+			err = fn(Frame{
+				Index:  scan.Frames,
+				Offset: scan.ValidSize,
+				Size:   frame.bytesOnDisk,
+				Event:  frame.event,
+			})
+			if err != nil {
+				return scan, err
+			}
+		}
+		scan.Frames++
+		scan.ValidSize += frame.bytesOnDisk
+	}
+	return scan, nil
+}
+
+// TruncateSegment removes the invalid data at the end of a segment found by
+// ScanSegment, and updates the frame count in the segment header. Segments
+// without any valid frames are deleted.
+func TruncateSegment(segment SegmentInfo, scan SegmentScan) error {
+	if scan.Frames == 0 {
+		return os.Remove(segment.Path)
+	}
+
+	file, err := os.OpenFile(segment.Path, os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := file.Truncate(int64(scan.ValidSize)); err != nil {
+		return err
+	}
+	// Schema 0 segments have no frame count. Never rewrite the whole header,
+	// its size depends on the schema version of the segment.
+	if segment.Version >= 1 {
+		if _, err := file.Seek(4, io.SeekStart); err != nil {
+			return err
+		}
+		if err := binary.Write(file, binary.LittleEndian, uint32(scan.Frames)); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
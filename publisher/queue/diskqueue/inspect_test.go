@@ -0,0 +1,227 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+)
+
+func TestInspectSegments(t *testing.T) {
+	tests := map[string]func(*Settings){
+		"default": nil,
+		"compressed and encrypted": func(settings *Settings) {
+			settings.Compression = "snappy"
+			settings.EncryptionKey = testEncryptionKey
+		},
+	}
+
+	for name, configure := range tests {
+		configure := configure
+		t.Run(name, func(t *testing.T) {
+			settings := inspectTestSettings(t, configure)
+			writeInspectTestEvents(t, settings, 10)
+
+			segments, err := ListSegments(settings)
+			require.NoError(t, err)
+			require.Len(t, segments, 1)
+			segment := segments[0]
+			assert.Equal(t, uint32(currentSegmentVersion), segment.Version)
+			assert.Equal(t, uint32(10), segment.FrameCount)
+			assert.Equal(t, settings.Compression != "", segment.Compression != "none")
+			assert.Equal(t, settings.EncryptionKey != "", segment.Encrypted)
+
+			var messages []interface{}
+			scan, err := ScanSegment(settings, segment, func(frame Frame) error {
+				assert.Equal(t, uint64(len(messages)), frame.Index)
+				messages = append(messages, frame.Event.Content.Fields["message"])
+				return nil
+			})
+			require.NoError(t, err)
+			assert.NoError(t, scan.Err)
+			assert.Equal(t, uint64(10), scan.Frames)
+			assert.Equal(t, uint64(segment.Size), scan.ValidSize)
+			assert.Equal(t, []interface{}{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, messages)
+		})
+	}
+}
+
+func TestScanEncryptedSegmentWithoutKey(t *testing.T) {
+	settings := inspectTestSettings(t, func(settings *Settings) {
+		settings.EncryptionKey = testEncryptionKey
+	})
+	writeInspectTestEvents(t, settings, 1)
+
+	segments, err := ListSegments(settings)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	settings.EncryptionKey = ""
+	_, err = ScanSegment(settings, segments[0], nil)
+	assert.Error(t, err)
+}
+
+func TestTruncateCorruptSegment(t *testing.T) {
+	settings := inspectTestSettings(t, nil)
+	writeInspectTestEvents(t, settings, 5)
+
+	segments, err := ListSegments(settings)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	segment := segments[0]
+
+	scan, err := ScanSegment(settings, segment, nil)
+	require.NoError(t, err)
+	require.NoError(t, scan.Err)
+	validSize := scan.ValidSize
+
+	// Corrupt the checksum of the last frame and append a partial frame.
+	data, err := ioutil.ReadFile(segment.Path)
+	require.NoError(t, err)
+	data[len(data)-6]++
+	data = append(data, 0xff, 0x01, 0x00)
+	require.NoError(t, ioutil.WriteFile(segment.Path, data, 0600))
+
+	segments, err = ListSegments(settings)
+	require.NoError(t, err)
+	segment = segments[0]
+
+	var lastFrame Frame
+	scan, err = ScanSegment(settings, segment, func(frame Frame) error {
+		lastFrame = frame
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Error(t, scan.Err)
+	assert.Equal(t, uint64(4), scan.Frames)
+	assert.Equal(t, lastFrame.Offset+lastFrame.Size, scan.ValidSize)
+	assert.True(t, scan.ValidSize < validSize)
+
+	require.NoError(t, TruncateSegment(segment, scan))
+
+	segments, err = ListSegments(settings)
+	require.NoError(t, err)
+	segment = segments[0]
+	assert.Equal(t, int64(scan.ValidSize), segment.Size)
+	assert.Equal(t, uint32(4), segment.FrameCount)
+
+	scan, err = ScanSegment(settings, segment, nil)
+	require.NoError(t, err)
+	assert.NoError(t, scan.Err)
+	assert.Equal(t, uint64(4), scan.Frames)
+
+	// The repaired segment must be readable by the queue.
+	dq, err := NewQueue(logp.L(), settings)
+	require.NoError(t, err)
+	defer dq.Close()
+	batch, err := dq.Consumer().Get(4)
+	require.NoError(t, err)
+	assert.Len(t, batch.Events(), 4)
+}
+
+func TestTruncateSegmentWithoutValidFrames(t *testing.T) {
+	settings := inspectTestSettings(t, nil)
+	writeInspectTestEvents(t, settings, 1)
+
+	segments, err := ListSegments(settings)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	segment := segments[0]
+
+	scan := SegmentScan{ValidSize: segment.headerSize()}
+	require.NoError(t, TruncateSegment(segment, scan))
+
+	segments, err = ListSegments(settings)
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+}
+
+func TestListSegmentsInvalidHeader(t *testing.T) {
+	settings := inspectTestSettings(t, nil)
+	writeInspectTestEvents(t, settings, 1)
+	require.NoError(t, ioutil.WriteFile(settings.segmentPath(5), []byte{0xff}, 0600))
+
+	segments, err := ListSegments(settings)
+	assert.Error(t, err)
+	assert.Len(t, segments, 1)
+}
+
+func TestReadPosition(t *testing.T) {
+	settings := inspectTestSettings(t, nil)
+
+	_, err := ReadPosition(settings)
+	assert.True(t, os.IsNotExist(err))
+
+	writeInspectTestEvents(t, settings, 3)
+
+	dq, err := NewQueue(logp.L(), settings)
+	require.NoError(t, err)
+	batch, err := dq.Consumer().Get(2)
+	require.NoError(t, err)
+	require.Len(t, batch.Events(), 2)
+	batch.ACK()
+	require.NoError(t, dq.Close())
+
+	position, err := ReadPosition(settings)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), position.SegmentID)
+	assert.Equal(t, uint64(2), position.FrameIndex)
+}
+
+func inspectTestSettings(t *testing.T, configure func(*Settings)) Settings {
+	dir, err := ioutil.TempDir("", "diskqueue_test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	settings := DefaultSettings()
+	settings.Path = dir
+	if configure != nil {
+		configure(&settings)
+	}
+	return settings
+}
+
+// writeInspectTestEvents writes count events to a new queue and closes it
+// once all of them are on disk.
+func writeInspectTestEvents(t *testing.T, settings Settings, count int) {
+	dq, err := NewQueue(logp.L(), settings)
+	require.NoError(t, err)
+
+	written := make(chan int, count)
+	producer := dq.Producer(queue.ProducerConfig{ACK: func(count int) { written <- count }})
+	for i := 0; i < count; i++ {
+		require.True(t, producer.Publish(publisher.Event{
+			Content: beat.Event{Fields: common.MapStr{"message": fmt.Sprint(i)}},
+		}))
+	}
+	for total := 0; total < count; {
+		total += <-written
+	}
+	require.NoError(t, dq.Close())
+}
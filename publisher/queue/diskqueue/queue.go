@@ -26,6 +26,7 @@ import (
 	"github.com/njcx/libbeat_v7/common"
 	"github.com/njcx/libbeat_v7/feature"
 	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/monitoring"
 	"github.com/njcx/libbeat_v7/publisher/queue"
 )
 
@@ -42,6 +43,10 @@ type diskQueue struct {
 	// frame.
 	acks *diskQueueACKs
 
+	// Compresses / encrypts event data, shared by the producers and the
+	// reader loop.
+	frameCodec *frameCodec
+
 	// The queue's helper loops, each of which is run in its own goroutine.
 	readerLoop  *readerLoop
 	writerLoop  *writerLoop
@@ -82,6 +87,14 @@ type diskQueue struct {
 	// waiting for free space in the queue.
 	blockedProducers []producerWriteRequest
 
+	// The number of events that were already in the queue when it was
+	// opened, excluding those acknowledged in a previous session.
+	initialEventCount int
+
+	// The number of events deleted by the retention policies without being
+	// sent to a consumer (see settings.MaxAge and settings.MaxEvents).
+	evicted monitoring.Uint
+
 	// The channel to signal our goroutines to shut down.
 	done chan struct{}
 }
@@ -123,9 +136,16 @@ func NewQueue(logger *logp.Logger, settings Settings) (*diskQueue, error) {
 				"twice the segment size (%v)",
 			settings.MaxBufferSize, settings.MaxSegmentSize)
 	}
+	if err := validateFrameEncoding(settings.Compression, settings.EncryptionKey); err != nil {
+		return nil, err
+	}
+	frameCodec, err := newFrameCodec(settings.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't initialize disk queue encryption: %w", err)
+	}
 
 	// Create the given directory path if it doesn't exist.
-	err := os.MkdirAll(settings.directoryPath(), os.ModePerm)
+	err = os.MkdirAll(settings.directoryPath(), os.ModePerm)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't create disk queue directory: %w", err)
 	}
@@ -186,6 +206,9 @@ func NewQueue(logger *logp.Logger, settings Settings) (*diskQueue, error) {
 	if len(initialSegments) > 0 && readSegmentID < initialSegments[0].id {
 		nextReadPosition = queuePosition{segmentID: initialSegments[0].id}
 	}
+	if len(initialSegments) > 0 {
+		initialSegments[0].previouslyACKed = nextReadPosition.frameIndex
+	}
 
 	// We can compute the active frames right now but still need a way to report
 	// them to the global beat metrics. For now, just log the total.
@@ -199,6 +222,9 @@ func NewQueue(logger *logp.Logger, settings Settings) (*diskQueue, error) {
 		activeFrameCount += int(segment.frameCount)
 	}
 	activeFrameCount -= int(nextReadPosition.frameIndex)
+	if activeFrameCount < 0 {
+		activeFrameCount = 0
+	}
 	logger.Infof("Found %d existing events on queue start", activeFrameCount)
 
 	queue := &diskQueue{
@@ -214,15 +240,28 @@ func NewQueue(logger *logp.Logger, settings Settings) (*diskQueue, error) {
 
 		acks: newDiskQueueACKs(logger, nextReadPosition, positionFile),
 
-		readerLoop:  newReaderLoop(settings),
+		frameCodec: frameCodec,
+
+		readerLoop:  newReaderLoop(settings, frameCodec),
 		writerLoop:  newWriterLoop(logger, settings),
 		deleterLoop: newDeleterLoop(settings),
 
 		producerWriteRequestChan: make(chan producerWriteRequest),
 
+		initialEventCount: activeFrameCount,
+
 		done: make(chan struct{}),
 	}
 
+	// Drop any events from a previous session that exceed the retention
+	// limits before anything is read. This happens before the core loop
+	// starts so the initial event count is accurate.
+	queue.maybeEvictSegments()
+	queue.initialEventCount -= int(queue.evicted.Get())
+	if queue.initialEventCount < 0 {
+		queue.initialEventCount = 0
+	}
+
 	// We wait for four goroutines on shutdown: core loop, reader loop,
 	// writer loop, deleter loop.
 	queue.waitGroup.Add(4)
@@ -257,6 +296,7 @@ func (dq *diskQueue) Close() error {
 	// shut down the other helper goroutines and wrap everything up.
 	close(dq.done)
 	dq.waitGroup.Wait()
+	dq.frameCodec.close()
 
 	return nil
 }
@@ -269,7 +309,7 @@ func (dq *diskQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
 	return &diskQueueProducer{
 		queue:   dq,
 		config:  cfg,
-		encoder: newEventEncoder(),
+		encoder: newEventEncoder(dq.frameCodec, dq.settings.frameEncoding()),
 		done:    make(chan struct{}),
 	}
 }
@@ -277,3 +317,16 @@ func (dq *diskQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
 func (dq *diskQueue) Consumer() queue.Consumer {
 	return &diskQueueConsumer{queue: dq, done: make(chan struct{})}
 }
+
+// InitialEventCount returns the number of events that were already pending
+// in the queue when it was opened.
+func (dq *diskQueue) InitialEventCount() int {
+	return dq.initialEventCount
+}
+
+// RegisterMetrics reports the number of events dropped by the retention
+// policies as queue.evicted in the pipeline registry.
+func (dq *diskQueue) RegisterMetrics(reg *monitoring.Registry) {
+	reg.Remove("queue.evicted")
+	reg.Add("queue.evicted", &dq.evicted, monitoring.Reported)
+}
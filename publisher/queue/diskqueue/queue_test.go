@@ -72,10 +72,14 @@ func TestProduceConsumer(t *testing.T) {
 		}
 	}
 
-	t.Run("direct", testWith(makeTestQueue()))
+	t.Run("direct", testWith(makeTestQueue(nil)))
+	t.Run("compressed and encrypted", testWith(makeTestQueue(func(settings *Settings) {
+		settings.Compression = "zstd"
+		settings.EncryptionKey = "0123456789abcdef"
+	})))
 }
 
-func makeTestQueue() queuetest.QueueFactory {
+func makeTestQueue(configure func(*Settings)) queuetest.QueueFactory {
 	return func(t *testing.T) queue.Queue {
 		dir, err := ioutil.TempDir("", "diskqueue_test")
 		if err != nil {
@@ -83,6 +87,9 @@ func makeTestQueue() queuetest.QueueFactory {
 		}
 		settings := DefaultSettings()
 		settings.Path = dir
+		if configure != nil {
+			configure(&settings)
+		}
 		queue, _ := NewQueue(logp.L(), settings)
 		return testQueue{
 			diskQueue: queue,
@@ -70,14 +70,14 @@ type readerLoop struct {
 	decoder *eventDecoder
 }
 
-func newReaderLoop(settings Settings) *readerLoop {
+func newReaderLoop(settings Settings, frameCodec *frameCodec) *readerLoop {
 	return &readerLoop{
 		settings: settings,
 
 		requestChan:  make(chan readerLoopRequest, 1),
 		responseChan: make(chan readerLoopResponse),
 		output:       make(chan *readFrame, settings.ReadAheadLimit),
-		decoder:      newEventDecoder(),
+		decoder:      newEventDecoder(frameCodec),
 	}
 }
 
@@ -102,6 +102,7 @@ func (rl *readerLoop) processRequest(request readerLoopRequest) readerLoopRespon
 	// Open the file and seek to the starting position.
 	handle, err := request.segment.getReader(rl.settings)
 	rl.decoder.useJSON = request.segment.shouldUseJSON()
+	rl.decoder.encoding = request.segment.encoding
 	if err != nil {
 		return readerLoopResponse{err: err}
 	}
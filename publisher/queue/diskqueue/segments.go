@@ -27,6 +27,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/njcx/libbeat_v7/logp"
 )
@@ -100,6 +101,11 @@ type queueSegment struct {
 	// the current CBOR.
 	schemaVersion *uint32
 
+	// The compression / encryption applied to the data frames of this
+	// segment. For segments loaded from a previous session it is read from
+	// the segment header, new segments use the current queue settings.
+	encoding frameEncoding
+
 	// The number of bytes occupied by this segment on-disk, as of the most
 	// recent completed writerLoop request.
 	byteCount uint64
@@ -122,10 +128,20 @@ type queueSegment struct {
 	//
 	// Used to count how many frames still need to be acknowledged by consumers.
 	framesRead uint64
+
+	// The number of frames at the beginning of this segment that were
+	// already acknowledged in a previous session. Only the first segment
+	// loaded on startup can have a nonzero value.
+	previouslyACKed uint64
+
+	// The time the most recent frame was written to this segment. For
+	// segments loaded from a previous session, this is the modification
+	// time of the segment file. Used to enforce settings.MaxAge.
+	modTime time.Time
 }
 
 type segmentHeader struct {
-	// The schema version for this segment file. Current schema version is 1.
+	// The schema version for this segment file. Current schema version is 2.
 	version uint32
 
 	// If the segment file has been completely written, this field contains
@@ -134,15 +150,20 @@ type segmentHeader struct {
 	// If the segment file has not been completely written, this field is zero.
 	// Only present in schema version >= 1.
 	frameCount uint32
+
+	// The compression / encryption of the segment's data frames, stored as
+	// a 4-byte options field. Only present in schema version >= 2.
+	encoding frameEncoding
 }
 
-const currentSegmentVersion = 1
+const currentSegmentVersion = 2
 
-// Segment headers are currently a 4-byte version plus a 4-byte frame count.
+// Segment headers are currently a 4-byte version, a 4-byte frame count and
+// 4 bytes of options.
 // In contexts where the segment may have been created by an earlier version,
 // instead use (queueSegment).headerSize() which accounts for the schema
 // version of the target segment.
-const segmentHeaderSize = 8
+const segmentHeaderSize = 12
 
 // Sort order: we store loaded segments in ascending order by their id.
 type bySegmentID []*queueSegment
@@ -183,8 +204,10 @@ func scanExistingSegments(logger *logp.Logger, pathStr string) ([]*queueSegment,
 				segments = append(segments, &queueSegment{
 					id:            segmentID(id),
 					schemaVersion: &header.version,
+					encoding:      header.encoding,
 					frameCount:    header.frameCount,
 					byteCount:     uint64(file.Size()),
+					modTime:       file.ModTime(),
 				})
 			}
 		}
@@ -201,6 +224,10 @@ func (segment *queueSegment) headerSize() uint64 {
 		// Schema 0 had nothing except the 4-byte version.
 		return 4
 	}
+	if segment.schemaVersion != nil && *segment.schemaVersion < 2 {
+		// Schema 1 added the 4-byte frame count.
+		return 8
+	}
 	return segmentHeaderSize
 }
 
@@ -243,7 +270,7 @@ func (segment *queueSegment) getWriter(
 	if err != nil {
 		return nil, err
 	}
-	err = writeSegmentHeader(file, 0)
+	err = writeSegmentHeader(file, segment.encoding, 0)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't write segment header: %w", err)
 	}
@@ -363,13 +390,24 @@ func readSegmentHeader(in io.Reader) (*segmentHeader, error) {
 			return nil, err
 		}
 	}
+	if header.version >= 2 {
+		var options uint32
+		err = binary.Read(in, binary.LittleEndian, &options)
+		if err != nil {
+			return nil, err
+		}
+		header.encoding, err = frameEncodingFromOptions(options)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return header, nil
 }
 
 // writeSegmentHeader seeks to the beginning of the given file handle and
 // writes a segment header with the current schema version, containing the
-// given frameCount.
-func writeSegmentHeader(out *os.File, frameCount uint32) error {
+// given frame encoding and frameCount.
+func writeSegmentHeader(out *os.File, encoding frameEncoding, frameCount uint32) error {
 	_, err := out.Seek(0, io.SeekStart)
 	if err != nil {
 		return err
@@ -381,6 +419,10 @@ func writeSegmentHeader(out *os.File, frameCount uint32) error {
 		return err
 	}
 	err = binary.Write(out, binary.LittleEndian, frameCount)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(out, binary.LittleEndian, encoding.options())
 	return err
 }
 
@@ -402,3 +444,30 @@ func (segments *diskQueueSegments) sizeOnDisk() uint64 {
 	}
 	return total
 }
+
+// The number of events in the queue's segment files that haven't been
+// acknowledged yet, counted at segment granularity: frames of a partly
+// acknowledged segment are included until the whole segment is
+// acknowledged. This should only be called from the core loop.
+func (segments *diskQueueSegments) eventCount() uint64 {
+	total := uint64(0)
+	for _, segment := range segments.writing {
+		total += segment.pendingFrameCount()
+	}
+	for _, segment := range segments.reading {
+		total += segment.pendingFrameCount()
+	}
+	for _, segment := range segments.acking {
+		total += segment.pendingFrameCount()
+	}
+	return total
+}
+
+// pendingFrameCount returns the number of frames in this segment that
+// weren't acknowledged in a previous session.
+func (segment *queueSegment) pendingFrameCount() uint64 {
+	if uint64(segment.frameCount) < segment.previouslyACKed {
+		return 0
+	}
+	return uint64(segment.frameCount) - segment.previouslyACKed
+}
@@ -36,6 +36,11 @@ import (
 type eventEncoder struct {
 	buf    bytes.Buffer
 	folder *gotype.Iterator
+
+	// The serialized events are compressed / encrypted by frameCodec
+	// according to encoding.
+	frameCodec *frameCodec
+	encoding   frameEncoding
 }
 
 type eventDecoder struct {
@@ -48,6 +53,11 @@ type eventDecoder struct {
 	// from old (schema 0) segment files generated by the disk queue beta.
 	useJSON bool
 
+	// The encoding of the current segment, used to decompress / decrypt
+	// the frame data before decoding.
+	frameCodec *frameCodec
+	encoding   frameEncoding
+
 	unfolder *gotype.Unfolder
 }
 
@@ -58,8 +68,8 @@ type entry struct {
 	Fields    common.MapStr
 }
 
-func newEventEncoder() *eventEncoder {
-	e := &eventEncoder{}
+func newEventEncoder(frameCodec *frameCodec, encoding frameEncoding) *eventEncoder {
+	e := &eventEncoder{frameCodec: frameCodec, encoding: encoding}
 	e.reset()
 	return e
 }
@@ -96,16 +106,21 @@ func (e *eventEncoder) encode(event *publisher.Event) ([]byte, error) {
 		return nil, err
 	}
 
-	// Copy the encoded bytes to a new array owned by the caller.
 	bytes := e.buf.Bytes()
+	if e.encoding != (frameEncoding{}) {
+		// The compressed / encrypted data is always in a new array.
+		return e.frameCodec.encode(e.encoding, bytes)
+	}
+
+	// Copy the encoded bytes to a new array owned by the caller.
 	result := make([]byte, len(bytes))
 	copy(result, bytes)
 
 	return result, nil
 }
 
-func newEventDecoder() *eventDecoder {
-	d := &eventDecoder{}
+func newEventDecoder(frameCodec *frameCodec) *eventDecoder {
+	d := &eventDecoder{frameCodec: frameCodec}
 	d.reset()
 	return d
 }
@@ -136,13 +151,21 @@ func (d *eventDecoder) Decode() (publisher.Event, error) {
 		err error
 	)
 
+	data := d.buf
+	if d.encoding != (frameEncoding{}) {
+		data, err = d.frameCodec.decode(d.encoding, data)
+		if err != nil {
+			return publisher.Event{}, err
+		}
+	}
+
 	d.unfolder.SetTarget(&to)
 	defer d.unfolder.Reset()
 
 	if d.useJSON {
-		err = d.jsonParser.Parse(d.buf)
+		err = d.jsonParser.Parse(data)
 	} else {
-		err = d.cborlParser.Parse(d.buf)
+		err = d.cborlParser.Parse(data)
 	}
 
 	if err != nil {
@@ -38,7 +38,7 @@ func TestSerialize(t *testing.T) {
 	}
 
 	for _, test := range testCases {
-		encoder := newEventEncoder()
+		encoder := newEventEncoder(nil, frameEncoding{})
 		event := publisher.Event{
 			Content: beat.Event{
 				Fields: common.MapStr{
@@ -52,7 +52,7 @@ func TestSerialize(t *testing.T) {
 		}
 
 		// Use decoder to decode the serialized bytes.
-		decoder := newEventDecoder()
+		decoder := newEventDecoder(nil)
 		buf := decoder.Buffer(len(serialized))
 		copy(buf, serialized)
 		decoded, err := decoder.Decode()
@@ -112,7 +112,8 @@ func (wl *writerLoop) run() {
 			// The request channel is closed, we are done. If there is an active
 			// segment file, finalize its frame count and close it.
 			if wl.outputFile != nil {
-				writeSegmentHeader(wl.outputFile, wl.currentSegment.frameCount)
+				writeSegmentHeader(wl.outputFile, wl.currentSegment.encoding,
+					wl.currentSegment.frameCount)
 				wl.outputFile.Sync()
 				wl.outputFile.Close()
 				wl.outputFile = nil
@@ -156,7 +157,7 @@ outerLoop:
 			if wl.outputFile != nil {
 				// Update the header with the frame count (including the ones we
 				// just wrote), try to sync to disk, then close the file.
-				writeSegmentHeader(wl.outputFile,
+				writeSegmentHeader(wl.outputFile, wl.currentSegment.encoding,
 					wl.currentSegment.frameCount+curSegmentResponse.framesWritten)
 				wl.outputFile.Sync()
 				wl.outputFile.Close()
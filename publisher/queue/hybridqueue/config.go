@@ -0,0 +1,108 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package hybridqueue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+	"github.com/njcx/libbeat_v7/publisher/queue/diskqueue"
+)
+
+// Settings contains the configuration fields to create a new hybrid queue.
+type Settings struct {
+	// A listener that is sent ACKs when events are acknowledged by the
+	// output, or written to disk if they were spilled.
+	ACKListener queue.ACKListener
+
+	InputQueueSize int
+
+	// Events is the size of the in-memory buffer, as in the memory queue.
+	Events         int
+	FlushMinEvents int
+	FlushTimeout   time.Duration
+
+	// HighWatermark is the number of events held in memory from which new
+	// events are written to disk instead.
+	HighWatermark int
+
+	// Disk contains the settings of the disk queue that events are spilled
+	// to.
+	Disk diskqueue.Settings
+}
+
+// userConfig holds the parameters for a hybrid queue that are configurable
+// by the end user in the beats yml file.
+type userConfig struct {
+	Events         int           `config:"events" validate:"min=32"`
+	FlushMinEvents int           `config:"flush.min_events" validate:"min=0"`
+	FlushTimeout   time.Duration `config:"flush.timeout"`
+
+	HighWatermark float64 `config:"high_watermark"`
+
+	Disk *common.Config `config:"disk" validate:"required"`
+}
+
+var defaultConfig = userConfig{
+	Events:         4 * 1024,
+	FlushMinEvents: 2 * 1024,
+	FlushTimeout:   1 * time.Second,
+	HighWatermark:  0.8,
+}
+
+func (c *userConfig) Validate() error {
+	if c.FlushMinEvents > c.Events {
+		return errors.New("flush.min_events must be less events")
+	}
+	if c.HighWatermark <= 0 || c.HighWatermark > 1 {
+		return fmt.Errorf(
+			"hybrid queue high_watermark (%v) must be greater than 0 and at most 1",
+			c.HighWatermark)
+	}
+	return nil
+}
+
+// SettingsForUserConfig returns a Settings struct initialized with the
+// end-user-configurable settings in the given config tree.
+func SettingsForUserConfig(config *common.Config) (Settings, error) {
+	userConfig := defaultConfig
+	if err := config.Unpack(&userConfig); err != nil {
+		return Settings{}, fmt.Errorf("parsing user config: %w", err)
+	}
+
+	diskSettings, err := diskqueue.SettingsForUserConfig(userConfig.Disk)
+	if err != nil {
+		return Settings{}, fmt.Errorf("disk settings: %w", err)
+	}
+
+	highWatermark := int(float64(userConfig.Events) * userConfig.HighWatermark)
+	if highWatermark < 1 {
+		highWatermark = 1
+	}
+
+	return Settings{
+		Events:         userConfig.Events,
+		FlushMinEvents: userConfig.FlushMinEvents,
+		FlushTimeout:   userConfig.FlushTimeout,
+		HighWatermark:  highWatermark,
+		Disk:           diskSettings,
+	}, nil
+}
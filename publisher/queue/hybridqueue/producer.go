@@ -0,0 +1,210 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package hybridqueue
+
+import (
+	"sync"
+
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+)
+
+// hybridProducer publishes events to the memory queue, or to the disk queue
+// while the hybrid queue is spilling.
+type hybridProducer struct {
+	// The hybrid queue that created this producer.
+	queue *hybridQueue
+
+	// The configuration this producer was created with.
+	config queue.ProducerConfig
+
+	memory queue.Producer
+	disk   queue.Producer
+
+	acks producerACKs
+
+	// ackMutex serializes the ACK callbacks, the memory and disk producers
+	// send ACKs from different goroutines.
+	ackMutex sync.Mutex
+}
+
+// producerACKs merges the ACKs of the memory and disk producers. Events
+// written to disk are acknowledged as soon as they are written, but must
+// not be reported before all earlier events in memory were acknowledged by
+// the output, as ACK counts always refer to the oldest published events.
+type producerACKs struct {
+	mutex sync.Mutex
+
+	// The published events, grouped into consecutive runs with the same
+	// target queue.
+	runs []ackRun
+}
+
+type ackRun struct {
+	toDisk    bool
+	published int
+	acked     int
+}
+
+func newProducer(hq *hybridQueue, cfg queue.ProducerConfig) *hybridProducer {
+	p := &hybridProducer{queue: hq, config: cfg}
+	p.memory = hq.memQueue.Producer(queue.ProducerConfig{
+		ACK:          func(count int) { p.ack(false, count) },
+		OnDrop:       cfg.OnDrop,
+		DropOnCancel: cfg.DropOnCancel,
+	})
+	p.disk = hq.diskQueue.Producer(queue.ProducerConfig{
+		ACK:          func(count int) { p.ack(true, count) },
+		OnDrop:       cfg.OnDrop,
+		DropOnCancel: cfg.DropOnCancel,
+	})
+	return p
+}
+
+//
+// hybridProducer implementation of the queue.Producer interface
+//
+
+func (p *hybridProducer) Publish(event publisher.Event) bool {
+	return p.publish(event, true)
+}
+
+func (p *hybridProducer) TryPublish(event publisher.Event) bool {
+	return p.publish(event, false)
+}
+
+func (p *hybridProducer) publish(event publisher.Event, shouldBlock bool) bool {
+	toDisk := p.queue.acquire()
+	if !toDisk {
+		// The event is added before publishing, the memory queue may send
+		// its ACK before Publish returns.
+		p.acks.add(false)
+		if publishTo(p.memory, event, shouldBlock) {
+			return true
+		}
+		p.acks.remove()
+		p.queue.release(false)
+		if shouldBlock {
+			// A blocking publish only fails if the producer was cancelled.
+			return false
+		}
+		// The memory queue can not accept the event right away, write it
+		// to disk instead of dropping it.
+		p.queue.spill()
+	}
+
+	p.acks.add(true)
+	if publishTo(p.disk, event, shouldBlock) {
+		return true
+	}
+	p.acks.remove()
+	p.queue.release(true)
+	return false
+}
+
+func publishTo(producer queue.Producer, event publisher.Event, shouldBlock bool) bool {
+	if shouldBlock {
+		return producer.Publish(event)
+	}
+	return producer.TryPublish(event)
+}
+
+func (p *hybridProducer) Cancel() int {
+	removed := p.memory.Cancel()
+	p.queue.removeInMemory(removed)
+	return removed + p.disk.Cancel()
+}
+
+// ack reports the ACKs of the memory or disk producer to the producer's
+// ACK callback and the queue's ACK listener, in publish order.
+func (p *hybridProducer) ack(toDisk bool, count int) {
+	p.ackMutex.Lock()
+	defer p.ackMutex.Unlock()
+
+	count = p.acks.ack(toDisk, count)
+	if count == 0 {
+		return
+	}
+	if p.config.ACK != nil {
+		p.config.ACK(count)
+	}
+	if listener := p.queue.settings.ACKListener; listener != nil {
+		listener.OnACK(count)
+	}
+}
+
+// add records a new event published to the given queue.
+func (a *producerACKs) add(toDisk bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if last := len(a.runs) - 1; last >= 0 && a.runs[last].toDisk == toDisk {
+		a.runs[last].published++
+		return
+	}
+	a.runs = append(a.runs, ackRun{toDisk: toDisk, published: 1})
+}
+
+// remove removes the last event recorded by add, if it could not be
+// published.
+func (a *producerACKs) remove() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	last := len(a.runs) - 1
+	a.runs[last].published--
+	if a.runs[last].published == 0 {
+		a.runs = a.runs[:last]
+	}
+}
+
+// ack records count ACKs from the given queue, and returns the number of
+// events that can be reported as acknowledged in publish order.
+func (a *producerACKs) ack(toDisk bool, count int) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for i := range a.runs {
+		if count == 0 {
+			break
+		}
+		run := &a.runs[i]
+		if run.toDisk != toDisk {
+			continue
+		}
+		n := run.published - run.acked
+		if n > count {
+			n = count
+		}
+		run.acked += n
+		count -= n
+	}
+
+	released := 0
+	for len(a.runs) > 0 {
+		run := &a.runs[0]
+		released += run.acked
+		run.published -= run.acked
+		run.acked = 0
+		if run.published > 0 {
+			break
+		}
+		a.runs = a.runs[1:]
+	}
+	return released
+}
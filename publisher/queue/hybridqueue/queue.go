@@ -0,0 +1,363 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package hybridqueue
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/joeshaw/multierror"
+
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/feature"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/monitoring"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+	"github.com/njcx/libbeat_v7/publisher/queue/diskqueue"
+	"github.com/njcx/libbeat_v7/publisher/queue/memqueue"
+)
+
+// hybridQueue is a queue.Queue that buffers events in a memory queue, and
+// writes them to a disk queue only while the memory queue is filled above
+// its high watermark, for example because the output is unavailable.
+//
+// Consumers always read from the memory queue. Events written to disk are
+// moved back into the memory queue by the forwarder as space becomes
+// available, and are only removed from disk once the output acknowledged
+// them. While any spilled events are left on disk, new events are written
+// to disk as well, so that events are delivered in order.
+type hybridQueue struct {
+	logger   *logp.Logger
+	settings Settings
+
+	memQueue  queue.Queue
+	diskQueue queue.Queue
+
+	// The forwarder reads events from the disk queue with forwardConsumer
+	// and publishes them to the memory queue with forwardProducer.
+	forwardConsumer queue.Consumer
+	forwardProducer queue.Producer
+	forwardBatches  forwardBatches
+
+	// mutex protects inMemory, onDisk and spilling.
+	mutex sync.Mutex
+
+	// The number of events published to the memory queue that were not
+	// acknowledged by the output yet.
+	inMemory int
+
+	// The number of events published to the disk queue that were not moved
+	// to the memory queue yet.
+	onDisk int
+
+	// spilling is true while onDisk is positive, it is used to log the start
+	// and end of spilling only once.
+	spilling bool
+
+	// Wait group for shutdown of the forwarder.
+	waitGroup sync.WaitGroup
+}
+
+// forwardBatches tracks the disk queue batches forwarded to the memory
+// queue, which are acknowledged once all their events are acknowledged by
+// the output.
+type forwardBatches struct {
+	mutex   sync.Mutex
+	pending []forwardBatch
+}
+
+type forwardBatch struct {
+	batch     queue.Batch
+	remaining int
+}
+
+// memoryACKListener updates the number of events in memory when the memory
+// queue receives ACKs from the output.
+type memoryACKListener struct {
+	queue *hybridQueue
+}
+
+// diskEvictionListener updates the number of events on disk when the disk
+// queue drops events because of its retention policies.
+type diskEvictionListener struct {
+	queue *hybridQueue
+}
+
+func init() {
+	queue.RegisterQueueType(
+		"hybrid",
+		queueFactory,
+		feature.MakeDetails(
+			"Hybrid queue",
+			"Buffer events in memory, spilling them to disk under backpressure.",
+			feature.Beta))
+}
+
+// queueFactory matches the queue.Factory interface, and is used to add the
+// hybrid queue to the registry.
+func queueFactory(
+	ackListener queue.ACKListener, logger *logp.Logger, cfg *common.Config, inQueueSize int,
+) (queue.Queue, error) {
+	settings, err := SettingsForUserConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("hybrid queue couldn't load user config: %w", err)
+	}
+	settings.ACKListener = ackListener
+	settings.InputQueueSize = inQueueSize
+	return NewQueue(logger, settings)
+}
+
+// NewQueue returns a hybrid queue configured with the given logger and
+// settings. Events left on disk by a previous session are delivered before
+// any new events.
+func NewQueue(logger *logp.Logger, settings Settings) (queue.Queue, error) {
+	if logger == nil {
+		logger = logp.L()
+	}
+	if settings.HighWatermark <= 0 || settings.HighWatermark > settings.Events {
+		return nil, fmt.Errorf(
+			"hybrid queue high watermark (%v) must be between 1 and the number of events (%v)",
+			settings.HighWatermark, settings.Events)
+	}
+
+	// Spilled events are reported to the ACK listener by the producers, in
+	// the order they were published.
+	settings.Disk.WriteToDiskListener = nil
+	hq := &hybridQueue{
+		logger:   logger.Named("hybridqueue"),
+		settings: settings,
+	}
+	settings.Disk.EvictionListener = diskEvictionListener{queue: hq}
+	diskQueue, err := diskqueue.NewQueue(logger, settings.Disk)
+	if err != nil {
+		return nil, err
+	}
+	hq.diskQueue = diskQueue
+	hq.onDisk = diskQueue.InitialEventCount()
+	hq.memQueue = memqueue.NewQueue(logger, memqueue.Settings{
+		ACKListener:    memoryACKListener{queue: hq},
+		Events:         settings.Events,
+		FlushMinEvents: settings.FlushMinEvents,
+		FlushTimeout:   settings.FlushTimeout,
+		WaitOnClose:    true,
+		InputQueueSize: settings.InputQueueSize,
+	})
+	hq.forwardConsumer = diskQueue.Consumer()
+	hq.forwardProducer = hq.memQueue.Producer(queue.ProducerConfig{
+		ACK: hq.forwardBatches.ack,
+	})
+
+	if hq.onDisk > 0 {
+		hq.spilling = true
+		hq.logger.Infof("Delivering %d events left on disk before new events", hq.onDisk)
+	}
+
+	hq.waitGroup.Add(1)
+	go func() {
+		defer hq.waitGroup.Done()
+		hq.forward()
+	}()
+
+	return hq, nil
+}
+
+//
+// hybridQueue implementation of the queue.Queue interface
+//
+
+func (hq *hybridQueue) Close() error {
+	// Stop the forwarder first: events it did not publish yet stay on disk.
+	hq.forwardConsumer.Close()
+	hq.forwardProducer.Cancel()
+	hq.waitGroup.Wait()
+
+	var errs multierror.Errors
+	if err := hq.memQueue.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("memory queue: %w", err))
+	}
+	if err := hq.diskQueue.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("disk queue: %w", err))
+	}
+	return errs.Err()
+}
+
+func (hq *hybridQueue) BufferConfig() queue.BufferConfig {
+	return queue.BufferConfig{MaxEvents: 0}
+}
+
+func (hq *hybridQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
+	return newProducer(hq, cfg)
+}
+
+func (hq *hybridQueue) Consumer() queue.Consumer {
+	return hq.memQueue.Consumer()
+}
+
+// RegisterMetrics reports the metrics of the disk queue, such as the number
+// of events dropped by its retention policies.
+func (hq *hybridQueue) RegisterMetrics(reg *monitoring.Registry) {
+	if reporter, ok := hq.diskQueue.(queue.MetricsReporter); ok {
+		reporter.RegisterMetrics(reg)
+	}
+}
+
+// forward moves events from the disk queue to the memory queue until the
+// queue is closed. Publishing to the memory queue blocks while it is full,
+// so the forwarder only reads from disk as fast as the output consumes.
+func (hq *hybridQueue) forward() {
+	for {
+		batch, err := hq.forwardConsumer.Get(hq.settings.Disk.ReadAheadLimit)
+		if err != nil {
+			// The consumer was closed.
+			return
+		}
+		events := batch.Events()
+		hq.forwardBatches.add(batch, len(events))
+
+		for _, event := range events {
+			hq.mutex.Lock()
+			hq.inMemory++
+			hq.mutex.Unlock()
+
+			if !hq.forwardProducer.Publish(event) {
+				// The queue is closing, the remaining events of the batch are
+				// read from disk again on the next start.
+				hq.release(false)
+				return
+			}
+			hq.forwarded()
+		}
+	}
+}
+
+// acquire reserves space for a new event, and returns true if the event
+// should be written to disk.
+func (hq *hybridQueue) acquire() bool {
+	hq.mutex.Lock()
+	defer hq.mutex.Unlock()
+
+	if hq.onDisk == 0 && hq.inMemory < hq.settings.HighWatermark {
+		hq.inMemory++
+		return false
+	}
+	hq.addOnDisk()
+	return true
+}
+
+// spill reserves space on disk for an event that could not be published to
+// the memory queue.
+func (hq *hybridQueue) spill() {
+	hq.mutex.Lock()
+	defer hq.mutex.Unlock()
+	hq.addOnDisk()
+}
+
+// addOnDisk must be called with the mutex held.
+func (hq *hybridQueue) addOnDisk() {
+	hq.onDisk++
+	if !hq.spilling {
+		hq.spilling = true
+		hq.logger.Infof(
+			"Memory buffer reached %d events, spilling new events to disk",
+			hq.inMemory)
+	}
+}
+
+// release frees the space reserved for an event that was not published.
+func (hq *hybridQueue) release(toDisk bool) {
+	hq.mutex.Lock()
+	defer hq.mutex.Unlock()
+
+	if toDisk {
+		hq.removeOnDisk(1)
+	} else {
+		hq.inMemory--
+	}
+}
+
+// forwarded is called when an event was moved from disk to memory.
+func (hq *hybridQueue) forwarded() {
+	hq.mutex.Lock()
+	defer hq.mutex.Unlock()
+	hq.removeOnDisk(1)
+}
+
+// evicted is called when the disk queue dropped events because of its
+// retention policies.
+func (hq *hybridQueue) evicted(count int) {
+	hq.mutex.Lock()
+	defer hq.mutex.Unlock()
+	hq.removeOnDisk(count)
+}
+
+// removeOnDisk must be called with the mutex held.
+func (hq *hybridQueue) removeOnDisk(count int) {
+	hq.onDisk -= count
+	if hq.onDisk < 0 {
+		hq.onDisk = 0
+	}
+	if hq.onDisk == 0 && hq.spilling {
+		hq.spilling = false
+		hq.logger.Info("All events on disk were moved to memory, stopped spilling")
+	}
+}
+
+// removeInMemory is called when events left the memory queue, because they
+// were acknowledged by the output or dropped when their producer was
+// cancelled.
+func (hq *hybridQueue) removeInMemory(count int) {
+	hq.mutex.Lock()
+	defer hq.mutex.Unlock()
+	hq.inMemory -= count
+}
+
+func (l memoryACKListener) OnACK(count int) {
+	l.queue.removeInMemory(count)
+}
+
+func (l diskEvictionListener) OnACK(count int) {
+	l.queue.evicted(count)
+}
+
+func (fb *forwardBatches) add(batch queue.Batch, count int) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.pending = append(fb.pending, forwardBatch{batch: batch, remaining: count})
+}
+
+// ack is called by the memory queue with the number of forwarded events
+// acknowledged by the output, in the order they were forwarded.
+func (fb *forwardBatches) ack(count int) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+
+	for count > 0 && len(fb.pending) > 0 {
+		current := &fb.pending[0]
+		n := count
+		if n > current.remaining {
+			n = current.remaining
+		}
+		current.remaining -= n
+		count -= n
+
+		if current.remaining == 0 {
+			current.batch.ACK()
+			fb.pending = fb.pending[1:]
+		}
+	}
+}
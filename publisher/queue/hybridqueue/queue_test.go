@@ -0,0 +1,246 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package hybridqueue
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+	"github.com/njcx/libbeat_v7/publisher/queue/diskqueue"
+	"github.com/njcx/libbeat_v7/publisher/queue/queuetest"
+)
+
+var seed int64
+
+type testQueue struct {
+	queue.Queue
+	teardown func()
+}
+
+func init() {
+	flag.Int64Var(&seed, "seed", time.Now().UnixNano(), "test random seed")
+}
+
+func TestProduceConsumer(t *testing.T) {
+	maxEvents := 1024
+	minEvents := 32
+
+	rand.Seed(seed)
+	events := rand.Intn(maxEvents-minEvents) + minEvents
+	batchSize := rand.Intn(events-8) + 4
+	bufferSize := rand.Intn(batchSize*2) + 4
+
+	t.Log("seed: ", seed)
+	t.Log("events: ", events)
+	t.Log("batchSize: ", batchSize)
+	t.Log("bufferSize: ", bufferSize)
+
+	testWith := func(factory queuetest.QueueFactory) func(t *testing.T) {
+		return func(t *testing.T) {
+			t.Run("single", func(t *testing.T) {
+				t.Parallel()
+				queuetest.TestSingleProducerConsumer(t, events, batchSize, factory)
+			})
+			t.Run("multi", func(t *testing.T) {
+				t.Parallel()
+				queuetest.TestMultiProducerConsumer(t, events, batchSize, factory)
+			})
+		}
+	}
+
+	t.Run("memory", testWith(makeTestQueue(bufferSize, bufferSize)))
+	t.Run("spill", testWith(makeTestQueue(bufferSize, 1)))
+}
+
+func TestSpillPreservesOrder(t *testing.T) {
+	settings := testSettings(t, 64, 8)
+	q, err := NewQueue(logp.L(), settings)
+	require.NoError(t, err)
+	defer q.Close()
+
+	const count = 200
+	acked := make(chan int, count)
+	producer := q.Producer(queue.ProducerConfig{ACK: func(n int) { acked <- n }})
+	for i := 0; i < count; i++ {
+		require.True(t, producer.Publish(makeEvent(i)))
+	}
+
+	// The memory buffer is too small for all events, so some were spilled.
+	hq := q.(*hybridQueue)
+	hq.mutex.Lock()
+	spilled := hq.onDisk > 0 || hq.inMemory > settings.HighWatermark
+	hq.mutex.Unlock()
+	assert.True(t, spilled)
+
+	consumer := q.Consumer()
+	for i := 0; i < count; {
+		batch, err := consumer.Get(16)
+		require.NoError(t, err)
+		for _, event := range batch.Events() {
+			require.Equal(t, fmt.Sprint(i), event.Content.Fields["message"])
+			i++
+		}
+		batch.ACK()
+	}
+
+	waitACKs(t, acked, count)
+}
+
+func TestEventsOnDiskAreDeliveredFirst(t *testing.T) {
+	settings := testSettings(t, 64, 1)
+
+	// The first event is kept in memory, all other events are spilled.
+	q, err := NewQueue(logp.L(), settings)
+	require.NoError(t, err)
+	acked := make(chan int, 20)
+	producer := q.Producer(queue.ProducerConfig{ACK: func(n int) { acked <- n }})
+	for i := 0; i < 20; i++ {
+		require.True(t, producer.Publish(makeEvent(i)))
+	}
+	batch, err := q.Consumer().Get(1)
+	require.NoError(t, err)
+	require.Len(t, batch.Events(), 1)
+	batch.ACK()
+
+	// Once the events on disk are reported, they are written to disk.
+	waitACKs(t, acked, 20)
+	require.NoError(t, q.Close())
+
+	q, err = NewQueue(logp.L(), settings)
+	require.NoError(t, err)
+	defer q.Close()
+
+	producer = q.Producer(queue.ProducerConfig{})
+	require.True(t, producer.Publish(makeEvent(20)))
+
+	consumer := q.Consumer()
+	for i := 1; i <= 20; {
+		batch, err := consumer.Get(0)
+		require.NoError(t, err)
+		for _, event := range batch.Events() {
+			require.Equal(t, fmt.Sprint(i), event.Content.Fields["message"])
+			i++
+		}
+		batch.ACK()
+	}
+}
+
+func TestProducerACKsInPublishOrder(t *testing.T) {
+	var acks producerACKs
+	acks.add(false)
+	acks.add(false)
+	acks.add(true)
+	acks.add(true)
+	acks.add(false)
+	acks.add(true)
+	acks.remove()
+
+	// Disk ACKs must wait for the earlier memory events.
+	assert.Equal(t, 0, acks.ack(true, 2))
+	assert.Equal(t, 1, acks.ack(false, 1))
+	assert.Equal(t, 3, acks.ack(false, 1))
+	assert.Equal(t, 1, acks.ack(false, 1))
+	assert.Empty(t, acks.runs)
+}
+
+func TestHighWatermarkConfig(t *testing.T) {
+	for _, highWatermark := range []float64{0, -0.5, 1.5} {
+		_, err := SettingsForUserConfig(common.MustNewConfigFrom(map[string]interface{}{
+			"high_watermark": highWatermark,
+			"disk.max_size":  "1GB",
+		}))
+		assert.Error(t, err, "high_watermark: %v", highWatermark)
+	}
+
+	settings, err := SettingsForUserConfig(common.MustNewConfigFrom(map[string]interface{}{
+		"events":         100,
+		"high_watermark": 0.5,
+		"disk.max_size":  "1GB",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, 50, settings.HighWatermark)
+}
+
+func makeTestQueue(events, highWatermark int) queuetest.QueueFactory {
+	return func(t *testing.T) queue.Queue {
+		settings := testSettings(t, events, highWatermark)
+		q, err := NewQueue(logp.L(), settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testQueue{
+			Queue: q,
+			teardown: func() {
+				os.RemoveAll(settings.Disk.Path)
+			},
+		}
+	}
+}
+
+func (t testQueue) Close() error {
+	err := t.Queue.Close()
+	t.teardown()
+	return err
+}
+
+func testSettings(t *testing.T, events, highWatermark int) Settings {
+	dir, err := ioutil.TempDir("", "hybridqueue_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	settings := Settings{
+		Events:        events,
+		HighWatermark: highWatermark,
+		Disk:          diskqueue.DefaultSettings(),
+	}
+	settings.Disk.Path = dir
+	return settings
+}
+
+func makeEvent(i int) publisher.Event {
+	return publisher.Event{
+		Content: beat.Event{Fields: common.MapStr{"message": fmt.Sprint(i)}},
+	}
+}
+
+func waitACKs(t *testing.T, acked chan int, count int) {
+	timeout := time.After(10 * time.Second)
+	for total := 0; total < count; {
+		select {
+		case n := <-acked:
+			total += n
+		case <-timeout:
+			t.Fatalf("timed out waiting for ACKs, got %d of %d", total, count)
+		}
+	}
+}
@@ -62,6 +62,18 @@ type Settings struct {
 	FlushTimeout   time.Duration
 	WaitOnClose    bool
 	InputQueueSize int
+
+	// Lanes enables priority lanes if set. Each lane buffers its events
+	// separately, using the flush settings above.
+	Lanes []Lane
+
+	// PriorityField is the @metadata field holding the name of an event's
+	// lane. It takes precedence over the lane conditions.
+	PriorityField string
+
+	// DefaultLane is the name of the lane of events not assigned to another
+	// lane. Defaults to the last lane.
+	DefaultLane string
 }
 
 type ackChan struct {
@@ -91,7 +103,8 @@ func create(
 	ackListener queue.ACKListener, logger *logp.Logger, cfg *common.Config, inQueueSize int,
 ) (queue.Queue, error) {
 	config := defaultConfig
-	if err := cfg.Unpack(&config); err != nil {
+	err := cfg.Unpack(&config)
+	if err != nil {
 		return nil, err
 	}
 
@@ -99,22 +112,44 @@ func create(
 		logger = logp.L()
 	}
 
-	return NewQueue(logger, Settings{
+	settings := Settings{
 		ACKListener:    ackListener,
 		Events:         config.Events,
 		FlushMinEvents: config.FlushMinEvents,
 		FlushTimeout:   config.FlushTimeout,
 		InputQueueSize: inQueueSize,
-	}), nil
+	}
+	if config.Priority != nil {
+		settings.PriorityField = config.Priority.Field
+		settings.DefaultLane = config.Priority.Default
+		settings.Lanes, err = lanesFromConfig(config.Events, config.Priority.Lanes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewQueue(logger, settings), nil
 }
 
 // NewQueue creates a new broker based in-memory queue holding up to sz number of events.
 // If waitOnClose is set to true, the broker will block on Close, until all internal
 // workers handling incoming messages and ACKs have been shut down.
+// If settings.Lanes is set, a queue with one broker per priority lane is
+// returned.
 func NewQueue(
 	logger logger,
 	settings Settings,
 ) queue.Queue {
+	if len(settings.Lanes) > 0 {
+		return newPriorityQueue(logger, settings)
+	}
+	return newBroker(logger, settings)
+}
+
+func newBroker(
+	logger logger,
+	settings Settings,
+) *broker {
 	var (
 		sz           = settings.Events
 		minEvents    = settings.FlushMinEvents
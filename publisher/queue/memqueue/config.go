@@ -19,13 +19,39 @@ package memqueue
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/njcx/libbeat_v7/conditions"
 )
 
 type config struct {
 	Events         int           `config:"events" validate:"min=32"`
 	FlushMinEvents int           `config:"flush.min_events" validate:"min=0"`
 	FlushTimeout   time.Duration `config:"flush.timeout"`
+
+	Priority *priorityConfig `config:"priority"`
+}
+
+// priorityConfig configures the priority lanes of the queue.
+type priorityConfig struct {
+	// Field is the @metadata field holding the name of an event's lane.
+	Field string `config:"field"`
+
+	// Default is the lane of events not assigned to another lane, the last
+	// lane by default.
+	Default string `config:"default"`
+
+	Lanes []laneConfig `config:"lanes" validate:"required"`
+}
+
+type laneConfig struct {
+	Name       string             `config:"name" validate:"required"`
+	Weight     int                `config:"weight" validate:"min=0"`
+	Events     int                `config:"events" validate:"min=0"`
+	When       *conditions.Config `config:"when"`
+	DropPolicy string             `config:"drop_policy"`
 }
 
 var defaultConfig = config{
@@ -39,5 +65,32 @@ func (c *config) Validate() error {
 		return errors.New("flush.min_events must be less events")
 	}
 
+	if c.Priority != nil {
+		return c.Priority.Validate()
+	}
+	return nil
+}
+
+func (c *priorityConfig) Validate() error {
+	names := map[string]bool{}
+	for _, lane := range c.Lanes {
+		if strings.Contains(lane.Name, ".") {
+			return fmt.Errorf("priority lane name '%v' must not contain dots", lane.Name)
+		}
+		if names[lane.Name] {
+			return fmt.Errorf("duplicate priority lane '%v'", lane.Name)
+		}
+		names[lane.Name] = true
+
+		if _, ok := dropPolicies[lane.DropPolicy]; !ok {
+			return fmt.Errorf(
+				"invalid drop_policy '%v' for priority lane '%v', must be drop or block",
+				lane.DropPolicy, lane.Name)
+		}
+	}
+
+	if c.Default != "" && !names[c.Default] {
+		return fmt.Errorf("default priority lane '%v' is not configured", c.Default)
+	}
 	return nil
 }
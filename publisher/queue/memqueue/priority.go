@@ -0,0 +1,479 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package memqueue
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common/atomic"
+	"github.com/njcx/libbeat_v7/conditions"
+	"github.com/njcx/libbeat_v7/monitoring"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+)
+
+// Lane configures a priority lane of the memory queue.
+type Lane struct {
+	Name string
+
+	// Weight is the share of batches read from the lane while other lanes
+	// have events available as well.
+	Weight int
+
+	// Events is the number of events the lane can buffer.
+	Events int
+
+	// Condition assigns events to the lane. Lanes are checked in order, the
+	// first lane whose condition matches is used.
+	Condition conditions.Condition
+
+	// BlockIfFull makes producers block if the lane is full, even if they
+	// would drop events otherwise (see beat.DropIfFull).
+	BlockIfFull bool
+}
+
+// dropPolicies maps the drop_policy setting of a lane to Lane.BlockIfFull.
+var dropPolicies = map[string]bool{
+	"":      false,
+	"drop":  false,
+	"block": true,
+}
+
+// priorityQueue implements queue.Queue with one broker per priority lane.
+// Producers assign each event to a lane, and consumers read batches from
+// the lanes with events available, using smooth weighted round-robin.
+type priorityQueue struct {
+	lanes       []*lane
+	lanesByName map[string]*lane
+	defaultLane *lane
+	field       string
+	totalWeight int
+}
+
+type lane struct {
+	Lane
+	index  int
+	broker *broker
+
+	published monitoring.Uint
+	dropped   monitoring.Uint
+	acked     monitoring.Uint
+}
+
+// laneACKListener counts the ACKs of a lane, and forwards them to the
+// queue's ACK listener.
+type laneACKListener struct {
+	lane     *lane
+	listener queue.ACKListener
+}
+
+type priorityProducer struct {
+	queue  *priorityQueue
+	config queue.ProducerConfig
+
+	// One producer per lane.
+	producers []queue.Producer
+
+	acks laneACKs
+
+	// ackMutex serializes the ACK callbacks, the lanes send ACKs from
+	// different goroutines.
+	ackMutex sync.Mutex
+}
+
+// laneACKs merges the ACKs of a producer's lanes. ACK counts always refer
+// to the oldest published events, so a lane's ACKs are only reported once
+// all events published to other lanes before them were ACKed.
+type laneACKs struct {
+	mutex sync.Mutex
+
+	// The published events, grouped into consecutive runs with the same
+	// lane.
+	runs []laneRun
+}
+
+type laneRun struct {
+	lane      int
+	published int
+	acked     int
+}
+
+type priorityConsumer struct {
+	queue *priorityQueue
+	resp  chan getResponse
+
+	// credits holds the current weight of each lane for smooth weighted
+	// round-robin.
+	credits []int
+
+	done   chan struct{}
+	closed atomic.Bool
+}
+
+func lanesFromConfig(events int, configs []laneConfig) ([]Lane, error) {
+	lanes := make([]Lane, len(configs))
+	for i, config := range configs {
+		lane := Lane{
+			Name:        config.Name,
+			Weight:      config.Weight,
+			Events:      config.Events,
+			BlockIfFull: dropPolicies[config.DropPolicy],
+		}
+		if lane.Weight == 0 {
+			lane.Weight = 1
+		}
+		if lane.Events == 0 {
+			// Lanes without explicit size share the queue size.
+			lane.Events = events / len(configs)
+		}
+		if config.When != nil {
+			var err error
+			lane.Condition, err = conditions.NewCondition(config.When)
+			if err != nil {
+				return nil, fmt.Errorf("priority lane '%v': %v", config.Name, err)
+			}
+		}
+		lanes[i] = lane
+	}
+	return lanes, nil
+}
+
+func newPriorityQueue(logger logger, settings Settings) *priorityQueue {
+	pq := &priorityQueue{
+		lanesByName: map[string]*lane{},
+		field:       settings.PriorityField,
+	}
+
+	for i, config := range settings.Lanes {
+		l := &lane{Lane: config, index: i}
+		if l.Weight <= 0 {
+			l.Weight = 1
+		}
+
+		laneSettings := settings
+		laneSettings.Lanes = nil
+		laneSettings.Events = l.Events
+		laneSettings.ACKListener = laneACKListener{lane: l, listener: settings.ACKListener}
+		l.broker = newBroker(logger, laneSettings)
+
+		pq.lanes = append(pq.lanes, l)
+		pq.lanesByName[l.Name] = l
+		pq.totalWeight += l.Weight
+	}
+
+	pq.defaultLane = pq.lanes[len(pq.lanes)-1]
+	if l, ok := pq.lanesByName[settings.DefaultLane]; ok {
+		pq.defaultLane = l
+	}
+	return pq
+}
+
+func (pq *priorityQueue) Close() error {
+	for _, l := range pq.lanes {
+		l.broker.Close()
+	}
+	return nil
+}
+
+func (pq *priorityQueue) BufferConfig() queue.BufferConfig {
+	maxEvents := 0
+	for _, l := range pq.lanes {
+		maxEvents += l.broker.bufSize
+	}
+	return queue.BufferConfig{MaxEvents: maxEvents}
+}
+
+func (pq *priorityQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
+	p := &priorityProducer{
+		queue:     pq,
+		config:    cfg,
+		producers: make([]queue.Producer, len(pq.lanes)),
+	}
+	for i, l := range pq.lanes {
+		var ackCB ackHandler
+		if cfg.ACK != nil {
+			index := i
+			ackCB = func(count int) { p.ack(index, count) }
+		}
+		p.producers[i] = newProducer(l.broker, ackCB, cfg.OnDrop, cfg.DropOnCancel)
+	}
+	return p
+}
+
+func (pq *priorityQueue) Consumer() queue.Consumer {
+	return &priorityConsumer{
+		queue:   pq,
+		resp:    make(chan getResponse),
+		credits: make([]int, len(pq.lanes)),
+		done:    make(chan struct{}),
+	}
+}
+
+// RegisterMetrics reports the metrics of each lane under queue.lanes in the
+// pipeline registry.
+func (pq *priorityQueue) RegisterMetrics(reg *monitoring.Registry) {
+	reg.Remove("queue.lanes")
+	lanesReg := reg.NewRegistry("queue.lanes")
+	for _, l := range pq.lanes {
+		laneReg := lanesReg.NewRegistry(l.Name)
+		monitoring.NewUint(laneReg, "max_events").Set(uint64(l.broker.bufSize))
+		monitoring.NewUint(laneReg, "weight").Set(uint64(l.Weight))
+		laneReg.Add("published", &l.published, monitoring.Reported)
+		laneReg.Add("dropped", &l.dropped, monitoring.Reported)
+		laneReg.Add("acked", &l.acked, monitoring.Reported)
+	}
+}
+
+// selectLane returns the lane of an event: the lane named in its priority
+// metadata field, else the first lane whose condition matches, else the
+// default lane.
+func (pq *priorityQueue) selectLane(event *beat.Event) *lane {
+	if pq.field != "" {
+		if v, err := event.Meta.GetValue(pq.field); err == nil {
+			if name, ok := v.(string); ok {
+				if l, ok := pq.lanesByName[name]; ok {
+					return l
+				}
+			}
+		}
+	}
+	for _, l := range pq.lanes {
+		if l.Condition != nil && l.Condition.Check(event) {
+			return l
+		}
+	}
+	return pq.defaultLane
+}
+
+func (l laneACKListener) OnACK(count int) {
+	l.lane.acked.Add(uint64(count))
+	if l.listener != nil {
+		l.listener.OnACK(count)
+	}
+}
+
+func (p *priorityProducer) Publish(event publisher.Event) bool {
+	return p.publish(event, true)
+}
+
+func (p *priorityProducer) TryPublish(event publisher.Event) bool {
+	return p.publish(event, false)
+}
+
+func (p *priorityProducer) publish(event publisher.Event, shouldBlock bool) bool {
+	l := p.queue.selectLane(&event.Content)
+	producer := p.producers[l.index]
+
+	// The event is added before publishing, the lane may send its ACK
+	// before Publish returns.
+	if p.config.ACK != nil {
+		p.acks.add(l.index)
+	}
+
+	var ok bool
+	if shouldBlock || l.BlockIfFull {
+		ok = producer.Publish(event)
+	} else {
+		ok = producer.TryPublish(event)
+	}
+	if ok {
+		l.published.Inc()
+		return true
+	}
+
+	if p.config.ACK != nil {
+		p.acks.remove()
+	}
+	if !shouldBlock {
+		l.dropped.Inc()
+	}
+	return false
+}
+
+func (p *priorityProducer) Cancel() int {
+	removed := 0
+	for _, producer := range p.producers {
+		removed += producer.Cancel()
+	}
+	return removed
+}
+
+func (p *priorityProducer) ack(lane, count int) {
+	p.ackMutex.Lock()
+	defer p.ackMutex.Unlock()
+
+	if count = p.acks.ack(lane, count); count > 0 {
+		p.config.ACK(count)
+	}
+}
+
+// add records a new event published to the given lane.
+func (a *laneACKs) add(lane int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if last := len(a.runs) - 1; last >= 0 && a.runs[last].lane == lane {
+		a.runs[last].published++
+		return
+	}
+	a.runs = append(a.runs, laneRun{lane: lane, published: 1})
+}
+
+// remove removes the last event recorded by add, if it could not be
+// published.
+func (a *laneACKs) remove() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	last := len(a.runs) - 1
+	a.runs[last].published--
+	if a.runs[last].published == 0 {
+		a.runs = a.runs[:last]
+	}
+}
+
+// ack records count ACKs from the given lane, and returns the number of
+// events that can be reported as ACKed in publish order.
+func (a *laneACKs) ack(lane, count int) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for i := range a.runs {
+		if count == 0 {
+			break
+		}
+		run := &a.runs[i]
+		if run.lane != lane {
+			continue
+		}
+		n := run.published - run.acked
+		if n > count {
+			n = count
+		}
+		run.acked += n
+		count -= n
+	}
+
+	released := 0
+	for len(a.runs) > 0 {
+		run := &a.runs[0]
+		released += run.acked
+		run.published -= run.acked
+		run.acked = 0
+		if run.published > 0 {
+			break
+		}
+		a.runs = a.runs[1:]
+	}
+	return released
+}
+
+func (c *priorityConsumer) Get(sz int) (queue.Batch, error) {
+	if c.closed.Load() {
+		return nil, io.EOF
+	}
+
+	req := getRequest{sz: sz, resp: c.resp}
+	lanes := c.queue.lanes
+
+	// Try the lanes in order of their credits, and take the first lane
+	// that has events available.
+	chosen := -1
+	for _, i := range c.schedule() {
+		select {
+		case lanes[i].broker.requests <- req:
+			chosen = i
+		default:
+		}
+		if chosen >= 0 {
+			break
+		}
+	}
+
+	if chosen < 0 {
+		// No lane has events available, wait for the first one that has.
+		cases := make([]reflect.SelectCase, len(lanes)+1)
+		for i, l := range lanes {
+			cases[i] = reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(l.broker.requests),
+				Send: reflect.ValueOf(req),
+			}
+		}
+		cases[len(lanes)] = reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(c.done),
+		}
+		chosen, _, _ = reflect.Select(cases)
+		if chosen == len(lanes) {
+			return nil, io.EOF
+		}
+	}
+	c.consume(chosen)
+
+	// if request has been send, we do have to wait for a response
+	resp := <-c.resp
+	return &batch{
+		events: resp.buf,
+		ack:    resp.ack,
+		state:  batchActive,
+	}, nil
+}
+
+// schedule returns the lane indices ordered by their credits after adding
+// the lane weights, as in smooth weighted round-robin.
+func (c *priorityConsumer) schedule() []int {
+	order := make([]int, len(c.credits))
+	for i, l := range c.queue.lanes {
+		c.credits[i] += l.Weight
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return c.credits[order[a]] > c.credits[order[b]]
+	})
+	return order
+}
+
+// consume charges the lane a batch was read from. Credits are bounded, so
+// that a lane that was idle for a long time can not take over all reads.
+func (c *priorityConsumer) consume(index int) {
+	total := c.queue.totalWeight
+	c.credits[index] -= total
+	for i := range c.credits {
+		if c.credits[i] > total {
+			c.credits[i] = total
+		} else if c.credits[i] < -total {
+			c.credits[i] = -total
+		}
+	}
+}
+
+func (c *priorityConsumer) Close() error {
+	if c.closed.Swap(true) {
+		return errors.New("already closed")
+	}
+
+	close(c.done)
+	return nil
+}
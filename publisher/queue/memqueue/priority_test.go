@@ -0,0 +1,247 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package memqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/njcx/libbeat_v7/beat"
+	"github.com/njcx/libbeat_v7/common"
+	"github.com/njcx/libbeat_v7/monitoring"
+	"github.com/njcx/libbeat_v7/publisher"
+	"github.com/njcx/libbeat_v7/publisher/queue"
+	"github.com/njcx/libbeat_v7/publisher/queue/queuetest"
+)
+
+func TestPriorityProduceConsumer(t *testing.T) {
+	events := 256
+	batchSize := 16
+
+	factory := func(t *testing.T) queue.Queue {
+		return makePriorityTestQueue(t, map[string]interface{}{
+			"events": 64,
+			"priority.lanes": []map[string]interface{}{
+				{"name": "first", "when.range.count.lt": 128},
+				{"name": "second", "weight": 3},
+			},
+		})
+	}
+	t.Run("single", func(t *testing.T) {
+		queuetest.TestSingleProducerConsumer(t, events, batchSize, factory)
+	})
+	t.Run("multi", func(t *testing.T) {
+		queuetest.TestMultiProducerConsumer(t, events, batchSize, factory)
+	})
+}
+
+func TestPrioritySelectLane(t *testing.T) {
+	q := makePriorityTestQueue(t, map[string]interface{}{
+		"events": 96,
+		"priority": map[string]interface{}{
+			"field":   "priority",
+			"default": "normal",
+			"lanes": []map[string]interface{}{
+				{"name": "alerts", "when.equals.event.kind": "alert"},
+				{"name": "normal"},
+				{"name": "debug", "when.equals.log.level": "debug"},
+			},
+		},
+	}).(*priorityQueue)
+	defer q.Close()
+
+	tests := map[string]struct {
+		event beat.Event
+		lane  string
+	}{
+		"condition": {
+			event: beat.Event{Fields: common.MapStr{"event": common.MapStr{"kind": "alert"}}},
+			lane:  "alerts",
+		},
+		"metadata overrides condition": {
+			event: beat.Event{
+				Meta:   common.MapStr{"priority": "debug"},
+				Fields: common.MapStr{"event": common.MapStr{"kind": "alert"}},
+			},
+			lane: "debug",
+		},
+		"unknown metadata lane": {
+			event: beat.Event{Meta: common.MapStr{"priority": "unknown"}},
+			lane:  "normal",
+		},
+		"default": {
+			event: beat.Event{Fields: common.MapStr{"message": "hello"}},
+			lane:  "normal",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.lane, q.selectLane(&test.event).Name)
+		})
+	}
+
+	assert.Equal(t, 32, q.lanes[0].broker.bufSize)
+	assert.Equal(t, queue.BufferConfig{MaxEvents: 96}, q.BufferConfig())
+}
+
+func TestPriorityWeightedDraining(t *testing.T) {
+	q := makePriorityTestQueue(t, map[string]interface{}{
+		"events": 512,
+		"priority": map[string]interface{}{
+			"field": "priority",
+			"lanes": []map[string]interface{}{
+				{"name": "high", "weight": 3},
+				{"name": "low"},
+			},
+		},
+	})
+	defer q.Close()
+
+	producer := q.Producer(queue.ProducerConfig{})
+	for i := 0; i < 100; i++ {
+		require.True(t, producer.Publish(makePriorityEvent("high")))
+		require.True(t, producer.Publish(makePriorityEvent("low")))
+	}
+	// Give the lanes time to buffer all events.
+	time.Sleep(100 * time.Millisecond)
+
+	counts := map[string]int{}
+	consumer := q.Consumer()
+	for i := 0; i < 40; i++ {
+		batch, err := consumer.Get(1)
+		require.NoError(t, err)
+		for _, event := range batch.Events() {
+			counts[event.Content.Meta["priority"].(string)]++
+		}
+		batch.ACK()
+	}
+	assert.Equal(t, map[string]int{"high": 30, "low": 10}, counts)
+}
+
+func TestPriorityDropPolicy(t *testing.T) {
+	q := makePriorityTestQueue(t, map[string]interface{}{
+		"priority": map[string]interface{}{
+			"field": "priority",
+			"lanes": []map[string]interface{}{
+				{"name": "high", "events": 32, "drop_policy": "block"},
+				{"name": "low", "events": 32},
+			},
+		},
+	})
+	defer q.Close()
+
+	reg := monitoring.NewRegistry()
+	q.(queue.MetricsReporter).RegisterMetrics(reg)
+
+	// Fill the low lane until events are dropped.
+	producer := q.Producer(queue.ProducerConfig{})
+	for producer.TryPublish(makePriorityEvent("low")) {
+	}
+
+	// Events of the high lane are never dropped, publishing blocks until
+	// the consumer made room.
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 64; i++ {
+			producer.TryPublish(makePriorityEvent("high"))
+		}
+	}()
+
+	consumer := q.Consumer()
+	high := 0
+	for high < 64 {
+		batch, err := consumer.Get(8)
+		require.NoError(t, err)
+		for _, event := range batch.Events() {
+			if event.Content.Meta["priority"] == "high" {
+				high++
+			}
+		}
+		batch.ACK()
+	}
+	<-published
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(64), snapshot.Ints["queue.lanes.high.published"])
+	assert.Equal(t, int64(0), snapshot.Ints["queue.lanes.high.dropped"])
+	assert.Equal(t, int64(1), snapshot.Ints["queue.lanes.low.dropped"])
+	assert.Equal(t, int64(32), snapshot.Ints["queue.lanes.low.max_events"])
+}
+
+func TestPriorityProducerACKsInPublishOrder(t *testing.T) {
+	var acks laneACKs
+	acks.add(0)
+	acks.add(1)
+	acks.add(1)
+	acks.add(0)
+	acks.add(2)
+	acks.remove()
+
+	// Lane 1 was drained first, but waits for the first event of lane 0.
+	assert.Equal(t, 0, acks.ack(1, 2))
+	assert.Equal(t, 3, acks.ack(0, 1))
+	assert.Equal(t, 1, acks.ack(0, 1))
+	assert.Empty(t, acks.runs)
+}
+
+func TestPriorityConfigErrors(t *testing.T) {
+	tests := map[string][]map[string]interface{}{
+		"duplicate lane":      {{"name": "a"}, {"name": "a"}},
+		"invalid drop policy": {{"name": "a", "drop_policy": "oldest"}},
+		"dotted name":         {{"name": "a.b"}},
+		"missing name":        {{"weight": 2}},
+	}
+	for name, lanes := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := common.MustNewConfigFrom(map[string]interface{}{
+				"priority.lanes": lanes,
+			})
+			_, err := create(nil, nil, cfg, 0)
+			assert.Error(t, err)
+		})
+	}
+
+	cfg := common.MustNewConfigFrom(map[string]interface{}{
+		"priority.lanes":   []map[string]interface{}{{"name": "a"}},
+		"priority.default": "b",
+	})
+	_, err := create(nil, nil, cfg, 0)
+	assert.Error(t, err)
+}
+
+func makePriorityTestQueue(t *testing.T, config map[string]interface{}) queue.Queue {
+	config["flush.min_events"] = 0
+	q, err := create(nil, nil, common.MustNewConfigFrom(config), 0)
+	require.NoError(t, err)
+	return q
+}
+
+func makePriorityEvent(priority string) publisher.Event {
+	return publisher.Event{
+		Content: beat.Event{
+			Timestamp: time.Now(),
+			Meta:      common.MapStr{"priority": priority},
+			Fields:    common.MapStr{"message": priority},
+		},
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/njcx/libbeat_v7/beat"
 	"github.com/njcx/libbeat_v7/common"
 	"github.com/njcx/libbeat_v7/logp"
+	"github.com/njcx/libbeat_v7/monitoring"
 	"github.com/njcx/libbeat_v7/publisher"
 )
 
@@ -52,6 +53,13 @@ type Queue interface {
 	Consumer() Consumer
 }
 
+// MetricsReporter is optionally implemented by queues reporting additional
+// metrics. RegisterMetrics is called once by the pipeline after creating the
+// queue, with the pipeline's monitoring registry.
+type MetricsReporter interface {
+	RegisterMetrics(reg *monitoring.Registry)
+}
+
 // BufferConfig returns the pipelines buffering settings,
 // for the pipeline to use.
 // In case of the pipeline itself storing events for reporting ACKs to clients,